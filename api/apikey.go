@@ -0,0 +1,151 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+)
+
+// An API key as returned when it is created : its secret is never given
+// again.
+type CreatedAPIKey struct {
+	*model.APIKey
+	Secret string `json:"secret"`
+}
+
+type apiKeysByCreation []*model.APIKey
+
+func (k apiKeysByCreation) Len() int           { return len(k) }
+func (k apiKeysByCreation) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k apiKeysByCreation) Less(i, j int) bool { return k[i].CreatedAt.Before(k[j].CreatedAt) }
+
+func (s *APIServer) APIKeyIndex(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.arkenModel.APIKeys()
+	if err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	result := make([]*model.APIKey, 0, len(keys))
+	for _, key := range keys {
+		key.SecretHash = ""
+		result = append(result, key)
+	}
+	sort.Sort(apiKeysByCreation(result))
+	writeJSON(w, result, http.StatusOK)
+}
+
+func (s *APIServer) APIKeyShow(w http.ResponseWriter, r *http.Request) {
+	key, err := s.arkenModel.GetAPIKey(mux.Vars(r)["keyId"])
+	if err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+	key.SecretHash = ""
+	writeJSON(w, key, http.StatusOK)
+}
+
+// Creates an API key, and returns it with its secret.
+func (s *APIServer) APIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	key := &model.APIKey{}
+	if err := json.NewDecoder(r.Body).Decode(key); err != nil {
+		http.Error(w, "Unable to read API key : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !canGrantAPIKey(w, r, key) {
+		return
+	}
+
+	created, secret, err := s.arkenModel.CreateAPIKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	created.SecretHash = ""
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/apikeys/%s", created.Id))
+	writeJSON(w, &CreatedAPIKey{APIKey: created, Secret: secret}, http.StatusCreated)
+}
+
+// Updates the description, access and expiry of an API key.
+func (s *APIServer) APIKeyUpdate(w http.ResponseWriter, r *http.Request) {
+	key := &model.APIKey{}
+	if err := json.NewDecoder(r.Body).Decode(key); err != nil {
+		http.Error(w, "Unable to read API key : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	key.Id = mux.Vars(r)["keyId"]
+	if !canGrantAPIKey(w, r, key) {
+		return
+	}
+
+	updated, err := s.arkenModel.UpdateAPIKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+	updated.SecretHash = ""
+	writeJSON(w, updated, http.StatusOK)
+}
+
+// Revokes an API key.
+func (s *APIServer) APIKeyDestroy(w http.ResponseWriter, r *http.Request) {
+	if err := s.arkenModel.DestroyAPIKey(mux.Vars(r)["keyId"]); err != nil {
+		http.Error(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the principal of a stored API key.
+func apiKeyPrincipal(key *model.APIKey) (*Principal, error) {
+	if key.Role == "" && len(key.Permissions) == 0 {
+		return nil, errors.New("An API key needs a role or permissions")
+	}
+	return NewPrincipal(key.Id, key.Role, key.Permissions, key.Services)
+}
+
+// Tells if the principal of the request may give the access of the key,
+// answering 400 when the access is invalid and 403 when it exceeds its own.
+func canGrantAPIKey(w http.ResponseWriter, r *http.Request, key *model.APIKey) bool {
+	granted, err := apiKeyPrincipal(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if principal := principalFrom(r); !principal.CanGrant(granted) {
+		http.Error(w, fmt.Sprintf("%s can not grant more than its own access", principal.Name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Maps the errors of the model about API keys to an HTTP status.
+func apiKeyErrorStatus(err error) int {
+	switch err {
+	case model.ErrAPIKeyNotFound:
+		return http.StatusNotFound
+	case model.ErrAPIKeysNotSupported:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_StoredAPIKeys(t *testing.T) {
+
+	Convey("Given an API without keys in the configuration", t, func() {
+		dir, err := ioutil.TempDir("", "arken-api")
+		So(err, ShouldBeNil)
+		driver, err := storage.NewBoltDriver(filepath.Join(dir, "arken.db"))
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+
+		s := NewAPIServer(arkenModel)
+		handler := s.getRoutes()
+		get := func(accessKey string, secret string) int {
+			request := httptest.NewRequest("GET", "/api/v1/services", nil)
+			if accessKey != "" {
+				request.Header.Set("AuthKey", accessKey)
+				request.Header.Set("AuthSecret", secret)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+
+		Convey("When there is no key in the store", func() {
+
+			Convey("Then the API is not protected", func() {
+				So(get("", ""), ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When there is a key in the store", func() {
+			key, secret, err := arkenModel.CreateAPIKey(&model.APIKey{Description: "CI", Role: VIEWER_ROLE})
+			So(err, ShouldBeNil)
+
+			Convey("Then the requests without key are rejected", func() {
+				So(get("", ""), ShouldEqual, http.StatusUnauthorized)
+			})
+
+			Convey("Then the requests with a wrong secret are rejected", func() {
+				So(get(key.Id, "wrong"), ShouldEqual, http.StatusUnauthorized)
+			})
+
+			Convey("Then the requests with the key are accepted", func() {
+				So(get(key.Id, secret), ShouldEqual, http.StatusOK)
+			})
+
+			Convey("When the last key is destroyed", func() {
+				So(arkenModel.DestroyAPIKey(key.Id), ShouldBeNil)
+				s.storedKeysCheckedAt = time.Time{}
+
+				Convey("Then the API is not protected anymore", func() {
+					So(get("", ""), ShouldEqual, http.StatusOK)
+				})
+			})
+		})
+
+		Reset(func() {
+			driver.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,235 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/gorilla/mux"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of entries returned by the audit log and the histories when no
+// limit is given
+const DEFAULT_QUERY_LIMIT = 1000
+
+// Size of the error messages kept from the responses of failed changes
+const MAX_AUDIT_ERROR_SIZE = 512
+
+// The action recorded for each route that changes something. The actions on
+// services are recorded when their operation ends.
+var routeActions = map[string]string{
+	"ServiceCreate":    "CreateService",
+	"ServiceDelete":    "DestroyService",
+	"ServiceUpdate":    "UpdateService",
+	"ServiceSnooze":    "SnoozePassivation",
+	"DomainCreate":     "CreateDomain",
+	"DomainUpdate":     "UpdateDomain",
+	"DomainDestroy":    "DestroyDomain",
+	"WebhookCreate":    "CreateWebhook",
+	"WebhookUpdate":    "UpdateWebhook",
+	"WebhookDestroy":   "DestroyWebhook",
+	"WebhookRedeliver": "RedeliverWebhook",
+	"APIKeyCreate":     "CreateAPIKey",
+	"APIKeyUpdate":     "UpdateAPIKey",
+	"APIKeyDestroy":    "DestroyAPIKey",
+}
+
+// The action recorded for each action on a service
+var serviceActionAudits = map[string]string{
+	"start":         "StartService",
+	"stop":          "StopService",
+	"passivate":     "PassivateService",
+	"upgrade":       "UpgradeService",
+	"finishupgrade": "FinishUpgradeService",
+	"rollback":      "RollbackService",
+}
+
+const auditEntryContextKey contextKey = 1
+
+// Returns who made a request, as recorded in the audit log.
+func auditActor(principal *Principal) (string, string) {
+	if principal == nil {
+		return "", model.ANONYMOUS_ACTOR
+	}
+	return principal.Name, principal.Type
+}
+
+// Returns the audit entry of the change a request makes, so that its handler
+// can complete it, nil when the request is not audited.
+func auditEntryFrom(r *http.Request) *model.AuditEntry {
+	entry, _ := r.Context().Value(auditEntryContextKey).(*model.AuditEntry)
+	return entry
+}
+
+// Records that the change of a request failed even though it was answered
+// as a success.
+func auditFailure(r *http.Request, err error) {
+	if entry := auditEntryFrom(r); entry != nil {
+		entry.Result = model.AUDIT_FAILURE
+		entry.Error = err.Error()
+	}
+}
+
+// Keeps the status and the beginning of the error message of a response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.body) < MAX_AUDIT_ERROR_SIZE {
+		w.body = append(w.body, data...)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Returns a middleware that records the changes made through the routes of
+// the router in the audit log, with their caller and their result.
+func (s *APIServer) audit(router *mux.Router) func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			next(w, r)
+			return
+		}
+		action, ok := routeActions[match.Route.GetName()]
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		actor, actorType := auditActor(principalFrom(r))
+		entry := &model.AuditEntry{
+			Actor:       actor,
+			ActorType:   actorType,
+			Action:      action,
+			ServiceName: match.Vars["serviceId"],
+			DomainName:  match.Vars["domain"],
+		}
+		for _, name := range []string{"webhookId", "keyId"} {
+			if id, ok := match.Vars[name]; ok {
+				entry.Object = id
+			}
+		}
+		entry.StatusBefore = s.arkenModel.ServiceStatus(entry.ServiceName)
+
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditEntryContextKey, entry)))
+
+		if entry.ServiceName != "" {
+			entry.StatusAfter = s.arkenModel.ServiceStatus(entry.ServiceName)
+		}
+		if entry.Object == "" {
+			if location := recorder.Header().Get("Location"); location != "" {
+				entry.Object = path.Base(location)
+			}
+		}
+		if recorder.status >= 400 {
+			entry.Result = model.AUDIT_FAILURE
+			entry.Error = strings.TrimSpace(string(recorder.body))
+		}
+		s.arkenModel.Audit(entry)
+	}
+}
+
+// Records the end of an action on a service, run by an operation.
+func (s *APIServer) auditServiceAction(r *http.Request, actionName string, serviceName string, before string, err error) {
+	actor, actorType := auditActor(principalFrom(r))
+	s.arkenModel.AuditService(actor, actorType, serviceActionAudits[actionName], serviceName, before, err)
+}
+
+// Returns the entries of the audit log, filtered by the service, actor, since,
+// until and limit query parameters. Times are in RFC 3339.
+func (s *APIServer) AuditIndex(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &model.AuditFilter{
+		ServiceName: query.Get("service"),
+		Actor:       query.Get("actor"),
+	}
+
+	var err error
+	if filter.Since, err = queryTime(query.Get("since")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = queryTime(query.Get("until")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Limit, err = queryLimit(query.Get("limit")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.arkenModel.AuditEntries(filter)
+	if err == model.ErrAuditNotSupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A principal restricted to some services only sees the changes of them
+	principal := principalFrom(r)
+	result := make([]*model.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		if principal == nil || len(principal.Services) == 0 || entry.ServiceName != "" && principal.CanAccessService(entry.ServiceName) {
+			result = append(result, entry)
+		}
+	}
+	writeJSON(w, result, http.StatusOK)
+}
+
+// Parses a time given in a query parameter, in RFC 3339. An empty value is
+// the zero time.
+func queryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Invalid time %s, expected RFC 3339 as 2016-01-02T15:04:05Z", value))
+	}
+	return t, nil
+}
+
+// Parses a limit given in a query parameter. An empty value is the default
+// limit.
+func queryLimit(value string) (int, error) {
+	if value == "" {
+		return DEFAULT_QUERY_LIMIT, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("Invalid limit : " + value)
+	}
+	return limit, nil
+}
@@ -0,0 +1,48 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"github.com/arkenio/arken/goarken/model"
+	"net/http"
+)
+
+func (s *APIServer) ClusterShow(w http.ResponseWriter, r *http.Request) {
+	status, err := s.arkenModel.ClusterStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// Middleware that rejects the requests that modify the model when the daemon
+// is not the leader of the cluster. The leader is given in the
+// X-Arken-Leader header, when known, so that clients can retry on it.
+func (s *APIServer) leaderOnly(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method == "GET" || r.Method == "HEAD" || s.arkenModel.IsLeader() {
+		next(w, r)
+		return
+	}
+
+	if status, err := s.arkenModel.ClusterStatus(); err == nil && status.Leader != "" {
+		w.Header().Set("X-Arken-Leader", status.Leader)
+	}
+	http.Error(w, model.ErrNotLeader.Error(), http.StatusServiceUnavailable)
+}
@@ -15,55 +15,187 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/arkenio/arken/goarken/model"
 	"github.com/gorilla/mux"
 	"net/http"
 )
 
+// Lists the domains of the model. Service domains are listed with their
+// service, the other ones as is. They may be filtered by type and, for
+// service domains, by the status of the service.
 func (s *APIServer) DomainIndex(w http.ResponseWriter, r *http.Request) {
 
 	statusFilter := r.URL.Query().Get("status")
+	typeFilter := r.URL.Query().Get("type")
 
-	domains := make(map[string]*model.Service)
+	domains := make(map[string]interface{})
 
-	for domainName, domain := range s.arkenModel.Domains {
-		if domain.Typ == "service" {
-			service := s.arkenModel.Services[domain.Value]
+	services := s.arkenModel.Services()
+	principal := principalFrom(r)
+
+	for domainName, domain := range s.arkenModel.Domains() {
+		if !principal.CanAccessDomain(domain) {
+			continue
+		}
+		if typeFilter != "" && typeFilter != domain.Typ {
+			continue
+		}
+
+		if domain.Typ == model.SERVICE_DOMAIN {
+			service := services[domain.Value]
 			if service != nil {
 				if statusFilter == "" || statusFilter == service.Status.Compute() {
 					domains[domainName] = service
 				}
 			}
+		} else if statusFilter == "" {
+			domains[domainName] = domain
 		}
 
 	}
 
+	w.Header().Add("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(domains); err != nil {
-		panic(err)
+		http.Error(w, err.Error(), 500)
 	}
 }
 
 func (s *APIServer) DomainShow(w http.ResponseWriter, r *http.Request) {
 	domainName := mux.Vars(r)["domain"]
-	domain := s.arkenModel.Domains[domainName]
+	domain, ok := s.arkenModel.GetDomain(domainName)
+	if !ok || !principalFrom(r).CanAccessDomain(domain) {
+		http.NotFound(w, r)
+		return
+	}
 
-	if domain != nil {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("ETag", revisionETag(domain.Revision))
 
-		if domain.Typ == "service" {
-			service := s.arkenModel.Services[domain.Value]
-			if service != nil {
+	var result interface{} = domain
+	if domain.Typ == model.SERVICE_DOMAIN {
+		service, ok := s.arkenModel.GetService(domain.Value)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		result = service
+	}
 
-				if err := json.NewEncoder(w).Encode(service); err != nil {
-					panic(err)
-				}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
 
-			}
-		} else {
-			if err := json.NewEncoder(w).Encode(domain); err != nil {
-				panic(err)
-			}
+func (s *APIServer) DomainCreate(w http.ResponseWriter, r *http.Request) {
+	domain := &model.Domain{}
+	if err := json.NewDecoder(r.Body).Decode(domain); err != nil {
+		http.Error(w, "Unable to read domain : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	domain.Name = mux.Vars(r)["domain"]
 
-		}
+	if !canWriteDomain(w, r, domain) {
+		return
+	}
+
+	created, err := s.arkenModel.CreateDomain(domain)
+	if err != nil {
+		http.Error(w, err.Error(), domainErrorStatus(err))
+		return
+	}
+
+	writeDomain(w, created, http.StatusCreated)
+}
+
+func (s *APIServer) DomainUpdate(w http.ResponseWriter, r *http.Request) {
+	domainName := mux.Vars(r)["domain"]
+	domain, ok := s.arkenModel.GetDomain(domainName)
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	update := &model.Domain{}
+	if err := json.NewDecoder(r.Body).Decode(update); err != nil {
+		http.Error(w, "Unable to read domain : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The If-Match header takes precedence over the revision of the body
+	if revision, err := ifMatchRevision(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if revision != 0 {
+		domain.Revision = revision
+	} else if update.Revision != 0 {
+		domain.Revision = update.Revision
+	}
+	if !canWriteDomain(w, r, domain) {
+		return
+	}
+	domain.Typ = update.Typ
+	domain.Value = update.Value
+	if !canWriteDomain(w, r, domain) {
+		return
+	}
+
+	updated, err := s.arkenModel.UpdateDomain(domain)
+	if err != nil {
+		http.Error(w, err.Error(), domainErrorStatus(err))
+		return
+	}
+
+	writeDomain(w, updated, http.StatusOK)
+}
+
+func (s *APIServer) DomainDestroy(w http.ResponseWriter, r *http.Request) {
+	domain, ok := s.arkenModel.GetDomain(mux.Vars(r)["domain"])
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	if !canWriteDomain(w, r, domain) {
+		return
+	}
+
+	if err := s.arkenModel.DestroyDomain(domain); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeDomain(w http.ResponseWriter, domain *model.Domain, status int) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("ETag", revisionETag(domain.Revision))
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(domain); err != nil {
+		log.Errorf("Unable to encode domain %s : %v", domain.Name, err)
+	}
+}
+
+// Tells if the principal of the request may write the domain, answering 403
+// when it may not.
+func canWriteDomain(w http.ResponseWriter, r *http.Request, domain *model.Domain) bool {
+	if principal := principalFrom(r); !principal.CanAccessDomain(domain) {
+		http.Error(w, fmt.Sprintf("%s is not allowed to write domain %s", principal.Name, domain.Name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Maps the errors of the model about domains to an HTTP status.
+func domainErrorStatus(err error) int {
+	if _, ok := err.(*model.InvalidDomainError); ok {
+		return http.StatusBadRequest
+	}
 
+	switch err {
+	case model.ErrDomainExists, model.ErrDomainConflict, model.ErrConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
 	}
 }
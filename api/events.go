@@ -0,0 +1,115 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"net/http"
+	"time"
+)
+
+// Streams the events of the model as Server-Sent Events, with the same JSON
+// as the websocket. The id of each event is its sequence : a client that
+// reconnects with a Last-Event-ID header, or a since parameter, gets the
+// events it missed first. The query may filter the events as on the
+// websocket.
+func (s *APIServer) EventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("since")
+	}
+	since, resume, err := parseSequence(lastEventId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := filterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Registered first, so that no event is missed between the replay and
+	// the live events
+	principal := principalFrom(r)
+	c := newConnection(nil, s.hub, principal, filter)
+	s.hub.register <- c
+	defer func() {
+		s.hub.unregister <- c
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	last := since
+	write := func(event *model.ModelEvent) error {
+		if err := writeServerSentEvent(w, event); err != nil {
+			return err
+		}
+		last = event.Sequence
+		return nil
+	}
+	if resume {
+		if err := replayEvents(s.arkenModel, since, principal, filter, write); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-c.send:
+			if !ok {
+				// Dropped by the hub, the client resumes from its last event
+				return
+			}
+			if event.Sequence <= last {
+				continue
+			}
+			if err := write(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			// Keeps the proxies from closing an idle stream
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event *model.ModelEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Sequence, data)
+	return err
+}
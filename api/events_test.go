@@ -0,0 +1,266 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An event read from a stream, with the id it has been sent with
+type streamedEvent struct {
+	id    uint64
+	event *model.ModelEvent
+}
+
+// Serves the API of the model, with the events of the model sent to its
+// hub as the server does.
+func newEventServer(arkenModel *model.Model) (*APIServer, *httptest.Server) {
+	s := NewAPIServer(arkenModel)
+	go s.hub.run()
+	go func() {
+		for event := range arkenModel.Listen() {
+			s.hub.broadcast <- event
+		}
+	}()
+	return s, httptest.NewServer(s.getRoutes())
+}
+
+// Opens an event stream and reads its events until it is closed.
+func openEventStream(url string, lastEventId string) (*http.Response, chan *streamedEvent, error) {
+	request, _ := http.NewRequest("GET", url, nil)
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, nil, err
+	}
+
+	events := make(chan *streamedEvent, 100)
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(response.Body)
+		streamed := &streamedEvent{}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if strings.HasPrefix(line, "id: ") {
+				streamed.id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			} else if strings.HasPrefix(line, "data: ") {
+				streamed.event = &model.ModelEvent{}
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), streamed.event)
+			} else if line == "" && streamed.event != nil {
+				events <- streamed
+				streamed = &streamedEvent{}
+			}
+		}
+	}()
+	return response, events, nil
+}
+
+// Returns the next event of the stream, or nil when none comes in time.
+func nextEvent(events chan *streamedEvent, timeout time.Duration) *streamedEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// Returns the name of the domain of an event read from a stream
+func domainName(streamed *streamedEvent) string {
+	if domain, ok := streamed.event.Model.(map[string]interface{}); ok {
+		name, _ := domain["name"].(string)
+		return name
+	}
+	return ""
+}
+
+func waitFor(condition func() bool) bool {
+	timeout := time.After(3 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func createTestDomain(arkenModel *model.Model, name string) error {
+	_, err := arkenModel.CreateDomain(&model.Domain{Name: name, Typ: model.URI_DOMAIN, Value: "http://backend.example.com/"})
+	return err
+}
+
+func Test_EventStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arken-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("Given a model that has published the creation of three domains", t, func() {
+		driver, err := storage.NewBoltDriver(filepath.Join(dir, "arken.db"))
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+		s, server := newEventServer(arkenModel)
+		streamURL := server.URL + "/api/v1/events"
+
+		start := arkenModel.LastEventSequence()
+		for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+			So(createTestDomain(arkenModel, name), ShouldBeNil)
+		}
+		var published []*model.ModelEvent
+		So(waitFor(func() bool {
+			published, _ = arkenModel.EventsSince(start)
+			created := 0
+			for _, event := range published {
+				if event.EventType == "create" {
+					created++
+				}
+			}
+			return created == 3
+		}), ShouldBeTrue)
+		// The storage may publish the domains again, with the next flush
+		time.Sleep(1200 * time.Millisecond)
+		published, _ = arkenModel.EventsSince(start)
+		first := strconv.FormatUint(published[0].Sequence, 10)
+
+		var response *http.Response
+		// Reads the events replayed after the first one
+		expectReplay := func(events chan *streamedEvent) {
+			for _, expected := range published[1:] {
+				streamed := nextEvent(events, 2*time.Second)
+				So(streamed, ShouldNotBeNil)
+				So(streamed.id, ShouldEqual, expected.Sequence)
+				So(streamed.event.Sequence, ShouldEqual, expected.Sequence)
+				So(domainName(streamed), ShouldEqual, expected.Model.(*model.Domain).Name)
+			}
+		}
+
+		Convey("When a client resumes from the first event with Last-Event-ID", func() {
+			var events chan *streamedEvent
+			response, events, err = openEventStream(streamURL, first)
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusOK)
+			So(response.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+			Convey("Then it receives the events that follow it, then the live ones", func() {
+				expectReplay(events)
+				So(createTestDomain(arkenModel, "d.example.com"), ShouldBeNil)
+				streamed := nextEvent(events, 3*time.Second)
+				So(streamed, ShouldNotBeNil)
+				So(domainName(streamed), ShouldEqual, "d.example.com")
+				So(streamed.id, ShouldBeGreaterThan, published[len(published)-1].Sequence)
+			})
+
+			Convey("Then the events it already received are not sent again", func() {
+				expectReplay(events)
+				last := published[len(published)-1].Sequence
+				s.hub.broadcast <- published[1]
+				s.hub.broadcast <- &model.ModelEvent{EventType: "update", ModelType: "Domain", Model: &model.Domain{Name: "b.example.com"}, Sequence: last}
+				s.hub.broadcast <- &model.ModelEvent{EventType: "update", ModelType: "Domain", Model: &model.Domain{Name: "e.example.com"}, Sequence: last + 1}
+
+				streamed := nextEvent(events, 2*time.Second)
+				So(streamed, ShouldNotBeNil)
+				So(streamed.id, ShouldEqual, last+1)
+				So(domainName(streamed), ShouldEqual, "e.example.com")
+			})
+		})
+
+		Convey("When a client resumes from the first event with the since parameter", func() {
+			var events chan *streamedEvent
+			response, events, err = openEventStream(streamURL+"?since="+first, "")
+			So(err, ShouldBeNil)
+
+			Convey("Then it receives the events that follow it", func() {
+				expectReplay(events)
+			})
+		})
+
+		Convey("When a client resumes with a filter in the query", func() {
+			var events chan *streamedEvent
+			response, events, err = openEventStream(fmt.Sprintf("%s?since=%d&domain=b*", streamURL, start), "")
+			So(err, ShouldBeNil)
+
+			Convey("Then it only receives the events that pass the filter", func() {
+				So(createTestDomain(arkenModel, "d.example.com"), ShouldBeNil)
+				So(createTestDomain(arkenModel, "b2.example.com"), ShouldBeNil)
+
+				names := []string{}
+				for streamed := nextEvent(events, 3*time.Second); streamed != nil; streamed = nextEvent(events, 3*time.Second) {
+					names = append(names, domainName(streamed))
+					if names[len(names)-1] == "b2.example.com" {
+						break
+					}
+				}
+				So(names, ShouldContain, "b.example.com")
+				So(names, ShouldContain, "b2.example.com")
+				So(names, ShouldNotContain, "a.example.com")
+				So(names, ShouldNotContain, "d.example.com")
+			})
+		})
+
+		Convey("When a client resumes from a sequence the journal does not know", func() {
+			var events chan *streamedEvent
+			response, events, err = openEventStream(streamURL, strconv.FormatUint(arkenModel.LastEventSequence()+1000, 10))
+			So(err, ShouldBeNil)
+
+			Convey("Then it is told to read the model again", func() {
+				streamed := nextEvent(events, 2*time.Second)
+				So(streamed, ShouldNotBeNil)
+				So(streamed.event.EventType, ShouldEqual, model.RESYNC_EVENT)
+			})
+		})
+
+		Convey("When a client resumes from an invalid sequence", func() {
+			response, _, err = openEventStream(streamURL, "last")
+			So(err, ShouldBeNil)
+
+			Convey("Then the stream is not opened", func() {
+				So(response.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Reset(func() {
+			if response != nil {
+				response.Body.Close()
+			}
+			server.CloseClientConnections()
+			server.Close()
+			driver.Close()
+			os.Remove(filepath.Join(dir, "arken.db"))
+		})
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"github.com/arkenio/arken/goarken/metrics"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+var serviceStatuses = []string{
+	model.STARTING_STATUS,
+	model.STARTED_STATUS,
+	model.STOPPING_STATUS,
+	model.STOPPED_STATUS,
+	model.ERROR_STATUS,
+	model.WARNING_STATUS,
+	model.NA_STATUS,
+	model.PASSIVATED_STATUS,
+}
+
+// Counts the services of the model by computed status, when scraped.
+type serviceStatusCollector struct {
+	arkenModel *model.Model
+	desc       *prometheus.Desc
+}
+
+func newServiceStatusCollector(arkenModel *model.Model) *serviceStatusCollector {
+	return &serviceStatusCollector{
+		arkenModel: arkenModel,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metrics.NAMESPACE, "", "services"),
+			"Services of the model, by computed status.",
+			[]string{"status"}, nil),
+	}
+}
+
+func (c *serviceStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *serviceStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[string]int)
+	for _, status := range serviceStatuses {
+		counts[status] = 0
+	}
+	for _, service := range c.arkenModel.Services() {
+		if service.Status != nil {
+			counts[service.Status.Compute()]++
+		}
+	}
+
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), status)
+	}
+}
+
+// Returns the handler of the /metrics endpoint, that also counts the
+// services of the given model.
+func metricsHandler(arkenModel *model.Model) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newServiceStatusCollector(arkenModel))
+	return promhttp.HandlerFor(prometheus.Gatherers{metrics.Registry, registry}, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,55 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	goarken "github.com/arkenio/arken/goarken/model"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (s *APIServer) OperationIndex(w http.ResponseWriter, r *http.Request) {
+
+	serviceFilter := r.URL.Query().Get("service")
+	principal := principalFrom(r)
+
+	operations := make([]*goarken.Operation, 0)
+	for _, operation := range s.arkenModel.Operations() {
+		if !principal.CanAccessService(operation.ServiceName) {
+			continue
+		}
+		if serviceFilter == "" || serviceFilter == operation.ServiceName {
+			operations = append(operations, operation)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(operations); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (s *APIServer) OperationShow(w http.ResponseWriter, r *http.Request) {
+	operationId := mux.Vars(r)["operationId"]
+
+	if operation, ok := s.arkenModel.GetOperation(operationId); ok && principalFrom(r).CanAccessService(operation.ServiceName) {
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(operation); err != nil {
+			http.Error(w, err.Error(), 500)
+		}
+	} else {
+		http.NotFound(w, r)
+	}
+}
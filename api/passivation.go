@@ -0,0 +1,82 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	goarken "github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/passivation"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+// The body of a snooze request
+type Snooze struct {
+	DurationInSeconds int `json:"durationInSeconds"`
+}
+
+// Lists what the passivation handler would do with every service now,
+// without applying anything.
+func (s *APIServer) PassivationPreview(w http.ResponseWriter, r *http.Request) {
+	if s.Passivation == nil {
+		http.Error(w, "Passivation is not running on this daemon", http.StatusServiceUnavailable)
+		return
+	}
+
+	principal := principalFrom(r)
+	decisions := make([]*passivation.Decision, 0)
+	for _, decision := range s.Passivation.Preview(time.Now()) {
+		if principal.CanAccessService(decision.ServiceName) {
+			decisions = append(decisions, decision)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(decisions); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// Delays the passivation of a service for the duration given in the body.
+func (s *APIServer) ServiceSnooze(w http.ResponseWriter, r *http.Request) {
+	serviceId := mux.Vars(r)["serviceId"]
+
+	var snooze Snooze
+	if err := json.NewDecoder(r.Body).Decode(&snooze); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	service, ok := s.arkenModel.GetService(serviceId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	service, err := s.arkenModel.SnoozePassivation(service, time.Duration(snooze.DurationInSeconds)*time.Second)
+	if err == goarken.ErrInvalidSnooze {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("ETag", revisionETag(service.Revision))
+	if err := json.NewEncoder(w).Encode(withNextTransition(service, time.Now())); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/gorilla/mux"
+	"github.com/pjebs/restgate"
+	"github.com/spf13/viper"
+	"net/http"
+	"path"
+	"time"
+)
+
+// How long the API remembers whether there are API keys in the store
+const STORED_API_KEYS_CHECK_INTERVAL = 5 * time.Second
+
+// The roles of the API keys
+const (
+	VIEWER_ROLE   = "viewer"
+	OPERATOR_ROLE = "operator"
+	ADMIN_ROLE    = "admin"
+)
+
+// The permissions on the API
+const (
+	READ_PERMISSION      = "read"
+	CREATE_PERMISSION    = "create"
+	UPDATE_PERMISSION    = "update"
+	START_PERMISSION     = "start"
+	STOP_PERMISSION      = "stop"
+	PASSIVATE_PERMISSION = "passivate"
+	UPGRADE_PERMISSION   = "upgrade"
+	DELETE_PERMISSION    = "delete"
+	DOMAINS_PERMISSION   = "domains"
+	WEBHOOKS_PERMISSION  = "webhooks"
+	APIKEYS_PERMISSION   = "apikeys"
+	AUDIT_PERMISSION     = "audit"
+)
+
+var Permissions = []string{READ_PERMISSION, CREATE_PERMISSION, UPDATE_PERMISSION, START_PERMISSION, STOP_PERMISSION,
+	PASSIVATE_PERMISSION, UPGRADE_PERMISSION, DELETE_PERMISSION, DOMAINS_PERMISSION, WEBHOOKS_PERMISSION, APIKEYS_PERMISSION, AUDIT_PERMISSION}
+
+// The permissions granted by each role
+var RolePermissions = map[string][]string{
+	VIEWER_ROLE:   {READ_PERMISSION},
+	OPERATOR_ROLE: {READ_PERMISSION, START_PERMISSION, STOP_PERMISSION, PASSIVATE_PERMISSION, UPGRADE_PERMISSION, UPDATE_PERMISSION},
+	ADMIN_ROLE:    Permissions,
+}
+
+// The permission needed by the routes that do not only read
+var routePermissions = map[string]string{
+	"ServiceCreate":      CREATE_PERMISSION,
+	"ServiceDelete":      DELETE_PERMISSION,
+	"ServiceUpdate":      UPDATE_PERMISSION,
+	"ServiceSnooze":      PASSIVATE_PERMISSION,
+	"DomainCreate":       DOMAINS_PERMISSION,
+	"DomainUpdate":       DOMAINS_PERMISSION,
+	"DomainDestroy":      DOMAINS_PERMISSION,
+	"WebhookIndex":       WEBHOOKS_PERMISSION,
+	"WebhookShow":        WEBHOOKS_PERMISSION,
+	"WebhookCreate":      WEBHOOKS_PERMISSION,
+	"WebhookUpdate":      WEBHOOKS_PERMISSION,
+	"WebhookDestroy":     WEBHOOKS_PERMISSION,
+	"WebhookDeliveries":  WEBHOOKS_PERMISSION,
+	"WebhookDeadLetters": WEBHOOKS_PERMISSION,
+	"WebhookRedeliver":   WEBHOOKS_PERMISSION,
+	"APIKeyIndex":        APIKEYS_PERMISSION,
+	"APIKeyShow":         APIKEYS_PERMISSION,
+	"APIKeyCreate":       APIKEYS_PERMISSION,
+	"APIKeyUpdate":       APIKEYS_PERMISSION,
+	"APIKeyDestroy":      APIKEYS_PERMISSION,
+	"AuditIndex":         AUDIT_PERMISSION,
+}
+
+// The permission needed by each action on a service
+var actionPermissions = map[string]string{
+	"start":         START_PERMISSION,
+	"stop":          STOP_PERMISSION,
+	"passivate":     PASSIVATE_PERMISSION,
+	"upgrade":       UPGRADE_PERMISSION,
+	"finishupgrade": UPGRADE_PERMISSION,
+	"rollback":      UPGRADE_PERMISSION,
+}
+
+// A Principal is who a request is made by, and what it may do.
+type Principal struct {
+	Name string
+	// Kind of actor recorded in the audit log, an API key unless set
+	Type        string
+	Role        string
+	Permissions map[string]bool
+	// Patterns of the names of the services it may access, as in
+	// path.Match, all when empty
+	Services []string
+}
+
+// Creates a principal with the permissions of its role and the given ones.
+func NewPrincipal(name string, role string, permissions []string, services []string) (*Principal, error) {
+	principal := &Principal{Name: name, Type: model.APIKEY_ACTOR, Role: role, Permissions: make(map[string]bool), Services: services}
+
+	if role != "" {
+		granted, ok := RolePermissions[role]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown role %s, available roles are %s, %s and %s", role, VIEWER_ROLE, OPERATOR_ROLE, ADMIN_ROLE))
+		}
+		permissions = append(append([]string{}, granted...), permissions...)
+	}
+	for _, permission := range permissions {
+		if !containsString(Permissions, permission) {
+			return nil, errors.New(fmt.Sprintf("Unknown permission %s, available permissions are %v", permission, Permissions))
+		}
+		principal.Permissions[permission] = true
+	}
+	for _, pattern := range services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid service pattern %q", pattern))
+		}
+	}
+	return principal, nil
+}
+
+// Reads the principal of an API key from the configuration. A key without
+// role nor permissions is an admin, as before roles existed.
+func principalFromConf(name string) (*Principal, error) {
+	role := viper.GetString(fmt.Sprintf("apiKeys.%s.role", name))
+	permissions := viper.GetStringSlice(fmt.Sprintf("apiKeys.%s.permissions", name))
+	if role == "" && len(permissions) == 0 {
+		role = ADMIN_ROLE
+	}
+	return NewPrincipal(name, role, permissions, viper.GetStringSlice(fmt.Sprintf("apiKeys.%s.services", name)))
+}
+
+// Tells if the principal has the permission. Without principal, the API is
+// not protected and everything is allowed.
+func (p *Principal) Can(permission string) bool {
+	return p == nil || p.Permissions[permission]
+}
+
+// Tells if the principal may give the access of another one : it has all
+// its permissions, and when it is restricted to some services, the other
+// one is restricted to some of them too.
+func (p *Principal) CanGrant(other *Principal) bool {
+	if p == nil {
+		return true
+	}
+	for permission := range other.Permissions {
+		if !p.Permissions[permission] {
+			return false
+		}
+	}
+	if len(p.Services) == 0 {
+		return true
+	}
+	if len(other.Services) == 0 {
+		return false
+	}
+	for _, pattern := range other.Services {
+		if !containsString(p.Services, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tells if the principal may access the service with the given name.
+func (p *Principal) CanAccessService(name string) bool {
+	return p == nil || len(p.Services) == 0 || matchesAny(p.Services, name)
+}
+
+// Tells if the principal may access the domain : a principal restricted to
+// some services only accesses their domains.
+func (p *Principal) CanAccessDomain(domain *model.Domain) bool {
+	if p == nil || len(p.Services) == 0 {
+		return true
+	}
+	return domain.Typ == model.SERVICE_DOMAIN && p.CanAccessService(domain.Value)
+}
+
+// Tells if the principal may receive the event.
+func (p *Principal) CanAccessEvent(event *model.ModelEvent) bool {
+	if p == nil || len(p.Services) == 0 {
+		return true
+	}
+	if domain, ok := event.Model.(*model.Domain); ok {
+		return p.CanAccessDomain(domain)
+	}
+	name, ok := event.ServiceName()
+	return ok && p.CanAccessService(name)
+}
+
+type contextKey int
+
+const principalContextKey contextKey = 0
+
+// Returns the principal a request has been authenticated as, nil when the
+// API is not protected.
+func principalFrom(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalContextKey).(*Principal)
+	return principal
+}
+
+func withPrincipal(r *http.Request, principal *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey, principal))
+}
+
+// Returns a middleware that authenticates the requests with a bearer token
+// when tokens are accepted, or else with an API key : the keys of the
+// configuration go through the gate, the other ones are looked up in the
+// store. The API is not protected when there are neither tokens nor keys in
+// the configuration or in the store.
+func (s *APIServer) authenticate(gate *restgate.RESTGate) func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		protected := gate != nil || s.tokens != nil || s.hasStoredAPIKeys()
+		if token, ok := bearerToken(r); ok && s.tokens != nil {
+			principal, err := s.tokens.Authenticate(token)
+			if err != nil {
+				log.Warnf("Rejecting bearer token : %s", err.Error())
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid bearer token : "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next(w, withPrincipal(r, principal))
+			return
+		}
+
+		if accessKey := r.Header.Get("AuthKey"); protected && accessKey != "" && s.principals[accessKey] == nil && s.arkenModel.SupportsAPIKeys() {
+			key, err := s.arkenModel.AuthenticateAPIKey(accessKey, r.Header.Get("AuthSecret"))
+			if err == model.ErrAPIKeyNotFound || err == model.ErrInvalidAPIKeySecret || err == model.ErrAPIKeyExpired {
+				http.Error(w, "Invalid API key : "+err.Error(), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			principal, err := apiKeyPrincipal(key)
+			if err != nil {
+				log.Warnf("Rejecting API key %s : %s", key.Id, err.Error())
+				http.Error(w, "Invalid API key : "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next(w, withPrincipal(r, principal))
+			return
+		}
+
+		if gate == nil {
+			if !protected {
+				next(w, r)
+			} else if s.tokens != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "A bearer token is required", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "An API key is required", http.StatusUnauthorized)
+			}
+			return
+		}
+
+		gate.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := s.principals[r.Header.Get("AuthKey")]
+			if !ok {
+				http.Error(w, "Unknown API key", http.StatusUnauthorized)
+				return
+			}
+			next(w, withPrincipal(r, principal))
+		})
+	}
+}
+
+// Tells if the store holds API keys, which protect the API even when the
+// configuration has none. The answer is kept STORED_API_KEYS_CHECK_INTERVAL,
+// and the API is protected when the store can't be read.
+func (s *APIServer) hasStoredAPIKeys() bool {
+	if !s.arkenModel.SupportsAPIKeys() {
+		return false
+	}
+
+	s.storedKeysMutex.Lock()
+	defer s.storedKeysMutex.Unlock()
+	if time.Since(s.storedKeysCheckedAt) > STORED_API_KEYS_CHECK_INTERVAL {
+		keys, err := s.arkenModel.APIKeys()
+		if err != nil {
+			log.Errorf("Unable to read the stored API keys : %s", err.Error())
+		}
+		s.storedKeys = err != nil || len(keys) > 0
+		s.storedKeysCheckedAt = time.Now()
+	}
+	return s.storedKeys
+}
+
+// Returns a middleware that checks that the principal of the request has the
+// permission needed by the route of the router it is sent to, on the
+// service of the route if any. The caller of the requests that modify
+// something is logged.
+func (s *APIServer) authorize(router *mux.Router) func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		principal := principalFrom(r)
+		if principal == nil {
+			next(w, r)
+			return
+		}
+
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			next(w, r)
+			return
+		}
+
+		permission := READ_PERMISSION
+		if match.Route.GetName() == "ServiceAction" {
+			permission = actionPermissions[r.URL.Query().Get("action")]
+		} else if p, ok := routePermissions[match.Route.GetName()]; ok {
+			permission = p
+		}
+
+		if !principal.Can(permission) {
+			http.Error(w, fmt.Sprintf("%s does not have the %s permission", principal.Name, permission), http.StatusForbidden)
+			return
+		}
+		if serviceId, ok := match.Vars["serviceId"]; ok && !principal.CanAccessService(serviceId) {
+			http.Error(w, fmt.Sprintf("%s is not allowed to access service %s", principal.Name, serviceId), http.StatusForbidden)
+			return
+		}
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			log.WithFields(logrus.Fields{
+				"caller": principal.Name,
+				"role":   principal.Role,
+				"route":  match.Route.GetName(),
+			}).Infof("%s %s", r.Method, r.URL.RequestURI())
+		}
+		next(w, r)
+	}
+}
+
+// Middleware that lets the principals that may read the events open a stream.
+func (s *APIServer) authorizeStream(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if principal := principalFrom(r); !principal.Can(READ_PERMISSION) {
+		http.Error(w, fmt.Sprintf("%s does not have the %s permission", principal.Name, READ_PERMISSION), http.StatusForbidden)
+		return
+	}
+	next(w, r)
+}
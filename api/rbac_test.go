@@ -0,0 +1,154 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"github.com/codegangsta/negroni"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Authorizes the requests as the given principal, the requests that are
+// authorized answer 204 without reaching the API.
+func authorizedAs(s *APIServer, principal *Principal) http.Handler {
+	n := negroni.New()
+	n.Use(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(w, withPrincipal(r, principal))
+	}))
+	n.Use(negroni.HandlerFunc(s.authorize(s.getAPIRouter())))
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return n
+}
+
+func authorizedStatus(handler http.Handler, method string, url string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, url, nil))
+	return recorder.Code
+}
+
+type routeCase struct {
+	method     string
+	url        string
+	permission string
+}
+
+// The permission needed by a request on each route
+var routeCases = []routeCase{
+	{"GET", "/api/v1/services", READ_PERMISSION},
+	{"GET", "/api/v1/services/app-1", READ_PERMISSION},
+	{"GET", "/api/v1/services/app-1/history", READ_PERMISSION},
+	{"POST", "/api/v1/services", CREATE_PERMISSION},
+	{"PUT", "/api/v1/services/app-1", UPDATE_PERMISSION},
+	{"DELETE", "/api/v1/services/app-1", DELETE_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=start", START_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=stop", STOP_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=passivate", PASSIVATE_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=upgrade", UPGRADE_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=finishupgrade", UPGRADE_PERMISSION},
+	{"POST", "/api/v1/services/app-1?action=rollback", UPGRADE_PERMISSION},
+	{"POST", "/api/v1/services/app-1/snooze", PASSIVATE_PERMISSION},
+	{"GET", "/api/v1/domains", READ_PERMISSION},
+	{"GET", "/api/v1/domains/app.example.com", READ_PERMISSION},
+	{"POST", "/api/v1/domains/app.example.com", DOMAINS_PERMISSION},
+	{"PUT", "/api/v1/domains/app.example.com", DOMAINS_PERMISSION},
+	{"DELETE", "/api/v1/domains/app.example.com", DOMAINS_PERMISSION},
+	{"GET", "/api/v1/operations", READ_PERMISSION},
+	{"GET", "/api/v1/operations/op-1", READ_PERMISSION},
+	{"GET", "/api/v1/events", READ_PERMISSION},
+	{"GET", "/api/v1/cluster", READ_PERMISSION},
+	{"GET", "/api/v1/passivation/preview", READ_PERMISSION},
+	{"GET", "/api/v1/webhooks", WEBHOOKS_PERMISSION},
+	{"POST", "/api/v1/webhooks", WEBHOOKS_PERMISSION},
+	{"GET", "/api/v1/webhooks/w1", WEBHOOKS_PERMISSION},
+	{"PUT", "/api/v1/webhooks/w1", WEBHOOKS_PERMISSION},
+	{"DELETE", "/api/v1/webhooks/w1", WEBHOOKS_PERMISSION},
+	{"GET", "/api/v1/webhooks/w1/deliveries", WEBHOOKS_PERMISSION},
+	{"GET", "/api/v1/webhooks/w1/deadletters", WEBHOOKS_PERMISSION},
+	{"POST", "/api/v1/webhooks/w1/deadletters/d1", WEBHOOKS_PERMISSION},
+	{"GET", "/api/v1/apikeys", APIKEYS_PERMISSION},
+	{"POST", "/api/v1/apikeys", APIKEYS_PERMISSION},
+	{"GET", "/api/v1/apikeys/k1", APIKEYS_PERMISSION},
+	{"PUT", "/api/v1/apikeys/k1", APIKEYS_PERMISSION},
+	{"DELETE", "/api/v1/apikeys/k1", APIKEYS_PERMISSION},
+	{"GET", "/api/v1/audit", AUDIT_PERMISSION},
+}
+
+func Test_Authorize(t *testing.T) {
+	s := NewAPIServer(nil)
+
+	for _, role := range []string{VIEWER_ROLE, OPERATOR_ROLE, ADMIN_ROLE} {
+		role := role
+		Convey("Given a principal with the "+role+" role", t, func() {
+			principal, err := NewPrincipal("caller", role, nil, nil)
+			So(err, ShouldBeNil)
+			handler := authorizedAs(s, principal)
+
+			Convey("Then it reaches the routes whose permission its role grants", func() {
+				for _, c := range routeCases {
+					expected := http.StatusForbidden
+					if containsString(RolePermissions[role], c.permission) {
+						expected = http.StatusNoContent
+					}
+					So(authorizedStatus(handler, c.method, c.url), ShouldEqual, expected)
+				}
+			})
+
+			Convey("Then an unknown action is forbidden", func() {
+				So(authorizedStatus(handler, "POST", "/api/v1/services/app-1?action=explode"), ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("Then an unknown route is left to the router", func() {
+				So(authorizedStatus(handler, "GET", "/api/v1/unknown"), ShouldEqual, http.StatusNoContent)
+			})
+		})
+	}
+
+	Convey("Given a viewer that may also manage the webhooks", t, func() {
+		principal, err := NewPrincipal("caller", VIEWER_ROLE, []string{WEBHOOKS_PERMISSION}, nil)
+		So(err, ShouldBeNil)
+		handler := authorizedAs(s, principal)
+
+		Convey("Then it reaches the webhooks but can't act on the services", func() {
+			So(authorizedStatus(handler, "POST", "/api/v1/webhooks"), ShouldEqual, http.StatusNoContent)
+			So(authorizedStatus(handler, "GET", "/api/v1/services"), ShouldEqual, http.StatusNoContent)
+			So(authorizedStatus(handler, "POST", "/api/v1/services/app-1?action=start"), ShouldEqual, http.StatusForbidden)
+		})
+	})
+
+	Convey("Given an operator restricted to the app-* services", t, func() {
+		principal, err := NewPrincipal("caller", OPERATOR_ROLE, nil, []string{"app-*"})
+		So(err, ShouldBeNil)
+		handler := authorizedAs(s, principal)
+
+		Convey("Then it only reaches the routes of its services", func() {
+			So(authorizedStatus(handler, "POST", "/api/v1/services/app-1?action=start"), ShouldEqual, http.StatusNoContent)
+			So(authorizedStatus(handler, "POST", "/api/v1/services/prod-db?action=start"), ShouldEqual, http.StatusForbidden)
+			So(authorizedStatus(handler, "GET", "/api/v1/services/prod-db/history"), ShouldEqual, http.StatusForbidden)
+			So(authorizedStatus(handler, "GET", "/api/v1/services"), ShouldEqual, http.StatusNoContent)
+		})
+	})
+
+	Convey("Given a request without principal", t, func() {
+		handler := authorizedAs(s, nil)
+
+		Convey("Then the API is not protected", func() {
+			So(authorizedStatus(handler, "DELETE", "/api/v1/services/app-1"), ShouldEqual, http.StatusNoContent)
+			So(authorizedStatus(handler, "GET", "/api/v1/audit"), ShouldEqual, http.StatusNoContent)
+		})
+	})
+}
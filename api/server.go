@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/passivation"
+	"github.com/arkenio/arken/webhook"
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -27,6 +29,8 @@ import (
 	"github.com/spf13/viper"
 	"gopkg.in/tylerb/graceful.v1"
 	"html/template"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -48,6 +52,19 @@ type APIServer struct {
 	// Needed for websocket
 	upgrader   websocket.Upgrader
 	hub        *hub
+	// Previewed by the passivation endpoint, when set
+	Passivation *passivation.PassivationHandler
+	// Managed by the webhook endpoints, when set
+	Webhooks *webhook.Dispatcher
+	// The principals of the API keys, by access key, nil when the API is
+	// not protected
+	principals map[string]*Principal
+	// Validates the bearer tokens, nil when they are not accepted
+	tokens *TokenAuthenticator
+	// Whether there were API keys in the store when last checked
+	storedKeysMutex     sync.Mutex
+	storedKeys          bool
+	storedKeysCheckedAt time.Time
 }
 
 func NewAPIServer(model *model.Model) *APIServer {
@@ -89,21 +106,23 @@ func (s *APIServer) Start() {
 
 func (s *APIServer) getRoutes() *mux.Router {
 	gate := s.getRestGate()
+	s.tokens = s.getTokenAuthenticator()
 
 	// Main API
 	negAPI := negroni.New()
-	if gate != nil {
-		negAPI.Use(gate)
-	}
+	negAPI.Use(negroni.HandlerFunc(s.authenticate(gate)))
 	negAPI.Use(negronilogrus.NewMiddleware())
-	negAPI.UseHandler(s.getAPIRouter())
+	negAPI.Use(negroni.HandlerFunc(s.leaderOnly))
+	apiRouter := s.getAPIRouter()
+	negAPI.Use(negroni.HandlerFunc(s.authorize(apiRouter)))
+	negAPI.Use(negroni.HandlerFunc(s.audit(apiRouter)))
+	negAPI.UseHandler(apiRouter)
 
 
 	// WebSocket
 	ws := negroni.New()
-	if gate != nil {
-		ws.Use(gate)
-	}
+	ws.Use(negroni.HandlerFunc(s.authenticate(gate)))
+	ws.Use(negroni.HandlerFunc(s.authorizeStream))
 	ws.UseHandlerFunc(s.serveWs)
 
 
@@ -111,6 +130,7 @@ func (s *APIServer) getRoutes() *mux.Router {
 
 	mainRouter.PathPrefix("/doc").Handler(http.FileServer(FS(false)))
 	mainRouter.PathPrefix("/swagger.yaml").HandlerFunc(serveSwaggerYaml)
+	mainRouter.Path("/metrics").Handler(metricsHandler(s.arkenModel))
 	mainRouter.PathPrefix("/api").Handler(negAPI)
 	mainRouter.PathPrefix("/ws").Handler(ws)
 
@@ -121,10 +141,17 @@ func (s *APIServer) getRestGate() *restgate.RESTGate {
 	if apiKeys := viper.GetStringMap("apiKeys"); len(apiKeys) > 0 {
 		Key := make([]string, len(apiKeys))
 		Secret := make([]string, len(apiKeys))
+		s.principals = make(map[string]*Principal)
 		i := 0
 		for k, value := range apiKeys {
 			key, value := s.extractKeyValueFromConf(value)
 			if key != "" && value != "" {
+				principal, err := principalFromConf(k)
+				if err != nil {
+					log.Warnf("Unable to parse permissions of accessKey %s : %s", k, err.Error())
+					continue
+				}
+				s.principals[key] = principal
 				Key[i] = key
 				log.Infof("Adding key for %s : %s", k, Key[i])
 				Secret[i] = value
@@ -141,6 +168,48 @@ func (s *APIServer) getRestGate() *restgate.RESTGate {
 	}
 }
 
+// Returns the authenticator of the bearer tokens configured by the oidc
+// key, nil when there is no key set.
+func (s *APIServer) getTokenAuthenticator() *TokenAuthenticator {
+	location := viper.GetString("oidc.jwks")
+	if location == "" {
+		return nil
+	}
+
+	nameClaim := viper.GetString("oidc.nameClaim")
+	if nameClaim == "" {
+		nameClaim = DEFAULT_NAME_CLAIM
+	}
+	roleClaim := viper.GetString("oidc.roleClaim")
+	if roleClaim == "" {
+		roleClaim = DEFAULT_ROLE_CLAIM
+	}
+
+	roles := make(map[string]string)
+	for value, role := range viper.GetStringMapString("oidc.roles") {
+		if _, ok := RolePermissions[role]; !ok {
+			log.Warnf("Ignoring the mapping of %s to unknown role %s", value, role)
+			continue
+		}
+		roles[strings.ToLower(value)] = role
+	}
+
+	jwks := NewJWKS(location)
+	if _, err := jwks.Key(""); err != nil && err != ErrUnknownSigningKey {
+		log.Warnf("Bearer tokens are rejected until the key set can be read")
+	}
+	log.Infof("Accepting bearer tokens signed by the keys of %s", location)
+
+	return &TokenAuthenticator{
+		JWKS:      jwks,
+		Issuer:    viper.GetString("oidc.issuer"),
+		Audience:  viper.GetString("oidc.audience"),
+		NameClaim: nameClaim,
+		RoleClaim: roleClaim,
+		Roles:     roles,
+	}
+}
+
 func (s *APIServer) extractKeyValueFromConf(value interface{}) (string, string) {
 	var k, v string
 	defer func() (string, string) {
@@ -196,6 +265,18 @@ func (s *APIServer) getAPIRouter() *mux.Router {
 			"/services/{serviceId}",
 			s.ServiceUpdate(),
 		},
+		Route{
+			"ServiceHistory",
+			"GET",
+			"/services/{serviceId}/history",
+			s.ServiceHistory,
+		},
+		Route{
+			"ServiceSnooze",
+			"POST",
+			"/services/{serviceId}/snooze",
+			s.ServiceSnooze,
+		},
 		Route{
 			"DomainShow",
 			"GET",
@@ -208,6 +289,138 @@ func (s *APIServer) getAPIRouter() *mux.Router {
 			"/domains",
 			s.DomainIndex,
 		},
+		Route{
+			"DomainCreate",
+			"POST",
+			"/domains/{domain}",
+			s.DomainCreate,
+		},
+		Route{
+			"DomainUpdate",
+			"PUT",
+			"/domains/{domain}",
+			s.DomainUpdate,
+		},
+		Route{
+			"DomainDestroy",
+			"DELETE",
+			"/domains/{domain}",
+			s.DomainDestroy,
+		},
+		Route{
+			"OperationIndex",
+			"GET",
+			"/operations",
+			s.OperationIndex,
+		},
+		Route{
+			"OperationShow",
+			"GET",
+			"/operations/{operationId}",
+			s.OperationShow,
+		},
+		Route{
+			"EventStream",
+			"GET",
+			"/events",
+			s.EventStream,
+		},
+		Route{
+			"ClusterShow",
+			"GET",
+			"/cluster",
+			s.ClusterShow,
+		},
+		Route{
+			"PassivationPreview",
+			"GET",
+			"/passivation/preview",
+			s.PassivationPreview,
+		},
+		Route{
+			"WebhookIndex",
+			"GET",
+			"/webhooks",
+			s.WebhookIndex,
+		},
+		Route{
+			"WebhookCreate",
+			"POST",
+			"/webhooks",
+			s.WebhookCreate,
+		},
+		Route{
+			"WebhookShow",
+			"GET",
+			"/webhooks/{webhookId}",
+			s.WebhookShow,
+		},
+		Route{
+			"WebhookUpdate",
+			"PUT",
+			"/webhooks/{webhookId}",
+			s.WebhookUpdate,
+		},
+		Route{
+			"WebhookDestroy",
+			"DELETE",
+			"/webhooks/{webhookId}",
+			s.WebhookDestroy,
+		},
+		Route{
+			"WebhookDeliveries",
+			"GET",
+			"/webhooks/{webhookId}/deliveries",
+			s.WebhookDeliveries,
+		},
+		Route{
+			"WebhookDeadLetters",
+			"GET",
+			"/webhooks/{webhookId}/deadletters",
+			s.WebhookDeadLetters,
+		},
+		Route{
+			"WebhookRedeliver",
+			"POST",
+			"/webhooks/{webhookId}/deadletters/{deliveryId}",
+			s.WebhookRedeliver,
+		},
+		Route{
+			"APIKeyIndex",
+			"GET",
+			"/apikeys",
+			s.APIKeyIndex,
+		},
+		Route{
+			"APIKeyCreate",
+			"POST",
+			"/apikeys",
+			s.APIKeyCreate,
+		},
+		Route{
+			"APIKeyShow",
+			"GET",
+			"/apikeys/{keyId}",
+			s.APIKeyShow,
+		},
+		Route{
+			"APIKeyUpdate",
+			"PUT",
+			"/apikeys/{keyId}",
+			s.APIKeyUpdate,
+		},
+		Route{
+			"APIKeyDestroy",
+			"DELETE",
+			"/apikeys/{keyId}",
+			s.APIKeyDestroy,
+		},
+		Route{
+			"AuditIndex",
+			"GET",
+			"/audit",
+			s.AuditIndex,
+		},
 	}
 
 	apiRouter := mux.NewRouter()
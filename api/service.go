@@ -22,6 +22,9 @@ import (
 	"io"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (s *APIServer) ServiceIndex(w http.ResponseWriter, r *http.Request) {
@@ -30,9 +33,14 @@ func (s *APIServer) ServiceIndex(w http.ResponseWriter, r *http.Request) {
 
 	services := make(map[string]*goarken.Service)
 
-	for _, service := range s.arkenModel.Services {
+	principal := principalFrom(r)
+	now := time.Now()
+	for _, service := range s.arkenModel.Services() {
+		if !principal.CanAccessService(service.Name) {
+			continue
+		}
 		if statusFilter == "" || statusFilter == service.Status.Compute() {
-			services[service.Name] = service
+			services[service.Name] = withNextTransition(service, now)
 		}
 	}
 
@@ -44,11 +52,12 @@ func (s *APIServer) ServiceIndex(w http.ResponseWriter, r *http.Request) {
 func (s *APIServer) ServiceShow(w http.ResponseWriter, r *http.Request) {
 	serviceId := mux.Vars(r)["serviceId"]
 
-	if s, ok := s.arkenModel.Services[serviceId]; ok {
-		//create new instance to override the actions with a pretty format
+	if ss, ok := s.arkenModel.GetService(serviceId); ok {
+		//the model returns a copy, so the actions can be overriden with a pretty format
 		w.Header().Add("Content-Type", "application/json")
-		ss := *s
-		ss.Actions = goarken.GetPrettyActions(&ss, r.URL)
+		w.Header().Set("ETag", revisionETag(ss.Revision))
+		ss.Actions = goarken.GetPrettyActions(ss, r.URL)
+		withNextTransition(ss, time.Now())
 		if err := json.NewEncoder(w).Encode(ss); err != nil {
 			http.Error(w, err.Error(), 500)
 		}
@@ -64,7 +73,7 @@ func (s *APIServer) run(methodName string) func(w http.ResponseWriter, r *http.R
 
 		serviceId := mux.Vars(r)["serviceId"]
 
-		if service, ok := s.arkenModel.Services[serviceId]; ok {
+		if service, ok := s.arkenModel.GetService(serviceId); ok {
 			value = reflect.ValueOf(s.arkenModel)
 			err := value.MethodByName(methodName).Call([]reflect.Value{reflect.ValueOf(service)})
 			if err != nil {
@@ -100,11 +109,20 @@ func (s *APIServer) ServiceCreate() func(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		if entry := auditEntryFrom(r); entry != nil {
+			entry.ServiceName = service.Name
+		}
+
+		if principal := principalFrom(r); !principal.CanAccessService(service.Name) {
+			http.Error(w, fmt.Sprintf("%s is not allowed to access service %s", principal.Name, service.Name), http.StatusForbidden)
+			return
+		}
+
 		log.Infof("Creating service %s", service.Name)
 		_, err = s.arkenModel.CreateService(service, false)
 		if err != nil {
 			log.Errorf("Error when creating service %s : %s", service.Name, err.Error())
-			http.Error(w, err.Error(), 500)
+			http.Error(w, err.Error(), serviceErrorStatus(err))
 			return
 		}
 
@@ -121,11 +139,16 @@ func (s *APIServer) ServiceCreate() func(w http.ResponseWriter, r *http.Request)
 func (s *APIServer) ServiceDestroy() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		serviceId := mux.Vars(r)["serviceId"]
-		service := s.arkenModel.Services[serviceId]
+		service, ok := s.arkenModel.GetService(serviceId)
+		if !ok {
+			http.Error(w, "Service not found", http.StatusNotFound)
+			return
+		}
 		err := s.arkenModel.DestroyService(service)
 		if err == nil {
 			io.WriteString(w, "{\"serviceDestroyed\":\"ok\"}")
 		} else {
+			auditFailure(r, err)
 			io.WriteString(w, fmt.Sprintf("{\"serviceDestroyed\":\"ko\", \"error\":\"%s\"}}", err))
 		}
 
@@ -140,6 +163,8 @@ func (e NotFoundError) Error() string {
 	return fmt.Sprintf("Service %s not found", e.serviceId)
 }
 
+// Runs the action in the background : the response is a 202 with the
+// operation that tracks it, also given by the Location header.
 func (s *APIServer) ServiceAction() func(w http.ResponseWriter, r *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -147,15 +172,40 @@ func (s *APIServer) ServiceAction() func(w http.ResponseWriter, r *http.Request)
 		serviceId := mux.Vars(r)["serviceId"]
 		serviceAction := r.URL.Query().Get("action")
 
-		if serviceCluster, ok := s.arkenModel.Services[serviceId]; !ok {
+		if !isServiceAction(serviceAction) {
+			http.Error(w, "Method not available", http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := s.arkenModel.GetService(serviceId); !ok {
 			http.Error(w, "Service not found", http.StatusNotFound)
-		} else {
-			err := s.runMethodFromAction(r, serviceAction, serviceCluster)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			} else {
-				s.ServiceShow(w, r)
+			return
+		}
+
+		operation, err := s.arkenModel.StartOperation(serviceId, serviceAction, func(progress func(int)) error {
+			// The service is read again, it may have changed since the request
+			service, ok := s.arkenModel.GetService(serviceId)
+			if !ok {
+				return NotFoundError{serviceId}
 			}
+			before := s.arkenModel.ServiceStatus(serviceId)
+			err := s.runMethodFromAction(r, serviceAction, service)
+			s.auditServiceAction(r, serviceAction, serviceId, before, err)
+			return err
+		})
+		if err == goarken.ErrOperationInProgress {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/operations/%s", operation.Id))
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(operation); err != nil {
+			log.Errorf("Unable to encode operation %s : %v", operation.Id, err)
 		}
 	}
 
@@ -170,15 +220,120 @@ func (s *APIServer) ServiceUpdate() func(w http.ResponseWriter, r *http.Request)
 
 		if err != nil {
 			http.Error(w, "Unable to read service : "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The If-Match header takes precedence over the revision of the body
+		if revision, err := ifMatchRevision(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if revision != 0 {
+			updatedService.Revision = revision
 		}
+
+		// The service of the URL is the one the caller has been authorized on
 		serviceId := mux.Vars(r)["serviceId"]
+		if updatedService.Name != "" && updatedService.Name != serviceId {
+			http.Error(w, fmt.Sprintf("The service of the body, %s, is not the one of the URL, %s", updatedService.Name, serviceId), http.StatusBadRequest)
+			return
+		}
+		updatedService.Name = serviceId
 
-		if _, ok := s.arkenModel.Services[serviceId]; !ok {
+		if _, ok := s.arkenModel.GetService(serviceId); !ok {
 			http.Error(w, "Service not found", http.StatusNotFound)
+		} else if _, err := s.arkenModel.UpdateService(updatedService); err != nil {
+			// Conflicts on the revision or on the domain of the service
+			http.Error(w, err.Error(), serviceErrorStatus(err))
 		} else {
-			s.arkenModel.UpdateService(updatedService)
+			s.ServiceShow(w, r)
+		}
+	}
+}
+
+// Returns the history of a service, filtered by the since, until and limit
+// query parameters as the audit log. The history outlives the service, it is
+// only not found when it is empty.
+func (s *APIServer) ServiceHistory(w http.ResponseWriter, r *http.Request) {
+	serviceId := mux.Vars(r)["serviceId"]
+	query := r.URL.Query()
+
+	since, err := queryTime(query.Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := queryTime(query.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryLimit(query.Get("limit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.arkenModel.ServiceHistory(serviceId, since, until, limit)
+	if err == goarken.ErrHistoryNotSupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := s.arkenModel.GetService(serviceId); !ok && len(events) == 0 {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, events, http.StatusOK)
+}
+
+// Sets the next scheduled transition of a copy of a service.
+func withNextTransition(service *goarken.Service, now time.Time) *goarken.Service {
+	if service.Config != nil {
+		service.NextTransition = service.Config.Passivation.NextTransition(now)
+	}
+	return service
+}
+
+// Maps the errors of the model about services to an HTTP status.
+func serviceErrorStatus(err error) int {
+	if _, ok := err.(*goarken.InvalidPassivationError); ok {
+		return http.StatusBadRequest
+	}
+	return domainErrorStatus(err)
+}
+
+// Revisions are exposed as strong ETags.
+func revisionETag(revision int64) string {
+	return fmt.Sprintf("\"%d\"", revision)
+}
+
+// Returns the revision given by the If-Match header of the request, or 0
+// when there is none or when it matches any revision.
+func ifMatchRevision(r *http.Request) (int64, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), "\""), 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid If-Match header : " + ifMatch)
+	}
+	return revision, nil
+}
+
+var serviceActions = []string{"start", "stop", "passivate", "upgrade", "finishupgrade", "rollback"}
+
+func isServiceAction(actionName string) bool {
+	for _, action := range serviceActions {
+		if action == actionName {
+			return true
 		}
 	}
+	return false
 }
 
 func (s *APIServer) runMethodFromAction(r *http.Request, actionName string, service *goarken.Service) error {
@@ -189,13 +344,13 @@ func (s *APIServer) runMethodFromAction(r *http.Request, actionName string, serv
 	case "stop":
 		_, err = s.arkenModel.StopService(service)
 	case "passivate":
-		s.arkenModel.PassivateService(service)
+		_, err = s.arkenModel.PassivateService(service)
 	case "upgrade":
-		s.arkenModel.UpgradeService(service)
+		_, err = s.arkenModel.UpgradeService(service)
 	case "finishupgrade":
-		s.arkenModel.FinishUpgradeService(service)
+		_, err = s.arkenModel.FinishUpgradeService(service)
 	case "rollback":
-		s.arkenModel.RollbackService(service)
+		_, err = s.arkenModel.RollbackService(service)
 	default:
 		return errors.New("Method not available")
 	}
@@ -205,7 +360,7 @@ func (s *APIServer) runMethodFromAction(r *http.Request, actionName string, serv
 func (s *APIServer) serviceNeedToBeUpgraded() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		serviceId := mux.Vars(r)["serviceId"]
-		if service, ok := s.arkenModel.Services[serviceId]; !ok {
+		if service, ok := s.arkenModel.GetService(serviceId); !ok {
 			http.Error(w, "Service not found", http.StatusNotFound)
 		} else {
 			log.Infof("Check if service needs to be upgarded for the service %v", serviceId)
@@ -0,0 +1,140 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	"github.com/codegangsta/negroni"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serves the API as the given principal, without authentication.
+func handlerAs(s *APIServer, principal *Principal) http.Handler {
+	router := s.getAPIRouter()
+	n := negroni.New()
+	n.Use(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(w, withPrincipal(r, principal))
+	}))
+	n.Use(negroni.HandlerFunc(s.authorize(router)))
+	n.UseHandler(router)
+	return n
+}
+
+func Test_ServiceUpdate(t *testing.T) {
+
+	Convey("Given a principal restricted to the app-* services", t, func() {
+		dir, err := ioutil.TempDir("", "arken-api")
+		So(err, ShouldBeNil)
+		driver, err := storage.NewBoltDriver(filepath.Join(dir, "arken.db"))
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+
+		for _, name := range []string{"app-1", "prod-db"} {
+			service := &model.Service{Name: name}
+			service.Init()
+			service.Config = &model.ServiceConfig{Environment: map[string]interface{}{"ROLE": name}}
+			_, err := arkenModel.CreateService(service, false)
+			So(err, ShouldBeNil)
+		}
+
+		principal, err := NewPrincipal("deployer", OPERATOR_ROLE, nil, []string{"app-*"})
+		So(err, ShouldBeNil)
+		handler := handlerAs(NewAPIServer(arkenModel), principal)
+
+		update := func(url string, body string) int {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("PUT", url, strings.NewReader(body)))
+			return recorder.Code
+		}
+		updateRevision := func(url string, revision int64, body string) int {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("PUT", url, strings.NewReader(body))
+			request.Header.Set("If-Match", revisionETag(revision))
+			handler.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+		environment := func(name string) interface{} {
+			service, _ := arkenModel.GetService(name)
+			return service.Config.Environment["ROLE"]
+		}
+
+		Convey("When it updates a service it accesses with a body naming another service", func() {
+			status := update("/api/v1/services/app-1", `{"name":"prod-db","config":{"environment":{"ROLE":"hacked"}}}`)
+
+			Convey("Then the update is rejected and no service is modified", func() {
+				So(status, ShouldEqual, http.StatusBadRequest)
+				So(environment("prod-db"), ShouldEqual, "prod-db")
+				So(environment("app-1"), ShouldEqual, "app-1")
+			})
+		})
+
+		Convey("When it updates a service it accesses with a body without name", func() {
+			status := update("/api/v1/services/app-1", `{"config":{"environment":{"ROLE":"web"}}}`)
+
+			Convey("Then the service of the URL is updated", func() {
+				So(status, ShouldEqual, http.StatusOK)
+				So(environment("app-1"), ShouldEqual, "web")
+			})
+		})
+
+		Convey("When it updates a service it does not access", func() {
+			status := update("/api/v1/services/prod-db", `{"name":"prod-db","config":{"environment":{"ROLE":"hacked"}}}`)
+
+			Convey("Then the update is forbidden", func() {
+				So(status, ShouldEqual, http.StatusForbidden)
+				So(environment("prod-db"), ShouldEqual, "prod-db")
+			})
+		})
+
+		Convey("When it updates a service from its revision", func() {
+			service, _ := arkenModel.GetService("app-1")
+			status := updateRevision("/api/v1/services/app-1", service.Revision, `{"config":{"environment":{"ROLE":"web"}}}`)
+
+			Convey("Then the service is updated", func() {
+				So(status, ShouldEqual, http.StatusOK)
+				So(environment("app-1"), ShouldEqual, "web")
+			})
+
+			Convey("Then the outdated revision can't be updated anymore", func() {
+				status := updateRevision("/api/v1/services/app-1", service.Revision, `{"config":{"environment":{"ROLE":"worker"}}}`)
+				So(status, ShouldEqual, http.StatusConflict)
+				So(environment("app-1"), ShouldEqual, "web")
+			})
+		})
+
+		Convey("When it updates a service from a revision that is not stored yet", func() {
+			service, _ := arkenModel.GetService("app-1")
+			status := updateRevision("/api/v1/services/app-1", service.Revision+1, `{"config":{"environment":{"ROLE":"web"}}}`)
+
+			Convey("Then a conflict is returned and the service is not modified", func() {
+				So(status, ShouldEqual, http.StatusConflict)
+				So(environment("app-1"), ShouldEqual, "app-1")
+			})
+		})
+
+		Reset(func() {
+			driver.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
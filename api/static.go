@@ -13668,30 +13668,105 @@ FcqX8253E25CdipISu/J/wYAAP//d4IZPRL9BAA=
 
 	"/swagger.tpl": {
 		local:   "static/swagger.tpl",
-		size:    5685,
-		modtime: 1473153385,
+		size:    36102,
+		modtime: 1792232388,
 		compressed: `
-H4sIAAAJbogA/+RYzY7bNhC+6ykGTgFfNrJ3syfdgm3aGgjaRd2eihxoaWwzlUiGpLw2mr57h9SPKUv2
-ardNs0ByWYOa/2/m4zDmgW02qBOY3sTzacTFWiYRgOU2xwTe6j9RwNv7BR3tUBsuRQKTeTyPrydR9AqY
-1uwAcg0sz8GkWyzQgN0yCwdZaqcIplRKamui+rOz/hq21ipn4IGT4gpBaVzzPWZgpbelmN2aaMUM3tOv
-BGZM8dnuOlJaZmXaWGFK5TxllsKafTRSRF7NfZsZ1DteCwJs0FY/gOIpCqYPCfyI1sWKkHNjXQ6NSi2o
-mGYFWsq6UXUuBZ1RCYxltjST9gMAd5X5VKI+hKf2oEjcWM3FJjhGURYJ/EFmpFKYTa6cQW1JqPnpD1Fr
-qemvYsbwHbO1JOk4yQ+1QY1GSWEwiPNmPk8CdxmaVHNlPXy/Ucp1qoGIR4eFSk3wHuPOObdYmK4owHeE
-IHXRq1lGSArufJnZsvJzl5eGCjn1KkqaPhh3Gik9A+wktE7kn1uX58SHMfPQrGTWQaYG8vS443BSxw/H
-nKru3jLjOpUaN/WRZKEJjZ9KrjFLwOrysRpXZZsMlu0HqX2idDb50liPgi8KJ2v2V/1rkf19fsqWW/kQ
-4DQ1NOpSIfV6O2hnMK407TGDQLNCAffUS0jtCKyQYuOmmMQ1faTxMHRuoJrTeMRIt8mczLSjlHPlXWSO
-N+xgkc92QYcSXgSsjfDt/HaMS8gkISCkA4Co8zkRvHPENq2yV2W/a35XmZ/vfmnPNMt5hZeH+TGK5xOT
-g6P0OUOfo2L4ReQH+F4WjIsruJNizTfxO7HjWooCBc2LyJrj+/pucdy2ZTsEXK8xteb/YbTBy2DpLsDZ
-ku65WRMdjm+FkdovuS+qKFjqLJ+E4DeMLx9Du6JM/TYyvZq6tYP+tKvI9MM3Tl6Vl5xaqNfA5NxqeRi7
-n3z9pvtGIQyOnFI3rspMVSy5+kicWDFWu4Q0fjx80YXqDqTidE6gg7Ty2ywrXNDoiTTEZGgh75geeM4c
-7cStTm+BvwTT9FiZ0SXJZfUqO/oY9PC+FmtaIPM31sVaPlrsauN7xPHSCzVuU38TPqZSN4eX9atL/077
-71pmRCWeE7WTwz0rVI6dUEDsuXw9d/+uuxEAbVU2znDH5Rm/mpqLtu5F/T8HbcRIXuieWBABpbIoSgrp
-kIhyjzKZt3LquH10Rz9nh4VYIjnLTAK3b4iO/PPjfaezRpT6p2DDGKzjvdQ9AS4sblB7lIN+GuGO5Xx3
-Gdq01Jp2sIsy9LIhF8Tdw0IBVwVgjIhOy5W0PUK5RFafA1QccREg1m2QNXdVBmO7t9WTbCPpEcia97Gj
-oOaGCNbPU/9ByAC/DrXTYG8HktXTtKc6oiJBmz6RwmtvrQUoDWawlrqqxOn9SJGzMre0GTebNR4r8jTv
-p5hYTLeCpyxvZjEsNu0Icd/hz8+4syg/TZsCy9gqP6YfGPVcEv+7K2CLLLdu5p4cXhPQhgZQVHa23tDw
-ilSP4fLkunjclzfltRyv0evVoa5lEbocItrLhBj5DvZ7yejeTWXWq1LDXM0p9WPBrD9/c1OfFmgM21wu
-8Jpjnp2ryz8BAAD//5OK3K81FgAA
+H4sIAAAAAAACA+09a2/bSJLf/Ssa2QM8s5AlJ5NZYP3l4EsyN8ZmZow4wRzuMB9aYkvimGJz2aRlbXb/
++1VVP9h8SGpSUuzMXRAkEtWP6q53dXVRrfliIfIrdv5qfHl+FqdzeXXGWBEXibhi1/m9SNn17Q08ehC5
+imV6xV5cji/HL1+cnf2J8TznGybnjCcJU7OlWAnFiiUv2EaWOXZkqswymRfqzPyMo1+wZVFkOMA6ho5T
+wbJczONHEbFC0lgZL5bqbMqVuIVPV2zCs3jy8PIsy2VUzuwoPMuSeMYLAGvyu5LpGXXD3yZK5A+xacjY
+QhT6AwN4Viueb67Yf4oCYRUsiVWBa7BdTMOM53wlCli17YpTpvAMtkAVvCjVC/cDYzHuzN9LkW/8p8Um
+g+aqyON04T0Wabm6Yv8Dw8gsE9GLEQ6YF9DIfqSHIs9lDv9nXKn4gRemJfTBlr+ZAXOhMpkq4cH56vLy
+ypsuEmqWx1lB6PsISzZL9ZoQdrjfyQJPOK49jwuxUvWmjP0bYBCo6E+TCDCZxjiXmtzped4kpYKNPKcu
+mVRtZLzJBSxPMd4ArQb5P92U25p344xQM5VRDTMGkc3HtQlfGPhZtSZN3UuukFKBcGcESeQPkYu/l3Eu
+oitW5OW+Pdbb9qJz236QOS0Unr04Na6D0Hfmc9bks/l0E/1rO5fdLeXaw9O5AlaXmQBad4y2Bce6Z1Gt
+wOupsSAegZYEkCPjK5kukIuheQ4/AnsoeK6Y5tNxAEu7xTR4GkXKtu29iVBuFJ2bvJUKaiLhmGhdCh7V
+loZ/3n3kiyarbhFKndPk4iFGsb99oYOpyTZ+ffk6ZKUskoD4VCLeQWIPgeAdytNzvelZ2SbWT1lEYqW9
+0C00ur3D8yO1JhQ384ufeDFbNoDQVLQNDCSnBiAVieDDknaExYqh8gag03EnHaHcxVYlNlovwcpYxaDk
+0sW4c2FznqiAlQ2X9B8r2NtCf8x+SZMNeytXPE5H7I1M5/Fi/C59iHOZrkQKAiiN7ONbo6xxsUv+IJiY
+z8WsUM9YReiFR19KpqRifQK54guU3csl2kOqAMWUapVhaRn0iqZjmQoL2qcP7w8QNk8m5aqJ/xo0Mdo0
+U4GMKKN4HiM5xCltiWCL+AF+sDg7EKhOA/AOjd7JHdi2k0/ZIgeqm/wAndXSfvsgk2TKZ/cTy18iXEx3
+DX5jBue5P/jO0U8u08cM+Bm4kUAc1YQsikr9PGIx+FvERJ5ZugbsZaVaojSNiyW7/fQRnaqHWJYq2Rys
+LPisqCMe10bezukVlnOXzskzOh+dowsE/5kNgU9zIpXqe27wCR+d73T+205r69VOJtHrZ3mZgl2pJQSO
+v8hlmUYaU2ibaqlf5PAT2p/7BOl7qZ3XQ4QpSCe70Q6CIQz6i+0cLkfNppxAYj1DUXmdehgGbuQJ6F20
+YVL0SxZAU4oZG+gAXeYBFIkEZE1LUAJQRS43ob7v01ucT+uyPiFNdXrKE5VK+Q+D1m5NSA20T5F5tiRG
+2cLU3ce63sDVVBGkETNBJwaqxhATfJluyF/2J5zLvFL+bvCo1DwwAqsdxnsA4wk7oix4iIsNWcLkecN+
+RWVC+iiNwJUffzWekUZRAwQ0Gg+z4JuUSrOcH8AlNED0RU1ni31HV1LBUA/i65T63Ry6hGElcOL+yLFp
+2ZM1Z+BjLGANGHKm8BTxDHxLjDnQoOcR/V44havQDEFdExfkW7vBLTiAm3uRgVM6L0TetCIdy4+IwU2f
+cQ4cmeLoN+lbvvmamBWdlD6GKbnyCAHIrhQQOc/lCkOGgNJ4BZsN4yUlmNnD4xAMd3bFC1DWICEvcNgW
+2CVsdnII2FMBk4gK8NOCm8SrmjkbDm6CAbLCQD1iLy8vL1HZAD/yMil6QB2nhVi4yNQAeWlBMAgHezlB
+ZsBAfqGjEmKvPDn8SORHzXDvEJhwuYtIUcwoZEIGACFIDD7wJI6eTP6SbMLQQSqtLDkCLN9fvtwNC8zD
+FyBKeXpe0DfhCeTqaGGgWogo0Nfn7ND02C38XS/TGmVNJB619YV8Nq5ij7oFIVkHX8irh86xC1aN3MBE
+zBTHkikGtZTtHyLGkaR7czaoizJPa6vRgp0XzQH3+fTGBAF3Po/RdRcRSIFZcf5bW9STtjwIVtXY3/US
+T5D8EBgtoTXTcfyaOpXsljFy+jvsgkeNk8/6Q8Bhm27YRYztQzXfdjEdRx5aNV2tYyVCaKk2cYg98DN0
+sxZBq/OJvUy7WKk9mAOC33tPzVpL28oY+6SwwcpRQx97TuRrwD9P7FcwNNy0Ds+trVthPGdigzYtB8AU
+vO36DGmn1/cygAAOnzrA19OkZjy945kZfw2ZleiaLB6Yf5ZwsH9ITTYObA6l/B2HwF8Z4Q86zt0uq9Aq
+1seC/4c47/IPznlfXrX04Pj2+eOJmX9LgP1tFWD/CgRAJx2/7rXfLiL0hJQycWcrfbyuqlOg41V1oBja
+dKPjJxEXK4xow/eZyaYgj3vM9Bk00mJzKka+GcXZMI7GYUNlmfeImx3kx3gLsW5Xe9jjmMkdKz9VbKR2
+BumRxOSz+xySbJi2TkJ3ocMbelAgs+vU9cSey/EPel+HzXdUlp/pM7p96CQRSQxqT95Nx5D00fUyni1t
+9wRsIeWPMPbClGQnMT6bAUfrRohEoQodEnDjknbSfeCjSEZV9AVJT611yH2lAzacfX/5nTNxYkc0ZjpP
+7OPT/7qghPuL9/pXbbuNh9OJWWY7nBGMLXOOekcDENZ0FHUH0gqQoCvlh6rNmmm79iANZJjIL+4wpe6d
+7qxoPDvGTziG+cWku5hD97WYKjm7F/pMxGy14IB9ggIsibNKIKG3D8jF9CaQWLBPMTYhMZqLmUxTTNiz
+GHzPVXFBc17cvDVIwaXX1hgXlMDoKdF5nKuCogsccbdJZwYSynaErhsTwmWJTBdIew88Tvg0AbXzDuF2
+Qout+AZzzXORUbI5jFmd+cgkYjMJmw9Lwh74O5nSCs+NzJQmX32FDgLdr9DbuXLqyrtWYaVjIR4Lje0L
+jYMAWVrbql6eyJ3Fh2UQbg8NECMifqgdyBz3RChg7pHDmiWAGWwuCsIphtKKUNiIBz7uj7juHIRAO3SQ
+AVbILS8A7aljaJ1CWT/PA6pL4nuBV3Mu/hwKS6f53AsUY5ESRME70Duc/EauspISZalre/HHz4Rx0k/L
+mWAX0sk3kj+Z3q7jeZQTL1FkQnmGYr1dJ9zqBq2clt3K4D1GgPQpNaaZbKrz8DUdMzTyY9ayBFEYSS22
+QR6ncm3YFr7oX/ms4lQ6QGcpSDnSz9pSyMuExEAzF2ZGud02+SESsxiApgP4nyVdN6GUMLyOJqIDFDYO
+jLEYRb6IjcojuZ7c9vbS1t8aKPzzuO92wu1vlTMRTZaC52EdRnKg4ZdS3u+wPYhirDVAbTvPQAQ4eYU+
+W0spgUl7Vgdhzk5IiNvLXIej61c9X1AA38C2PzOlw17TRpE2HPDhPE5Q9ds2ZmjaS4RC3+BE7vn04f0I
+VPEi9RS34Uy0vhAF1p63Zu8dtAa5mlsd6yw5IKCFSIU2boijkb7oXDTE4a6v/2QJXTWUDIrtm8uEHQAP
+BWK/orAY/IJh/ms3qTnUBgoDLNuMWh36P46smHw2n4IuKdb3PYCkBoYM2vg9ccDguAT1OoigjntE2XFQ
+80FkCZ/tk3DaGNLixuTFGQmSUj5cXynyJCh/lrIs7E7ZH0KWPQHFbz2dwMcezYOXj/GDSrdCRyBpLwnq
+q5JjYTvtnV3gdkRPiapOXTOpsBBirlLEoeqik3Icgr00Sgoq/fH0U4tmT2c4v9VTbc6fI83wCMi5wuhu
+otlCL7ZCxQMY6zzGCxg6DxzDfjp/EIMBq6xQfzw6Mgv+f3JqkNPks9mSjbODO31GsxpUL/Wt3DC+CDsK
+fx5mUrXcQWDY7sek3FchEnAzYplIIwwo+RveL9tkMEma/HKH82OSKM/ie7EJEmxYNgrb6sRunfmBAYJt
+5j1YA0sXi6X86BMEeCxQT31/AOD4m9gclipv13LSvNHUTrM/7oTOvzkiNa4a5b3bkM/I/yXGa7YuGwJv
+w5sQo8XpmF17szI6PDMHNTqTfoVbgAe9SrXqPaABTVEYGZTKoYn61M5YHeUHxJUMQkbNQNygk2E9ZpMc
+97louUxMZYNM5FTyhXJYatfU1AnutuyOX8OmmBMCsptaFOKRxVGk4OQz/BuWylJnol3ESEMOUneOLvRN
+Xlp3na5PbLfVF3kAc+zXdWaqLxie0irVgTGyO4wiTzxmsblAulNe3nhBcx3FGn9FFPEMBWZY8OqodPk8
+BeMTcMuW0NYH8SDva5bDCM8P9dkvGYA2kQv8W5kGFb541jIxbOtdrCun/YmeEncTXkZxEWLD20vuKx4J
+Kuq6zYCvLMF3hGjd0fRb5rJcLC0+Rjpht1EWI7dPKcXKXpNs5bdEeUy1KhRleOUR5fRg4/VS6tniwhWv
+AHSVickkE2lBMRY/3bfKAcb9OODy/KAr1V4BAbJ8d12lDyyc56o81Qyc/iDRRtq0asdCpcKrqTnmqQEX
+wFYdDurwa/81UL+2y//1ff66SgAY2J+0BoCD4YmdeBQb70CwbJ5VCYCh1+5JCrJELg6AwXuEnep1nPQw
+jYvRzCsIbOchUj3bSevtcpTeXRybcmUTxM8srauCp7UE2S4iqA3dUVq8Gmd8tpVo9hXqMR+Dt8Te72uC
+XuelBvAfustzjgwDUdY1JVdrS9kuJ2kVtOtcja17ZylPZ2/uxNtexOqUzD0TV3nspLxn3oWj3ji1xXjo
+uAWzgaeIXzLRGoXg+iL7euZvjk493Lcwwy7U1vbEU8Vrslz3sMR24V9bPWZ3u7CIrNOFNaqciZbLx01l
+CDl77YPgSqZ9WPTX5abGms4e9muakUZFCy6mmt9YP18H0C0IpljWJ2x3nN3YWWXNAARwOwgw0/RjzlMV
+B3DIna2eVvWgutXtsr+nlQO/pIbDR+ZKzO5Sz6HMGsDwQ8lePPJVloiaQmDpYywvLvHPyzoEDC2TcYR1
+UrfMq/2GG/NejPrTKwbYAUz5NnPGN4nkUcNiEAATbNYNWDZ4WaOEBWyu0vJRyKvLs6qrY5O6Gk745ia9
+w4spkbpir78DK4dq8dfrhwbQwI9exL5z129lXmwjkDMqpOuEbMB0HM+RdmO5zHPwB3a2EY8ZTCGirY3g
+rxaZxzMTwG1bymhnkzLfIkjogcesbzyKCuFTOZWF6iMh/+kRCxm5MkV/1OpuPeC4eDQ3nBYSXHdu7wSh
+CLMSzqto3pzfA7mbJ7qPIl3D87MdNL4vId3yN3RoPQ3eV5Hi3aoWTqdSJoJXoYkar/URlQ194OkCjFWx
+OEqEKanJC7ryNa6ZIXsQbsslVeWMXRlkc3/bFUyylTf7GDa/6hqdWN8RKVhfhmjVn95S87OvfePh0Oq5
+Jm7t82DsktLvwzRvcjRhHzMs3Isa7ZtVnJagzvAWN6j+zYWcX6yAkZZM/2serYW4/9a7+pvidtnkBdpE
+Z3EAdg4ByAw6S6TaOgdaJ/8t034uD/Rh//Dq21dzgl/86eMbzzW386x5viqzm/Qn2qOD+MJaZ44NqjCG
+XR3tK7hIKELbdlC49unDV7a0eAB/8aK3AYlLedsQmq0VdMp07XajndXwUL0q5CJtVtU0EtovD4pAAd/C
+HtlQKKVYfGMsGIRrxOaJEAV+HI/H31J4QRX4hi59LdrmT7S32phDR3C8DWwWLAPSCOw1WCd8vS+nIk8x
+BfZb65tHfeeNoy2baSY3e2r5WLf5eZ/JMND3XQqeFEs0rHoHLnTX5lq4yWm3npheVN3eums4y/vnKwwd
+loqiuHQL0sXQNCBnWwzgDgOiKwFK770CWy+exzOGb6JDNqITUzFejBlnHypa7bL1u43yNjw7jXFo5k0T
+LGy8QXti0czmRtCvxHGWQuscQ0vmK/bihTNsnNXWb/am3ViI2TKNZzyxO+gbhOzGZU95E/48IN5GpwF4
+1QftMbd8b1BdC7MLw3tQR3LW1bcIxt0eCRIqAAbpm6GvsqiCXiLUctR5hThkmab6kypBsItIRDgxJXy6
+oe1LDXrp+luRzwCBGCMGqYSCIhF+RWtXSMSREgZ/+xIQddI5zyZLtTWuyXi6LgbGeoyVMrj/3NQNGjRA
+x1XccDv4lMQaYBxtf00ISTOdZDUV9q60vqRNd7HoVhafKqAfl6ZZJqHUHcWK3DuEzPoUo3NtT+IzHf6D
+T+iH7YLW3gBvnjPXb4DTYsw18CqpdG9oE2OAQyJ/zLwVIpgMbJ3+YFfW0iE8/+5VnQZ/5TmKixCr9ba7
+JAvaJBydCPKW6JaxLmpfXRn2L22PvyRtn8bg3xLJNiVEDEd0lQzYSUI6p+hYeq2TVW2ey4gpqpqjAb4u
+i+XfMBelVpHI775rIkxtCsQGlokQOeBAS3SJH3kErnmll6rsqB7xjVsvp4pHUUVzVDDbBcqSXWc1ewpZ
+o0UD0GrNQyo9slRVU/U+wVVqXudE4Qdd7RmFl2EH3AKdNoqf8Ki10v+1txyH7URIERf6grlDmiColhC4
+gSSi8erQpt82HaqN8SDrkzpggNbppjmswtIGUulsMpBQOgQ0ruLOMVhAx5rU5hlTBtLvFNF2HhQWyhjp
+ciToBJmZq1IHSpBOrOVca6gALb/MLYAXIamIF90OWVt8aALDnNOu9IeOt5M1qmzUxYeuumEkyKiRw++K
+K5kNMgSDa65SJY4l9xBFA1FKCVJ9hKpf57NKatTfbVaUDfTZ5ChbciMSXsrP2IcAaz8FClQtOVDUKJKr
+HGytzUqWKEvcjJWmC9GcW5JqRqjlNYXe2VNhfOWifaUBrNSUVtXlcMc9tboWjHubacros4Qq59MmN3j3
+oQzSxrUsg/+giGVAOsL1vNgTFtOZhqF2eEni2DhrZV4Zsvv9qHzQwTv3yLKDIv2XmTwD9izC+ULjB3bS
+hu2QN3TeReUB69dnBgTLVO942kfD8V45sVYgT1/btb+S2TxuwPZ+WNBxYKxymHio3p3ZyIshiUHWkX4p
+HaWIkgAZHB6wySJmTh0ewNFMLYljEenWw18LuC3R18c0syXRHSXYNxYdYnv5RN+2TZ3ZaZwzR/yuUOFB
+C9BbfLL13Ll3x5gK66Pzqrrt6Lztw57ccDbVD9WfkZb/PcsOW2vT+tpP/1hCq1UdgNLcf/zp+s3F3Y/X
+r77/i4ZH4bFK+3qsvT18Ii/zOlGy5lra4l92Xltfk1LxU/eueKeAy6kbbU+02/Hg7kwPS+h7BXz/QKvB
+QVd41dZg6BuXqbTNGwB8SO/9MvVQd83cnhrcHwsXvKfCBbuTNZrSJy8xigjbXL/FHrtXNuPAzJREsLd9
+ja1H7pVf7Pi4eSUNUH/A7PbK6zHXmujt0ljNR+irKbIsbKFoENMzP759yKGnns2l7NIEw0czALpshXre
+tHrfOXwIHut7EytvNpJTXgrhiRLDE7mmQr8Ks0KUPpYamVeGUSEelvCpSGx5Zg5IS6IZz104r489uu81
+aFTT+Rgn7J0v/MLXFxRF9o36ln36cNN+6Vdo8qgncP7yGp6+q4RNAJJmA0UaKGLFF7t3Zx6LJNpmov8v
+3VWAhAaNAAA=
 `,
 	},
 
@@ -0,0 +1,337 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_NAME_CLAIM = "sub"
+	DEFAULT_ROLE_CLAIM = "roles"
+	// A key set read from an URL is read again that often
+	JWKS_REFRESH_INTERVAL = time.Hour
+	// Tokens signed by an unknown key read the key set again, at most that often
+	JWKS_MIN_REFRESH_INTERVAL = time.Minute
+	JWKS_TIMEOUT              = 10 * time.Second
+)
+
+// The algorithms the tokens may be signed with
+var TokenSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// The roles from the least to the most privileged
+var roleOrder = []string{VIEWER_ROLE, OPERATOR_ROLE, ADMIN_ROLE}
+
+var (
+	ErrUnknownSigningKey = errors.New("The token is signed by an unknown key")
+	ErrNoRole            = errors.New("The token grants no arken role")
+)
+
+// A JWKS is the set of public keys bearer tokens are signed with, read from
+// a file or an URL.
+type JWKS struct {
+	location string
+	client   *http.Client
+	mutex    sync.Mutex
+	// Public keys by key id
+	keys     map[string]interface{}
+	loadedAt time.Time
+	// Last attempt to read the key set, and its error
+	triedAt time.Time
+	err     error
+}
+
+func NewJWKS(location string) *JWKS {
+	return &JWKS{location: location, client: &http.Client{Timeout: JWKS_TIMEOUT}}
+}
+
+func (j *JWKS) remote() bool {
+	return strings.HasPrefix(j.location, "http://") || strings.HasPrefix(j.location, "https://")
+}
+
+// Returns the public key with the given id. The key set is read on first
+// use, then again when it is stale or the key is unknown, at most once per
+// JWKS_MIN_REFRESH_INTERVAL : the last keys read are kept when it fails.
+func (j *JWKS) Key(kid string) (interface{}, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	key, ok := j.keys[kid]
+	stale := j.keys == nil || j.remote() && (!ok || time.Since(j.loadedAt) > JWKS_REFRESH_INTERVAL)
+	if stale && time.Since(j.triedAt) > JWKS_MIN_REFRESH_INTERVAL {
+		j.triedAt = time.Now()
+		if j.err = j.load(); j.err != nil {
+			log.Error(j.err.Error())
+		}
+		key, ok = j.keys[kid]
+	}
+
+	if j.keys == nil && j.err != nil {
+		return nil, j.err
+	} else if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// Reads the key set. Must be called with the mutex held.
+func (j *JWKS) load() error {
+	var data []byte
+	var err error
+	if j.remote() {
+		data, err = j.fetch()
+	} else {
+		data, err = ioutil.ReadFile(j.location)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to read the key set %s : %s", j.location, err.Error()))
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to parse the key set %s : %s", j.location, err.Error()))
+	}
+	log.Infof("Read %d keys from %s", len(keys), j.location)
+	j.keys = keys
+	j.loadedAt = time.Now()
+	return nil
+}
+
+func (j *JWKS) fetch() ([]byte, error) {
+	resp, err := j.client.Get(j.location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Unexpected status %s", resp.Status))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parses the signature keys of a JSON Web Key Set, by key id. RSA and EC
+// keys are supported, the other ones are skipped.
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Key %q : %s", jwk.Kid, err.Error()))
+		} else if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(fmt.Sprintf("Unsupported curve %s", jwk.Crv))
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("The point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		log.Warnf("Skipping key %q of unsupported type %s", jwk.Kid, jwk.Kty)
+		return nil, nil
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// A TokenAuthenticator validates bearer tokens issued by an OpenID Connect
+// provider, and maps their claims to a principal.
+type TokenAuthenticator struct {
+	JWKS *JWKS
+	// Checked against the iss and aud claims when set
+	Issuer   string
+	Audience string
+	// Claims holding the name of the caller and its roles, dotted for
+	// nested claims
+	NameClaim string
+	RoleClaim string
+	// Arken roles by lower case value of the role claim. Without mapping,
+	// the values are arken roles.
+	Roles map[string]string
+}
+
+// Validates the token and returns the principal it is issued to, with the
+// most privileged role its claims are mapped to.
+func (a *TokenAuthenticator) Authenticate(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(TokenSigningMethods))
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.JWKS.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("The token has no expiration")
+	}
+	if a.Issuer != "" && !claims.VerifyIssuer(a.Issuer, true) {
+		return nil, errors.New(fmt.Sprintf("The token is not issued by %s", a.Issuer))
+	}
+	if a.Audience != "" && !claims.VerifyAudience(a.Audience, true) {
+		return nil, errors.New(fmt.Sprintf("The token is not issued for %s", a.Audience))
+	}
+
+	name, _ := claimValue(claims, a.NameClaim).(string)
+	if name == "" {
+		return nil, errors.New(fmt.Sprintf("The token has no %s claim", a.NameClaim))
+	}
+
+	role := ""
+	for _, value := range claimStrings(claimValue(claims, a.RoleClaim)) {
+		if len(a.Roles) > 0 {
+			value = a.Roles[strings.ToLower(value)]
+		}
+		if roleRank(value) > roleRank(role) {
+			role = value
+		}
+	}
+	if role == "" {
+		return nil, ErrNoRole
+	}
+	principal, err := NewPrincipal(name, role, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	principal.Type = model.USER_ACTOR
+	return principal, nil
+}
+
+// Returns the value of a claim, nested ones being separated by dots.
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := claims[part].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		claims = nested
+	}
+	return claims[parts[len(parts)-1]]
+}
+
+// Returns the values of a claim that is a string, space separated strings
+// or an array of them.
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func roleRank(role string) int {
+	for i, r := range roleOrder {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Returns the bearer token of the request, from its Authorization header or
+// its access_token parameter, which browsers need on websockets.
+func bearerToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:]), true
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, true
+	}
+	return "", false
+}
@@ -0,0 +1,165 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// Serves a key set holding the public keys of the given RSA and EC keys.
+func serveJWKS(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *httptest.Server {
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+			{"kid": "ec", "kty": "EC", "use": "sig", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+		},
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+}
+
+func signToken(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func Test_TokenAuthenticator(t *testing.T) {
+
+	Convey("Given an authenticator reading a key set from an URL", t, func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		server := serveJWKS(rsaKey, ecKey)
+
+		authenticator := &TokenAuthenticator{
+			JWKS:      NewJWKS(server.URL),
+			Issuer:    "https://idp.example.com",
+			Audience:  "arken",
+			NameClaim: DEFAULT_NAME_CLAIM,
+			RoleClaim: "realm_access.roles",
+			Roles:     map[string]string{"arken-admins": ADMIN_ROLE, "arken-operators": OPERATOR_ROLE},
+		}
+		claims := func() jwt.MapClaims {
+			return jwt.MapClaims{
+				"sub": "alice",
+				"iss": "https://idp.example.com",
+				"aud": "arken",
+				"exp": time.Now().Add(time.Hour).Unix(),
+				"realm_access": map[string]interface{}{
+					"roles": []string{"offline_access", "arken-operators"},
+				},
+			}
+		}
+
+		Convey("When a valid token signed by the RSA key is given", func() {
+			principal, err := authenticator.Authenticate(signToken(jwt.SigningMethodRS256, "rsa", rsaKey, claims()))
+
+			Convey("Then it is mapped to the role of its claims", func() {
+				So(err, ShouldBeNil)
+				So(principal.Name, ShouldEqual, "alice")
+				So(principal.Role, ShouldEqual, OPERATOR_ROLE)
+				So(principal.Permissions[START_PERMISSION], ShouldBeTrue)
+				So(principal.Permissions[DELETE_PERMISSION], ShouldBeFalse)
+			})
+		})
+
+		Convey("When a valid token signed by the EC key grants several roles", func() {
+			c := claims()
+			c["realm_access"] = map[string]interface{}{"roles": []string{"arken-operators", "arken-admins"}}
+			principal, err := authenticator.Authenticate(signToken(jwt.SigningMethodES256, "ec", ecKey, c))
+
+			Convey("Then it is mapped to the most privileged one", func() {
+				So(err, ShouldBeNil)
+				So(principal.Role, ShouldEqual, ADMIN_ROLE)
+			})
+		})
+
+		Convey("When the token has expired", func() {
+			c := claims()
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			_, err := authenticator.Authenticate(signToken(jwt.SigningMethodRS256, "rsa", rsaKey, c))
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the token is issued by another issuer", func() {
+			c := claims()
+			c["iss"] = "https://evil.example.com"
+			_, err := authenticator.Authenticate(signToken(jwt.SigningMethodRS256, "rsa", rsaKey, c))
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the token is issued for another audience", func() {
+			c := claims()
+			c["aud"] = "another-app"
+			_, err := authenticator.Authenticate(signToken(jwt.SigningMethodES256, "ec", ecKey, c))
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the token grants no arken role", func() {
+			c := claims()
+			c["realm_access"] = map[string]interface{}{"roles": []string{"offline_access"}}
+			_, err := authenticator.Authenticate(signToken(jwt.SigningMethodRS256, "rsa", rsaKey, c))
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldEqual, ErrNoRole)
+			})
+		})
+
+		Convey("When the token is signed by an unknown key", func() {
+			otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+			_, err := authenticator.Authenticate(signToken(jwt.SigningMethodRS256, "rsa", otherKey, claims()))
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			server.Close()
+		})
+	})
+}
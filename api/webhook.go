@@ -0,0 +1,176 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/arkenio/arken/webhook"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func (s *APIServer) WebhookIndex(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	subscriptions := s.Webhooks.Subscriptions()
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	writeJSON(w, subscriptions, http.StatusOK)
+}
+
+func (s *APIServer) WebhookShow(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	subscription, ok := s.Webhooks.Subscription(mux.Vars(r)["webhookId"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	subscription.Secret = ""
+	writeJSON(w, subscription, http.StatusOK)
+}
+
+func (s *APIServer) WebhookCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	subscription := &webhook.Subscription{}
+	if err := json.NewDecoder(r.Body).Decode(subscription); err != nil {
+		http.Error(w, "Unable to read webhook : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.Webhooks.CreateSubscription(subscription)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	created.Secret = ""
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/webhooks/%s", created.Id))
+	writeJSON(w, created, http.StatusCreated)
+}
+
+func (s *APIServer) WebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	subscription := &webhook.Subscription{}
+	if err := json.NewDecoder(r.Body).Decode(subscription); err != nil {
+		http.Error(w, "Unable to read webhook : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscription.Id = mux.Vars(r)["webhookId"]
+
+	updated, err := s.Webhooks.UpdateSubscription(subscription)
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+
+	updated.Secret = ""
+	writeJSON(w, updated, http.StatusOK)
+}
+
+func (s *APIServer) WebhookDestroy(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	if err := s.Webhooks.DestroySubscription(mux.Vars(r)["webhookId"]); err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	deliveries, err := s.Webhooks.Deliveries(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+	writeJSON(w, deliveries, http.StatusOK)
+}
+
+func (s *APIServer) WebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	deliveries, err := s.Webhooks.DeadLetters(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+	writeJSON(w, deliveries, http.StatusOK)
+}
+
+func (s *APIServer) WebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	if !s.hasWebhooks(w) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	delivery, err := s.Webhooks.Redeliver(vars["webhookId"], vars["deliveryId"])
+	if err != nil {
+		http.Error(w, err.Error(), webhookErrorStatus(err))
+		return
+	}
+	writeJSON(w, delivery, http.StatusAccepted)
+}
+
+func (s *APIServer) hasWebhooks(w http.ResponseWriter) bool {
+	if s.Webhooks == nil {
+		http.Error(w, "Webhooks are not running on this daemon", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// Maps the errors of the webhook dispatcher to an HTTP status.
+func webhookErrorStatus(err error) int {
+	if _, ok := err.(*webhook.InvalidSubscriptionError); ok {
+		return http.StatusBadRequest
+	}
+
+	switch err {
+	case webhook.ErrSubscriptionNotFound, webhook.ErrDeliveryNotFound:
+		return http.StatusNotFound
+	case webhook.ErrSubscriptionExists:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}, status int) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Unable to encode response : %v", err)
+	}
+}
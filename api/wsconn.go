@@ -15,10 +15,14 @@ package api
 
 import (
 	"github.com/gorilla/websocket"
+	"github.com/arkenio/arken/goarken/metrics"
 	"github.com/arkenio/arken/goarken/model"
 	"net/http"
 	"time"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 )
 
 const (
@@ -30,6 +34,9 @@ const (
 
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum size of a message of the peer.
+	maxMessageSize = 4096
 )
 
 type connection struct {
@@ -40,6 +47,25 @@ type connection struct {
 	send chan *model.ModelEvent
 
 	h *hub
+
+	// Sequence of the last event sent, the ones up to it are not sent again
+	last uint64
+
+	// Filters of the client by id, only used by the hub
+	filters map[string]*EventFilter
+
+	// Set by the hub when the connection does not keep up
+	dropped bool
+
+	// Who opened the connection, the events it may not access are not sent
+	principal *Principal
+}
+
+// A message of a client, handed to the hub
+type subscriptionRequest struct {
+	c       *connection
+	message *SubscriptionMessage
+	err     error
 }
 
 // write writes a message with the given message type and payload.
@@ -58,12 +84,19 @@ func (c *connection) WriteServiceActivity() {
 	for {
 		select {
 		case modelEvent, ok := <-c.send:
-			if !ok {
+			if !ok && c.dropped {
+				reason := fmt.Sprintf("Too slow, resume with ?since=%d", c.last)
+				c.write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason))
+				return
+			} else if !ok {
 				c.write(websocket.CloseMessage, []byte{})
 				return
 			}
-			message, _ := json.Marshal(modelEvent)
-			if err := c.write(websocket.TextMessage, message); err != nil {
+			// Replayed events may also be received live
+			if modelEvent.Sequence != 0 && modelEvent.Sequence <= c.last {
+				continue
+			}
+			if err := c.writeEvent(modelEvent); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -74,6 +107,76 @@ func (c *connection) WriteServiceActivity() {
 	}
 }
 
+func (c *connection) writeEvent(modelEvent *model.ModelEvent) error {
+	message, _ := json.Marshal(modelEvent)
+	if err := c.write(websocket.TextMessage, message); err != nil {
+		return err
+	}
+	if modelEvent.Sequence != 0 {
+		c.last = modelEvent.Sequence
+	}
+	return nil
+}
+
+// Reads the subscription messages of the client, and its pongs.
+func (c *connection) ReadSubscriptions() {
+	defer func() {
+		c.h.unregister <- c
+	}()
+	c.ws.SetReadLimit(maxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, payload, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		message := &SubscriptionMessage{}
+		err = json.Unmarshal(payload, message)
+		c.h.subscribe <- &subscriptionRequest{c, message, err}
+	}
+}
+
+// Sends the events published since the given sequence that pass the filter,
+// or a resync event when they are no longer available.
+func (c *connection) replay(arkenModel *model.Model, since uint64, filter *EventFilter) error {
+	c.last = since
+	return replayEvents(arkenModel, since, c.principal, filter, c.writeEvent)
+}
+
+// Writes the events published since the given sequence that the principal
+// may access and that pass the filter, or a resync event when they are no longer available : the client
+// then has to read the model again, the events that follow the resync event
+// are sent.
+func replayEvents(arkenModel *model.Model, since uint64, principal *Principal, filter *EventFilter, write func(*model.ModelEvent) error) error {
+	events, err := arkenModel.EventsSince(since)
+	if err == model.ErrResyncRequired {
+		return write(&model.ModelEvent{EventType: model.RESYNC_EVENT, Time: time.Now(), Sequence: arkenModel.LastEventSequence()})
+	}
+
+	for _, event := range events {
+		if !principal.CanAccessEvent(event) || filter != nil && !filter.Matches(event) {
+			continue
+		}
+		if err := write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newConnection(ws *websocket.Conn, h *hub, principal *Principal, filter *EventFilter) *connection {
+	c := &connection{send: make(chan *model.ModelEvent, 256), ws: ws, h: h, filters: make(map[string]*EventFilter), principal: principal}
+	if filter != nil {
+		c.filters[QUERY_FILTER] = filter
+	}
+	return c
+}
+
 type hub struct {
 	// Registered connections.
 	connections map[*connection]bool
@@ -86,6 +189,9 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan *connection
+
+	// Subscription messages from the connections.
+	subscribe chan *subscriptionRequest
 }
 
 func newHub() *hub {
@@ -93,6 +199,7 @@ func newHub() *hub {
 		broadcast:   make(chan *model.ModelEvent),
 		register:    make(chan *connection),
 		unregister:  make(chan *connection),
+		subscribe:   make(chan *subscriptionRequest),
 		connections: make(map[*connection]bool),
 	}
 }
@@ -102,33 +209,83 @@ func (h *hub) run() {
 		select {
 		case c := <-h.register:
 			h.connections[c] = true
+			metrics.WebSocketConnections.Inc()
 		case c := <-h.unregister:
 			if _, ok := h.connections[c]; ok {
 				delete(h.connections, c)
 				close(c.send)
+				metrics.WebSocketConnections.Dec()
+			}
+		case r := <-h.subscribe:
+			if _, ok := h.connections[r.c]; ok {
+				h.send(r.c, r.c.apply(r.message, r.err))
 			}
 		case m := <-h.broadcast:
 			for c := range h.connections {
-				select {
-				case c.send <- m:
-				default:
-					close(c.send)
-					delete(h.connections, c)
+				if c.accepts(m) {
+					h.send(c, m)
 				}
 			}
 		}
 	}
 }
 
+// Queues an event on a connection, the connections that do not keep up are
+// dropped.
+func (h *hub) send(c *connection, m *model.ModelEvent) {
+	select {
+	case c.send <- m:
+	default:
+		c.dropped = true
+		close(c.send)
+		delete(h.connections, c)
+		metrics.WebSocketConnections.Dec()
+	}
+}
+
+// Streams the events of the model. With a since parameter, the events
+// published after that sequence are sent first. The query may hold a first
+// filter of the events.
 func (s *APIServer) serveWs(w http.ResponseWriter, r *http.Request) {
+	since, resume, err := parseSequence(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := filterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	c := &connection{send: make(chan *model.ModelEvent, 256), ws: ws, h: s.hub}
+	c := newConnection(ws, s.hub, principalFrom(r), filter)
 	log.Infof("New ws connection from %s", r.RemoteAddr)
+	// Registered first, so that no event is missed between the replay and
+	// the live events
 	s.hub.register <- c
+	go c.ReadSubscriptions()
 
+	if resume {
+		if err := c.replay(s.arkenModel, since, filter); err != nil {
+			log.Warnf("Unable to replay the events to %s : %v", r.RemoteAddr, err)
+		}
+	}
 	c.WriteServiceActivity()
 }
+
+// Parses the sequence a client resumes from, when given.
+func parseSequence(value string) (uint64, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	sequence, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, errors.New("Invalid sequence : " + value)
+	}
+	return sequence, true, nil
+}
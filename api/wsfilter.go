@@ -0,0 +1,211 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// The messages a websocket client sends to choose the events it receives
+const (
+	SUBSCRIBE_MESSAGE   = "subscribe"
+	UNSUBSCRIBE_MESSAGE = "unsubscribe"
+)
+
+// The events answering the messages of a websocket client
+const (
+	SUBSCRIBED_EVENT   = "subscribed"
+	UNSUBSCRIBED_EVENT = "unsubscribed"
+	ERROR_EVENT        = "error"
+)
+
+// Selects the events sent on a websocket. Empty fields match any event,
+// services and domains are patterns as in path.Match.
+type EventFilter struct {
+	ModelTypes []string `json:"modelTypes,omitempty"`
+	EventTypes []string `json:"eventTypes,omitempty"`
+	Services   []string `json:"services,omitempty"`
+	Domains    []string `json:"domains,omitempty"`
+	// Computed statuses of the services
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// A message of a websocket client. A subscribe message adds or replaces the
+// filter with the given id, an unsubscribe message removes it. A connection
+// without filter receives all the events.
+type SubscriptionMessage struct {
+	Type   string       `json:"type"`
+	Id     string       `json:"id"`
+	Filter *EventFilter `json:"filter,omitempty"`
+}
+
+func (m *SubscriptionMessage) Validate() error {
+	if m.Id == "" {
+		return errors.New("The subscription has no id")
+	}
+
+	switch m.Type {
+	case UNSUBSCRIBE_MESSAGE:
+		return nil
+	case SUBSCRIBE_MESSAGE:
+		if m.Filter == nil {
+			return errors.New("The subscription has no filter")
+		}
+		for _, pattern := range append(append([]string{}, m.Filter.Services...), m.Filter.Domains...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.New(fmt.Sprintf("Invalid pattern %q", pattern))
+			}
+		}
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unknown message type %q, expected %s or %s", m.Type, SUBSCRIBE_MESSAGE, UNSUBSCRIBE_MESSAGE))
+	}
+}
+
+// The id of the filter given in the query string of a connection
+const QUERY_FILTER = "query"
+
+// Reads a filter from the modelType, eventType, service, domain and status
+// parameters of a query, which may be repeated or hold comma separated
+// values. Returns nil when there is none.
+func filterFromQuery(query url.Values) (*EventFilter, error) {
+	filter := &EventFilter{
+		ModelTypes: queryValues(query, "modelType"),
+		EventTypes: queryValues(query, "eventType"),
+		Services:   queryValues(query, "service"),
+		Domains:    queryValues(query, "domain"),
+		Statuses:   queryValues(query, "status"),
+	}
+	if len(filter.ModelTypes)+len(filter.EventTypes)+len(filter.Services)+len(filter.Domains)+len(filter.Statuses) == 0 {
+		return nil, nil
+	}
+
+	message := &SubscriptionMessage{Type: SUBSCRIBE_MESSAGE, Id: QUERY_FILTER, Filter: filter}
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func queryValues(query url.Values, key string) []string {
+	values := []string{}
+	for _, value := range query[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// Tells if the event passes all the fields of the filter. Events that do not
+// relate to a service, a domain or a status never match the corresponding
+// fields.
+func (f *EventFilter) Matches(event *model.ModelEvent) bool {
+	if len(f.ModelTypes) > 0 && !containsString(f.ModelTypes, event.ModelType) {
+		return false
+	}
+	if len(f.EventTypes) > 0 && !containsString(f.EventTypes, event.EventType) {
+		return false
+	}
+	if len(f.Services) > 0 {
+		name, ok := event.ServiceName()
+		if !ok || !matchesAny(f.Services, name) {
+			return false
+		}
+	}
+	if len(f.Domains) > 0 {
+		domain, ok := eventDomain(event)
+		if !ok || !matchesAny(f.Domains, domain) {
+			return false
+		}
+	}
+	if len(f.Statuses) > 0 {
+		service, ok := event.Model.(*model.Service)
+		if !ok || service.Status == nil || !containsString(f.Statuses, service.Status.Compute()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the domain name of a domain or of a service.
+func eventDomain(event *model.ModelEvent) (string, bool) {
+	switch m := event.Model.(type) {
+	case *model.Domain:
+		return m.Name, true
+	case *model.Service:
+		return m.Domain, m.Domain != ""
+	}
+	return "", false
+}
+
+// Tells if the connection receives the event : its principal may access it,
+// and it has no filter or one of them matches it.
+func (c *connection) accepts(event *model.ModelEvent) bool {
+	if !c.principal.CanAccessEvent(event) {
+		return false
+	}
+	if len(c.filters) == 0 {
+		return true
+	}
+	for _, filter := range c.filters {
+		if filter.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies a message of the client, and returns the event that answers it.
+func (c *connection) apply(message *SubscriptionMessage, err error) *model.ModelEvent {
+	if err == nil {
+		err = message.Validate()
+	}
+	if err != nil {
+		return &model.ModelEvent{EventType: ERROR_EVENT, ModelType: "Subscription", Model: map[string]string{"error": err.Error()}, Time: time.Now()}
+	}
+
+	if message.Type == SUBSCRIBE_MESSAGE {
+		c.filters[message.Id] = message.Filter
+		return &model.ModelEvent{EventType: SUBSCRIBED_EVENT, ModelType: "Subscription", Model: message, Time: time.Now()}
+	}
+	delete(c.filters, message.Id)
+	return &model.ModelEvent{EventType: UNSUBSCRIBED_EVENT, ModelType: "Subscription", Model: message, Time: time.Now()}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
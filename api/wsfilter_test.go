@@ -0,0 +1,286 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FilterFromQuery(t *testing.T) {
+	Convey("Given the query of a connection", t, func() {
+
+		Convey("When it has no filter parameter", func() {
+			filter, err := filterFromQuery(url.Values{"since": {"12"}})
+
+			Convey("Then there is no filter", func() {
+				So(err, ShouldBeNil)
+				So(filter, ShouldBeNil)
+			})
+		})
+
+		Convey("When it holds repeated and comma separated values", func() {
+			query, _ := url.ParseQuery("modelType=Service,Domain&eventType=update&service=app-*&service=web&domain=*.example.com&status=started,%20passivated&domain=")
+			filter, err := filterFromQuery(query)
+
+			Convey("Then the filter holds all of them", func() {
+				So(err, ShouldBeNil)
+				So(filter.ModelTypes, ShouldResemble, []string{"Service", "Domain"})
+				So(filter.EventTypes, ShouldResemble, []string{"update"})
+				So(filter.Services, ShouldResemble, []string{"app-*", "web"})
+				So(filter.Domains, ShouldResemble, []string{"*.example.com"})
+				So(filter.Statuses, ShouldResemble, []string{"started", "passivated"})
+			})
+		})
+
+		Convey("When it holds an invalid pattern", func() {
+			filter, err := filterFromQuery(url.Values{"domain": {"["}})
+
+			Convey("Then it is refused", func() {
+				So(err, ShouldNotBeNil)
+				So(filter, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func Test_EventFilter(t *testing.T) {
+	service := &model.Service{Name: "app-1", Domain: "app-1.example.com"}
+	service.Status = &model.Status{Alive: "1", Current: model.STARTED_STATUS, Expected: model.STARTED_STATUS, Service: service}
+	unknown := &model.Service{Name: "app-2"}
+	uriDomain := &model.Domain{Name: "www.example.com", Typ: model.URI_DOMAIN, Value: "http://backend.example.com/"}
+	serviceDomain := &model.Domain{Name: "app-1.example.com", Typ: model.SERVICE_DOMAIN, Value: "app-1"}
+	operation := &model.Operation{ServiceName: "app-1"}
+
+	cases := []struct {
+		description string
+		filter      EventFilter
+		event       *model.ModelEvent
+		matches     bool
+	}{
+		{"an empty filter", EventFilter{}, model.NewModelEvent("update", uriDomain), true},
+		{"the model type", EventFilter{ModelTypes: []string{"Service"}}, model.NewModelEvent("update", service), true},
+		{"another model type", EventFilter{ModelTypes: []string{"Service"}}, model.NewModelEvent("update", uriDomain), false},
+		{"the event type", EventFilter{EventTypes: []string{"create", "delete"}}, model.NewModelEvent("delete", service), true},
+		{"another event type", EventFilter{EventTypes: []string{"create"}}, model.NewModelEvent("update", service), false},
+		{"the service", EventFilter{Services: []string{"app-*"}}, model.NewModelEvent("update", service), true},
+		{"the service of an operation", EventFilter{Services: []string{"app-1"}}, model.NewModelEvent("update", operation), true},
+		{"the service of a domain", EventFilter{Services: []string{"app-1"}}, model.NewModelEvent("update", serviceDomain), true},
+		{"another service", EventFilter{Services: []string{"web*"}}, model.NewModelEvent("update", service), false},
+		{"a service on a domain without service", EventFilter{Services: []string{"*"}}, model.NewModelEvent("update", uriDomain), false},
+		{"the domain", EventFilter{Domains: []string{"*.example.com"}}, model.NewModelEvent("update", uriDomain), true},
+		{"the domain of a service", EventFilter{Domains: []string{"app-1.*"}}, model.NewModelEvent("update", service), true},
+		{"a domain on a service without domain", EventFilter{Domains: []string{"*"}}, model.NewModelEvent("update", unknown), false},
+		{"a domain on an operation", EventFilter{Domains: []string{"*"}}, model.NewModelEvent("update", operation), false},
+		{"the status", EventFilter{Statuses: []string{model.STARTED_STATUS}}, model.NewModelEvent("update", service), true},
+		{"another status", EventFilter{Statuses: []string{model.PASSIVATED_STATUS}}, model.NewModelEvent("update", service), false},
+		{"a status on a service without status", EventFilter{Statuses: []string{model.STARTED_STATUS}}, model.NewModelEvent("update", unknown), false},
+		{"a status on a domain", EventFilter{Statuses: []string{model.STARTED_STATUS}}, model.NewModelEvent("update", serviceDomain), false},
+		{"all the fields", EventFilter{ModelTypes: []string{"Service"}, Services: []string{"app-1"}, Statuses: []string{model.STARTED_STATUS}}, model.NewModelEvent("update", service), true},
+		{"all the fields but one", EventFilter{ModelTypes: []string{"Service"}, Services: []string{"app-1"}, Statuses: []string{model.STOPPED_STATUS}}, model.NewModelEvent("update", service), false},
+	}
+
+	Convey("Given some event filters", t, func() {
+		for _, c := range cases {
+			Convey("Matching "+c.description, func() {
+				So(c.filter.Matches(c.event), ShouldEqual, c.matches)
+			})
+		}
+	})
+}
+
+func Test_ConnectionSubscriptions(t *testing.T) {
+	Convey("Given a connection filtered by its query", t, func() {
+		filter, _ := filterFromQuery(url.Values{"domain": {"b*"}})
+		c := newConnection(nil, nil, nil, filter)
+		a := model.NewModelEvent("update", &model.Domain{Name: "a.example.com"})
+		b := model.NewModelEvent("update", &model.Domain{Name: "b.example.com"})
+
+		So(c.accepts(a), ShouldBeFalse)
+		So(c.accepts(b), ShouldBeTrue)
+
+		Convey("When it subscribes to other events", func() {
+			event := c.apply(&SubscriptionMessage{Type: SUBSCRIBE_MESSAGE, Id: "a", Filter: &EventFilter{Domains: []string{"a*"}}}, nil)
+
+			Convey("Then it receives the events of both filters", func() {
+				So(event.EventType, ShouldEqual, SUBSCRIBED_EVENT)
+				So(c.accepts(a), ShouldBeTrue)
+				So(c.accepts(b), ShouldBeTrue)
+			})
+
+			Convey("Then it no longer receives them once unsubscribed", func() {
+				event = c.apply(&SubscriptionMessage{Type: UNSUBSCRIBE_MESSAGE, Id: "a"}, nil)
+				So(event.EventType, ShouldEqual, UNSUBSCRIBED_EVENT)
+				So(c.accepts(a), ShouldBeFalse)
+			})
+		})
+
+		Convey("When it removes the filter of its query", func() {
+			c.apply(&SubscriptionMessage{Type: UNSUBSCRIBE_MESSAGE, Id: QUERY_FILTER}, nil)
+
+			Convey("Then it receives all the events", func() {
+				So(c.accepts(a), ShouldBeTrue)
+				So(c.accepts(b), ShouldBeTrue)
+			})
+		})
+
+		Convey("When it sends an invalid message", func() {
+			invalid := []*SubscriptionMessage{
+				{Type: SUBSCRIBE_MESSAGE, Filter: &EventFilter{}},
+				{Type: SUBSCRIBE_MESSAGE, Id: "a"},
+				{Type: SUBSCRIBE_MESSAGE, Id: "a", Filter: &EventFilter{Services: []string{"["}}},
+				{Type: "replace", Id: "a"},
+			}
+
+			Convey("Then it gets an error and its filters are kept", func() {
+				for _, message := range invalid {
+					So(c.apply(message, nil).EventType, ShouldEqual, ERROR_EVENT)
+				}
+				So(c.apply(nil, json.Unmarshal([]byte("{"), &SubscriptionMessage{})).EventType, ShouldEqual, ERROR_EVENT)
+				So(c.filters, ShouldHaveLength, 1)
+				So(c.accepts(a), ShouldBeFalse)
+			})
+		})
+
+		Convey("When its principal is restricted to some services", func() {
+			c.principal = &Principal{Name: "deployer", Role: "viewer", Services: []string{"app-*"}}
+			c.apply(&SubscriptionMessage{Type: UNSUBSCRIBE_MESSAGE, Id: QUERY_FILTER}, nil)
+
+			Convey("Then it only receives the events of these services", func() {
+				So(c.accepts(model.NewModelEvent("update", &model.Service{Name: "app-1"})), ShouldBeTrue)
+				So(c.accepts(model.NewModelEvent("update", &model.Service{Name: "web"})), ShouldBeFalse)
+				So(c.accepts(a), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+// Reads the events of a websocket until one of them passes the condition,
+// and returns the ones read.
+func readWsEvents(ws *websocket.Conn, until func(*model.ModelEvent) bool) []*model.ModelEvent {
+	events := []*model.ModelEvent{}
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		event := &model.ModelEvent{}
+		if err := ws.ReadJSON(event); err != nil {
+			return events
+		}
+		events = append(events, event)
+		if until(event) {
+			return events
+		}
+	}
+}
+
+func wsEventDomain(event *model.ModelEvent) string {
+	return domainName(&streamedEvent{event: event})
+}
+
+func Test_WebsocketFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arken-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("Given a server streaming the events of a model on a websocket", t, func() {
+		driver, err := storage.NewBoltDriver(filepath.Join(dir, "arken.db"))
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+		_, server := newEventServer(arkenModel)
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+		var ws *websocket.Conn
+
+		Convey("When a client filters the domains in its query", func() {
+			var response *http.Response
+			ws, response, err = websocket.DefaultDialer.Dial(wsURL+"?modelType=Domain&domain=b*", nil)
+			So(err, ShouldBeNil)
+			So(response.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+
+			for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+				So(createTestDomain(arkenModel, name), ShouldBeNil)
+			}
+
+			Convey("Then it only receives the events that pass the filter", func() {
+				So(createTestDomain(arkenModel, "b2.example.com"), ShouldBeNil)
+				events := readWsEvents(ws, func(event *model.ModelEvent) bool {
+					return wsEventDomain(event) == "b2.example.com"
+				})
+				So(events, ShouldNotBeEmpty)
+				for _, event := range events {
+					So(event.ModelType, ShouldEqual, "Domain")
+					So(wsEventDomain(event), ShouldStartWith, "b")
+				}
+				So(wsEventDomain(events[len(events)-1]), ShouldEqual, "b2.example.com")
+			})
+
+			Convey("Then it receives the events of the filters it subscribes to", func() {
+				So(ws.WriteJSON(&SubscriptionMessage{Type: SUBSCRIBE_MESSAGE, Id: "c", Filter: &EventFilter{Domains: []string{"c*"}}}), ShouldBeNil)
+				events := readWsEvents(ws, func(event *model.ModelEvent) bool {
+					return event.EventType == SUBSCRIBED_EVENT
+				})
+				So(events[len(events)-1].EventType, ShouldEqual, SUBSCRIBED_EVENT)
+
+				So(createTestDomain(arkenModel, "c2.example.com"), ShouldBeNil)
+				events = readWsEvents(ws, func(event *model.ModelEvent) bool {
+					return wsEventDomain(event) == "c2.example.com"
+				})
+				So(events, ShouldNotBeEmpty)
+				So(wsEventDomain(events[len(events)-1]), ShouldEqual, "c2.example.com")
+			})
+
+			Convey("Then it gets an error for an invalid message", func() {
+				So(ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"replace","id":"c"}`)), ShouldBeNil)
+				events := readWsEvents(ws, func(event *model.ModelEvent) bool {
+					return event.EventType == ERROR_EVENT
+				})
+				So(events, ShouldNotBeEmpty)
+				So(events[len(events)-1].EventType, ShouldEqual, ERROR_EVENT)
+			})
+		})
+
+		Convey("When a client gives an invalid filter in its query", func() {
+			var response *http.Response
+			ws, response, err = websocket.DefaultDialer.Dial(wsURL+"?domain=[", nil)
+
+			Convey("Then the connection is refused", func() {
+				So(err, ShouldNotBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Reset(func() {
+			if ws != nil {
+				ws.Close()
+			}
+			server.CloseClientConnections()
+			server.Close()
+			driver.Close()
+			os.Remove(filepath.Join(dir, "arken.db"))
+		})
+	})
+}
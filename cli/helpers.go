@@ -16,11 +16,17 @@ package cli
 import (
 	"errors"
 	"fmt"
-	"github.com/arkenio/arken/goarken/drivers"
+	// Registers the service drivers
+	_ "github.com/arkenio/arken/goarken/drivers"
 	"github.com/arkenio/arken/goarken/model"
 	"github.com/arkenio/arken/goarken/storage"
+	"github.com/arkenio/arken/passivation"
+	"github.com/arkenio/arken/webhook"
 	"github.com/coreos/etcd/client"
 	"github.com/spf13/viper"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -40,41 +46,137 @@ func CreateEtcdClient() client.KeysAPI {
 
 }
 
+func CreateEtcdV3Client() (*clientv3.Client, error) {
+	return clientv3.New(clientv3.Config{
+		Endpoints:   []string{viper.GetString("etcdAddress")},
+		DialTimeout: 5 * time.Second,
+	})
+}
+
 func CreateServiceDriver(etcdClient client.KeysAPI) (model.ServiceDriver, error) {
+	// Drivers register themselves in the driver registry of the model
+	return model.NewServiceDriver(viper.GetString("driver"), viper.GetViper())
+}
 
-	rancherHost := viper.GetString("CATTLE_URL")
-	if rancherHost == "" {
-		rancherHost = viper.GetString("rancher.host") //compat with old catalog def
-	}
+func CreateWatcherFromCli(client client.KeysAPI) *storage.Watcher {
+	return storage.NewWatcher(client, viper.GetString("serviceDir"), viper.GetString("domainDir"))
 
-	accessKey := viper.GetString("CATTLE_ACCESS_KEY")
-	if accessKey == "" {
-		accessKey = viper.GetString("rancher.accessKey") //compat with old catalog def
-	}
+}
 
-	secretKey := viper.GetString("CATTLE_SECRET_KEY")
-	if secretKey == "" {
-		secretKey = viper.GetString("rancher.secretKey") //compat with old catalog def
+// Creates the persistence driver selected by the storage key : etcd, on the
+// API selected by the etcdApi key, or a local bolt file.
+func CreatePersistenceDriver(etcdClient client.KeysAPI) (model.PersistenceDriver, error) {
+	switch viper.GetString("storage") {
+	case "etcd":
+		return createEtcdPersistenceDriver(etcdClient)
+	case "bolt":
+		return storage.NewBoltDriver(viper.GetString("bolt.path"))
+	default:
+		return nil, errors.New("Unknown storage " + viper.GetString("storage") + ", expected etcd or bolt")
 	}
+}
 
-	switch viper.GetString("driver") {
-	case "rancher":
-		log.Infof("Rancher host: %s", rancherHost)
-		log.Infof("Rancher Access key: %s", accessKey)
-		log.Infof("Rancher Secret key: ************************")
-		sd, err := drivers.NewRancherServiceDriver(rancherHost, accessKey, secretKey)
+func createEtcdPersistenceDriver(etcdClient client.KeysAPI) (model.PersistenceDriver, error) {
+	switch viper.GetString("etcdApi") {
+	case "v2":
+		watcher := CreateWatcherFromCli(etcdClient)
+		watcher.APIKeyDir = viper.GetString("apiKeyDir")
+		watcher.AuditDir = viper.GetString("auditDir")
+		watcher.HistoryDir = viper.GetString("historyDir")
+		watcher.WebhookDir = viper.GetString("webhookDir")
+		return watcher, nil
+	case "v3":
+		v3Client, err := CreateEtcdV3Client()
+		if err != nil {
+			return nil, err
+		}
+		driver, err := storage.NewEtcdV3Driver(v3Client, viper.GetString("serviceDir"), viper.GetString("domainDir"))
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Unable to connect to Rancher : %s", err.Error()))
+			return nil, err
 		}
+		driver.APIKeyDir = viper.GetString("apiKeyDir")
+		driver.AuditDir = viper.GetString("auditDir")
+		driver.HistoryDir = viper.GetString("historyDir")
+		driver.WebhookDir = viper.GetString("webhookDir")
+		return driver, nil
+	default:
+		return nil, errors.New("Unknown etcd API " + viper.GetString("etcdApi") + ", expected v2 or v3")
+	}
+}
 
-		return sd, nil
+// Creates the elector of the persistence driver when the cluster key is
+// enabled, or nil to run alone.
+func CreateElector(persistenceDriver model.PersistenceDriver) (model.Elector, error) {
+	if !viper.GetBool("cluster.enabled") {
+		return nil, nil
+	}
 
-	default:
-		return drivers.NewFleetServiceDriver(viper.GetString("etcdAddress")), nil
+	driver, ok := persistenceDriver.(model.ElectingPersistenceDriver)
+	if !ok {
+		return nil, errors.New("The " + viper.GetString("storage") + " storage can't elect a leader")
 	}
+
+	return driver.NewElector(viper.GetString("cluster.key"), ClusterId(), time.Duration(viper.GetInt("cluster.ttl"))*time.Second)
 }
 
-func CreateWatcherFromCli(client client.KeysAPI) *storage.Watcher {
-	return storage.NewWatcher(client, viper.GetString("serviceDir"), viper.GetString("domainDir"))
+// Returns the id of the daemon in the cluster, given by the cluster.id key or
+// made of its host name and pid.
+func ClusterId() string {
+	id := viper.GetString("cluster.id")
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return id
+}
 
+// Creates the idle policy of the passivation from the activity sources listed
+// in the passivation.activitySources key.
+func CreateIdlePolicy(arkenModel *model.Model) (*passivation.IdlePolicy, error) {
+	sources := []passivation.ActivitySource{}
+	for _, name := range strings.Split(viper.GetString("passivation.activitySources"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		source, err := passivation.NewActivitySource(name, viper.GetViper(), arkenModel)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return passivation.NewIdlePolicy(sources...), nil
+}
+
+// Creates the webhook dispatcher with the subscriptions of the webhooks key,
+// whose names are their ids, and the ones of the passivation.webhooks URLs,
+// which only receive the passivation warnings. They replace the stored
+// subscriptions with the same ids.
+func CreateWebhookDispatcher(arkenModel *model.Model) (*webhook.Dispatcher, error) {
+	dispatcher := webhook.NewDispatcher(arkenModel)
+	for name := range viper.GetStringMap("webhooks") {
+		key := "webhooks." + name
+		subscription := &webhook.Subscription{
+			Id:         name,
+			URL:        viper.GetString(key + ".url"),
+			EventTypes: viper.GetStringSlice(key + ".eventTypes"),
+			ModelTypes: viper.GetStringSlice(key + ".modelTypes"),
+			Services:   viper.GetStringSlice(key + ".services"),
+			Secret:     viper.GetString(key + ".secret"),
+		}
+		if err := dispatcher.ConfigureSubscription(subscription); err != nil {
+			return nil, errors.New(fmt.Sprintf("Webhook %s : %s", name, err))
+		}
+	}
+	for i, url := range viper.GetStringSlice("passivation.webhooks") {
+		subscription := &webhook.Subscription{
+			Id:         fmt.Sprintf("passivation-%d", i),
+			URL:        url,
+			EventTypes: []string{"warning"},
+			ModelTypes: []string{"PassivationWarning"},
+		}
+		if err := dispatcher.ConfigureSubscription(subscription); err != nil {
+			return nil, errors.New(fmt.Sprintf("Passivation webhook %s : %s", url, err))
+		}
+	}
+	return dispatcher, nil
 }
@@ -0,0 +1,64 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cli
+
+import (
+	"fmt"
+	"github.com/arkenio/arken/proxy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/tylerb/graceful.v1"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Starts the Arken reverse proxy",
+	Long: `Starts an HTTP reverse proxy that routes the requests to the services
+by their domain, and wakes up the passivated services on their first request.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// The proxy never leads the cluster, it would not passivate nor
+		// serve the API
+		initModel(false)
+
+		p := proxy.NewProxy(arkenModel)
+		p.StartTimeout = time.Duration(viper.GetInt("proxy.startTimeout")) * time.Second
+
+		if path := viper.GetString("proxy.waitingPage"); path != "" {
+			page, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Errorf("Unable to read the waiting page : %v", err)
+				os.Exit(-1)
+			}
+			p.WaitingPage = page
+		}
+
+		go p.Start()
+
+		port := viper.GetInt("proxy.port")
+		log.Infof("Starting Arken proxy on port : %d", port)
+		graceful.Run(fmt.Sprintf(":%d", port), 5*time.Second, p)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(proxyCmd)
+
+	proxyCmd.Flags().Int("port", 8080, "Port to run the proxy on")
+	viper.BindPFlag("proxy.port", proxyCmd.Flags().Lookup("port"))
+
+}
@@ -78,8 +78,24 @@ func initConfig() {
 
 	viper.SetDefault("domainDir","/domains")
 	viper.SetDefault("serviceDir","/services")
+	viper.SetDefault("apiKeyDir","/apikeys")
+	viper.SetDefault("auditDir","/audit")
+	viper.SetDefault("audit.retentionInDays",30)
+	viper.SetDefault("historyDir","/history")
+	viper.SetDefault("history.retentionInDays",30)
+	viper.SetDefault("webhookDir","/webhooks")
 	viper.SetDefault("etcdAddress","http://127.0.0.1:4001")
+	viper.SetDefault("etcdApi","v2")
+	viper.SetDefault("storage","etcd")
+	viper.SetDefault("bolt.path","arken.db")
+	viper.SetDefault("cluster.key","/arken/leader")
+	viper.SetDefault("cluster.ttl",15)
+	viper.SetDefault("proxy.startTimeout",120)
+	viper.SetDefault("passivation.activitySources","access")
+	viper.SetDefault("passivation.warningInSeconds", 0)
 	viper.SetDefault("driver","fleet")
+	viper.SetDefault("docker.host", "unix:///var/run/docker.sock")
+	viper.SetDefault("kubernetes.namespace", "default")
 
 
 	log.Info("Starting Arken...")
@@ -91,8 +107,11 @@ func initConfig() {
 		log.Errorf("Unable to read config file")
 	}
 
+}
 
-	// Initialize GoArken model
+// Initializes the GoArken model. Only a daemon that campaigns may lead the
+// cluster, the other ones are followers forever.
+func initModel(campaign bool) {
 	etcdClient := CreateEtcdClient()
 
 	serviceDriver, err := CreateServiceDriver(etcdClient)
@@ -102,9 +121,25 @@ func initConfig() {
 		os.Exit(-1)
 	}
 
-	persistenceDriver := CreateWatcherFromCli(etcdClient)
+	persistenceDriver, err := CreatePersistenceDriver(etcdClient)
+	if err != nil {
+		log.Error("Unable to create Persistence Driver :")
+		log.Error(err.Error())
+		os.Exit(-1)
+	}
 
-	arkenModel, err = model.NewArkenModel(serviceDriver, persistenceDriver )
+	if campaign {
+		var elector model.Elector
+		elector, err = CreateElector(persistenceDriver)
+		if err != nil {
+			log.Error("Unable to create the cluster elector :")
+			log.Error(err.Error())
+			os.Exit(-1)
+		}
+		arkenModel, err = model.NewClusteredArkenModel(serviceDriver, persistenceDriver, elector)
+	} else {
+		arkenModel, err = model.NewFollowerArkenModel(serviceDriver, persistenceDriver, ClusterId())
+	}
 	if err != nil {
 		log.Error("Unable to initialize Arken model:")
 		log.Error(err.Error())
@@ -112,3 +147,12 @@ func initConfig() {
 	}
 
 }
+
+// Gives up the leadership of the cluster before the daemon exits.
+func Shutdown() {
+	if arkenModel != nil {
+		if err := arkenModel.Resign(); err != nil {
+			log.Errorf("Unable to resign from the leadership : %v", err)
+		}
+	}
+}
@@ -15,6 +15,9 @@
 package cli
 
 import (
+	"os"
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/arkenio/arken/api"
 	"github.com/arkenio/arken/passivation"
@@ -26,10 +29,35 @@ var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Starts the Arken dameon",
 	Run: func(cmd *cobra.Command, args []string) {
+		initModel(true)
+
+		handler := passivation.NewHandler(arkenModel)
+		policy, err := CreateIdlePolicy(arkenModel)
+		if err != nil {
+			log.Errorf("Unable to create the passivation policy : %v", err)
+			os.Exit(-1)
+		}
+		handler.Policy = policy
+		handler.DryRun = viper.GetBool("passivation.dryRun")
+		handler.WarningDelay = time.Duration(viper.GetInt("passivation.warningInSeconds")) * time.Second
+		if handler.DryRun {
+			log.Info("Passivation runs in dry run mode, its actions are only logged")
+		}
 
+		dispatcher, err := CreateWebhookDispatcher(arkenModel)
+		if err != nil {
+			log.Errorf("Unable to create the webhooks : %v", err)
+			os.Exit(-1)
+		}
 
-		go passivation.NewHandler(arkenModel).Start()
-		api.NewAPIServer(arkenModel).Start()
+		go handler.Start()
+		go dispatcher.Start()
+		go arkenModel.KeepAuditFor(time.Duration(viper.GetInt("audit.retentionInDays")) * 24 * time.Hour)
+		go arkenModel.KeepHistoryFor(time.Duration(viper.GetInt("history.retentionInDays")) * 24 * time.Hour)
+		server := api.NewAPIServer(arkenModel)
+		server.Passivation = handler
+		server.Webhooks = dispatcher
+		server.Start()
 
 
 	},
@@ -0,0 +1,681 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package drivers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/arkenio/arken/goarken/model"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DOCKER_DRIVER      = "docker"
+	DOCKER_API_VERSION = "v1.24"
+
+	// Label set on every container created by arken, holding the service name
+	DOCKER_SERVICE_LABEL = "io.arken.service"
+	// Label holding the container port that is published as the service location
+	DOCKER_PORT_LABEL = "io.arken.port"
+
+	// Suffix of the container kept during an upgrade to allow a rollback
+	dockerPreviousSuffix = "-previous"
+)
+
+func init() {
+	RegisterServiceDriver(&DriverRegistration{
+		Name: DOCKER_DRIVER,
+		Factory: func(config DriverConfig) (ServiceDriver, error) {
+			dockerHost := config.GetString("docker.host")
+			log.Infof("Docker host: %s", dockerHost)
+			sd, err := NewDockerServiceDriver(dockerHost, config.GetString("docker.advertisedHost"))
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to connect to Docker : %s", err.Error()))
+			}
+			return sd, nil
+		},
+		NewPayload:    func() interface{} { return &DockerInfoType{} },
+		LegacyInfoKey: "dockerInfo",
+	})
+}
+
+// Docker specific information of a service, held in the DriverInfo payload
+type DockerInfoType struct {
+	// Image the container is created from
+	Image string `json:"image,omitempty"`
+	// Port of the container that is published as the service location
+	Port int `json:"port,omitempty"`
+}
+
+// Error returned by the Docker Engine API
+type DockerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e DockerError) Error() string {
+	return fmt.Sprintf("Docker API error (%d) : %s", e.StatusCode, e.Message)
+}
+
+func isDockerNotFound(err error) bool {
+	if dockerErr, ok := err.(DockerError); ok {
+		return dockerErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// DockerServiceDriver implements the ServiceDriver interface on top of
+// the Docker Engine API. Each arken Service is a container named after
+// the service.
+type DockerServiceDriver struct {
+	client         *http.Client
+	eventClient    *http.Client
+	baseUrl        string
+	advertisedHost string
+	broadcaster    *Broadcaster
+}
+
+// Creates a Docker service driver. The endpoint is either a unix socket
+// (unix:///var/run/docker.sock) or a TCP address (tcp://host:2375). The
+// advertised host is the host used in service locations when the ports are
+// published on all the interfaces.
+func NewDockerServiceDriver(endpoint string, advertisedHost string) (*DockerServiceDriver, error) {
+	transport, baseUrl, err := dockerTransport(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if advertisedHost == "" {
+		advertisedHost = "localhost"
+	}
+
+	sd := &DockerServiceDriver{
+		client:         &http.Client{Transport: transport, Timeout: 60 * time.Second},
+		eventClient:    &http.Client{Transport: transport},
+		baseUrl:        baseUrl,
+		advertisedHost: advertisedHost,
+		broadcaster:    NewBroadcaster(),
+	}
+
+	if _, err := sd.do("GET", "/_ping", nil, nil); err != nil {
+		return nil, errors.New("Unable to reach Docker daemon : " + err.Error())
+	}
+
+	go sd.watch()
+
+	return sd, nil
+}
+
+func dockerTransport(endpoint string) (*http.Transport, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socketPath := u.Path
+		transport := &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout("unix", socketPath, 10*time.Second)
+			},
+		}
+		return transport, "http://docker", nil
+	case "tcp", "http":
+		return &http.Transport{}, "http://" + u.Host, nil
+	default:
+		return nil, "", errors.New("Unsupported Docker endpoint : " + endpoint)
+	}
+}
+
+// Docker Engine API payloads, limited to what the driver uses.
+
+type dockerPortBinding struct {
+	HostIp   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort"`
+}
+
+type dockerHostConfig struct {
+	PortBindings map[string][]dockerPortBinding `json:"PortBindings,omitempty"`
+}
+
+type dockerContainerConfig struct {
+	Image        string              `json:"Image"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   *dockerHostConfig   `json:"HostConfig,omitempty"`
+}
+
+type dockerContainer struct {
+	Id     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status  string `json:"Status"`
+		Running bool   `json:"Running"`
+		Health  *struct {
+			Status string `json:"Status"`
+		} `json:"Health,omitempty"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Ports map[string][]dockerPortBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+type dockerStats struct {
+	CpuStats struct {
+		CpuUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// Sends a request to the Docker daemon and returns the body of the response.
+func (d *DockerServiceDriver) do(method string, path string, query url.Values, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	u := d.baseUrl + "/" + DOCKER_API_VERSION + path
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		message := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(content, &message) != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(content))
+		}
+		return nil, DockerError{resp.StatusCode, message.Message}
+	}
+	return content, nil
+}
+
+func dockerInfoFromService(s *Service) (*DockerInfoType, error) {
+	if s.Config != nil && s.Config.DriverInfo != nil {
+		if info, ok := s.Config.DriverInfo.Payload.(*DockerInfoType); ok && info.Image != "" {
+			return info, nil
+		}
+	}
+	return nil, errors.New("Docker image has to be specified !")
+}
+
+// Returns the container reference of a service : its id if known, its name otherwise.
+func containerRef(s *Service) string {
+	if s.Config != nil && s.Config.DriverInfo != nil && s.Config.DriverInfo.Driver == DOCKER_DRIVER && s.Config.DriverInfo.Id != "" {
+		return s.Config.DriverInfo.Id
+	}
+	return s.Name
+}
+
+// Converts the service environment to the KEY=value form expected by Docker.
+func dockerEnvFromService(s *Service) []string {
+	env := make([]string, 0, len(s.Config.Environment))
+	for key, value := range s.Config.Environment {
+		env = append(env, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (d *DockerServiceDriver) containerConfigFromService(s *Service, info *DockerInfoType) *dockerContainerConfig {
+	config := &dockerContainerConfig{
+		Image:      info.Image,
+		Env:        dockerEnvFromService(s),
+		Labels:     map[string]string{DOCKER_SERVICE_LABEL: s.Name},
+		HostConfig: &dockerHostConfig{},
+	}
+
+	if info.Port != 0 {
+		port := fmt.Sprintf("%d/tcp", info.Port)
+		config.Labels[DOCKER_PORT_LABEL] = strconv.Itoa(info.Port)
+		config.ExposedPorts = map[string]struct{}{port: {}}
+		// An empty host port lets Docker pick a free one
+		config.HostConfig.PortBindings = map[string][]dockerPortBinding{port: {{HostPort: ""}}}
+	}
+	return config
+}
+
+// Pulls the given image.
+func (d *DockerServiceDriver) pull(image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > 0 && !strings.Contains(image[i:], "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	_, err := d.do("POST", "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil)
+	return err
+}
+
+// Creates the container of a service, pulling its image if needed.
+func (d *DockerServiceDriver) createContainer(s *Service, info *DockerInfoType, name string) (string, error) {
+	config := d.containerConfigFromService(s, info)
+	query := url.Values{"name": {name}}
+
+	content, err := d.do("POST", "/containers/create", query, config)
+	if isDockerNotFound(err) {
+		log.Infof("Pulling image %s for service %s", info.Image, s.Name)
+		if err = d.pull(info.Image); err != nil {
+			return "", errors.New("Unable to pull image " + info.Image + " : " + err.Error())
+		}
+		content, err = d.do("POST", "/containers/create", query, config)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	created := struct {
+		Id string `json:"Id"`
+	}{}
+	if err = json.Unmarshal(content, &created); err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (d *DockerServiceDriver) startContainer(ref string) error {
+	_, err := d.do("POST", "/containers/"+ref+"/start", nil, nil)
+	return err
+}
+
+func (d *DockerServiceDriver) stopContainer(ref string) error {
+	_, err := d.do("POST", "/containers/"+ref+"/stop", url.Values{"t": {"10"}}, nil)
+	return err
+}
+
+func (d *DockerServiceDriver) removeContainer(ref string) error {
+	_, err := d.do("DELETE", "/containers/"+ref, url.Values{"force": {"1"}, "v": {"1"}}, nil)
+	if isDockerNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *DockerServiceDriver) renameContainer(ref string, name string) error {
+	_, err := d.do("POST", "/containers/"+ref+"/rename", url.Values{"name": {name}}, nil)
+	return err
+}
+
+func (d *DockerServiceDriver) inspect(ref string) (*dockerContainer, error) {
+	content, err := d.do("GET", "/containers/"+ref+"/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	container := &dockerContainer{}
+	if err = json.Unmarshal(content, container); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+func (d *DockerServiceDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
+	info, err := dockerInfoFromService(s)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Creating container %s from image %s", s.Name, info.Image)
+	id, err := d.createContainer(s, info, s.Name)
+	if err != nil {
+		log.Error("Error when creating container on Docker side: " + err.Error())
+		return nil, errors.New("Error when creating container on Docker side: " + err.Error())
+	}
+
+	if startOnCreate {
+		if err = d.startContainer(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.infoFromContainerId(id)
+}
+
+func (d *DockerServiceDriver) Start(s *Service) (*DriverInfo, error) {
+	ref := containerRef(s)
+	if err := d.startContainer(ref); err != nil {
+		return nil, err
+	}
+	return d.infoFromContainerId(ref)
+}
+
+func (d *DockerServiceDriver) Stop(s *Service) (*DriverInfo, error) {
+	ref := containerRef(s)
+	if err := d.stopContainer(ref); err != nil {
+		return nil, err
+	}
+	return d.infoFromContainerId(ref)
+}
+
+func (d *DockerServiceDriver) Destroy(s *Service) error {
+	if err := d.removeContainer(s.Name + dockerPreviousSuffix); err != nil {
+		return err
+	}
+	return d.removeContainer(containerRef(s))
+}
+
+// Upgrades a service by replacing its container by a new one built from the
+// current definition. The old container is kept stopped until the upgrade
+// is finished, so that it can be rolled back.
+func (d *DockerServiceDriver) Upgrade(s *Service) (*DriverInfo, error) {
+	info, err := dockerInfoFromService(s)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Upgrading container %s to image %s", s.Name, info.Image)
+
+	previousName := s.Name + dockerPreviousSuffix
+	if err = d.removeContainer(previousName); err != nil {
+		return nil, err
+	}
+
+	ref := containerRef(s)
+	if err = d.stopContainer(ref); err != nil && !isDockerNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = d.renameContainer(ref, previousName); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := d.createContainer(s, info, s.Name)
+	if err != nil {
+		log.Errorf("Container upgrade failed in Docker : %v", err)
+		return nil, err
+	}
+	if err = d.startContainer(id); err != nil {
+		return nil, err
+	}
+
+	return d.infoFromContainerId(id)
+}
+
+func (d *DockerServiceDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
+	log.Infof("Finishing upgrading container %s", s.Name)
+
+	if err := d.removeContainer(s.Name + dockerPreviousSuffix); err != nil {
+		return nil, err
+	}
+	return d.infoFromContainerId(s.Name)
+}
+
+func (d *DockerServiceDriver) Rollback(s *Service) (*DriverInfo, error) {
+	log.Infof("Rollbacking container %s", s.Name)
+
+	previousName := s.Name + dockerPreviousSuffix
+	if _, err := d.inspect(previousName); err != nil {
+		return nil, errors.New("No previous container to rollback to : " + err.Error())
+	}
+
+	if err := d.removeContainer(s.Name); err != nil {
+		return nil, err
+	}
+	if err := d.renameContainer(previousName, s.Name); err != nil {
+		return nil, err
+	}
+	if err := d.startContainer(s.Name); err != nil {
+		return nil, err
+	}
+	return d.infoFromContainerId(s.Name)
+}
+
+// Tells if the container differs from the service definition (image or environment)
+func (d *DockerServiceDriver) NeedToBeUpgraded(s *Service) (bool, error) {
+	info, err := dockerInfoFromService(s)
+	if err != nil {
+		return false, err
+	}
+
+	container, err := d.inspect(containerRef(s))
+	if err != nil {
+		log.Errorf("Error when fetching the container %v", err)
+		return false, err
+	}
+
+	if container.Config.Image != info.Image {
+		return true, nil
+	}
+
+	// The container environment also holds the variables defined by the image
+	actualEnv := make(map[string]bool, len(container.Config.Env))
+	for _, value := range container.Config.Env {
+		actualEnv[value] = true
+	}
+	for _, value := range dockerEnvFromService(s) {
+		if !actualEnv[value] {
+			return true, nil
+		}
+	}
+	return container.Config.Labels[DOCKER_PORT_LABEL] != containerPortLabel(info), nil
+}
+
+func containerPortLabel(info *DockerInfoType) string {
+	if info.Port == 0 {
+		return ""
+	}
+	return strconv.Itoa(info.Port)
+}
+
+func (d *DockerServiceDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	return d.infoFromContainerId(containerRef(s))
+}
+
+// Returns the CPU and network counters of the container of the service.
+func (d *DockerServiceDriver) Activity(s *Service) (*ActivityStats, error) {
+	content, err := d.do("GET", "/containers/"+containerRef(s)+"/stats", url.Values{"stream": {"false"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &dockerStats{}
+	if err := json.Unmarshal(content, stats); err != nil {
+		return nil, err
+	}
+
+	activity := &ActivityStats{CpuUsage: stats.CpuStats.CpuUsage.TotalUsage}
+	for _, network := range stats.Networks {
+		activity.NetworkBytes += network.RxBytes + network.TxBytes
+	}
+	return activity, nil
+}
+
+func (d *DockerServiceDriver) Listen() chan *ModelEvent {
+	return FromInterfaceChannel(d.broadcaster.Listen())
+}
+
+func (d *DockerServiceDriver) infoFromContainerId(ref string) (*DriverInfo, error) {
+	container, err := d.inspect(ref)
+	if err != nil {
+		return nil, err
+	}
+	return d.dockerInfoFromContainer(container), nil
+}
+
+func (d *DockerServiceDriver) dockerInfoFromContainer(c *dockerContainer) *DriverInfo {
+	payload := &DockerInfoType{Image: c.Config.Image}
+	info := &DriverInfo{
+		Driver:      DOCKER_DRIVER,
+		Id:          c.Id,
+		ServiceName: strings.TrimPrefix(c.Name, "/"),
+		HealthState: c.State.Status,
+		Payload:     payload,
+	}
+
+	if serviceName, ok := c.Config.Labels[DOCKER_SERVICE_LABEL]; ok {
+		info.ServiceName = serviceName
+	}
+
+	health := ""
+	if c.State.Health != nil {
+		health = c.State.Health.Status
+		info.HealthState = health
+	}
+	info.CurrentStatus = convertDockerStateToStatus(c.State.Status, health)
+
+	if port, err := strconv.Atoi(c.Config.Labels[DOCKER_PORT_LABEL]); err == nil {
+		payload.Port = port
+		bindings := c.NetworkSettings.Ports[fmt.Sprintf("%d/tcp", port)]
+		if len(bindings) > 0 {
+			hostPort, err := strconv.Atoi(bindings[0].HostPort)
+			if err == nil {
+				host := bindings[0].HostIp
+				if host == "" || host == "0.0.0.0" || host == "::" {
+					host = d.advertisedHost
+				}
+				info.Location = &Location{Host: host, Port: hostPort}
+			}
+		}
+	}
+	return info
+}
+
+func convertDockerStateToStatus(state string, health string) string {
+	switch state {
+	case "running":
+		switch health {
+		case "starting":
+			return STARTING_STATUS
+		case "unhealthy":
+			return ERROR_STATUS
+		default:
+			return STARTED_STATUS
+		}
+	case "restarting":
+		return STARTING_STATUS
+	case "removing":
+		return STOPPING_STATUS
+	default:
+		return STOPPED_STATUS
+	}
+}
+
+// Streams the Docker events of the containers created by arken and publishes
+// the updated DriverInfo of each changed container.
+func (d *DockerServiceDriver) watch() {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"label": {DOCKER_SERVICE_LABEL},
+	})
+	u := d.baseUrl + "/" + DOCKER_API_VERSION + "/events?" + url.Values{"filters": {string(filters)}}.Encode()
+
+	for {
+		resp, err := d.eventClient.Get(u)
+		if err == nil {
+			d.readEvents(resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Warningf("Unable to listen to Docker events : %v", err)
+		}
+
+		log.Warningf("Waiting 1 second and relaunch Docker event stream")
+		time.Sleep(time.Second)
+	}
+}
+
+func (d *DockerServiceDriver) readEvents(stream io.Reader) {
+	decoder := json.NewDecoder(bufio.NewReader(stream))
+	for {
+		event := &dockerEvent{}
+		if err := decoder.Decode(event); err != nil {
+			return
+		}
+		if info := d.infoFromEvent(event); info != nil {
+			d.broadcaster.Write(NewModelEvent("update", info))
+		}
+	}
+}
+
+func (d *DockerServiceDriver) infoFromEvent(event *dockerEvent) *DriverInfo {
+	if event.Type != "container" {
+		return nil
+	}
+
+	serviceName := event.Actor.Attributes[DOCKER_SERVICE_LABEL]
+	name := event.Actor.Attributes["name"]
+	if serviceName == "" || name != serviceName {
+		// Containers kept for a rollback do not reflect the service state
+		return nil
+	}
+
+	if event.Action == "destroy" {
+		return &DriverInfo{
+			Driver:        DOCKER_DRIVER,
+			Id:            event.Actor.ID,
+			ServiceName:   serviceName,
+			CurrentStatus: STOPPED_STATUS,
+		}
+	}
+
+	info, err := d.infoFromContainerId(event.Actor.ID)
+	if err != nil {
+		log.Warningf("Unable to inspect container %s : %v", event.Actor.ID, err)
+		return nil
+	}
+	return info
+}
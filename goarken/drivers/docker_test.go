@@ -0,0 +1,396 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeContainer struct {
+	Id     string
+	Name   string
+	Image  string
+	Env    []string
+	Labels map[string]string
+	Status string
+}
+
+// Minimal in-memory implementation of the Docker Engine API
+type fakeDocker struct {
+	sync.Mutex
+	containers map[string]*fakeContainer
+	images     map[string]bool
+	nextId     int
+	events     chan *dockerEvent
+	done       chan struct{}
+}
+
+func newFakeDocker() *fakeDocker {
+	return &fakeDocker{
+		containers: make(map[string]*fakeContainer),
+		images:     make(map[string]bool),
+		events:     make(chan *dockerEvent, 100),
+		done:       make(chan struct{}),
+	}
+}
+
+func (f *fakeDocker) find(ref string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.Id == ref || c.Name == ref {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDocker) emit(action string, c *fakeContainer) {
+	event := &dockerEvent{Type: "container", Action: action}
+	event.Actor.ID = c.Id
+	event.Actor.Attributes = map[string]string{"name": c.Name}
+	for k, v := range c.Labels {
+		event.Actor.Attributes[k] = v
+	}
+	f.events <- event
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	writeJson(w, http.StatusNotFound, map[string]string{"message": message})
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/"+DOCKER_API_VERSION)
+	query := r.URL.Query()
+
+	if path == "/events" {
+		f.serveEvents(w)
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+
+	case path == "/images/create":
+		f.images[query.Get("fromImage")+":"+query.Get("tag")] = true
+		w.WriteHeader(http.StatusOK)
+
+	case path == "/containers/create":
+		config := &dockerContainerConfig{}
+		json.NewDecoder(r.Body).Decode(config)
+		image := config.Image
+		if !strings.Contains(image, ":") {
+			image = image + ":latest"
+		}
+		if !f.images[image] {
+			notFound(w, "No such image: "+image)
+			return
+		}
+		f.nextId++
+		c := &fakeContainer{
+			Id:     fmt.Sprintf("container%d", f.nextId),
+			Name:   query.Get("name"),
+			Image:  config.Image,
+			Env:    config.Env,
+			Labels: config.Labels,
+			Status: "created",
+		}
+		f.containers[c.Id] = c
+		f.emit("create", c)
+		writeJson(w, http.StatusCreated, map[string]string{"Id": c.Id})
+
+	case strings.HasPrefix(path, "/containers/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+		c := f.find(parts[0])
+		if c == nil {
+			notFound(w, "No such container: "+parts[0])
+			return
+		}
+
+		action := ""
+		if len(parts) > 1 {
+			action = parts[1]
+		}
+
+		switch {
+		case r.Method == "DELETE":
+			delete(f.containers, c.Id)
+			f.emit("destroy", c)
+			w.WriteHeader(http.StatusNoContent)
+		case action == "start":
+			c.Status = "running"
+			f.emit("start", c)
+			w.WriteHeader(http.StatusNoContent)
+		case action == "stop":
+			c.Status = "exited"
+			f.emit("die", c)
+			w.WriteHeader(http.StatusNoContent)
+		case action == "rename":
+			c.Name = query.Get("name")
+			f.emit("rename", c)
+			w.WriteHeader(http.StatusNoContent)
+		case action == "json":
+			f.inspect(w, c)
+		case action == "stats":
+			stats := &dockerStats{}
+			stats.CpuStats.CpuUsage.TotalUsage = 2000
+			stats.Networks = map[string]struct {
+				RxBytes uint64 `json:"rx_bytes"`
+				TxBytes uint64 `json:"tx_bytes"`
+			}{"eth0": {RxBytes: 100, TxBytes: 20}, "eth1": {RxBytes: 3}}
+			writeJson(w, http.StatusOK, stats)
+		default:
+			http.NotFound(w, r)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDocker) inspect(w http.ResponseWriter, c *fakeContainer) {
+	container := &dockerContainer{Id: c.Id, Name: "/" + c.Name}
+	container.Config.Image = c.Image
+	container.Config.Env = append([]string{"PATH=/usr/bin"}, c.Env...)
+	container.Config.Labels = c.Labels
+	container.State.Status = c.Status
+	container.State.Running = c.Status == "running"
+	if port, ok := c.Labels[DOCKER_PORT_LABEL]; ok && container.State.Running {
+		container.NetworkSettings.Ports = map[string][]dockerPortBinding{
+			port + "/tcp": {{HostIp: "0.0.0.0", HostPort: "32768"}},
+		}
+	}
+	writeJson(w, http.StatusOK, container)
+}
+
+func (f *fakeDocker) serveEvents(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-f.events:
+			encoder.Encode(event)
+			w.(http.Flusher).Flush()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func startFakeDocker() (*fakeDocker, *httptest.Server, string) {
+	dir, err := ioutil.TempDir("", "arken-docker")
+	if err != nil {
+		panic(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		panic(err)
+	}
+
+	fake := newFakeDocker()
+	server := httptest.NewUnstartedServer(fake)
+	server.Listener = listener
+	server.Start()
+	return fake, server, socket
+}
+
+func waitForDockerInfo(events chan *ModelEvent, status string) *DriverInfo {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if info, ok := event.Model.(*DriverInfo); ok && info.Driver == DOCKER_DRIVER && info.CurrentStatus == status {
+				return info
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func Test_DockerDriver(t *testing.T) {
+
+	fake, server, socket := startFakeDocker()
+	defer os.RemoveAll(filepath.Dir(socket))
+	defer server.Close()
+	defer close(fake.done)
+
+	sd, err := NewDockerServiceDriver("unix://"+socket, "docker.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the driver channel drained so that the event stream never blocks
+	events := make(chan *ModelEvent, 100)
+	go func() {
+		for event := range sd.Listen() {
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}()
+
+	Convey("Given a Docker service driver", t, func() {
+
+		service := &Service{Name: "testService"}
+		service.Init()
+		service.Config.Environment = map[string]interface{}{"NUXEO_PACKAGES": "nuxeo-web-ui"}
+		service.Config.DriverInfo = &DriverInfo{Driver: DOCKER_DRIVER, Payload: &DockerInfoType{Image: "nuxeo:8.10", Port: 8080}}
+
+		Convey("When a service without image is created", func() {
+			_, err := sd.Create(&Service{Name: "noImage", Config: &ServiceConfig{}}, false)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the service is created", func() {
+			info, err := sd.Create(service, false)
+			So(err, ShouldBeNil)
+			service.Config.DriverInfo = info
+
+			Convey("Then the image is pulled and the container is created stopped", func() {
+				So(fake.images["nuxeo:8.10"], ShouldBeTrue)
+				So(info.Id, ShouldNotEqual, "")
+				So(info.ServiceName, ShouldEqual, "testService")
+				So(info.Payload.(*DockerInfoType).Image, ShouldEqual, "nuxeo:8.10")
+				So(info.Payload.(*DockerInfoType).Port, ShouldEqual, 8080)
+				So(info.CurrentStatus, ShouldEqual, STOPPED_STATUS)
+			})
+
+			Convey("Then the container gets the service environment", func() {
+				So(fake.find("testService").Env, ShouldResemble, []string{"NUXEO_PACKAGES=nuxeo-web-ui"})
+			})
+
+			Convey("When the service is started", func() {
+				info, err := sd.Start(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then it is started and located on the published port", func() {
+					So(info.CurrentStatus, ShouldEqual, STARTED_STATUS)
+					So(info.Location, ShouldResemble, &Location{Host: "docker.local", Port: 32768})
+				})
+
+				Convey("Then the start is published on the driver channel", func() {
+					info := waitForDockerInfo(events, STARTED_STATUS)
+					So(info, ShouldNotBeNil)
+					So(info.ServiceName, ShouldEqual, "testService")
+				})
+
+				Convey("Then its activity is reported", func() {
+					activity, err := sd.Activity(service)
+					So(err, ShouldBeNil)
+					So(activity.CpuUsage, ShouldEqual, 2000)
+					So(activity.NetworkBytes, ShouldEqual, 123)
+				})
+
+				Convey("When the service is stopped", func() {
+					result, err := sd.Stop(service)
+					So(err, ShouldBeNil)
+
+					Convey("Then it is reported as stopped", func() {
+						So(result.CurrentStatus, ShouldEqual, STOPPED_STATUS)
+						So(result.Location, ShouldBeNil)
+					})
+				})
+			})
+
+			Convey("Then it does not need to be upgraded", func() {
+				needUpgrade, err := sd.NeedToBeUpgraded(service)
+				So(err, ShouldBeNil)
+				So(needUpgrade, ShouldBeFalse)
+			})
+
+			Convey("When the image of the service changes", func() {
+				service.Config.DriverInfo.Payload.(*DockerInfoType).Image = "nuxeo:9.1"
+
+				Convey("Then it needs to be upgraded", func() {
+					needUpgrade, err := sd.NeedToBeUpgraded(service)
+					So(err, ShouldBeNil)
+					So(needUpgrade, ShouldBeTrue)
+				})
+
+				Convey("When the service is upgraded", func() {
+					result, err := sd.Upgrade(service)
+					So(err, ShouldBeNil)
+					service.Config.DriverInfo = result
+
+					Convey("Then a new container runs the new image", func() {
+						So(service.Config.DriverInfo.Payload.(*DockerInfoType).Image, ShouldEqual, "nuxeo:9.1")
+						So(service.Config.DriverInfo.CurrentStatus, ShouldEqual, STARTED_STATUS)
+						So(fake.find("testService-previous"), ShouldNotBeNil)
+					})
+
+					Convey("When the upgrade is rollbacked", func() {
+						result, err := sd.Rollback(service)
+						So(err, ShouldBeNil)
+
+						Convey("Then the previous container is back", func() {
+							So(result.Payload.(*DockerInfoType).Image, ShouldEqual, "nuxeo:8.10")
+							So(fake.find("testService-previous"), ShouldBeNil)
+						})
+					})
+
+					Convey("When the upgrade is finished", func() {
+						_, err := sd.FinishUpgrade(service)
+						So(err, ShouldBeNil)
+
+						Convey("Then the previous container is removed", func() {
+							So(fake.find("testService-previous"), ShouldBeNil)
+							So(fake.find("testService").Image, ShouldEqual, "nuxeo:9.1")
+						})
+					})
+				})
+			})
+
+			Convey("When the service is destroyed", func() {
+				err := sd.Destroy(service)
+
+				Convey("Then the container is removed", func() {
+					So(err, ShouldBeNil)
+					So(fake.find("testService"), ShouldBeNil)
+				})
+			})
+
+			Reset(func() {
+				sd.Destroy(&Service{Name: "testService"})
+			})
+		})
+	})
+}
@@ -22,6 +22,24 @@ import (
 	"strings"
 )
 
+const FLEET_DRIVER = "fleet"
+
+func init() {
+	RegisterServiceDriver(&DriverRegistration{
+		Name: FLEET_DRIVER,
+		Factory: func(config DriverConfig) (ServiceDriver, error) {
+			return NewFleetServiceDriver(config.GetString("etcdAddress")), nil
+		},
+		NewPayload:    func() interface{} { return &FleetInfoType{} },
+		LegacyInfoKey: "fleetInfo",
+	})
+}
+
+// Fleet specific information of a service, held in the DriverInfo payload
+type FleetInfoType struct {
+	UnitName string
+}
+
 type FleetServiceDriver struct {
 	etcdAddress string
 }
@@ -30,35 +48,35 @@ func NewFleetServiceDriver(etcdAddress string) *FleetServiceDriver {
 	return &FleetServiceDriver{etcdAddress}
 }
 
-func (f *FleetServiceDriver) Create(s *Service, startOnCreate bool) (interface{}, error) {
+func (f *FleetServiceDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
 	return nil, errors.New("Not implemented")
 }
 
-func (f *FleetServiceDriver) Start(s *Service) (interface{}, error) {
+func (f *FleetServiceDriver) Start(s *Service) (*DriverInfo, error) {
 	err := f.fleetcmd(s, "start")
-	return s, err
+	return fleetDriverInfo(s, err)
 }
 
-func (f *FleetServiceDriver) Stop(s *Service) (interface{}, error) {
+func (f *FleetServiceDriver) Stop(s *Service) (*DriverInfo, error) {
 	err := f.fleetcmd(s, "stop")
-	return s, err
+	return fleetDriverInfo(s, err)
 }
 
 
-func (f *FleetServiceDriver) Upgrade(s *Service) (interface{}, error) {
+func (f *FleetServiceDriver) Upgrade(s *Service) (*DriverInfo, error) {
 	err := f.fleetcmd(s, "stop")
 	if(err != nil) {
 		return nil, err;
 	}
 	err = f.fleetcmd(s, "start")
-	return s, err
+	return fleetDriverInfo(s, err)
 }
 
-func (f *FleetServiceDriver) FinishUpgrade(s *Service) (interface{}, error) {
+func (f *FleetServiceDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
 	return nil, errors.New("Not implemented")
 }
 
-func (f *FleetServiceDriver) Rollback(s *Service) (interface{}, error) {
+func (f *FleetServiceDriver) Rollback(s *Service) (*DriverInfo, error) {
 	return nil, errors.New("Not implemented")
 }
 
@@ -110,8 +128,22 @@ func (f *FleetServiceDriver) Listen() chan *ModelEvent {
 	return nil
 }
 
-func (f *FleetServiceDriver) GetInfo(s *Service) (interface{}, error) {
-	return &FleetInfoType{UnitName: unitNameFromService(s)}, nil
+func (f *FleetServiceDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	return fleetDriverInfo(s, nil)
+}
+
+// Fleet does not report the state of the units : only the unit is known.
+func fleetDriverInfo(s *Service, err error) (*DriverInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	unitName := unitNameFromService(s)
+	return &DriverInfo{
+		Driver:      FLEET_DRIVER,
+		Id:          unitName,
+		ServiceName: s.Name,
+		Payload:     &FleetInfoType{UnitName: unitName},
+	}, nil
 }
 
 func (r *FleetServiceDriver) NeedToBeUpgraded(s *Service) (bool, error) {
@@ -0,0 +1,623 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/arkenio/arken/goarken/model"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KUBERNETES_DRIVER = "kubernetes"
+
+	// Label set on the deployments, pods and services created by arken
+	KUBERNETES_SERVICE_LABEL = "arken.io/service"
+	// Annotation holding the name of the arken service, which may not be a valid Kubernetes name
+	KUBERNETES_SERVICE_NAME_ANNOTATION = "arken.io/service-name"
+	// Annotation holding the revision to go back to when an upgrade is rollbacked
+	KUBERNETES_PREVIOUS_REVISION_ANNOTATION = "arken.io/previous-revision"
+
+	// Annotation maintained by the deployment controller
+	kubernetesRevisionAnnotation = "deployment.kubernetes.io/revision"
+	// Label added by the deployment controller on its replica sets
+	kubernetesPodTemplateHashLabel = "pod-template-hash"
+	// Name of the container running the service in the pods
+	kubernetesContainerName = "service"
+)
+
+var kubernetesInvalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+func init() {
+	RegisterServiceDriver(&DriverRegistration{
+		Name: KUBERNETES_DRIVER,
+		Factory: func(config DriverConfig) (ServiceDriver, error) {
+			namespace := config.GetString("kubernetes.namespace")
+			log.Infof("Kubernetes namespace: %s", namespace)
+			sd, err := NewKubernetesServiceDriver(config.GetString("kubernetes.kubeconfig"), namespace)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to connect to Kubernetes : %s", err.Error()))
+			}
+			return sd, nil
+		},
+		NewPayload:    func() interface{} { return &KubernetesInfoType{} },
+		LegacyInfoKey: "kubernetesInfo",
+	})
+}
+
+// Kubernetes specific information of a service, held in the DriverInfo payload
+type KubernetesInfoType struct {
+	Namespace      string `json:"namespace,omitempty"`
+	DeploymentName string `json:"deploymentName,omitempty"`
+	// Image of the deployment pods
+	Image string `json:"image,omitempty"`
+	// Port of the pods that is exposed by the Kubernetes service
+	Port int `json:"port,omitempty"`
+	// Rollout revision of the deployment
+	Revision string `json:"revision,omitempty"`
+}
+
+// KubernetesServiceDriver implements the ServiceDriver interface on top of
+// Kubernetes. Each arken Service is a Deployment, exposed by a Kubernetes
+// Service of the same name when a port is defined.
+type KubernetesServiceDriver struct {
+	clientset   kubernetes.Interface
+	namespace   string
+	broadcaster *Broadcaster
+}
+
+// Creates a Kubernetes service driver working in the given namespace. When
+// no kubeconfig is given, the in-cluster configuration is used.
+func NewKubernetesServiceDriver(kubeconfig string, namespace string) (*KubernetesServiceDriver, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, errors.New("Unable to load Kubernetes configuration : " + err.Error())
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newKubernetesServiceDriver(clientset, namespace)
+}
+
+func newKubernetesServiceDriver(clientset kubernetes.Interface, namespace string) (*KubernetesServiceDriver, error) {
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	sd := &KubernetesServiceDriver{
+		clientset:   clientset,
+		namespace:   namespace,
+		broadcaster: NewBroadcaster(),
+	}
+
+	// The first watch is opened synchronously to check that the cluster is reachable
+	w, err := sd.watchDeployments()
+	if err != nil {
+		return nil, errors.New("Unable to watch Kubernetes deployments : " + err.Error())
+	}
+	go sd.watch(w)
+
+	return sd, nil
+}
+
+// Converts a service name to a valid Kubernetes object name (RFC 1035 label).
+func kubernetesName(name string) string {
+	result := kubernetesInvalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	result = strings.Trim(result, "-")
+	if result == "" || result[0] < 'a' || result[0] > 'z' {
+		result = "arken-" + result
+	}
+	if len(result) > 63 {
+		result = strings.TrimRight(result[:63], "-")
+	}
+	return result
+}
+
+func kubernetesInfoFromService(s *Service) (*KubernetesInfoType, error) {
+	if s.Config != nil && s.Config.DriverInfo != nil {
+		if info, ok := s.Config.DriverInfo.Payload.(*KubernetesInfoType); ok && info.Image != "" {
+			return info, nil
+		}
+	}
+	return nil, errors.New("Kubernetes image has to be specified !")
+}
+
+// Returns the deployment name of a service : the one known by the service, or
+// the one derived from its name.
+func deploymentName(s *Service) string {
+	if s.Config != nil && s.Config.DriverInfo != nil {
+		if info, ok := s.Config.DriverInfo.Payload.(*KubernetesInfoType); ok && info.DeploymentName != "" {
+			return info.DeploymentName
+		}
+	}
+	return kubernetesName(s.Name)
+}
+
+// Converts the service environment to the container environment, sorted by name.
+func kubernetesEnvFromService(s *Service) []corev1.EnvVar {
+	env := make([]corev1.EnvVar, 0, len(s.Config.Environment))
+	for key, value := range s.Config.Environment {
+		env = append(env, corev1.EnvVar{Name: key, Value: fmt.Sprintf("%v", value)})
+	}
+	sort.Sort(envVarsByName(env))
+	return env
+}
+
+type envVarsByName []corev1.EnvVar
+
+func (e envVarsByName) Len() int           { return len(e) }
+func (e envVarsByName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e envVarsByName) Less(i, j int) bool { return e[i].Name < e[j].Name }
+
+func (k *KubernetesServiceDriver) podTemplateFromService(s *Service, info *KubernetesInfoType) corev1.PodTemplateSpec {
+	container := corev1.Container{
+		Name:  kubernetesContainerName,
+		Image: info.Image,
+		Env:   kubernetesEnvFromService(s),
+	}
+	if info.Port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: int32(info.Port), Protocol: corev1.ProtocolTCP}}
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{KUBERNETES_SERVICE_LABEL: kubernetesName(s.Name)},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+		},
+	}
+}
+
+func (k *KubernetesServiceDriver) getDeployment(name string) (*appsv1.Deployment, error) {
+	return k.clientset.AppsV1().Deployments(k.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *KubernetesServiceDriver) updateDeployment(deployment *appsv1.Deployment) (*DriverInfo, error) {
+	updated, err := k.clientset.AppsV1().Deployments(k.namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return k.kubernetesInfoFromDeployment(updated), nil
+}
+
+// Creates, updates or deletes the Kubernetes service exposing the deployment
+// so that it matches the port of the service.
+func (k *KubernetesServiceDriver) syncKubernetesService(s *Service, info *KubernetesInfoType) error {
+	name := deploymentName(s)
+	services := k.clientset.CoreV1().Services(k.namespace)
+
+	existing, err := services.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	if info.Port == 0 {
+		if found {
+			return k.deleteKubernetesService(name)
+		}
+		return nil
+	}
+
+	ports := []corev1.ServicePort{{
+		Name:       "http",
+		Port:       int32(info.Port),
+		TargetPort: intstr.FromInt(info.Port),
+		Protocol:   corev1.ProtocolTCP,
+	}}
+
+	if found {
+		existing.Spec.Ports = ports
+		_, err = services.Update(context.TODO(), existing, metav1.UpdateOptions{})
+		return err
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{KUBERNETES_SERVICE_LABEL: kubernetesName(s.Name)},
+			Annotations: map[string]string{KUBERNETES_SERVICE_NAME_ANNOTATION: s.Name},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{KUBERNETES_SERVICE_LABEL: kubernetesName(s.Name)},
+			Ports:    ports,
+		},
+	}
+	_, err = services.Create(context.TODO(), service, metav1.CreateOptions{})
+	return err
+}
+
+func (k *KubernetesServiceDriver) deleteKubernetesService(name string) error {
+	err := k.clientset.CoreV1().Services(k.namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Scales the deployment of a service to the given number of replicas.
+func (k *KubernetesServiceDriver) scale(s *Service, replicas int32) (*DriverInfo, error) {
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		return nil, err
+	}
+	deployment.Spec.Replicas = &replicas
+	return k.updateDeployment(deployment)
+}
+
+func (k *KubernetesServiceDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
+	info, err := kubernetesInfoFromService(s)
+	if err != nil {
+		return nil, err
+	}
+
+	name := kubernetesName(s.Name)
+	log.Infof("Creating deployment %s from image %s", name, info.Image)
+
+	replicas := int32(0)
+	if startOnCreate {
+		replicas = 1
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{KUBERNETES_SERVICE_LABEL: name},
+			Annotations: map[string]string{KUBERNETES_SERVICE_NAME_ANNOTATION: s.Name},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{KUBERNETES_SERVICE_LABEL: name},
+			},
+			Template: k.podTemplateFromService(s, info),
+		},
+	}
+
+	created, err := k.clientset.AppsV1().Deployments(k.namespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	if err != nil {
+		log.Error("Error when creating deployment on Kubernetes side: " + err.Error())
+		return nil, errors.New("Error when creating deployment on Kubernetes side: " + err.Error())
+	}
+
+	if err = k.syncKubernetesService(s, info); err != nil {
+		log.Error("Error when creating service on Kubernetes side: " + err.Error())
+		return nil, errors.New("Error when creating service on Kubernetes side: " + err.Error())
+	}
+
+	return k.kubernetesInfoFromDeployment(created), nil
+}
+
+func (k *KubernetesServiceDriver) Start(s *Service) (*DriverInfo, error) {
+	return k.scale(s, 1)
+}
+
+// Stops a service by scaling its deployment to zero, which keeps its
+// definition and its rollout history.
+func (k *KubernetesServiceDriver) Stop(s *Service) (*DriverInfo, error) {
+	return k.scale(s, 0)
+}
+
+func (k *KubernetesServiceDriver) Destroy(s *Service) error {
+	name := deploymentName(s)
+	if err := k.deleteKubernetesService(name); err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err := k.clientset.AppsV1().Deployments(k.namespace).Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Upgrades a service by rolling out its current definition. The revision
+// running before the upgrade is recorded so that it can be rolled back until
+// the upgrade is finished.
+func (k *KubernetesServiceDriver) Upgrade(s *Service) (*DriverInfo, error) {
+	info, err := kubernetesInfoFromService(s)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		log.Errorf("Error when retrieving deployment: %v", err)
+		return nil, err
+	}
+
+	log.Infof("Upgrading deployment %s to image %s", deployment.Name, info.Image)
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	if revision := deployment.Annotations[kubernetesRevisionAnnotation]; revision != "" {
+		deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION] = revision
+	}
+
+	replicas := int32(1)
+	deployment.Spec.Replicas = &replicas
+	deployment.Spec.Template = k.podTemplateFromService(s, info)
+
+	result, err := k.updateDeployment(deployment)
+	if err != nil {
+		log.Errorf("Deployment upgrade failed in Kubernetes : %v", err)
+		return nil, err
+	}
+
+	if err = k.syncKubernetesService(s, info); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Finishes an upgrade : the rollout is handled by Kubernetes, so only the
+// recorded revision is forgotten.
+func (k *KubernetesServiceDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Finishing upgrading deployment %s", deployment.Name)
+
+	if _, ok := deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION]; !ok {
+		return k.kubernetesInfoFromDeployment(deployment), nil
+	}
+	delete(deployment.Annotations, KUBERNETES_PREVIOUS_REVISION_ANNOTATION)
+	return k.updateDeployment(deployment)
+}
+
+// Rollbacks a service to the revision recorded by the last upgrade, or to the
+// latest revision before the current one. Like `kubectl rollout undo`, the pod
+// template of the replica set of that revision is rolled out again.
+func (k *KubernetesServiceDriver) Rollback(s *Service) (*DriverInfo, error) {
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Rollbacking deployment %s", deployment.Name)
+
+	target, err := k.previousReplicaSet(deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	template := *target.Spec.Template.DeepCopy()
+	delete(template.Labels, kubernetesPodTemplateHashLabel)
+	deployment.Spec.Template = template
+	delete(deployment.Annotations, KUBERNETES_PREVIOUS_REVISION_ANNOTATION)
+
+	result, err := k.updateDeployment(deployment)
+	if err != nil {
+		log.Errorf("Rollbacking deployment failed in Kubernetes : %v", err)
+		return nil, err
+	}
+
+	// Keep the Kubernetes service in line with the restored port
+	restored := &KubernetesInfoType{}
+	if ports := template.Spec.Containers[0].Ports; len(ports) > 0 {
+		restored.Port = int(ports[0].ContainerPort)
+	}
+	if err = k.syncKubernetesService(s, restored); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Returns the replica set of the revision to rollback to.
+func (k *KubernetesServiceDriver) previousReplicaSet(deployment *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaSets, err := k.clientset.AppsV1().ReplicaSets(k.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	current := revisionOf(deployment.ObjectMeta)
+	wanted, _ := strconv.ParseInt(deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION], 10, 64)
+
+	var result *appsv1.ReplicaSet
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if !metav1.IsControlledBy(rs, deployment) || len(rs.Spec.Template.Spec.Containers) == 0 {
+			continue
+		}
+		revision := revisionOf(rs.ObjectMeta)
+		if wanted != 0 {
+			if revision == wanted {
+				return rs, nil
+			}
+		} else if revision < current && (result == nil || revision > revisionOf(result.ObjectMeta)) {
+			result = rs
+		}
+	}
+
+	if result == nil {
+		return nil, errors.New("No previous revision to rollback to for deployment " + deployment.Name)
+	}
+	return result, nil
+}
+
+func revisionOf(meta metav1.ObjectMeta) int64 {
+	revision, _ := strconv.ParseInt(meta.Annotations[kubernetesRevisionAnnotation], 10, 64)
+	return revision
+}
+
+// Tells if the deployment differs from the service definition (image, environment or port)
+func (k *KubernetesServiceDriver) NeedToBeUpgraded(s *Service) (bool, error) {
+	info, err := kubernetesInfoFromService(s)
+	if err != nil {
+		return false, err
+	}
+
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		log.Errorf("Error when fetching the deployment %v", err)
+		return false, err
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return true, nil
+	}
+	expected := k.podTemplateFromService(s, info).Spec.Containers[0]
+
+	if containers[0].Image != expected.Image || len(containers[0].Env) != len(expected.Env) || len(containers[0].Ports) != len(expected.Ports) {
+		return true, nil
+	}
+	actualEnv := append([]corev1.EnvVar{}, containers[0].Env...)
+	sort.Sort(envVarsByName(actualEnv))
+	for i, env := range expected.Env {
+		if actualEnv[i].Name != env.Name || actualEnv[i].Value != env.Value || actualEnv[i].ValueFrom != nil {
+			return true, nil
+		}
+	}
+	for i, port := range expected.Ports {
+		if containers[0].Ports[i].ContainerPort != port.ContainerPort {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (k *KubernetesServiceDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	deployment, err := k.getDeployment(deploymentName(s))
+	if err != nil {
+		return nil, err
+	}
+	return k.kubernetesInfoFromDeployment(deployment), nil
+}
+
+func (k *KubernetesServiceDriver) Listen() chan *ModelEvent {
+	return FromInterfaceChannel(k.broadcaster.Listen())
+}
+
+func (k *KubernetesServiceDriver) kubernetesInfoFromDeployment(d *appsv1.Deployment) *DriverInfo {
+	payload := &KubernetesInfoType{
+		Namespace:      d.Namespace,
+		DeploymentName: d.Name,
+		Revision:       d.Annotations[kubernetesRevisionAnnotation],
+	}
+	if payload.Namespace == "" {
+		payload.Namespace = k.namespace
+	}
+
+	info := &DriverInfo{
+		Driver:      KUBERNETES_DRIVER,
+		Id:          payload.Namespace + "/" + d.Name,
+		ServiceName: d.Annotations[KUBERNETES_SERVICE_NAME_ANNOTATION],
+		Payload:     payload,
+	}
+	if info.ServiceName == "" {
+		info.ServiceName = d.Name
+	}
+
+	if containers := d.Spec.Template.Spec.Containers; len(containers) > 0 {
+		payload.Image = containers[0].Image
+		if len(containers[0].Ports) > 0 {
+			payload.Port = int(containers[0].Ports[0].ContainerPort)
+		}
+	}
+
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	info.HealthState = fmt.Sprintf("%d/%d ready", d.Status.ReadyReplicas, desired)
+	info.CurrentStatus = convertDeploymentToStatus(d, desired)
+
+	if payload.Port != 0 && d.Status.ReadyReplicas > 0 {
+		info.Location = &Location{Host: fmt.Sprintf("%s.%s.svc", d.Name, payload.Namespace), Port: payload.Port}
+	}
+	return info
+}
+
+func convertDeploymentToStatus(d *appsv1.Deployment, desired int32) string {
+	if desired == 0 {
+		if d.Status.Replicas > 0 {
+			return STOPPING_STATUS
+		}
+		return STOPPED_STATUS
+	}
+
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return ERROR_STATUS
+		}
+	}
+
+	if d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas >= desired && d.Status.ReadyReplicas >= desired {
+		return STARTED_STATUS
+	}
+	return STARTING_STATUS
+}
+
+func (k *KubernetesServiceDriver) watchDeployments() (watch.Interface, error) {
+	// Selects every deployment having the arken label, whatever its value
+	options := metav1.ListOptions{LabelSelector: KUBERNETES_SERVICE_LABEL}
+	return k.clientset.AppsV1().Deployments(k.namespace).Watch(context.TODO(), options)
+}
+
+// Watches the deployments created by arken and publishes the updated
+// DriverInfo of each changed deployment.
+func (k *KubernetesServiceDriver) watch(w watch.Interface) {
+	for {
+		for event := range w.ResultChan() {
+			deployment, ok := event.Object.(*appsv1.Deployment)
+			if !ok {
+				continue
+			}
+
+			info := k.kubernetesInfoFromDeployment(deployment)
+			if event.Type == watch.Deleted {
+				info.CurrentStatus = STOPPED_STATUS
+				info.Location = nil
+			}
+			k.broadcaster.Write(NewModelEvent("update", info))
+		}
+		w.Stop()
+
+		log.Warningf("Waiting 1 second and relaunch Kubernetes deployment watch")
+		time.Sleep(time.Second)
+
+		var err error
+		for w, err = k.watchDeployments(); err != nil; w, err = k.watchDeployments() {
+			log.Warningf("Unable to watch Kubernetes deployments : %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package drivers
+
+import (
+	"context"
+	. "github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+// The fake clientset has no deployment controller : these helpers play its
+// role by updating the status and recording the rollout history.
+
+func setDeploymentReady(clientset *fake.Clientset, name string) {
+	deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), name, metav1.GetOptions{})
+	deployment.Status.Replicas = *deployment.Spec.Replicas
+	deployment.Status.UpdatedReplicas = *deployment.Spec.Replicas
+	deployment.Status.ReadyReplicas = *deployment.Spec.Replicas
+	clientset.AppsV1().Deployments("arken").UpdateStatus(context.TODO(), deployment, metav1.UpdateOptions{})
+}
+
+func recordRevision(clientset *fake.Clientset, name string, revision string) {
+	deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), name, metav1.GetOptions{})
+	deployment.Annotations[kubernetesRevisionAnnotation] = revision
+	clientset.AppsV1().Deployments("arken").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+
+	template := *deployment.Spec.Template.DeepCopy()
+	template.Labels[kubernetesPodTemplateHashLabel] = "hash" + revision
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-" + revision,
+			Labels:          template.Labels,
+			Annotations:     map[string]string{kubernetesRevisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Selector: deployment.Spec.Selector,
+			Template: template,
+		},
+	}
+	clientset.AppsV1().ReplicaSets("arken").Create(context.TODO(), rs, metav1.CreateOptions{})
+}
+
+func waitForKubernetesInfo(events chan *ModelEvent, status string) *DriverInfo {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if info, ok := event.Model.(*DriverInfo); ok && info.Driver == KUBERNETES_DRIVER && info.CurrentStatus == status {
+				return info
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func Test_kubernetesName(t *testing.T) {
+	Convey("Service names are converted to valid Kubernetes names", t, func() {
+		So(kubernetesName("nxio_000123"), ShouldEqual, "nxio-000123")
+		So(kubernetesName("testService"), ShouldEqual, "testservice")
+		So(kubernetesName("42"), ShouldEqual, "arken-42")
+	})
+}
+
+func Test_KubernetesDriver(t *testing.T) {
+
+	clientset := fake.NewClientset()
+	sd, err := newKubernetesServiceDriver(clientset, "arken")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan *ModelEvent, 100)
+	go func() {
+		for event := range sd.Listen() {
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}()
+
+	Convey("Given a Kubernetes service driver", t, func() {
+
+		service := &Service{Name: "test_service"}
+		service.Init()
+		service.Config.Environment = map[string]interface{}{"NUXEO_PACKAGES": "nuxeo-web-ui"}
+		service.Config.DriverInfo = &DriverInfo{Driver: KUBERNETES_DRIVER, Payload: &KubernetesInfoType{Image: "nuxeo:8.10", Port: 8080}}
+
+		Convey("When a service without image is created", func() {
+			_, err := sd.Create(&Service{Name: "noImage", Config: &ServiceConfig{}}, false)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the service is created", func() {
+			info, err := sd.Create(service, false)
+			So(err, ShouldBeNil)
+			service.Config.DriverInfo = info
+			payload := info.Payload.(*KubernetesInfoType)
+
+			Convey("Then a deployment without replicas is created", func() {
+				So(info.ServiceName, ShouldEqual, "test_service")
+				So(info.Id, ShouldEqual, "arken/test-service")
+				So(payload.DeploymentName, ShouldEqual, "test-service")
+				So(payload.Namespace, ShouldEqual, "arken")
+				So(payload.Image, ShouldEqual, "nuxeo:8.10")
+				So(payload.Port, ShouldEqual, 8080)
+				So(info.CurrentStatus, ShouldEqual, STOPPED_STATUS)
+
+				deployment, err := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+				So(err, ShouldBeNil)
+				So(*deployment.Spec.Replicas, ShouldEqual, 0)
+				env := deployment.Spec.Template.Spec.Containers[0].Env
+				So(len(env), ShouldEqual, 1)
+				So(env[0].Name, ShouldEqual, "NUXEO_PACKAGES")
+				So(env[0].Value, ShouldEqual, "nuxeo-web-ui")
+			})
+
+			Convey("Then a Kubernetes service exposes the port", func() {
+				svc, err := clientset.CoreV1().Services("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+				So(err, ShouldBeNil)
+				So(svc.Spec.Ports[0].Port, ShouldEqual, 8080)
+				So(svc.Spec.Selector[KUBERNETES_SERVICE_LABEL], ShouldEqual, "test-service")
+			})
+
+			Convey("When the service is started", func() {
+				result, err := sd.Start(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then it is starting until its pods are ready", func() {
+					So(result.CurrentStatus, ShouldEqual, STARTING_STATUS)
+					So(result.Location, ShouldBeNil)
+				})
+
+				Convey("When its pods are ready", func() {
+					setDeploymentReady(clientset, "test-service")
+
+					Convey("Then it is started and located on the Kubernetes service", func() {
+						info := waitForKubernetesInfo(events, STARTED_STATUS)
+						So(info, ShouldNotBeNil)
+						So(info.ServiceName, ShouldEqual, "test_service")
+						So(info.Location, ShouldResemble, &Location{Host: "test-service.arken.svc", Port: 8080})
+					})
+
+					Convey("When the service is stopped", func() {
+						result, err := sd.Stop(service)
+						So(err, ShouldBeNil)
+
+						Convey("Then the deployment is scaled to zero", func() {
+							So(result.CurrentStatus, ShouldEqual, STOPPING_STATUS)
+							deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+							So(*deployment.Spec.Replicas, ShouldEqual, 0)
+						})
+					})
+				})
+			})
+
+			Convey("Then it does not need to be upgraded", func() {
+				needUpgrade, err := sd.NeedToBeUpgraded(service)
+				So(err, ShouldBeNil)
+				So(needUpgrade, ShouldBeFalse)
+			})
+
+			Convey("When the image of the service changes", func() {
+				recordRevision(clientset, "test-service", "1")
+				payload.Image = "nuxeo:9.1"
+
+				Convey("Then it needs to be upgraded", func() {
+					needUpgrade, err := sd.NeedToBeUpgraded(service)
+					So(err, ShouldBeNil)
+					So(needUpgrade, ShouldBeTrue)
+				})
+
+				Convey("When the service is upgraded", func() {
+					result, err := sd.Upgrade(service)
+					So(err, ShouldBeNil)
+					recordRevision(clientset, "test-service", "2")
+
+					Convey("Then the new image is rolled out", func() {
+						So(result.Payload.(*KubernetesInfoType).Image, ShouldEqual, "nuxeo:9.1")
+						deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+						So(deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION], ShouldEqual, "1")
+						So(*deployment.Spec.Replicas, ShouldEqual, 1)
+					})
+
+					Convey("When the upgrade is rollbacked", func() {
+						result, err := sd.Rollback(service)
+						So(err, ShouldBeNil)
+
+						Convey("Then the template of the previous revision is back", func() {
+							So(result.Payload.(*KubernetesInfoType).Image, ShouldEqual, "nuxeo:8.10")
+							deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+							So(deployment.Spec.Template.Labels[kubernetesPodTemplateHashLabel], ShouldEqual, "")
+							So(deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION], ShouldEqual, "")
+						})
+					})
+
+					Convey("When the upgrade is finished", func() {
+						_, err := sd.FinishUpgrade(service)
+						So(err, ShouldBeNil)
+
+						Convey("Then the previous revision is forgotten", func() {
+							deployment, _ := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+							_, ok := deployment.Annotations[KUBERNETES_PREVIOUS_REVISION_ANNOTATION]
+							So(ok, ShouldBeFalse)
+							So(deployment.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "nuxeo:9.1")
+						})
+
+						Convey("Then it can still be rollbacked to the latest revision before the current one", func() {
+							result, err := sd.Rollback(service)
+							So(err, ShouldBeNil)
+							So(result.Payload.(*KubernetesInfoType).Image, ShouldEqual, "nuxeo:8.10")
+						})
+					})
+				})
+			})
+
+			Convey("When the service is destroyed", func() {
+				err := sd.Destroy(service)
+
+				Convey("Then the deployment and the Kubernetes service are removed", func() {
+					So(err, ShouldBeNil)
+					_, err := clientset.AppsV1().Deployments("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+					So(err, ShouldNotBeNil)
+					_, err = clientset.CoreV1().Services("arken").Get(context.TODO(), "test-service", metav1.GetOptions{})
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("Then the deletion is published as stopped", func() {
+					info := waitForKubernetesInfo(events, STOPPED_STATUS)
+					So(info, ShouldNotBeNil)
+					So(info.ServiceName, ShouldEqual, "test_service")
+				})
+			})
+
+			Reset(func() {
+				sd.Destroy(&Service{Name: "test_service"})
+				clientset.AppsV1().ReplicaSets("arken").DeleteCollection(context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{})
+			})
+		})
+	})
+}
@@ -31,11 +31,29 @@ import (
 	"strings"
 )
 
+const RANCHER_DRIVER = "rancher"
+
 var (
 	rancherHostRegexp = regexp.MustCompile("http.*/projects/(.*)")
 	log               = logrus.New()
 )
 
+func init() {
+	RegisterServiceDriver(&DriverRegistration{
+		Name:          RANCHER_DRIVER,
+		Factory:       newRancherServiceDriverFromConfig,
+		NewPayload:    func() interface{} { return &RancherInfoType{} },
+		LegacyInfoKey: "rancherInfo",
+	})
+}
+
+// Rancher specific information of a service, held in the DriverInfo payload
+type RancherInfoType struct {
+	EnvironmentId   string `json:"environmentId,omitempty"`
+	EnvironmentName string `json:"environmentName,omitempty"`
+	TemplateId      string `json:"templateId,omitempty"`
+}
+
 type RancherServiceDriver struct {
 	rancherClient    *client.RancherClient
 	broadcaster      *Broadcaster
@@ -79,6 +97,32 @@ func NewRancherServiceDriver(rancherHost string, rancherAccessKey string, ranche
 
 }
 
+func newRancherServiceDriverFromConfig(config DriverConfig) (ServiceDriver, error) {
+	rancherHost := config.GetString("CATTLE_URL")
+	if rancherHost == "" {
+		rancherHost = config.GetString("rancher.host") //compat with old catalog def
+	}
+
+	accessKey := config.GetString("CATTLE_ACCESS_KEY")
+	if accessKey == "" {
+		accessKey = config.GetString("rancher.accessKey") //compat with old catalog def
+	}
+
+	secretKey := config.GetString("CATTLE_SECRET_KEY")
+	if secretKey == "" {
+		secretKey = config.GetString("rancher.secretKey") //compat with old catalog def
+	}
+
+	log.Infof("Rancher host: %s", rancherHost)
+	log.Infof("Rancher Access key: %s", accessKey)
+	log.Infof("Rancher Secret key: ************************")
+	sd, err := NewRancherServiceDriver(rancherHost, accessKey, secretKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to connect to Rancher : %s", err.Error()))
+	}
+	return sd, nil
+}
+
 func getProjectIdFromRancherHost(host string) string {
 	matches := rancherHostRegexp.FindStringSubmatch(host)
 	if len(matches) > 1 {
@@ -129,8 +173,9 @@ func (r *RancherServiceDriver) watch(c *websocket.Conn) {
 				if err != nil {
 					log.Printf(err.Error())
 				} else {
-					info := rancherInfoTypeFromEnvironment(&result)
-					info.EnvironmentId = publish.ResourceId
+					info := driverInfoFromEnvironment(&result)
+					info.Id = publish.ResourceId
+					info.Payload.(*RancherInfoType).EnvironmentId = publish.ResourceId
 					r.broadcaster.Write(NewModelEvent("update", info))
 				}
 				break
@@ -140,17 +185,32 @@ func (r *RancherServiceDriver) watch(c *websocket.Conn) {
 
 }
 
-func rancherInfoTypeFromEnvironment(e *client.Stack) *RancherInfoType {
-	return &RancherInfoType{
-		EnvironmentId:   e.Id,
-		EnvironmentName: e.Name,
-		Location:        &Location{Host: fmt.Sprintf("lb.%s", e.Name), Port: 80},
-		HealthState:     e.HealthState,
-		CurrentStatus:   convertRancherHealthToStatus(e.HealthState),
-		TemplateId:      strings.Replace(e.ExternalId, "catalog://", "", 1),
+func driverInfoFromEnvironment(e *client.Stack) *DriverInfo {
+	return &DriverInfo{
+		Driver:        RANCHER_DRIVER,
+		Id:            e.Id,
+		ServiceName:   e.Name,
+		Location:      &Location{Host: fmt.Sprintf("lb.%s", e.Name), Port: 80},
+		HealthState:   e.HealthState,
+		CurrentStatus: convertRancherHealthToStatus(e.HealthState),
+		Payload: &RancherInfoType{
+			EnvironmentId:   e.Id,
+			EnvironmentName: e.Name,
+			TemplateId:      strings.Replace(e.ExternalId, "catalog://", "", 1),
+		},
 	}
 }
 
+// Returns the Rancher information of a service, empty if the service has none.
+func rancherInfoFromService(s *Service) *RancherInfoType {
+	if s.Config != nil && s.Config.DriverInfo != nil {
+		if info, ok := s.Config.DriverInfo.Payload.(*RancherInfoType); ok {
+			return info
+		}
+	}
+	return &RancherInfoType{}
+}
+
 func convertRancherHealthToStatus(health string) string {
 	switch health {
 	case "healthy":
@@ -164,9 +224,9 @@ func convertRancherHealthToStatus(health string) string {
 }
 
 func (r *RancherServiceDriver) computeEnvFromService(s *Service) (*client.Stack, error) {
-	info := s.Config.RancherInfo
+	info := rancherInfoFromService(s)
 
-	if info == nil || info.TemplateId == "" {
+	if info.TemplateId == "" {
 		return nil, errors.New("Rancher template has to be specified !")
 	}
 	log.Infof("Looking for template %s", info.TemplateId)
@@ -186,7 +246,7 @@ func (r *RancherServiceDriver) computeEnvFromService(s *Service) (*client.Stack,
 	return env, nil
 }
 
-func (r *RancherServiceDriver) Create(s *Service, startOnCreate bool) (interface{}, error) {
+func (r *RancherServiceDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
 
 	log.Infof("Creating stack %s on rancher", s.Name)
 	env, err := r.computeEnvFromService(s)
@@ -204,7 +264,7 @@ func (r *RancherServiceDriver) Create(s *Service, startOnCreate bool) (interface
 		return nil, errors.New("Error when creating service on Rancher side: " + err.Error())
 	}
 
-	return &RancherInfoType{EnvironmentId: env.Id}, nil
+	return driverInfoFromEnvironment(env), nil
 
 }
 
@@ -217,7 +277,7 @@ func (r *RancherServiceDriver) NeedToBeUpgraded(s *Service) (bool, error) {
 		return false, err
 	}
 
-	rancherId := s.Config.RancherInfo.EnvironmentId
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	actualEnv, err := r.rancherClient.Stack.ById(rancherId)
 	if err != nil {
 		
@@ -231,20 +291,20 @@ func (r *RancherServiceDriver) NeedToBeUpgraded(s *Service) (bool, error) {
 
 }
 
-func (r *RancherServiceDriver) Upgrade(s *Service) (interface{}, error) {
+func (r *RancherServiceDriver) Upgrade(s *Service) (*DriverInfo, error) {
 
 	log.Infof("Upgrading environment %s", s.Name)
 
-	rancherId := s.Config.RancherInfo.EnvironmentId
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 
 	if err != nil {
 		log.Errorf("Error when retrieving environment: %v", err)
 	}
 
-	info := s.Config.RancherInfo
+	info := rancherInfoFromService(s)
 
-	if info == nil || info.TemplateId == "" {
+	if info.TemplateId == "" {
 		log.Errorf("Rancher template has to be specified : %v", info)
 		return nil, errors.New("Rancher template has to be specified !")
 	}
@@ -269,16 +329,17 @@ func (r *RancherServiceDriver) Upgrade(s *Service) (interface{}, error) {
 
 	if err != nil {
 		log.Errorf("Environment upgrade failed in rancher : %v", err)
+		return nil, err
 	}
 
-	return s, err
+	return driverInfoFromEnvironment(env), nil
 }
 
-func (r *RancherServiceDriver) FinishUpgrade(s *Service) (interface{}, error) {
+func (r *RancherServiceDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
 
 	log.Infof("Finishing upgrading environment %s", s.Name)
 
-	rancherId := s.Config.RancherInfo.EnvironmentId
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 
 	if err != nil {
@@ -289,16 +350,17 @@ func (r *RancherServiceDriver) FinishUpgrade(s *Service) (interface{}, error) {
 
 	if err != nil {
 		log.Errorf("Finish upgrade environment failed in rancher : %v", err)
+		return nil, err
 	}
 
-	return s, err
+	return driverInfoFromEnvironment(env), nil
 }
 
-func (r *RancherServiceDriver) Rollback(s *Service) (interface{}, error) {
+func (r *RancherServiceDriver) Rollback(s *Service) (*DriverInfo, error) {
 
 	log.Infof("Rollbacking environment %s", s.Name)
 
-	rancherId := s.Config.RancherInfo.EnvironmentId
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 
 	if err != nil {
@@ -309,9 +371,10 @@ func (r *RancherServiceDriver) Rollback(s *Service) (interface{}, error) {
 
 	if err != nil {
 		log.Errorf("Rollbacking environment failed in rancher : %v", err)
+		return nil, err
 	}
 
-	return s, err
+	return driverInfoFromEnvironment(env), nil
 }
 
 func extractFileContent(template *catalogclient.Template, filename string) string {
@@ -321,28 +384,34 @@ func extractFileContent(template *catalogclient.Template, filename string) strin
 	return ""
 }
 
-func (r *RancherServiceDriver) Start(s *Service) (interface{}, error) {
-	rancherId := s.Config.RancherInfo.EnvironmentId
+func (r *RancherServiceDriver) Start(s *Service) (*DriverInfo, error) {
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 	if err != nil {
 		return nil, err
 	}
 	env, err = r.rancherClient.Stack.ActionActivateservices(env)
-	return s, err
+	if err != nil {
+		return nil, err
+	}
+	return driverInfoFromEnvironment(env), nil
 }
 
-func (r *RancherServiceDriver) Stop(s *Service) (interface{}, error) {
-	rancherId := s.Config.RancherInfo.EnvironmentId
+func (r *RancherServiceDriver) Stop(s *Service) (*DriverInfo, error) {
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 	if err != nil {
 		return nil, err
 	}
 	env, err = r.rancherClient.Stack.ActionDeactivateservices(env)
-	return s, err
+	if err != nil {
+		return nil, err
+	}
+	return driverInfoFromEnvironment(env), nil
 }
 
 func (r *RancherServiceDriver) Destroy(s *Service) error {
-	rancherId := s.Config.RancherInfo.EnvironmentId
+	rancherId := rancherInfoFromService(s).EnvironmentId
 	env, err := r.rancherClient.Stack.ById(rancherId)
 	if err != nil {
 		return err
@@ -359,15 +428,15 @@ func basicAuth(username, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(auth))
 }
 
-// Return the RancheInfotype for the given service
-func (r *RancherServiceDriver) GetInfo(s *Service) (interface{}, error) {
-	rancherId := s.Config.RancherInfo.EnvironmentId
+// Return the DriverInfo for the given service
+func (r *RancherServiceDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	rancherId := rancherInfoFromService(s).EnvironmentId
 
 	env, error := r.rancherClient.Stack.ById(rancherId)
 	if error != nil {
 		return nil, error
 	} else {
-		return rancherInfoTypeFromEnvironment(env), nil
+		return driverInfoFromEnvironment(env), nil
 	}
 
 }
@@ -0,0 +1,108 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package metrics holds the Prometheus metrics of arken. They are
+// registered in Registry, which the API server exposes on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const NAMESPACE = "arken"
+
+var (
+	// Actions run by the model on the services, by action and driver
+	Actions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "actions_total",
+		Help:      "Actions run on the services, by action and driver.",
+	}, []string{"action", "driver"})
+
+	// Actions that have failed, by action and driver
+	ActionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "action_failures_total",
+		Help:      "Actions that have failed, by action and driver.",
+	}, []string{"action", "driver"})
+
+	// Duration of the calls to the service driver, by driver and call
+	DriverCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "driver_call_duration_seconds",
+		Help:      "Duration of the calls to the service driver, by driver and call.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"driver", "call"})
+
+	// Services passivated by the passivation handler, by passivation action
+	Passivations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "passivations_total",
+		Help:      "Services passivated by the passivation handler, by passivation action.",
+	}, []string{"action"})
+
+	// Restarts of the etcd watches, by watched prefix
+	WatchRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "etcd_watch_restarts_total",
+		Help:      "Restarts of the etcd watches, by watched prefix.",
+	}, []string{"prefix"})
+
+	// Open WebSocket connections
+	WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "websocket_connections",
+		Help:      "Open WebSocket connections.",
+	})
+
+	// Events received by the event buffer of the model, by model type
+	BufferedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "buffered_events_total",
+		Help:      "Events received by the event buffer of the model, by model type.",
+	}, []string{"model"})
+
+	// Events published by the event buffer of the model, by model type. The
+	// buffer merges the events on the same object, so less are published.
+	PublishedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "published_events_total",
+		Help:      "Events published by the event buffer of the model, by model type.",
+	}, []string{"model"})
+
+	// Deliveries to the webhooks, by final state
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "webhook_deliveries_total",
+		Help:      "Deliveries to the webhooks, by final state.",
+	}, []string{"state"})
+
+	Registry = prometheus.NewRegistry()
+)
+
+func init() {
+	Registry.MustRegister(
+		Actions,
+		ActionFailures,
+		DriverCallDuration,
+		Passivations,
+		WatchRestarts,
+		WebSocketConnections,
+		BufferedEvents,
+		PublishedEvents,
+		WebhookDeliveries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// The last access of a service is not written more often than that
+const ACCESS_RECORD_INTERVAL = 10 * time.Second
+
+// Records an access to a service, as a router in front of the services does :
+// the last access of the service is updated, which delays its passivation,
+// and a passivated service is expected to be started again. The leader
+// starts it right away, a follower leaves it to the passivation handler of
+// the leader.
+func (m *Model) RecordAccess(name string) (*Service, error) {
+	service, ok := m.store.getService(name)
+	if !ok {
+		return nil, errors.New("Service not found")
+	}
+
+	now := time.Now()
+	wake := service.Status != nil && service.Status.Compute() == PASSIVATED_STATUS
+	if !wake && service.LastAccess != nil && now.Sub(*service.LastAccess) < ACCESS_RECORD_INTERVAL {
+		return service, nil
+	}
+
+	service.LastAccess = &now
+	if wake && m.IsLeader() {
+		log.Infof("Service %s is accessed, waking it up", service.Name)
+		started, err := m.StartService(service)
+		m.AuditService(ACCESS_COMPONENT, COMPONENT_ACTOR, "StartService", name, PASSIVATED_STATUS, err)
+		return started, err
+	}
+
+	if wake {
+		service.Status.Expected = STARTED_STATUS
+	}
+	service, err := m.saveService(service)
+	if err != nil {
+		return nil, err
+	}
+	m.eventBuffer.events <- NewModelEvent("update", service.Copy())
+	return service, nil
+}
@@ -26,6 +26,7 @@ const (
 	UPGRADE_ACTION       = "upgrade"
 	FINISHUPGRADE_ACTION = "finishupgrade"
 	ROLLBACK_ACTION      = "rollback"
+	PASSIVATE_ACTION     = "passivate"
 )
 
 //represents the action as returned in the service
@@ -0,0 +1,50 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+)
+
+// Returned when the service driver can't tell the activity of the services.
+var ErrActivityNotSupported = errors.New("The service driver does not report the activity of the services")
+
+// Cumulated activity counters of a service in its backend.
+type ActivityStats struct {
+	// CPU time used by the service, in nanoseconds
+	CpuUsage uint64 `json:"cpuUsage"`
+	// Bytes received and sent by the service on the network
+	NetworkBytes uint64 `json:"networkBytes"`
+}
+
+// Implemented by the service drivers that report the activity of the
+// services they run.
+type ActivityReporter interface {
+	Activity(s *Service) (*ActivityStats, error)
+}
+
+// Returns the activity counters of the service from the service driver, or
+// ErrActivityNotSupported when the driver does not report them.
+func (m *Model) ServiceActivity(service *Service) (*ActivityStats, error) {
+	driver := m.serviceDriver
+	if instrumented, ok := driver.(*instrumentedDriver); ok {
+		driver = instrumented.driver
+	}
+
+	reporter, ok := driver.(ActivityReporter)
+	if !ok {
+		return nil, ErrActivityNotSupported
+	}
+	return reporter.Activity(service)
+}
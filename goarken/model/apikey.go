@@ -0,0 +1,231 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// The last use of an API key is persisted at most that often
+const API_KEY_TOUCH_INTERVAL = time.Minute
+
+var (
+	ErrAPIKeysNotSupported = errors.New("The persistence driver can't store API keys")
+	ErrAPIKeyNotFound      = errors.New("API key not found")
+	ErrAPIKeyExpired       = errors.New("The API key has expired")
+	ErrInvalidAPIKeySecret = errors.New("Invalid API key secret")
+)
+
+// An APIKey authenticates the callers of the REST API. Only the hash of its
+// secret is stored, the secret is given once when the key is created.
+type APIKey struct {
+	// The access key, sent in the AuthKey header
+	Id          string     `json:"id"`
+	Description string     `json:"description,omitempty"`
+	SecretHash  string     `json:"secretHash,omitempty"`
+	Role        string     `json:"role,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+	Services    []string   `json:"services,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	// Revision of the key in the persistence driver, 0 when unknown
+	Revision int64 `json:"-"`
+}
+
+// Implemented by the persistence drivers that can store the API keys.
+type APIKeyPersistenceDriver interface {
+	PersistenceDriver
+	LoadAllAPIKeys() (map[string]*APIKey, error)
+	// Returns ErrAPIKeyNotFound when there is no key with the given id
+	LoadAPIKey(id string) (*APIKey, error)
+	PersistAPIKey(*APIKey) (*APIKey, error)
+	DestroyAPIKey(*APIKey) error
+	// Records the last use of a key, unless it has been modified or
+	// destroyed since it was loaded : returns ErrConflict then
+	TouchAPIKey(key *APIKey, at time.Time) error
+}
+
+func (k *APIKey) Copy() *APIKey {
+	result := *k
+	result.Permissions = append([]string(nil), k.Permissions...)
+	result.Services = append([]string(nil), k.Services...)
+	if k.LastUsedAt != nil {
+		lastUsedAt := *k.LastUsedAt
+		result.LastUsedAt = &lastUsedAt
+	}
+	if k.ExpiresAt != nil {
+		expiresAt := *k.ExpiresAt
+		result.ExpiresAt = &expiresAt
+	}
+	return &result
+}
+
+// Tells if the key has expired at the given time.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomBytes(size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (m *Model) apiKeyDriver() (APIKeyPersistenceDriver, error) {
+	driver, ok := m.persistenceDriver.(APIKeyPersistenceDriver)
+	if !ok {
+		return nil, ErrAPIKeysNotSupported
+	}
+	return driver, nil
+}
+
+// Tells if the persistence driver can store API keys.
+func (m *Model) SupportsAPIKeys() bool {
+	_, err := m.apiKeyDriver()
+	return err == nil
+}
+
+// Returns the stored API keys, by id.
+func (m *Model) APIKeys() (map[string]*APIKey, error) {
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return nil, err
+	}
+	return driver.LoadAllAPIKeys()
+}
+
+// Returns the stored API key with the given id.
+func (m *Model) GetAPIKey(id string) (*APIKey, error) {
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return nil, err
+	}
+	return driver.LoadAPIKey(id)
+}
+
+// Creates an API key with the description, access and expiry of the given
+// one, and returns it with its secret.
+func (m *Model) CreateAPIKey(key *APIKey) (*APIKey, string, error) {
+	if !m.IsLeader() {
+		return nil, "", ErrNotLeader
+	}
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(randomBytes(32))
+	key = key.Copy()
+	key.Id = hex.EncodeToString(randomBytes(10))
+	key.SecretHash = hashAPIKeySecret(secret)
+	key.CreatedAt = time.Now()
+	key.LastUsedAt = nil
+
+	key, err = driver.PersistAPIKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// Updates the description, access and expiry of a stored API key. Its
+// secret is kept.
+func (m *Model) UpdateAPIKey(key *APIKey) (*APIKey, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := driver.LoadAPIKey(key.Id)
+	if err != nil {
+		return nil, err
+	}
+	stored.Description = key.Description
+	stored.Role = key.Role
+	stored.Permissions = key.Permissions
+	stored.Services = key.Services
+	stored.ExpiresAt = key.ExpiresAt
+	return driver.PersistAPIKey(stored)
+}
+
+// Revokes a stored API key.
+func (m *Model) DestroyAPIKey(id string) error {
+	if !m.IsLeader() {
+		return ErrNotLeader
+	}
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return err
+	}
+
+	key, err := driver.LoadAPIKey(id)
+	if err != nil {
+		return err
+	}
+	return driver.DestroyAPIKey(key)
+}
+
+// Returns the stored API key with the given id when the secret is its own
+// and it has not expired. The key is read from the store on every call, so
+// that the keys created or revoked by another daemon are taken into account
+// at once. Its last use is recorded, whether the daemon leads or not.
+func (m *Model) AuthenticateAPIKey(id string, secret string) (*APIKey, error) {
+	driver, err := m.apiKeyDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := driver.LoadAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKeySecret
+	}
+
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > API_KEY_TOUCH_INTERVAL {
+		// Only the last use is written, a key updated or revoked meanwhile
+		// is left as is
+		if err := driver.TouchAPIKey(key, now); err == nil {
+			key.LastUsedAt = &now
+		} else if err == ErrConflict {
+			log.Debugf("API key %s has changed since it was loaded, its use is not recorded", key.Id)
+		} else {
+			log.Warnf("Unable to record the use of API key %s : %s", key.Id, err.Error())
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,169 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// Stores the API keys in memory, the other methods are not implemented
+type mockAPIKeyDriver struct {
+	PersistenceDriver
+	keys     map[string]*APIKey
+	revision int64
+	// Called before a use is recorded, to change the key meanwhile
+	beforeTouch func()
+}
+
+func (d *mockAPIKeyDriver) LoadAllAPIKeys() (map[string]*APIKey, error) {
+	result := make(map[string]*APIKey)
+	for id, key := range d.keys {
+		result[id] = key.Copy()
+	}
+	return result, nil
+}
+
+func (d *mockAPIKeyDriver) LoadAPIKey(id string) (*APIKey, error) {
+	key, ok := d.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key.Copy(), nil
+}
+
+func (d *mockAPIKeyDriver) PersistAPIKey(key *APIKey) (*APIKey, error) {
+	d.revision++
+	key.Revision = d.revision
+	d.keys[key.Id] = key.Copy()
+	return key, nil
+}
+
+func (d *mockAPIKeyDriver) DestroyAPIKey(key *APIKey) error {
+	delete(d.keys, key.Id)
+	return nil
+}
+
+func (d *mockAPIKeyDriver) TouchAPIKey(key *APIKey, at time.Time) error {
+	if d.beforeTouch != nil {
+		d.beforeTouch()
+	}
+	stored, ok := d.keys[key.Id]
+	if !ok || stored.Revision != key.Revision {
+		return ErrConflict
+	}
+	d.revision++
+	stored.Revision = d.revision
+	stored.LastUsedAt = &at
+	return nil
+}
+
+func Test_APIKeys(t *testing.T) {
+
+	Convey("Given a model whose driver stores API keys", t, func() {
+		driver := &mockAPIKeyDriver{keys: make(map[string]*APIKey)}
+		m := &Model{store: newStateStore(), persistenceDriver: driver}
+		So(m.SupportsAPIKeys(), ShouldBeTrue)
+
+		Convey("When a key is created", func() {
+			key, secret, err := m.CreateAPIKey(&APIKey{Description: "CI", Role: "operator"})
+			So(err, ShouldBeNil)
+
+			Convey("Then only the hash of its secret is stored", func() {
+				So(key.Id, ShouldNotBeEmpty)
+				So(secret, ShouldNotBeEmpty)
+				stored := driver.keys[key.Id]
+				So(stored.SecretHash, ShouldNotBeEmpty)
+				So(stored.SecretHash, ShouldNotContainSubstring, secret)
+				So(stored.Description, ShouldEqual, "CI")
+			})
+
+			Convey("Then it authenticates with its secret only", func() {
+				authenticated, err := m.AuthenticateAPIKey(key.Id, secret)
+				So(err, ShouldBeNil)
+				So(authenticated.Role, ShouldEqual, "operator")
+				So(driver.keys[key.Id].LastUsedAt, ShouldNotBeNil)
+
+				_, err = m.AuthenticateAPIKey(key.Id, "wrong")
+				So(err, ShouldEqual, ErrInvalidAPIKeySecret)
+			})
+
+			Convey("When it is updated", func() {
+				key.Role = "viewer"
+				_, err := m.UpdateAPIKey(key)
+				So(err, ShouldBeNil)
+
+				Convey("Then its secret is kept", func() {
+					authenticated, err := m.AuthenticateAPIKey(key.Id, secret)
+					So(err, ShouldBeNil)
+					So(authenticated.Role, ShouldEqual, "viewer")
+				})
+			})
+
+			Convey("When it has expired", func() {
+				expiresAt := time.Now().Add(-time.Minute)
+				key.ExpiresAt = &expiresAt
+				m.UpdateAPIKey(key)
+
+				Convey("Then it does not authenticate anymore", func() {
+					_, err := m.AuthenticateAPIKey(key.Id, secret)
+					So(err, ShouldEqual, ErrAPIKeyExpired)
+				})
+			})
+
+			Convey("When it is revoked while it authenticates", func() {
+				driver.beforeTouch = func() {
+					m.DestroyAPIKey(key.Id)
+				}
+				m.AuthenticateAPIKey(key.Id, secret)
+
+				Convey("Then the recording of its use does not restore it", func() {
+					_, ok := driver.keys[key.Id]
+					So(ok, ShouldBeFalse)
+				})
+			})
+
+			Convey("When its role is changed while it authenticates", func() {
+				driver.beforeTouch = func() {
+					m.UpdateAPIKey(&APIKey{Id: key.Id, Description: "CI", Role: "viewer"})
+				}
+				m.AuthenticateAPIKey(key.Id, secret)
+
+				Convey("Then the recording of its use does not undo the change", func() {
+					So(driver.keys[key.Id].Role, ShouldEqual, "viewer")
+				})
+			})
+
+			Convey("When it is revoked", func() {
+				So(m.DestroyAPIKey(key.Id), ShouldBeNil)
+
+				Convey("Then it does not authenticate anymore", func() {
+					_, err := m.AuthenticateAPIKey(key.Id, secret)
+					So(err, ShouldEqual, ErrAPIKeyNotFound)
+				})
+			})
+		})
+	})
+
+	Convey("Given a model whose driver does not store API keys", t, func() {
+		m := &Model{store: newStateStore()}
+
+		Convey("Then API keys can not be created", func() {
+			So(m.SupportsAPIKeys(), ShouldBeFalse)
+			_, _, err := m.CreateAPIKey(&APIKey{})
+			So(err, ShouldEqual, ErrAPIKeysNotSupported)
+		})
+	})
+}
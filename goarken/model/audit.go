@@ -0,0 +1,208 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrAuditNotSupported = errors.New("The persistence driver can't store the audit log")
+
+// Types of the actors of the changes
+const (
+	// A key of the API, from the configuration or the store
+	APIKEY_ACTOR = "apikey"
+	// A user authenticated by a bearer token
+	USER_ACTOR = "user"
+	// A caller of an API that is not protected
+	ANONYMOUS_ACTOR = "anonymous"
+	// A component of arken, as the passivation handler or the driver sync
+	COMPONENT_ACTOR = "component"
+)
+
+// The components of arken that change the model
+const (
+	DRIVER_COMPONENT      = "driver"
+	ACCESS_COMPONENT      = "access"
+	PASSIVATION_COMPONENT = "passivation"
+)
+
+// Results of the audited changes
+const (
+	AUDIT_SUCCESS = "success"
+	AUDIT_FAILURE = "failure"
+)
+
+// An AuditEntry records a change of the model : who made it, what it was,
+// and how it ended.
+type AuditEntry struct {
+	// Ordered as the time of the entries
+	Id        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	ActorType string    `json:"actorType"`
+	// Name of the change, as CreateService or DestroyDomain
+	Action      string `json:"action"`
+	ServiceName string `json:"serviceName,omitempty"`
+	DomainName  string `json:"domainName,omitempty"`
+	// Id of the other changed object, as a webhook or an API key
+	Object       string `json:"object,omitempty"`
+	StatusBefore string `json:"statusBefore,omitempty"`
+	StatusAfter  string `json:"statusAfter,omitempty"`
+	Result       string `json:"result"`
+	Error        string `json:"error,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// Selects audit entries. Zero values select everything.
+type AuditFilter struct {
+	ServiceName string
+	Actor       string
+	// Entries from Since, included, to Until, excluded
+	Since time.Time
+	Until time.Time
+	// The latest entries are kept when there are more
+	Limit int
+}
+
+// Implemented by the persistence drivers that can store the audit log.
+type AuditPersistenceDriver interface {
+	PersistenceDriver
+	AppendAuditEntry(*AuditEntry) error
+	// Returns the entries from since, included, to until, excluded, a zero
+	// until meaning now, in the order of their ids
+	LoadAuditEntries(since time.Time, until time.Time) ([]*AuditEntry, error)
+	// Deletes the entries older than before
+	PruneAuditEntries(before time.Time) error
+}
+
+// Returns the prefix of the ids of the entries of the audit log and of the
+// histories recorded at the given time. Ids are compared as strings, the
+// prefix has a fixed width. The zero time sorts before any entry.
+func TimeIdPrefix(t time.Time) string {
+	if t.Before(time.Unix(0, 0)) {
+		return fmt.Sprintf("%019d", 0)
+	}
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
+
+// Returns a new id for an entry recorded at the given time.
+func newTimeId(t time.Time) string {
+	return TimeIdPrefix(t) + "-" + hex.EncodeToString(randomBytes(4))
+}
+
+func (f *AuditFilter) Matches(entry *AuditEntry) bool {
+	return (f.ServiceName == "" || f.ServiceName == entry.ServiceName) &&
+		(f.Actor == "" || f.Actor == entry.Actor) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+// Records an entry in the audit log. Recording never fails the change : the
+// entry is logged, and only lost when the persistence driver can't store it.
+func (m *Model) Audit(entry *AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Id = newTimeId(entry.Time)
+	if entry.Result == "" {
+		entry.Result = AUDIT_SUCCESS
+	}
+
+	log.Infof("Audit : %s %s%s by %s %s : %s %s", entry.Action, entry.ServiceName, entry.DomainName, entry.ActorType, entry.Actor, entry.Result, entry.Error)
+
+	driver, ok := m.persistenceDriver.(AuditPersistenceDriver)
+	if !ok {
+		return
+	}
+	if err := driver.AppendAuditEntry(entry); err != nil {
+		log.Errorf("Unable to record audit entry %s : %s", entry.Id, err.Error())
+	}
+}
+
+// Records the result of an action on a service, whose status was before
+// when it started. The status after the action is read from the model.
+func (m *Model) AuditService(actor string, actorType string, action string, serviceName string, before string, err error) {
+	entry := &AuditEntry{
+		Actor:        actor,
+		ActorType:    actorType,
+		Action:       action,
+		ServiceName:  serviceName,
+		StatusBefore: before,
+		StatusAfter:  m.ServiceStatus(serviceName),
+	}
+	if err != nil {
+		entry.Result = AUDIT_FAILURE
+		entry.Error = err.Error()
+	}
+	m.Audit(entry)
+}
+
+// Returns the computed status of a service, or an empty string when there
+// is no such service.
+func (m *Model) ServiceStatus(name string) string {
+	service, ok := m.store.getService(name)
+	if !ok || service.Status == nil {
+		return ""
+	}
+	return service.Status.Compute()
+}
+
+// Returns the entries of the audit log that match the filter, in the order
+// they were recorded.
+func (m *Model) AuditEntries(filter *AuditFilter) ([]*AuditEntry, error) {
+	driver, ok := m.persistenceDriver.(AuditPersistenceDriver)
+	if !ok {
+		return nil, ErrAuditNotSupported
+	}
+
+	entries, err := driver.LoadAuditEntries(filter.Since, filter.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		if filter.Matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result, nil
+}
+
+// Deletes the entries of the audit log older than the retention, every
+// hour while the daemon leads. Does not return.
+func (m *Model) KeepAuditFor(retention time.Duration) {
+	driver, ok := m.persistenceDriver.(AuditPersistenceDriver)
+	if !ok || retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if m.IsLeader() {
+			if err := driver.PruneAuditEntries(time.Now().Add(-retention)); err != nil {
+				log.Errorf("Unable to prune the audit log : %s", err.Error())
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// Keeps the audit log in memory, the other methods are not implemented
+type mockAuditDriver struct {
+	PersistenceDriver
+	entries []*AuditEntry
+}
+
+func (d *mockAuditDriver) AppendAuditEntry(entry *AuditEntry) error {
+	d.entries = append(d.entries, entry)
+	return nil
+}
+
+func (d *mockAuditDriver) LoadAuditEntries(since time.Time, until time.Time) ([]*AuditEntry, error) {
+	result := []*AuditEntry{}
+	for _, entry := range d.entries {
+		if entry.Id >= TimeIdPrefix(since) && (until.IsZero() || entry.Id < TimeIdPrefix(until)) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func (d *mockAuditDriver) PruneAuditEntries(before time.Time) error {
+	d.entries, _ = d.LoadAuditEntries(before, time.Time{})
+	return nil
+}
+
+func Test_Audit(t *testing.T) {
+
+	Convey("Given a model whose driver stores the audit log", t, func() {
+		driver := &mockAuditDriver{}
+		m := &Model{store: newStateStore(), persistenceDriver: driver}
+
+		Convey("When changes are audited", func() {
+			m.AuditService("ci", APIKEY_ACTOR, "StopService", "app1", "started", nil)
+			m.AuditService(PASSIVATION_COMPONENT, COMPONENT_ACTOR, "PassivateService", "app2", "started", errors.New("boom"))
+			m.Audit(&AuditEntry{Actor: "ci", ActorType: APIKEY_ACTOR, Action: "DestroyDomain", DomainName: "app.example.com"})
+
+			Convey("Then they are recorded in order with an id and a result", func() {
+				So(len(driver.entries), ShouldEqual, 3)
+				So(driver.entries[0].Id, ShouldNotBeEmpty)
+				So(driver.entries[0].Id < driver.entries[1].Id, ShouldBeTrue)
+				So(driver.entries[0].Result, ShouldEqual, AUDIT_SUCCESS)
+				So(driver.entries[1].Result, ShouldEqual, AUDIT_FAILURE)
+				So(driver.entries[1].Error, ShouldEqual, "boom")
+			})
+
+			Convey("Then they can be filtered by service and actor", func() {
+				entries, err := m.AuditEntries(&AuditFilter{ServiceName: "app1"})
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].Action, ShouldEqual, "StopService")
+
+				entries, _ = m.AuditEntries(&AuditFilter{Actor: "ci"})
+				So(len(entries), ShouldEqual, 2)
+			})
+
+			Convey("Then the limit keeps the latest ones", func() {
+				entries, _ := m.AuditEntries(&AuditFilter{Limit: 1})
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].Action, ShouldEqual, "DestroyDomain")
+			})
+
+			Convey("Then they can be filtered by time", func() {
+				entries, _ := m.AuditEntries(&AuditFilter{Since: time.Now().Add(time.Minute)})
+				So(len(entries), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given a model whose driver can't store the audit log", t, func() {
+		m := &Model{store: newStateStore(), persistenceDriver: &mockAPIKeyDriver{}}
+
+		Convey("Then the audit log is not supported", func() {
+			_, err := m.AuditEntries(&AuditFilter{})
+			So(err, ShouldEqual, ErrAuditNotSupported)
+		})
+	})
+}
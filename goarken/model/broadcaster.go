@@ -13,8 +13,13 @@
 // limitations under the License.
 package model
 
+import (
+	"sync"
+)
+
 // Simple structure that implements a publish/subscribe mecanism.
 type Broadcaster struct {
+	mutex     sync.RWMutex
 	listeners []chan interface{}
 }
 
@@ -26,14 +31,20 @@ func NewBroadcaster() *Broadcaster {
 }
 
 func (b *Broadcaster) Write(message interface{}) {
-	for _, channel := range b.listeners {
+	b.mutex.RLock()
+	listeners := b.listeners
+	b.mutex.RUnlock()
+
+	for _, channel := range listeners {
 		channel <- message
 	}
 }
 
 func (b *Broadcaster) Listen() chan interface{} {
 	channel := make(chan interface{})
+	b.mutex.Lock()
 	b.listeners = append(b.listeners, channel)
+	b.mutex.Unlock()
 	return channel
 
 }
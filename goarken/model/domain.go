@@ -13,18 +13,67 @@
 // limitations under the License.
 package model
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Types of domains
+const (
+	// The domain serves a service of the model, its value is the service name
+	SERVICE_DOMAIN = "service"
+	// The domain serves the content of an URI
+	URI_DOMAIN = "uri"
+	// The domain redirects to an URI
+	REDIRECT_DOMAIN = "redirect"
+)
+
+var (
+	DomainTypes = []string{SERVICE_DOMAIN, URI_DOMAIN, REDIRECT_DOMAIN}
+
+	// Returned when a domain is created but already exists
+	ErrDomainExists = errors.New("The domain already exists")
+	// Returned when a service claims a domain already claimed by another service
+	ErrDomainConflict = errors.New("The domain is already claimed by another service")
+
+	// Lower case host names, the first label may be a wildcard
+	domainNameRegexp = regexp.MustCompile(`^(\*|[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+)
+
+// Returned when a domain is not valid.
+type InvalidDomainError struct {
+	Domain string
+	Reason string
+}
+
+func (e *InvalidDomainError) Error() string {
+	return fmt.Sprintf("Invalid domain %s : %s", e.Domain, e.Reason)
+}
+
 // A Domain in the Arken model is of a given and may point to a service (if type is service)
 type Domain struct {
 	NodeKey string `json:"-"`
-	Name    string `json:"name,omitempty"`
-	Typ     string `json:"type,omitempty"`
-	Value   string `json:"value,omitempty"`
+	// Revision of the domain in the persistence driver, 0 when unknown
+	Revision int64  `json:"revision,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Typ      string `json:"type,omitempty"`
+	Value    string `json:"value,omitempty"`
 }
 
 func (d *Domain) String() string {
 	return d.Value + " at " + d.NodeKey
 }
 
+func (d *Domain) Copy() *Domain {
+	if d == nil {
+		return nil
+	}
+	domain := *d
+	return &domain
+}
+
 func (domain *Domain) Equals(other *Domain) bool {
 	if domain == nil && other == nil {
 		return true
@@ -33,3 +82,59 @@ func (domain *Domain) Equals(other *Domain) bool {
 	return domain != nil && other != nil &&
 		domain.Typ == other.Typ && domain.Value == other.Value
 }
+
+// Checks the name, the type and the value of the domain. Whether the service
+// of a service domain exists is left to the model.
+func (d *Domain) Validate() error {
+	if len(d.Name) > 253 || !domainNameRegexp.MatchString(d.Name) {
+		return &InvalidDomainError{d.Name, "the name must be a lower case host name"}
+	}
+
+	switch d.Typ {
+	case SERVICE_DOMAIN:
+		if d.Value == "" {
+			return &InvalidDomainError{d.Name, "the value must be the name of a service"}
+		}
+	case URI_DOMAIN, REDIRECT_DOMAIN:
+		uri, err := url.Parse(d.Value)
+		if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+			return &InvalidDomainError{d.Name, "the value must be an http or https URI"}
+		}
+	default:
+		return &InvalidDomainError{d.Name, fmt.Sprintf("the type must be one of %v", DomainTypes)}
+	}
+	return nil
+}
+
+// Checks that no other service than the given one claims the domain, either
+// through a service domain or through the domain of the service.
+func (m *Model) checkDomainClaim(domainName string, serviceName string) error {
+	if domain, ok := m.store.getDomain(domainName); ok && domain.Typ == SERVICE_DOMAIN && domain.Value != serviceName {
+		if _, exists := m.store.getService(domain.Value); exists {
+			return ErrDomainConflict
+		}
+	}
+
+	for name, service := range m.store.allServices() {
+		if name != serviceName && service.Domain == domainName {
+			return ErrDomainConflict
+		}
+	}
+	return nil
+}
+
+// Validates a domain before it is persisted : a service domain must point to
+// an existing service that is the only one to claim it.
+func (m *Model) validateDomain(domain *Domain) error {
+	if err := domain.Validate(); err != nil {
+		return err
+	}
+
+	if domain.Typ == SERVICE_DOMAIN {
+		if _, ok := m.store.getService(domain.Value); !ok {
+			return &InvalidDomainError{domain.Name, fmt.Sprintf("the service %s does not exist", domain.Value)}
+		}
+		return m.checkDomainClaim(domain.Name, domain.Value)
+	}
+	return nil
+}
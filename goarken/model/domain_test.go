@@ -0,0 +1,95 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_DomainValidation(t *testing.T) {
+
+	Convey("Given valid domains", t, func() {
+		domains := []*Domain{
+			{Name: "test.domain.com", Typ: SERVICE_DOMAIN, Value: "testService"},
+			{Name: "*.domain.com", Typ: URI_DOMAIN, Value: "http://static.domain.com/site/"},
+			{Name: "localhost", Typ: REDIRECT_DOMAIN, Value: "https://www.domain.com"},
+		}
+
+		Convey("Then they are valid", func() {
+			for _, domain := range domains {
+				So(domain.Validate(), ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("Given invalid domains", t, func() {
+		domains := []*Domain{
+			{Name: "Test.Domain.com", Typ: SERVICE_DOMAIN, Value: "testService"},
+			{Name: "test..domain.com", Typ: SERVICE_DOMAIN, Value: "testService"},
+			{Name: "-test.domain.com", Typ: SERVICE_DOMAIN, Value: "testService"},
+			{Name: "test.*.com", Typ: SERVICE_DOMAIN, Value: "testService"},
+			{Name: "test.domain.com", Typ: SERVICE_DOMAIN},
+			{Name: "test.domain.com", Typ: URI_DOMAIN, Value: "ftp://domain.com"},
+			{Name: "test.domain.com", Typ: REDIRECT_DOMAIN, Value: "/relative"},
+			{Name: "test.domain.com", Typ: "other", Value: "testService"},
+		}
+
+		Convey("Then they are not valid", func() {
+			for _, domain := range domains {
+				err := domain.Validate()
+				So(err, ShouldNotBeNil)
+				So(err, ShouldHaveSameTypeAs, &InvalidDomainError{})
+			}
+		})
+	})
+}
+
+func Test_DomainClaims(t *testing.T) {
+
+	Convey("Given a model with a service that claims a domain", t, func() {
+		m := &Model{store: newStateStore()}
+		m.store.putService(&Service{Name: "testService", Domain: "test.domain.com"})
+		m.store.putService(&Service{Name: "otherService"})
+		m.store.putDomain(&Domain{Name: "test.domain.com", Typ: SERVICE_DOMAIN, Value: "testService"})
+
+		Convey("Then the service may claim it again", func() {
+			So(m.checkDomainClaim("test.domain.com", "testService"), ShouldBeNil)
+		})
+
+		Convey("Then another service can not claim it", func() {
+			So(m.checkDomainClaim("test.domain.com", "otherService"), ShouldEqual, ErrDomainConflict)
+			So(m.validateDomain(&Domain{Name: "test.domain.com", Typ: SERVICE_DOMAIN, Value: "otherService"}), ShouldEqual, ErrDomainConflict)
+		})
+
+		Convey("Then another service can claim a free domain", func() {
+			So(m.validateDomain(&Domain{Name: "other.domain.com", Typ: SERVICE_DOMAIN, Value: "otherService"}), ShouldBeNil)
+		})
+
+		Convey("Then a domain can not point to an unknown service", func() {
+			err := m.validateDomain(&Domain{Name: "other.domain.com", Typ: SERVICE_DOMAIN, Value: "unknown"})
+			So(err, ShouldHaveSameTypeAs, &InvalidDomainError{})
+		})
+
+		Convey("Then the domain can not be created again", func() {
+			_, err := m.CreateDomain(&Domain{Name: "test.domain.com", Typ: URI_DOMAIN, Value: "http://domain.com"})
+			So(err, ShouldEqual, ErrDomainExists)
+		})
+
+		Convey("Then a service can not be created on that domain", func() {
+			_, err := m.CreateService(&Service{Name: "newService", Domain: "test.domain.com"}, false)
+			So(err, ShouldEqual, ErrDomainConflict)
+		})
+	})
+}
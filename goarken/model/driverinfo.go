@@ -0,0 +1,113 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Driver neutral view of a service in the backend of a ServiceDriver. It is
+// what drivers return from their operations and publish on their Listen
+// channel, and what the model keeps in the service configuration.
+type DriverInfo struct {
+	// Name of the driver, as registered in the driver registry
+	Driver string `json:"driver"`
+	// Id of the service in the driver backend
+	Id string `json:"id,omitempty"`
+	// Name of the arken service
+	ServiceName   string    `json:"serviceName,omitempty"`
+	Location      *Location `json:"location,omitempty"`
+	HealthState   string    `json:"healthState,omitempty"`
+	CurrentStatus string    `json:"currentStatus,omitempty"`
+	// Driver specific information, opaque to the model. It is decoded with
+	// the payload type registered by the driver.
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+func (d DriverInfo) String() string {
+	return fmt.Sprintf("DriverInfo (%s) for %s : id: %s, location: %s, currentStatus: %s, health: %s, payload: %+v", d.Driver, d.ServiceName, d.Id, d.Location, d.CurrentStatus, d.HealthState, d.Payload)
+}
+
+// Returns a copy of the driver info. Payloads are expected to be pointers to
+// flat structs : the pointed value is copied, other payloads are shared.
+func (d *DriverInfo) Copy() *DriverInfo {
+	if d == nil {
+		return nil
+	}
+	result := *d
+	result.Location = d.Location.Copy()
+
+	if value := reflect.ValueOf(d.Payload); value.Kind() == reflect.Ptr && !value.IsNil() {
+		payload := reflect.New(value.Elem().Type())
+		payload.Elem().Set(value.Elem())
+		result.Payload = payload.Interface()
+	}
+	return &result
+}
+
+func (d *DriverInfo) UnmarshalJSON(data []byte) error {
+	type driverInfo DriverInfo
+	aux := struct {
+		*driverInfo
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}{driverInfo: (*driverInfo)(d)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	d.Payload = nil
+	if len(aux.Payload) == 0 || string(aux.Payload) == "null" {
+		return nil
+	}
+
+	var payload interface{}
+	if registration, ok := GetServiceDriverRegistration(d.Driver); ok && registration.NewPayload != nil {
+		payload = registration.NewPayload()
+	} else {
+		payload = &map[string]interface{}{}
+	}
+	if err := json.Unmarshal(aux.Payload, payload); err != nil {
+		return err
+	}
+	if m, ok := payload.(*map[string]interface{}); ok {
+		payload = *m
+	}
+	d.Payload = payload
+	return nil
+}
+
+// Reads the per driver keys (rancherInfo, dockerInfo...) used in the service
+// configuration before DriverInfo existed.
+func driverInfoFromLegacyKeys(data []byte) (*DriverInfo, error) {
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	for _, registration := range serviceDriverRegistrations() {
+		raw, ok := keys[registration.LegacyInfoKey]
+		if registration.LegacyInfoKey == "" || !ok || registration.NewPayload == nil || string(raw) == "null" {
+			continue
+		}
+		payload := registration.NewPayload()
+		if err := json.Unmarshal(raw, payload); err != nil {
+			return nil, err
+		}
+		return &DriverInfo{Driver: registration.Name, Payload: payload}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,109 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testPayload struct {
+	Image string `json:"image"`
+}
+
+func init() {
+	RegisterServiceDriver(&DriverRegistration{
+		Name:          "test",
+		NewPayload:    func() interface{} { return &testPayload{} },
+		LegacyInfoKey: "testInfo",
+	})
+}
+
+func Test_DriverInfo(t *testing.T) {
+
+	Convey("Given a service with driver info", t, func() {
+		service := &Service{Name: "testService"}
+		service.Init()
+		service.Config.DriverInfo = &DriverInfo{
+			Driver:        "test",
+			Id:            "id1",
+			ServiceName:   "testService",
+			Location:      &Location{Host: "127.0.0.1", Port: 8080},
+			CurrentStatus: STARTED_STATUS,
+			Payload:       &testPayload{Image: "nuxeo"},
+		}
+
+		Convey("When it is serialized and deserialized", func() {
+			data, err := json.Marshal(service)
+			So(err, ShouldBeNil)
+			result := &Service{}
+			err = json.Unmarshal(data, result)
+			So(err, ShouldBeNil)
+
+			Convey("Then the payload is decoded with the registered type", func() {
+				So(result.Config.DriverInfo.Id, ShouldEqual, "id1")
+				So(result.Config.DriverInfo.Location, ShouldResemble, &Location{Host: "127.0.0.1", Port: 8080})
+				So(result.Config.DriverInfo.Payload, ShouldResemble, &testPayload{Image: "nuxeo"})
+			})
+		})
+
+		Convey("When the service is copied", func() {
+			copy := service.Copy()
+			copy.Config.DriverInfo.Payload.(*testPayload).Image = "other"
+			copy.Config.DriverInfo.Location.Port = 9090
+
+			Convey("Then the driver info of the original is not modified", func() {
+				So(service.Config.DriverInfo.Payload.(*testPayload).Image, ShouldEqual, "nuxeo")
+				So(service.Config.DriverInfo.Location.Port, ShouldEqual, 8080)
+			})
+		})
+	})
+
+	Convey("Given a configuration using the legacy driver key", t, func() {
+		config := &ServiceConfig{}
+		err := json.Unmarshal([]byte(`{"robots": "", "testInfo": {"image": "nuxeo"}}`), config)
+
+		Convey("Then the driver info is read from it", func() {
+			So(err, ShouldBeNil)
+			So(config.DriverInfo.Driver, ShouldEqual, "test")
+			So(config.DriverInfo.Payload, ShouldResemble, &testPayload{Image: "nuxeo"})
+		})
+	})
+
+	Convey("Given driver info of an unknown driver", t, func() {
+		info := &DriverInfo{}
+		err := json.Unmarshal([]byte(`{"driver": "unknown", "payload": {"image": "nuxeo"}}`), info)
+
+		Convey("Then the payload is kept as a map", func() {
+			So(err, ShouldBeNil)
+			So(info.Payload, ShouldResemble, map[string]interface{}{"image": "nuxeo"})
+		})
+
+		Convey("Then the driver can not be created", func() {
+			_, err := NewServiceDriver("unknown", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given driver info reported without payload", t, func() {
+		current := &DriverInfo{Driver: "test", Payload: &testPayload{Image: "nuxeo"}}
+		merged := mergeDriverInfo(current, &DriverInfo{Driver: "test", CurrentStatus: STOPPED_STATUS})
+
+		Convey("Then the known payload is kept", func() {
+			So(merged.CurrentStatus, ShouldEqual, STOPPED_STATUS)
+			So(merged.Payload, ShouldResemble, &testPayload{Image: "nuxeo"})
+		})
+	})
+}
@@ -13,41 +13,58 @@
 // limitations under the License.
 package model
 
-// A driver knows how to create and manage Services.
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A driver knows how to create and manage Services. Its operations return the
+// DriverInfo of the service in the driver backend.
 type ServiceDriver interface {
 	// Creates a service and start it if asked.
-	Create(s *Service, startOnCreate bool) (interface{}, error)
+	Create(s *Service, startOnCreate bool) (*DriverInfo, error)
 
 	// Starts a given service
-	Start(s *Service) (interface{}, error)
+	Start(s *Service) (*DriverInfo, error)
 
 	// Upgrades a given service
-	Upgrade(s *Service) (interface{}, error)
+	Upgrade(s *Service) (*DriverInfo, error)
 
 	//Finishes the upgrade 
-	FinishUpgrade(s *Service) (interface{}, error)
+	FinishUpgrade(s *Service) (*DriverInfo, error)
 	
 	//Rollbacks after the upgrade
-	Rollback(s *Service) (interface{}, error)
+	Rollback(s *Service) (*DriverInfo, error)
 
 	// Stops a given service
-	Stop(s *Service) (interface{}, error)
+	Stop(s *Service) (*DriverInfo, error)
 
 	// Destroys a given service
 	Destroy(s *Service) error
 
-	// Returns a channell where ModelEvent are published by the service driver
+	// Returns a channell where ModelEvent are published by the service driver.
+	// Changes of the services in the backend are published as *DriverInfo
 	Listen() chan *ModelEvent
 
 	// Returns the driver's information for a given service
-	GetInfo(s *Service) (interface{}, error)
+	GetInfo(s *Service) (*DriverInfo, error)
 
 
 	// Tells if the service need to be upgrade when compared to its definition
 	NeedToBeUpgraded(s *Service) (bool, error)
 }
 
+// Returned by a PersistenceDriver when a Service or a Domain is persisted with
+// a revision that is not the stored one anymore.
+var ErrConflict = errors.New("Conflict : the object has been modified since it was read")
+
 // This drivers allow to persist the model in a backend.
+//
+// Persisted Services and Domains are compared and swapped on their Revision :
+// when it is not 0, they are only written if the stored revision is the same,
+// ErrConflict is returned otherwise. The revision of the written object is
+// updated, as is the one of the loaded objects and the ones in the events.
 type PersistenceDriver interface {
 	LoadAllServices() (map[string]*Service, error)
 	LoadService(serviceName string) (*Service, error)
@@ -61,3 +78,79 @@ type PersistenceDriver interface {
 
 	Listen() chan *ModelEvent
 }
+
+// Configuration given to the driver factories. It is typically backed by viper.
+type DriverConfig interface {
+	GetString(key string) string
+}
+
+// Creates a ServiceDriver from the configuration
+type DriverFactory func(config DriverConfig) (ServiceDriver, error)
+
+// Describes a ServiceDriver to the driver registry
+type DriverRegistration struct {
+	// Name of the driver, used in the configuration and in DriverInfo
+	Name string
+	// Creates the driver
+	Factory DriverFactory
+	// Returns a pointer to a new payload of the DriverInfo of the driver
+	NewPayload func() interface{}
+	// Key of the driver information in the service configuration before
+	// DriverInfo existed (e.g. rancherInfo), read for compatibility.
+	LegacyInfoKey string
+}
+
+var driverRegistry = struct {
+	sync.RWMutex
+	names         []string
+	registrations map[string]*DriverRegistration
+}{registrations: make(map[string]*DriverRegistration)}
+
+// Registers a ServiceDriver. Drivers usually register themselves in an
+// init function, registering the same name twice replaces the first one.
+func RegisterServiceDriver(registration *DriverRegistration) {
+	driverRegistry.Lock()
+	defer driverRegistry.Unlock()
+
+	if _, ok := driverRegistry.registrations[registration.Name]; !ok {
+		driverRegistry.names = append(driverRegistry.names, registration.Name)
+	}
+	driverRegistry.registrations[registration.Name] = registration
+}
+
+// Returns the registration of the driver with the given name.
+func GetServiceDriverRegistration(name string) (*DriverRegistration, bool) {
+	driverRegistry.RLock()
+	defer driverRegistry.RUnlock()
+
+	registration, ok := driverRegistry.registrations[name]
+	return registration, ok
+}
+
+// Returns the names of the registered drivers, in registration order.
+func ServiceDriverNames() []string {
+	driverRegistry.RLock()
+	defer driverRegistry.RUnlock()
+
+	return append([]string{}, driverRegistry.names...)
+}
+
+func serviceDriverRegistrations() []*DriverRegistration {
+	driverRegistry.RLock()
+	defer driverRegistry.RUnlock()
+
+	result := make([]*DriverRegistration, 0, len(driverRegistry.names))
+	for _, name := range driverRegistry.names {
+		result = append(result, driverRegistry.registrations[name])
+	}
+	return result
+}
+
+// Creates the registered driver with the given name.
+func NewServiceDriver(name string, config DriverConfig) (ServiceDriver, error) {
+	registration, ok := GetServiceDriverRegistration(name)
+	if !ok || registration.Factory == nil {
+		return nil, errors.New(fmt.Sprintf("Unknown service driver %s, available drivers are %v", name, ServiceDriverNames()))
+	}
+	return registration.Factory(config)
+}
@@ -15,6 +15,7 @@ package model
 
 import (
 	"fmt"
+	"github.com/arkenio/arken/goarken/metrics"
 	"sort"
 	"time"
 )
@@ -25,6 +26,7 @@ type eventBuffer struct {
 	eventsMap      map[string]*ModelEvent
 	events         chan *ModelEvent
 	eventBroadcast *Broadcaster
+	journal        *eventJournal
 }
 
 // Creates a new eventBuffer. Buffer has to be started by
@@ -34,6 +36,7 @@ func newEventBuffer(b *Broadcaster) *eventBuffer {
 		eventsMap:      make(map[string]*ModelEvent),
 		events:         make(chan *ModelEvent),
 		eventBroadcast: b,
+		journal:        newEventJournal(EVENT_JOURNAL_SIZE),
 	}
 }
 
@@ -53,11 +56,14 @@ func (eb *eventBuffer) run(duration time.Duration) {
 			sort.Sort(ModelByTime(events))
 
 			for _, event := range events {
+				metrics.PublishedEvents.WithLabelValues(event.ModelType).Inc()
+				eb.journal.append(event)
 				eb.eventBroadcast.Write(event)
 				delete(eb.eventsMap, eb.keyFromModelEvent(event))
 			}
 			break
 		case event := <-eb.events:
+			metrics.BufferedEvents.WithLabelValues(event.ModelType).Inc()
 			eb.eventsMap[eb.keyFromModelEvent(event)] = event
 			break
 		}
@@ -73,6 +79,10 @@ func (eb *eventBuffer) keyFromModelEvent(event *ModelEvent) string {
 		return fmt.Sprintf("D_%s_%s", event.EventType, domain.Name)
 	} else if service, ok := event.Model.(*Service); ok {
 		return fmt.Sprintf("S_%s_%s", event.EventType, service.Name)
+	} else if operation, ok := event.Model.(*Operation); ok {
+		return fmt.Sprintf("O_%s_%s", event.EventType, operation.Id)
+	} else if warning, ok := event.Model.(*PassivationWarning); ok {
+		return fmt.Sprintf("W_%s_%s", event.EventType, warning.ServiceName)
 	}
 	return "unknown"
 }
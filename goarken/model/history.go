@@ -0,0 +1,144 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrHistoryNotSupported = errors.New("The persistence driver can't store the history of the services")
+
+// Types of the events of the history of a service
+const (
+	// The computed status of the service changed
+	STATUS_HISTORY_EVENT = "status"
+	// The service moved to another host or port
+	LOCATION_HISTORY_EVENT = "location"
+	// An action has been run on the service
+	ACTION_HISTORY_EVENT = "action"
+)
+
+// A HistoryEvent is a change in the life of a service.
+type HistoryEvent struct {
+	// Ordered as the time of the events
+	Id   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// For status events, the computed status before and after the change
+	PreviousStatus string `json:"previousStatus,omitempty"`
+	Status         string `json:"status,omitempty"`
+	// For location events
+	PreviousLocation *Location `json:"previousLocation,omitempty"`
+	Location         *Location `json:"location,omitempty"`
+	// For action events, the action and its error if it failed
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Implemented by the persistence drivers that can store the history of the
+// services.
+type HistoryPersistenceDriver interface {
+	PersistenceDriver
+	AppendHistoryEvent(serviceName string, event *HistoryEvent) error
+	// Returns the events of a service from since, included, to until,
+	// excluded, a zero until meaning now, in the order of their ids
+	LoadServiceHistory(serviceName string, since time.Time, until time.Time) ([]*HistoryEvent, error)
+	// Deletes the events older than before, of every service
+	PruneServiceHistories(before time.Time) error
+}
+
+// Records an event in the history of a service. As for the audit log,
+// recording never fails the change.
+func (m *Model) recordHistory(serviceName string, event *HistoryEvent) {
+	driver, ok := m.persistenceDriver.(HistoryPersistenceDriver)
+	if !ok {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Id = newTimeId(event.Time)
+	if err := driver.AppendHistoryEvent(serviceName, event); err != nil {
+		log.Errorf("Unable to record the history of service %s : %s", serviceName, err.Error())
+	}
+}
+
+// Records an action run on a service whose status was before when it
+// started, and the change of status it made. Both statuses are the ones of
+// the model, the callers may pass objects that don't hold the status, as the
+// body of an update.
+func (m *Model) recordAction(action string, serviceName string, before string, err error) {
+	if err == ErrNotLeader {
+		return
+	}
+
+	event := &HistoryEvent{Type: ACTION_HISTORY_EVENT, Action: action}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	m.recordHistory(serviceName, event)
+
+	if after := m.ServiceStatus(serviceName); err == nil && after != "" && after != before {
+		m.recordStatusChange(serviceName, before, after)
+	}
+}
+
+func (m *Model) recordStatusChange(serviceName string, before string, after string) {
+	m.recordHistory(serviceName, &HistoryEvent{Type: STATUS_HISTORY_EVENT, PreviousStatus: before, Status: after})
+}
+
+func (m *Model) recordLocationChange(serviceName string, before *Location, after *Location) {
+	m.recordHistory(serviceName, &HistoryEvent{Type: LOCATION_HISTORY_EVENT, PreviousLocation: before, Location: after})
+}
+
+// Returns the events of the history of a service from since, included, to
+// until, excluded, zero values meaning no bound. Only the latest events are
+// kept when there are more than limit, when it is positive.
+func (m *Model) ServiceHistory(serviceName string, since time.Time, until time.Time, limit int) ([]*HistoryEvent, error) {
+	driver, ok := m.persistenceDriver.(HistoryPersistenceDriver)
+	if !ok {
+		return nil, ErrHistoryNotSupported
+	}
+
+	events, err := driver.LoadServiceHistory(serviceName, since, until)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events, nil
+}
+
+// Deletes the events of the histories older than the retention, every hour
+// while the daemon leads. Does not return.
+func (m *Model) KeepHistoryFor(retention time.Duration) {
+	driver, ok := m.persistenceDriver.(HistoryPersistenceDriver)
+	if !ok || retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if m.IsLeader() {
+			if err := driver.PruneServiceHistories(time.Now().Add(-retention)); err != nil {
+				log.Errorf("Unable to prune the histories of the services : %s", err.Error())
+			}
+		}
+		<-ticker.C
+	}
+}
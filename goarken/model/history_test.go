@@ -0,0 +1,144 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// Keeps the histories in memory and accepts the services, the other methods
+// are not implemented
+type mockHistoryDriver struct {
+	PersistenceDriver
+	events map[string][]*HistoryEvent
+}
+
+func (d *mockHistoryDriver) PersistService(service *Service) (*Service, error) {
+	return service, nil
+}
+
+func (d *mockHistoryDriver) AppendHistoryEvent(serviceName string, event *HistoryEvent) error {
+	d.events[serviceName] = append(d.events[serviceName], event)
+	return nil
+}
+
+func (d *mockHistoryDriver) LoadServiceHistory(serviceName string, since time.Time, until time.Time) ([]*HistoryEvent, error) {
+	result := []*HistoryEvent{}
+	for _, event := range d.events[serviceName] {
+		if event.Id >= TimeIdPrefix(since) && (until.IsZero() || event.Id < TimeIdPrefix(until)) {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (d *mockHistoryDriver) PruneServiceHistories(before time.Time) error {
+	for name := range d.events {
+		d.events[name], _ = d.LoadServiceHistory(name, before, time.Time{})
+	}
+	return nil
+}
+
+func Test_ServiceHistory(t *testing.T) {
+
+	Convey("Given a model whose driver stores the histories of a stopped service", t, func() {
+		driver := &mockHistoryDriver{events: make(map[string][]*HistoryEvent)}
+		m := &Model{store: newStateStore(), persistenceDriver: driver}
+		service := &Service{Name: "app1"}
+		service.Init()
+		m.store.putService(service)
+
+		Convey("When an action changes its status", func() {
+			service.Status.Expected = STARTED_STATUS
+			service.Status.Current = STARTING_STATUS
+			m.store.putService(service)
+			m.recordAction(START_ACTION, "app1", STOPPED_STATUS, nil)
+
+			Convey("Then the action and the change of status are recorded in order", func() {
+				events, err := m.ServiceHistory("app1", time.Time{}, time.Time{}, 0)
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 2)
+				So(events[0].Type, ShouldEqual, ACTION_HISTORY_EVENT)
+				So(events[0].Action, ShouldEqual, START_ACTION)
+				So(events[1].Type, ShouldEqual, STATUS_HISTORY_EVENT)
+				So(events[1].PreviousStatus, ShouldEqual, STOPPED_STATUS)
+				So(events[1].Status, ShouldEqual, STARTING_STATUS)
+				So(events[0].Id < events[1].Id, ShouldBeTrue)
+			})
+
+			Convey("Then the limit keeps the latest events", func() {
+				events, _ := m.ServiceHistory("app1", time.Time{}, time.Time{}, 1)
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, STATUS_HISTORY_EVENT)
+			})
+		})
+
+		Convey("When an action fails", func() {
+			m.recordAction(STOP_ACTION, "app1", STOPPED_STATUS, errors.New("boom"))
+
+			Convey("Then only the action is recorded, with its error", func() {
+				events, _ := m.ServiceHistory("app1", time.Time{}, time.Time{}, 0)
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Error, ShouldEqual, "boom")
+			})
+		})
+
+		Convey("When an action is refused by a follower", func() {
+			m.recordAction(STOP_ACTION, "app1", STOPPED_STATUS, ErrNotLeader)
+
+			Convey("Then nothing is recorded", func() {
+				So(len(driver.events["app1"]), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When it is started and updated with a body without status", func() {
+			service.Status.Expected = STARTED_STATUS
+			service.Status.Current = STARTED_STATUS
+			service.Status.Alive = "1"
+			m.store.putService(service)
+			_, err := m.UpdateService(&Service{Name: "app1", Config: &ServiceConfig{Environment: map[string]interface{}{"KEY": "value"}}})
+			So(err, ShouldBeNil)
+
+			Convey("Then only the update is recorded, without change of status", func() {
+				events, _ := m.ServiceHistory("app1", time.Time{}, time.Time{}, 0)
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, ACTION_HISTORY_EVENT)
+				So(events[0].Action, ShouldEqual, UPDATE_ACTION)
+			})
+		})
+
+		Convey("When the service moves", func() {
+			m.recordLocationChange("app1", nil, &Location{Host: "10.0.0.1", Port: 8080})
+
+			Convey("Then its new location is recorded", func() {
+				events, _ := m.ServiceHistory("app1", time.Time{}, time.Time{}, 0)
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, LOCATION_HISTORY_EVENT)
+				So(events[0].Location.Host, ShouldEqual, "10.0.0.1")
+			})
+		})
+	})
+
+	Convey("Given a model whose driver can't store the histories", t, func() {
+		m := &Model{store: newStateStore(), persistenceDriver: &mockAPIKeyDriver{}}
+
+		Convey("Then the history is not supported", func() {
+			_, err := m.ServiceHistory("app1", time.Time{}, time.Time{}, 0)
+			So(err, ShouldEqual, ErrHistoryNotSupported)
+		})
+	})
+}
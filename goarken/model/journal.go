@@ -0,0 +1,96 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Events kept by the journal of the model
+const EVENT_JOURNAL_SIZE = 1000
+
+// Event sent to the clients that missed events no longer in the journal
+const RESYNC_EVENT = "resync"
+
+var ErrResyncRequired = errors.New("The events since that sequence are no longer available, a resync is required")
+
+// The journal numbers the published events and keeps the last ones, so that
+// clients can catch up with the events they missed.
+//
+// Sequences start from the time the journal is created, in microseconds, so
+// that they keep growing when the daemon restarts : the sequences of a
+// previous run are then too old for the journal.
+type eventJournal struct {
+	mutex  sync.RWMutex
+	size   int
+	events []*ModelEvent
+	last   uint64
+}
+
+func newEventJournal(size int) *eventJournal {
+	return &eventJournal{
+		size:   size,
+		events: make([]*ModelEvent, 0, size),
+		last:   uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+	}
+}
+
+// Numbers the event and keeps it.
+func (j *eventJournal) append(event *ModelEvent) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.last++
+	event.Sequence = j.last
+	if len(j.events) == j.size {
+		copy(j.events, j.events[1:])
+		j.events = j.events[:j.size-1]
+	}
+	j.events = append(j.events, event)
+}
+
+// Returns the events published after the given sequence, or
+// ErrResyncRequired when some of them are no longer kept.
+func (j *eventJournal) since(sequence uint64) ([]*ModelEvent, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	if sequence > j.last {
+		return nil, ErrResyncRequired
+	}
+	missed := int(j.last - sequence)
+	if missed > len(j.events) {
+		return nil, ErrResyncRequired
+	}
+	return append([]*ModelEvent(nil), j.events[len(j.events)-missed:]...), nil
+}
+
+func (j *eventJournal) lastSequence() uint64 {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	return j.last
+}
+
+// Returns the events published after the given sequence, oldest first, or
+// ErrResyncRequired when some of them are no longer available.
+func (m *Model) EventsSince(sequence uint64) ([]*ModelEvent, error) {
+	return m.eventBuffer.journal.since(sequence)
+}
+
+// Returns the sequence of the last published event.
+func (m *Model) LastEventSequence() uint64 {
+	return m.eventBuffer.journal.lastSequence()
+}
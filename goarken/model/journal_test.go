@@ -0,0 +1,70 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_EventJournal(t *testing.T) {
+
+	Convey("Given a journal of 3 events", t, func() {
+		journal := newEventJournal(3)
+		first := journal.lastSequence()
+
+		Convey("When 5 events are appended", func() {
+			for i := 0; i < 5; i++ {
+				journal.append(NewModelEvent("update", &Service{Name: "testService"}))
+			}
+
+			Convey("Then they are numbered in order", func() {
+				So(journal.lastSequence(), ShouldEqual, first+5)
+			})
+
+			Convey("Then the events since a kept sequence are returned, oldest first", func() {
+				events, err := journal.since(first + 3)
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 2)
+				So(events[0].Sequence, ShouldEqual, first+4)
+				So(events[1].Sequence, ShouldEqual, first+5)
+
+				events, err = journal.since(first + 2)
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 3)
+			})
+
+			Convey("Then nothing is returned since the last sequence", func() {
+				events, err := journal.since(first + 5)
+				So(err, ShouldBeNil)
+				So(events, ShouldBeEmpty)
+			})
+
+			Convey("Then a resync is required since a sequence no longer kept", func() {
+				_, err := journal.since(first + 1)
+				So(err, ShouldEqual, ErrResyncRequired)
+			})
+
+			Convey("Then a resync is required since an unknown sequence", func() {
+				_, err := journal.since(first + 6)
+				So(err, ShouldEqual, ErrResyncRequired)
+			})
+		})
+
+		Convey("Then a resync is required since a sequence of a previous run", func() {
+			_, err := journal.since(first - 1)
+			So(err, ShouldEqual, ErrResyncRequired)
+		})
+	})
+}
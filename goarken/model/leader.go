@@ -0,0 +1,147 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned by the Model mutators when the daemon is not the leader of the
+// cluster.
+var ErrNotLeader = errors.New("Not the leader of the cluster")
+
+// An Elector elects a leader among the arken daemons that share the same
+// persistence backend.
+type Elector interface {
+	// Returns the id of the daemon in the election
+	Id() string
+
+	// Campaigns for the leadership until Resign is called. The leadership
+	// changes of the daemon are published on the returned channel : true
+	// when it is elected, false when it loses the leadership. The channel
+	// is closed, which also ends the leadership, when the elector resigns.
+	Campaign() chan bool
+
+	// Returns the id of the current leader, or an empty string if there is none
+	Leader() (string, error)
+
+	// Gives up the leadership and stops campaigning
+	Resign() error
+}
+
+// Implemented by the persistence drivers that can elect a leader in their
+// backend. The election is held on the given key, a leader that does not
+// renew its leadership within the ttl loses it.
+type ElectingPersistenceDriver interface {
+	PersistenceDriver
+	NewElector(key string, id string, ttl time.Duration) (Elector, error)
+}
+
+// An Elector that never campaigns : the daemon follows the leader of the
+// cluster until it resigns.
+type followerElector struct {
+	id         string
+	leadership chan bool
+	once       sync.Once
+}
+
+func (e *followerElector) Id() string {
+	return e.id
+}
+
+func (e *followerElector) Campaign() chan bool {
+	return e.leadership
+}
+
+// The leader is not known without taking part in the election
+func (e *followerElector) Leader() (string, error) {
+	return "", nil
+}
+
+func (e *followerElector) Resign() error {
+	e.once.Do(func() { close(e.leadership) })
+	return nil
+}
+
+// Describes the daemon in the cluster
+type ClusterStatus struct {
+	// False when the daemon runs alone, without election
+	Enabled  bool   `json:"enabled"`
+	Id       string `json:"id,omitempty"`
+	Leader   string `json:"leader,omitempty"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+// Tells if the daemon is the leader of the cluster. A model without elector
+// is always the leader.
+func (m *Model) IsLeader() bool {
+	m.leaderMutex.RLock()
+	defer m.leaderMutex.RUnlock()
+	return m.elector == nil || m.leading
+}
+
+// Returns the status of the daemon in the cluster.
+func (m *Model) ClusterStatus() (*ClusterStatus, error) {
+	if m.elector == nil {
+		return &ClusterStatus{IsLeader: true}, nil
+	}
+
+	leader, err := m.elector.Leader()
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterStatus{
+		Enabled:  true,
+		Id:       m.elector.Id(),
+		Leader:   leader,
+		IsLeader: m.IsLeader(),
+	}, nil
+}
+
+// Gives up the leadership, to let another daemon take it over without
+// waiting for the expiration of this one.
+func (m *Model) Resign() error {
+	if m.elector == nil {
+		return nil
+	}
+	return m.elector.Resign()
+}
+
+// Follows the leadership changes published by the elector. A newly elected
+// leader synchronizes all the services, since the previous one may have
+// missed some driver updates.
+func (m *Model) followLeadership(leadership chan bool) {
+	for leading := range leadership {
+		m.leaderMutex.Lock()
+		changed := m.leading != leading
+		m.leading = leading
+		m.leaderMutex.Unlock()
+
+		if !changed {
+			continue
+		}
+		if leading {
+			log.Infof("%s is now the leader of the cluster", m.elector.Id())
+			go m.syncServiceByStatus(nil)
+		} else {
+			log.Warnf("%s is not the leader of the cluster anymore", m.elector.Id())
+		}
+	}
+
+	m.leaderMutex.Lock()
+	m.leading = false
+	m.leaderMutex.Unlock()
+}
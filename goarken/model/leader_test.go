@@ -0,0 +1,137 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+type mockElector struct {
+	leadership chan bool
+	leader     string
+}
+
+func (e *mockElector) Id() string {
+	return "self"
+}
+
+func (e *mockElector) Campaign() chan bool {
+	return e.leadership
+}
+
+func (e *mockElector) Leader() (string, error) {
+	return e.leader, nil
+}
+
+func (e *mockElector) Resign() error {
+	close(e.leadership)
+	return nil
+}
+
+func waitForLeadership(m *Model, leading bool) bool {
+	timeout := time.After(time.Second)
+	for m.IsLeader() != leading {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func Test_Leadership(t *testing.T) {
+
+	Convey("Given a model without elector", t, func() {
+		m := &Model{store: newStateStore()}
+
+		Convey("Then it is the leader", func() {
+			So(m.IsLeader(), ShouldBeTrue)
+			status, _ := m.ClusterStatus()
+			So(status.Enabled, ShouldBeFalse)
+			So(status.IsLeader, ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a model with an elector", t, func() {
+		elector := &mockElector{leadership: make(chan bool), leader: "other"}
+		m := &Model{store: newStateStore(), elector: elector}
+		go m.followLeadership(elector.Campaign())
+
+		Convey("Then it is not the leader until it is elected", func() {
+			So(m.IsLeader(), ShouldBeFalse)
+			status, _ := m.ClusterStatus()
+			So(status.Enabled, ShouldBeTrue)
+			So(status.Id, ShouldEqual, "self")
+			So(status.Leader, ShouldEqual, "other")
+		})
+
+		Convey("Then it can not modify the model", func() {
+			service := &Service{Name: "testService"}
+			service.Init()
+			_, err := m.StartService(service)
+			So(err, ShouldEqual, ErrNotLeader)
+			So(m.DestroyService(service), ShouldEqual, ErrNotLeader)
+			_, err = m.CreateDomain(&Domain{Name: "test.domain.com"})
+			So(err, ShouldEqual, ErrNotLeader)
+		})
+
+		Convey("When it is elected", func() {
+			elector.leadership <- true
+
+			Convey("Then it is the leader", func() {
+				So(waitForLeadership(m, true), ShouldBeTrue)
+			})
+
+			Convey("When it loses the leadership", func() {
+				elector.leadership <- false
+
+				Convey("Then it is not the leader anymore", func() {
+					So(waitForLeadership(m, false), ShouldBeTrue)
+				})
+			})
+		})
+
+		Reset(func() {
+			m.Resign()
+		})
+	})
+	Convey("Given a follower model", t, func() {
+		elector := &followerElector{id: "proxy", leadership: make(chan bool)}
+		m := &Model{store: newStateStore(), elector: elector}
+		go m.followLeadership(elector.Campaign())
+
+		Convey("Then it never leads, nor modifies the model", func() {
+			So(m.IsLeader(), ShouldBeFalse)
+			status, _ := m.ClusterStatus()
+			So(status.Id, ShouldEqual, "proxy")
+			service := &Service{Name: "testService"}
+			service.Init()
+			_, err := m.StartService(service)
+			So(err, ShouldEqual, ErrNotLeader)
+		})
+
+		Convey("Then it can resign more than once", func() {
+			So(m.Resign(), ShouldBeNil)
+			So(m.Resign(), ShouldBeNil)
+			So(m.IsLeader(), ShouldBeFalse)
+		})
+
+		Reset(func() {
+			m.Resign()
+		})
+	})
+}
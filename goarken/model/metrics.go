@@ -0,0 +1,116 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"github.com/arkenio/arken/goarken/metrics"
+	"time"
+)
+
+// Records an action run on a service, and its failure.
+func observeAction(action string, service *Service, err error) {
+	driver := driverName(service, nil)
+	metrics.Actions.WithLabelValues(action, driver).Inc()
+	if err != nil {
+		metrics.ActionFailures.WithLabelValues(action, driver).Inc()
+	}
+}
+
+// Returns the name of the driver of a service, as given by the driver info
+// when there is one.
+func driverName(service *Service, info *DriverInfo) string {
+	if info != nil && info.Driver != "" {
+		return info.Driver
+	}
+	if service != nil && service.Config != nil && service.Config.DriverInfo != nil && service.Config.DriverInfo.Driver != "" {
+		return service.Config.DriverInfo.Driver
+	}
+	return "unknown"
+}
+
+// Wraps a ServiceDriver to measure the duration of its calls.
+type instrumentedDriver struct {
+	driver ServiceDriver
+}
+
+func (d *instrumentedDriver) observe(call string, s *Service, info *DriverInfo, start time.Time) {
+	metrics.DriverCallDuration.WithLabelValues(driverName(s, info), call).Observe(time.Since(start).Seconds())
+}
+
+func (d *instrumentedDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.Create(s, startOnCreate)
+	d.observe("create", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) Start(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.Start(s)
+	d.observe("start", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) Upgrade(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.Upgrade(s)
+	d.observe("upgrade", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.FinishUpgrade(s)
+	d.observe("finishupgrade", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) Rollback(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.Rollback(s)
+	d.observe("rollback", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) Stop(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.Stop(s)
+	d.observe("stop", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) Destroy(s *Service) error {
+	start := time.Now()
+	err := d.driver.Destroy(s)
+	d.observe("destroy", s, nil, start)
+	return err
+}
+
+func (d *instrumentedDriver) Listen() chan *ModelEvent {
+	return d.driver.Listen()
+}
+
+func (d *instrumentedDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	start := time.Now()
+	info, err := d.driver.GetInfo(s)
+	d.observe("getinfo", s, info, start)
+	return info, err
+}
+
+func (d *instrumentedDriver) NeedToBeUpgraded(s *Service) (bool, error) {
+	start := time.Now()
+	upgrade, err := d.driver.NeedToBeUpgraded(s)
+	d.observe("needtobeupgraded", s, nil, start)
+	return upgrade, err
+}
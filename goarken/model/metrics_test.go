@@ -0,0 +1,60 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"github.com/arkenio/arken/goarken/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Metrics(t *testing.T) {
+
+	Convey("Given a service of the docker driver", t, func() {
+		service := &Service{Name: "testService"}
+		service.Init()
+		service.Config.DriverInfo = &DriverInfo{Driver: "docker"}
+
+		Convey("When an action succeeds", func() {
+			before := testutil.ToFloat64(metrics.Actions.WithLabelValues(START_ACTION, "docker"))
+			failures := testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(START_ACTION, "docker"))
+			observeAction(START_ACTION, service, nil)
+
+			Convey("Then it is counted, without failure", func() {
+				So(testutil.ToFloat64(metrics.Actions.WithLabelValues(START_ACTION, "docker")), ShouldEqual, before+1)
+				So(testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(START_ACTION, "docker")), ShouldEqual, failures)
+			})
+		})
+
+		Convey("When an action fails", func() {
+			failures := testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(STOP_ACTION, "docker"))
+			observeAction(STOP_ACTION, service, errors.New("Driver error"))
+
+			Convey("Then its failure is counted", func() {
+				So(testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(STOP_ACTION, "docker")), ShouldEqual, failures+1)
+			})
+		})
+	})
+
+	Convey("Given a service without driver info", t, func() {
+		service := &Service{Name: "testService"}
+
+		Convey("Then its driver is unknown, unless given by the driver", func() {
+			So(driverName(service, nil), ShouldEqual, "unknown")
+			So(driverName(service, &DriverInfo{Driver: "rancher"}), ShouldEqual, "rancher")
+		})
+	})
+}
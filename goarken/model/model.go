@@ -17,39 +17,70 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"sync"
 	"time"
 )
 
 var log = logrus.New()
 
 /*
-The Arken model is a structure that holds a map of Services and
-a map of Domain. A Model MUST be backed by a PersistenceDriver and
+The Arken model is a structure that holds the Services and the Domains
+of the cluster. A Model MUST be backed by a PersistenceDriver and
 MAY drivre ServiceDriver.
+
+The Model is safe for concurrent use : Services and Domains are kept in
+a state store and are only handed out as copies, which callers may modify
+freely before passing them back to the Model methods.
+
+When several daemons share the same persistence backend, an Elector elects
+one of them : only the leader modifies the model and synchronizes it with
+the service driver, the others follow the changes of the backend.
 */
 type Model struct {
 	serviceDriver     ServiceDriver
 	persistenceDriver PersistenceDriver
 
-	Domains        map[string]*Domain
-	Services       map[string]*Service
+	store          *stateStore
+	operations     *operationStore
 	eventBroadcast *Broadcaster
 	eventBuffer    *eventBuffer
+
+	elector     Elector
+	leaderMutex sync.RWMutex
+	leading     bool
 }
 
 // Create an ArkenModel base on a serviceDriver and a PersistenceDriver. The
 // ServiceDriver is optional.
 func NewArkenModel(sDriver ServiceDriver, pDriver PersistenceDriver) (*Model, error) {
+	return NewClusteredArkenModel(sDriver, pDriver, nil)
+}
+
+// Create an ArkenModel that never leads the cluster : it reads the model and
+// records the accesses to the services, but leaves the other changes to the
+// leader.
+func NewFollowerArkenModel(sDriver ServiceDriver, pDriver PersistenceDriver, id string) (*Model, error) {
+	return NewClusteredArkenModel(sDriver, pDriver, &followerElector{id: id, leadership: make(chan bool)})
+}
+
+// Create an ArkenModel that only modifies the model while the given Elector
+// elects it. Without Elector, the model is always the leader.
+func NewClusteredArkenModel(sDriver ServiceDriver, pDriver PersistenceDriver, elector Elector) (*Model, error) {
 	if pDriver == nil {
 		return nil, errors.New("Can't use a nil persistence Driver for Arken model")
 	}
 
+	if sDriver != nil {
+		sDriver = &instrumentedDriver{sDriver}
+	}
+
 	model := &Model{
-		Domains:           make(map[string]*Domain),
-		Services:          make(map[string]*Service),
+		store:             newStateStore(),
+		operations:        newOperationStore(),
 		serviceDriver:     sDriver,
 		persistenceDriver: pDriver,
 		eventBroadcast:    NewBroadcaster(),
+		elector:           elector,
 	}
 	model.eventBuffer = newEventBuffer(model.eventBroadcast)
 	err := model.Init()
@@ -67,6 +98,26 @@ func (m *Model) Listen() chan *ModelEvent {
 	return FromInterfaceChannel(m.eventBroadcast.Listen())
 }
 
+// Returns a copy of the service with the given name.
+func (m *Model) GetService(name string) (*Service, bool) {
+	return m.store.getService(name)
+}
+
+// Returns a snapshot of all the services of the model, indexed by name.
+func (m *Model) Services() map[string]*Service {
+	return m.store.allServices()
+}
+
+// Returns a copy of the domain with the given name.
+func (m *Model) GetDomain(name string) (*Domain, bool) {
+	return m.store.getDomain(name)
+}
+
+// Returns a snapshot of all the domains of the model, indexed by name.
+func (m *Model) Domains() map[string]*Domain {
+	return m.store.allDomains()
+}
+
 // Inits the model. It loads the model from the persistence driver and then listen
 // to changes. It also starts listening on service driver updates.
 func (m *Model) Init() error {
@@ -76,13 +127,13 @@ func (m *Model) Init() error {
 	if err != nil {
 		return err
 	}
-	m.Domains = domains
+	m.store.resetDomains(domains)
 
 	services, err := m.persistenceDriver.LoadAllServices()
 	if err != nil {
 		return err
 	}
-	m.Services = services
+	m.store.resetServices(services)
 
 	// Listen to external events
 	go m.handlePersistenceModelEventOn(m.persistenceDriver.Listen())
@@ -97,6 +148,11 @@ func (m *Model) Init() error {
 	// Launch event buffer
 	go m.eventBuffer.run(time.Second)
 
+	// Campaign for the leadership
+	if m.elector != nil {
+		go m.followLeadership(m.elector.Campaign())
+	}
+
 	return nil
 
 }
@@ -115,9 +171,15 @@ func (m *Model) keepServiceDriverInSync(duration time.Duration) {
 	for {
 		select {
 		case <-allTicker.C:
+			if !m.IsLeader() {
+				continue
+			}
 			m.syncServiceByStatus(nil)
 			allTickerPassed = true
 		case <-startedTicker.C:
+			if !m.IsLeader() {
+				continue
+			}
 			if allTickerPassed == false {
 				m.syncServiceByStatus([]string{"started", "error"})
 			} else {
@@ -138,16 +200,17 @@ func (m *Model) syncServiceByStatus(status []string) {
 
 // Synchronize ServiceDriver state with internal Arken state
 func (m *Model) SyncService(service *Service) {
-	if m.serviceDriver == nil {
+	if m.serviceDriver == nil || !m.IsLeader() {
 		return
 	}
 	info, err := m.serviceDriver.GetInfo(service)
 	if err != nil {
 		log.Warningf("Unable to get Status from service driver on %v", service.Name)
-	} else {
-		if info, ok := info.(*RancherInfoType); ok {
-			m.onRancherInfo(info)
+	} else if info != nil {
+		if info.ServiceName == "" {
+			info.ServiceName = service.Name
 		}
+		m.onDriverInfo(info)
 	}
 }
 
@@ -162,16 +225,39 @@ func inArray(array []string, seek string) bool {
 	return false
 }
 
-// Helper to execute a function on each service of the model
+// Helper to execute a function on each service of the model. The function
+// is called on a snapshot, so it may call the Model mutators.
 func (m *Model) onAllService(serviceHandler func(s *Service)) {
-	for _, service := range m.Services {
+	for _, service := range m.store.allServices() {
 		serviceHandler(service)
 	}
 }
 
 // Creates a Service and starts it if asked. If the Domain of the service is provided, then the
 // corresponding domain is also created.
-func (m *Model) CreateService(service *Service, startOnCreate bool) (*Service, error) {
+func (m *Model) CreateService(service *Service, startOnCreate bool) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction("create", service, err)
+		m.recordAction("create", service.Name, before, err)
+	}(service, "")
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	if service.Config != nil {
+		if err := service.Config.Passivation.Validate(service.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if service.Domain != "" {
+		if err := (&Domain{Name: service.Domain, Typ: SERVICE_DOMAIN, Value: service.Name}).Validate(); err != nil {
+			return nil, err
+		}
+		if err := m.checkDomainClaim(service.Domain, service.Name); err != nil {
+			return nil, err
+		}
+	}
 
 	s, err := m.persistenceDriver.PersistService(service)
 	if err != nil {
@@ -181,7 +267,7 @@ func (m *Model) CreateService(service *Service, startOnCreate bool) (*Service, e
 	if m.serviceDriver != nil {
 		info, err := m.serviceDriver.Create(s, startOnCreate)
 		if err != nil {
-			return nil, errors.New(fmt.Sprintf("Unable to create service in backend : %s", s.Name, err.Error()))
+			return nil, errors.New(fmt.Sprintf("Unable to create service %s in backend : %s", s.Name, err.Error()))
 		}
 
 		m.updateInfoFromDriver(s, info)
@@ -194,64 +280,99 @@ func (m *Model) CreateService(service *Service, startOnCreate bool) (*Service, e
 
 	if s.Domain != "" {
 
-		if domain, ok := m.Domains[s.Domain]; ok {
-			domain.Typ = "service"
-			domain.Value = s.Name
-			_, err = m.UpdateDomain(domain)
-			if err != nil {
-				log.Errorf("Unable to update domain %s for service %s : %v", s.Domain, s.Name, err)
-			}
-		} else {
-			_, err := m.CreateDomain(&Domain{Name: s.Domain, Typ: "service", Value: s.Name})
-			if err != nil {
-				log.Errorf("Unable to create domain %s for service %s : %v", s.Domain, s.Name, err)
-			}
-		}
+		m.claimDomain(s.Domain, s.Name)
 	}
 
-	m.eventBuffer.events <- NewModelEvent("create", s)
+	m.eventBuffer.events <- NewModelEvent("create", s.Copy())
 
 	return s, nil
 
 }
 
-// Creates a Domain
+// Points the domain to the service, creating the domain if needed.
+func (m *Model) claimDomain(domainName string, serviceName string) {
+	if domain, ok := m.store.getDomain(domainName); ok {
+		domain.Typ = SERVICE_DOMAIN
+		domain.Value = serviceName
+		if _, err := m.UpdateDomain(domain); err != nil {
+			log.Errorf("Unable to update domain %s for service %s : %v", domainName, serviceName, err)
+		}
+	} else {
+		_, err := m.CreateDomain(&Domain{Name: domainName, Typ: SERVICE_DOMAIN, Value: serviceName})
+		if err != nil {
+			log.Errorf("Unable to create domain %s for service %s : %v", domainName, serviceName, err)
+		}
+	}
+}
+
+// Creates a Domain. The domain must be valid and must not exist yet,
+// ErrDomainExists is returned otherwise. A service domain must point to an
+// existing service, that no other service claims, ErrDomainConflict is
+// returned otherwise.
 func (m *Model) CreateDomain(domain *Domain) (*Domain, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	if _, ok := m.store.getDomain(domain.Name); ok {
+		return nil, ErrDomainExists
+	}
+	if err := m.validateDomain(domain); err != nil {
+		return nil, err
+	}
+
 	domain, err := m.persistenceDriver.PersistDomain(domain)
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("create", domain)
+		m.eventBuffer.events <- NewModelEvent("create", m.store.putDomain(domain))
 		return domain, nil
 	}
 }
 
 //Destroys a Domain
 func (m *Model) DestroyDomain(domain *Domain) error {
+	if !m.IsLeader() {
+		return ErrNotLeader
+	}
 
 	err := m.persistenceDriver.DestroyDomain(domain)
 	if err != nil {
 		return err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("delete", domain)
+		m.store.deleteDomain(domain.Name)
+		m.eventBuffer.events <- NewModelEvent("delete", domain.Copy())
 		return nil
 	}
 
 }
 
-// Updates a domain
+// Updates a domain. The same checks as in CreateDomain apply to the result.
 func (m *Model) UpdateDomain(domain *Domain) (*Domain, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	if err := m.validateDomain(domain); err != nil {
+		return nil, err
+	}
+
 	domain, err := m.persistenceDriver.PersistDomain(domain)
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("update", domain)
+		m.eventBuffer.events <- NewModelEvent("update", m.store.putDomain(domain))
 		return domain, nil
 	}
 }
 
 // Starts a service (only works if ServiceDriver is set)
-func (m *Model) StartService(service *Service) (*Service, error) {
+func (m *Model) StartService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(START_ACTION, service, err)
+		m.recordAction(START_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 
 	if m.serviceDriver != nil {
 		info, err := m.serviceDriver.Start(service)
@@ -263,19 +384,27 @@ func (m *Model) StartService(service *Service) (*Service, error) {
 
 	service.Status.Expected = STARTED_STATUS
 	service.Status.Current = STARTING_STATUS
+	service.PassivationReason = ""
 	AddAction(service, STOP_ACTION, UPDATE_ACTION, DELETE_ACTION)
-	service, err := m.saveService(service)
+	service, err = m.saveService(service)
 
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("update", service)
+		m.eventBuffer.events <- NewModelEvent("update", service.Copy())
 		return service, nil
 	}
 }
 
 // Stops a service (only works if ServiceDriver is set)
-func (m *Model) StopService(service *Service) (*Service, error) {
+func (m *Model) StopService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(STOP_ACTION, service, err)
+		m.recordAction(STOP_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 	service.Status.Expected = STOPPED_STATUS
 	AddAction(service, START_ACTION, DELETE_ACTION)
 
@@ -288,18 +417,25 @@ func (m *Model) StopService(service *Service) (*Service, error) {
 		m.updateInfoFromDriver(service, info)
 	}
 
-	service, err := m.saveService(service)
+	service, err = m.saveService(service)
 
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("update", service)
+		m.eventBuffer.events <- NewModelEvent("update", service.Copy())
 		return service, nil
 	}
 }
 
 // Passivates a service (only works if ServiceDriver is set)
-func (m *Model) PassivateService(service *Service) (*Service, error) {
+func (m *Model) PassivateService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(PASSIVATE_ACTION, service, err)
+		m.recordAction(PASSIVATE_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 	service.Status.Expected = PASSIVATED_STATUS
 	AddAction(service, DELETE_ACTION)
 	info, err := m.serviceDriver.Stop(service)
@@ -314,16 +450,29 @@ func (m *Model) PassivateService(service *Service) (*Service, error) {
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("update", service)
+		m.eventBuffer.events <- NewModelEvent("update", service.Copy())
 		return service, nil
 	}
 }
 
-func (m *Model) UpdateService(service *Service) (*Service, error) {
+// Updates the environment, passivation and domain of a service. When the
+// service has a revision, the update is only done on that revision of the
+// service, ErrConflict is returned when the stored one is another one.
+func (m *Model) UpdateService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(UPDATE_ACTION, service, err)
+		m.recordAction(UPDATE_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 
-	if origService, ok := m.Services[service.Name]; !ok {
+	if origService, ok := m.store.getService(service.Name); !ok {
 		return nil, errors.New("Service not found")
 	} else {
+		if service.Revision != 0 && service.Revision != origService.Revision {
+			return nil, ErrConflict
+		}
 
 		if service.Config != nil {
 
@@ -332,32 +481,28 @@ func (m *Model) UpdateService(service *Service) (*Service, error) {
 			}
 
 			if service.Config.Passivation != nil {
+				if err := service.Config.Passivation.Validate(service.Name); err != nil {
+					return nil, err
+				}
 				origService.Config.Passivation = service.Config.Passivation
 			}
 		}
 
 		//Updates the domain of the service
+		claimed := false
 		if service.Domain != "" && service.Domain != origService.Domain {
-			if oldDomain, ok := m.Domains[origService.Domain]; ok {
-				m.DestroyDomain(oldDomain)
-
-				if domain, ok := m.Domains[service.Domain]; ok {
-					domain.Typ = "service"
-					domain.Value = service.Name
-					_, err := m.UpdateDomain(domain)
-					if err != nil {
-						log.Errorf("Unable to update domain %s for service %s : %v", service.Domain, service.Name, err)
-					}
-				} else {
-					_, err := m.CreateDomain(&Domain{Name: service.Domain, Typ: "service", Value: service.Name})
-					if err != nil {
-						log.Errorf("Unable to create domain %s for service %s : %v", service.Domain, service.Name, err)
-					}
-				}
+			if err := (&Domain{Name: service.Domain, Typ: SERVICE_DOMAIN, Value: service.Name}).Validate(); err != nil {
+				return nil, err
+			}
+			if err := m.checkDomainClaim(service.Domain, service.Name); err != nil {
+				return nil, err
 			}
 
+			if oldDomain, ok := m.store.getDomain(origService.Domain); ok && oldDomain.Value == service.Name {
+				m.DestroyDomain(oldDomain)
+			}
 			origService.Domain = service.Domain
-
+			claimed = true
 		}
 		//update the actions available on the service if the service needs to be upgraded
 		var updated, err = m.NeedToBeUpgraded(origService)
@@ -368,24 +513,35 @@ func (m *Model) UpdateService(service *Service) (*Service, error) {
 			AddAction(origService, UPGRADE_ACTION)
 		}
 
-		m.saveService(origService)
-
-		return m.Services[service.Name], nil
+		result, err := m.saveService(origService)
+		if err == nil && claimed {
+			m.claimDomain(result.Domain, result.Name)
+		}
+		return result, err
 	}
 }
 
 func (m *Model) NeedToBeUpgraded(service *Service) (bool, error) {
-	if _, ok := m.Services[service.Name]; !ok {
+	if _, ok := m.store.getService(service.Name); !ok {
 		return false, errors.New("Service not found")
+	} else if m.serviceDriver == nil {
+		return false, nil
 	} else {
 		return m.serviceDriver.NeedToBeUpgraded(service)
 
 	}
 }
 
-func (m *Model) UpgradeService(service *Service) (*Service, error) {
+func (m *Model) UpgradeService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(UPGRADE_ACTION, service, err)
+		m.recordAction(UPGRADE_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 
-	if s, ok := m.Services[service.Name]; !ok {
+	if s, ok := m.store.getService(service.Name); !ok {
 		return nil, errors.New("Service not found")
 	} else {
 		_, err := m.serviceDriver.Upgrade(s)
@@ -402,11 +558,16 @@ func (m *Model) UpgradeService(service *Service) (*Service, error) {
 			return s, nil
 		}
 	}
-	return nil, errors.New("No service in cluster ! Doh !")
-
 }
 
-func (m *Model) FinishUpgradeService(service *Service) (*Service, error) {
+func (m *Model) FinishUpgradeService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(FINISHUPGRADE_ACTION, service, err)
+		m.recordAction(FINISHUPGRADE_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 
 	if m.serviceDriver != nil {
 		info, err := m.serviceDriver.FinishUpgrade(service)
@@ -419,17 +580,24 @@ func (m *Model) FinishUpgradeService(service *Service) (*Service, error) {
 	service.Status.Expected = STARTED_STATUS
 	service.Status.Current = STARTING_STATUS
 	AddAction(service, UPDATE_ACTION)
-	service, err := m.saveService(service)
+	service, err = m.saveService(service)
 
 	if err != nil {
 		return nil, err
 	} else {
-		m.eventBuffer.events <- NewModelEvent("update", service)
+		m.eventBuffer.events <- NewModelEvent("update", service.Copy())
 		return service, nil
 	}
 }
 
-func (m *Model) RollbackService(service *Service) (*Service, error) {
+func (m *Model) RollbackService(service *Service) (result *Service, err error) {
+	defer func(service *Service, before string) {
+		observeAction(ROLLBACK_ACTION, service, err)
+		m.recordAction(ROLLBACK_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
 
 	if m.serviceDriver != nil {
 		info, err := m.serviceDriver.Rollback(service)
@@ -442,7 +610,7 @@ func (m *Model) RollbackService(service *Service) (*Service, error) {
 	service.Status.Expected = STARTED_STATUS
 	service.Status.Current = STARTING_STATUS
 	AddAction(service, UPDATE_ACTION)
-	service, err := m.saveService(service)
+	service, err = m.saveService(service)
 
 	if err != nil {
 		return nil, err
@@ -452,7 +620,14 @@ func (m *Model) RollbackService(service *Service) (*Service, error) {
 }
 
 // Destroys a service (only works if ServiceDriver is set)
-func (m *Model) DestroyService(service *Service) error {
+func (m *Model) DestroyService(service *Service) (err error) {
+	defer func(service *Service, before string) {
+		observeAction(DELETE_ACTION, service, err)
+		m.recordAction(DELETE_ACTION, service.Name, before, err)
+	}(service, m.ServiceStatus(service.Name))
+	if !m.IsLeader() {
+		return ErrNotLeader
+	}
 	if m.serviceDriver != nil {
 		err := m.serviceDriver.Destroy(service)
 		if err != nil {
@@ -460,29 +635,48 @@ func (m *Model) DestroyService(service *Service) error {
 		}
 	}
 
-	error := m.persistenceDriver.DestroyService(m.Services[service.Name])
+	if stored, ok := m.store.getService(service.Name); ok {
+		service = stored
+	}
+
+	error := m.persistenceDriver.DestroyService(service)
 	if error != nil {
 		return error
 	} else {
+		m.store.deleteService(service.Name)
 		m.eventBuffer.events <- NewModelEvent("delete", service)
 		return nil
 	}
 }
 
+// Persists the service and updates the state store accordingly.
 func (m *Model) saveService(service *Service) (*Service, error) {
-	return m.persistenceDriver.PersistService(service)
+	s, err := m.persistenceDriver.PersistService(service)
+	if err != nil {
+		return nil, err
+	}
+	m.store.putService(s)
+	return s, nil
 }
 
-func (m *Model) updateInfoFromDriver(service *Service, info interface{}) {
-	if rancherInfo, ok := info.(*RancherInfoType); ok {
-		service.Config.RancherInfo = rancherInfo
+func (m *Model) updateInfoFromDriver(service *Service, info *DriverInfo) {
+	if info != nil {
+		service.Config.DriverInfo = mergeDriverInfo(service.Config.DriverInfo, info)
 	}
+	m.eventBuffer.events <- NewModelEvent("update", service.Copy())
 
-	if fleetInfo, ok := info.(*FleetInfoType); ok {
-		service.Config.FleetInfo = fleetInfo
-	}
-	m.eventBuffer.events <- NewModelEvent("update", service)
+}
 
+// Returns the driver info to keep in a service configuration when the driver
+// reports the given info. Drivers may report a state without its payload, in
+// which case the known payload is kept.
+func mergeDriverInfo(current *DriverInfo, info *DriverInfo) *DriverInfo {
+	if info.Payload == nil && current != nil && current.Driver == info.Driver {
+		merged := *info
+		merged.Payload = current.Payload
+		return &merged
+	}
+	return info
 }
 
 func (m *Model) handlePersistenceModelEventOn(eventStream chan *ModelEvent) {
@@ -494,21 +688,18 @@ func (m *Model) handlePersistenceModelEventOn(eventStream chan *ModelEvent) {
 		case "create":
 		case "update":
 			if sc, ok := event.Model.(*Service); ok {
-				m.Services[sc.Name] = sc
-				m.eventBuffer.events <- event
+				m.eventBuffer.events <- NewModelEvent(event.EventType, m.store.putService(sc))
 			} else if domain, ok := event.Model.(*Domain); ok {
-				m.Domains[domain.Name] = domain
-				m.eventBuffer.events <- event
-			} else if info, ok := event.Model.(*RancherInfoType); ok {
-				m.onRancherInfo(info)
+				m.eventBuffer.events <- NewModelEvent(event.EventType, m.store.putDomain(domain))
+			} else if info, ok := event.Model.(*DriverInfo); ok {
+				m.onDriverInfo(info)
 			}
 
 		case "delete":
 			if sc, ok := event.Model.(*Service); ok {
-				delete(m.Services, sc.Name)
-				m.eventBuffer.events <- event
+				m.store.deleteService(sc.Name)
 			} else if domain, ok := event.Model.(*Domain); ok {
-				delete(m.Domains, domain.Name)
+				m.store.deleteDomain(domain.Name)
 			}
 			m.eventBuffer.events <- event
 		}
@@ -517,11 +708,41 @@ func (m *Model) handlePersistenceModelEventOn(eventStream chan *ModelEvent) {
 
 }
 
-func (m *Model) onRancherInfo(info *RancherInfoType) {
-	service := m.Services[info.EnvironmentName]
-	if service != nil {
-		service.Config.RancherInfo = info
+// Persists the changes reported by the service driver. Only the leader does,
+// the others receive them from the persistence driver.
+func (m *Model) onDriverInfo(info *DriverInfo) {
+	if !m.IsLeader() {
+		return
+	}
+	service, ok := m.store.getService(info.ServiceName)
+	if ok {
+		service.Config.DriverInfo = mergeDriverInfo(service.Config.DriverInfo, info)
+		if m.updateStatusFromDriver(service, info) == ErrConflict {
+			// Applies the driver update again on the latest revision
+			if service, ok = m.reloadService(info.ServiceName); ok {
+				service.Config.DriverInfo = mergeDriverInfo(service.Config.DriverInfo, info)
+				m.updateStatusFromDriver(service, info)
+			}
+		}
+	}
+}
 
+// Reloads a service from the persistence driver after a conflict, the model
+// may not have received the latest revision yet.
+func (m *Model) reloadService(name string) (*Service, bool) {
+	service, err := m.persistenceDriver.LoadService(name)
+	if err != nil || service == nil {
+		log.Errorf("Unable to reload service %s : %v", name, err)
+		return nil, false
+	}
+	return m.store.putService(service).Copy(), true
+}
+
+// Updates the location and the status of a service with the information
+// reported by the service driver, and persists it.
+func (m *Model) updateStatusFromDriver(service *Service, info *DriverInfo) error {
+	if service != nil {
+		previousLocation := service.Location
 		if !service.Location.Equals(info.Location) {
 			log.Infof("Service %s changed location from %s to %s", service.Name, service.Location, info.Location)
 			service.Location = info.Location
@@ -555,14 +776,31 @@ func (m *Model) onRancherInfo(info *RancherInfoType) {
 			}
 		}
 
-		s, err := m.persistenceDriver.PersistService(service)
+		s, err := m.saveService(service)
 
 		if err != nil {
-			log.Errorf("Error when persisting rancher update : %s", err.Error())
-			log.Errorf("Rancher update was : %s", info)
+			log.Errorf("Error when persisting driver update : %s", err.Error())
+			log.Errorf("Driver update was : %s", info)
+			return err
 		} else {
-			m.eventBuffer.events <- NewModelEvent("update", s)
+			m.eventBuffer.events <- NewModelEvent("update", s.Copy())
+		}
+
+		if !previousLocation.Equals(service.Location) {
+			m.recordLocationChange(service.Name, previousLocation, service.Location)
+		}
+		if computedSatus != newStatus {
+			m.recordStatusChange(service.Name, computedSatus, newStatus)
+			m.Audit(&AuditEntry{
+				Actor:        DRIVER_COMPONENT,
+				ActorType:    COMPONENT_ACTOR,
+				Action:       "SyncService",
+				ServiceName:  service.Name,
+				StatusBefore: computedSatus,
+				StatusAfter:  newStatus,
+			})
 		}
 
 	}
+	return nil
 }
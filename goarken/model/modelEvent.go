@@ -18,17 +18,37 @@ import (
 	"time"
 )
 
-// That struct hold an event for a Model (Service, ServiceCluster or Domain)
+// That struct hold an event for a Model (Service, ServiceCluster, Domain or Operation)
 type ModelEvent struct {
 	EventType string
 	ModelType string
 	Model     interface{}
 	Time      time.Time
+	// Numbers the published events, in order
+	Sequence uint64
 }
 
 // Creates a new ModelEvent
 func NewModelEvent(eventType string, model interface{}) *ModelEvent {
-	return &ModelEvent{eventType, getModelType(model), model, time.Now()}
+	return &ModelEvent{eventType, getModelType(model), model, time.Now(), 0}
+}
+
+// Returns the name of the service the event relates to : the service, the
+// service of an operation or a warning, or the service a domain points to.
+func (e *ModelEvent) ServiceName() (string, bool) {
+	switch m := e.Model.(type) {
+	case *Service:
+		return m.Name, true
+	case *Operation:
+		return m.ServiceName, true
+	case *PassivationWarning:
+		return m.ServiceName, true
+	case *Domain:
+		if m.Typ == SERVICE_DOMAIN {
+			return m.Value, true
+		}
+	}
+	return "", false
 }
 
 // Return the event ModelType
@@ -37,6 +57,10 @@ func getModelType(model interface{}) string {
 		return "Domain"
 	} else if _, ok := model.(*Service); ok {
 		return "Service"
+	} else if _, ok := model.(*Operation); ok {
+		return "Operation"
+	} else if _, ok := model.(*PassivationWarning); ok {
+		return "PassivationWarning"
 	} else {
 		return "Unknown"
 	}
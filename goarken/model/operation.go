@@ -0,0 +1,225 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OPERATION_PENDING   = "pending"
+	OPERATION_RUNNING   = "running"
+	OPERATION_SUCCEEDED = "succeeded"
+	OPERATION_FAILED    = "failed"
+
+	// Finished operations are forgotten after that delay
+	OPERATION_RETENTION = time.Hour
+)
+
+// Returned when an action is asked on a service that already runs one.
+var ErrOperationInProgress = errors.New("An operation is already in progress on the service")
+
+// An Operation tracks an action run in the background on a service.
+type Operation struct {
+	Id          string `json:"id"`
+	ServiceName string `json:"serviceName"`
+	Action      string `json:"action"`
+	State       string `json:"state"`
+	// Percentage of completion, reported by the action
+	Progress   int        `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// The function run by an operation. It may report its progress, in percent.
+type OperationFunc func(progress func(percent int)) error
+
+func (o *Operation) String() string {
+	return fmt.Sprintf("%s %s on %s : %s", o.Id, o.Action, o.ServiceName, o.State)
+}
+
+// Tells if the operation is pending or running.
+func (o *Operation) InProgress() bool {
+	return o.State == OPERATION_PENDING || o.State == OPERATION_RUNNING
+}
+
+func (o *Operation) Copy() *Operation {
+	result := *o
+	if o.StartedAt != nil {
+		startedAt := *o.StartedAt
+		result.StartedAt = &startedAt
+	}
+	if o.FinishedAt != nil {
+		finishedAt := *o.FinishedAt
+		result.FinishedAt = &finishedAt
+	}
+	return &result
+}
+
+// Holds the operations of the model. As for the stateStore, operations are
+// only handed out as copies.
+type operationStore struct {
+	mutex      sync.RWMutex
+	operations map[string]*Operation
+}
+
+func newOperationStore() *operationStore {
+	return &operationStore{operations: make(map[string]*Operation)}
+}
+
+// Creates a pending operation, unless the service already has one in progress.
+// Finished operations past their retention are removed at the same time.
+func (st *operationStore) create(serviceName string, action string) (*Operation, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	now := time.Now()
+	for id, operation := range st.operations {
+		if operation.ServiceName == serviceName && operation.InProgress() {
+			return nil, ErrOperationInProgress
+		}
+		if operation.FinishedAt != nil && now.Sub(*operation.FinishedAt) > OPERATION_RETENTION {
+			delete(st.operations, id)
+		}
+	}
+
+	operation := &Operation{
+		Id:          newOperationId(),
+		ServiceName: serviceName,
+		Action:      action,
+		State:       OPERATION_PENDING,
+		CreatedAt:   now,
+	}
+	st.operations[operation.Id] = operation
+	return operation.Copy(), nil
+}
+
+// Applies the given modification to the stored operation and returns a copy
+// of the result.
+func (st *operationStore) update(id string, modify func(o *Operation)) *Operation {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	operation := st.operations[id]
+	modify(operation)
+	return operation.Copy()
+}
+
+func (st *operationStore) get(id string) (*Operation, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	operation, ok := st.operations[id]
+	if !ok {
+		return nil, false
+	}
+	return operation.Copy(), true
+}
+
+// Returns copies of all the operations, by creation time.
+func (st *operationStore) all() []*Operation {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	result := make([]*Operation, 0, len(st.operations))
+	for _, operation := range st.operations {
+		result = append(result, operation.Copy())
+	}
+	sort.Sort(operationsByTime(result))
+	return result
+}
+
+type operationsByTime []*Operation
+
+func (o operationsByTime) Len() int           { return len(o) }
+func (o operationsByTime) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o operationsByTime) Less(i, j int) bool { return o[i].CreatedAt.Before(o[j].CreatedAt) }
+
+func newOperationId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// Runs an action on a service in the background. The returned operation is
+// pending, its changes are published as ModelEvents on the Operation. Only
+// one operation at a time may run on a service, ErrOperationInProgress is
+// returned otherwise.
+func (m *Model) StartOperation(serviceName string, action string, run OperationFunc) (*Operation, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	operation, err := m.operations.create(serviceName, action)
+	if err != nil {
+		return nil, err
+	}
+	m.eventBuffer.events <- NewModelEvent("create", operation.Copy())
+
+	go m.runOperation(operation.Id, run)
+	return operation, nil
+}
+
+func (m *Model) runOperation(id string, run OperationFunc) {
+	m.updateOperation(id, func(o *Operation) {
+		now := time.Now()
+		o.State = OPERATION_RUNNING
+		o.StartedAt = &now
+	})
+
+	err := run(func(percent int) {
+		m.updateOperation(id, func(o *Operation) {
+			o.Progress = percent
+		})
+	})
+
+	m.updateOperation(id, func(o *Operation) {
+		now := time.Now()
+		o.FinishedAt = &now
+		if err != nil {
+			o.State = OPERATION_FAILED
+			o.Error = err.Error()
+		} else {
+			o.State = OPERATION_SUCCEEDED
+			o.Progress = 100
+		}
+	})
+	if err != nil {
+		log.Errorf("Operation %s has failed : %s", id, err.Error())
+	}
+}
+
+func (m *Model) updateOperation(id string, modify func(o *Operation)) {
+	m.eventBuffer.events <- NewModelEvent("update", m.operations.update(id, modify))
+}
+
+// Returns a copy of the operation with the given id.
+func (m *Model) GetOperation(id string) (*Operation, bool) {
+	return m.operations.get(id)
+}
+
+// Returns a snapshot of the operations, by creation time.
+func (m *Model) Operations() []*Operation {
+	return m.operations.all()
+}
@@ -0,0 +1,133 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func waitForOperation(m *Model, id string, state string) *Operation {
+	timeout := time.After(5 * time.Second)
+	for {
+		if operation, ok := m.GetOperation(id); ok && operation.State == state {
+			return operation
+		}
+		select {
+		case <-timeout:
+			return nil
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func Test_Operations(t *testing.T) {
+
+	Convey("Given a model", t, func() {
+		m := &Model{
+			store:          newStateStore(),
+			operations:     newOperationStore(),
+			eventBroadcast: NewBroadcaster(),
+		}
+		m.eventBuffer = newEventBuffer(m.eventBroadcast)
+		go m.eventBuffer.run(10 * time.Millisecond)
+
+		Convey("When an operation is started while listening to the model", func() {
+			events := m.Listen()
+			operation, _ := m.StartOperation("testService", START_ACTION, func(progress func(int)) error {
+				return nil
+			})
+
+			Convey("Then its changes are published", func() {
+				event := <-events
+				So(event.ModelType, ShouldEqual, "Operation")
+				So(event.Model.(*Operation).Id, ShouldEqual, operation.Id)
+			})
+		})
+
+		Convey("When an operation succeeds", func() {
+			operation, err := m.StartOperation("testService", START_ACTION, func(progress func(int)) error {
+				progress(50)
+				return nil
+			})
+			So(err, ShouldBeNil)
+
+			Convey("Then it is pending when returned", func() {
+				So(operation.State, ShouldEqual, OPERATION_PENDING)
+				So(operation.Id, ShouldNotBeEmpty)
+			})
+
+			Convey("Then it ends succeeded", func() {
+				done := waitForOperation(m, operation.Id, OPERATION_SUCCEEDED)
+				So(done, ShouldNotBeNil)
+				So(done.Progress, ShouldEqual, 100)
+				So(done.StartedAt, ShouldNotBeNil)
+				So(done.FinishedAt, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When an operation fails", func() {
+			operation, _ := m.StartOperation("testService", STOP_ACTION, func(progress func(int)) error {
+				return errors.New("Driver error")
+			})
+
+			Convey("Then it ends failed with the error", func() {
+				done := waitForOperation(m, operation.Id, OPERATION_FAILED)
+				So(done, ShouldNotBeNil)
+				So(done.Error, ShouldEqual, "Driver error")
+			})
+		})
+
+		Convey("When an operation is in progress on a service", func() {
+			release := make(chan bool)
+			operation, _ := m.StartOperation("testService", UPGRADE_ACTION, func(progress func(int)) error {
+				<-release
+				return nil
+			})
+
+			Convey("Then no other operation can start on it", func() {
+				_, err := m.StartOperation("testService", STOP_ACTION, func(progress func(int)) error { return nil })
+				So(err, ShouldEqual, ErrOperationInProgress)
+			})
+
+			Convey("Then operations can start on other services", func() {
+				_, err := m.StartOperation("otherService", STOP_ACTION, func(progress func(int)) error { return nil })
+				So(err, ShouldBeNil)
+				So(len(m.Operations()), ShouldEqual, 2)
+			})
+
+			Reset(func() {
+				close(release)
+				waitForOperation(m, operation.Id, OPERATION_SUCCEEDED)
+			})
+		})
+
+		Convey("When a finished operation is past its retention", func() {
+			operation, _ := m.StartOperation("testService", START_ACTION, func(progress func(int)) error { return nil })
+			waitForOperation(m, operation.Id, OPERATION_SUCCEEDED)
+			m.operations.update(operation.Id, func(o *Operation) {
+				finishedAt := time.Now().Add(-2 * OPERATION_RETENTION)
+				o.FinishedAt = &finishedAt
+			})
+
+			Convey("Then it is removed when another one is created", func() {
+				m.StartOperation("otherService", START_ACTION, func(progress func(int)) error { return nil })
+				_, ok := m.GetOperation(operation.Id)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
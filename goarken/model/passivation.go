@@ -4,6 +4,8 @@ type PassivationConfig struct {
 	DelayInSeconds int    `json:"delayInSeconds,omitempty"`
 	Enabled        bool   `json:"enabled,omitempty"`
 	Action         string `json:"action,omitempty"`
+	// Windows during which the service is up, whatever its activity
+	Schedules []*PassivationSchedule `json:"schedules,omitempty"`
 }
 
 func DefaultPassivation() *PassivationConfig {
@@ -0,0 +1,173 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A window of a PassivationSchedule opens at each occurrence of Start and
+// closes at the next occurrence of Stop, both evaluated in TimeZone.
+type PassivationSchedule struct {
+	// Cron expression of the opening of the window, e.g. 0 8 * * 1-5
+	Start string `json:"start"`
+	// Cron expression of the closing of the window, e.g. 0 19 * * 1-5
+	Stop string `json:"stop"`
+	// Name of the time zone of the expressions, e.g. Europe/Paris, UTC by default
+	TimeZone string `json:"timeZone,omitempty"`
+	// The service is started that long before the window opens
+	WarmupInMinutes int `json:"warmupInMinutes,omitempty"`
+}
+
+// Returned when the passivation configuration of a service is not valid.
+type InvalidPassivationError struct {
+	Service string
+	Reason  string
+}
+
+func (e *InvalidPassivationError) Error() string {
+	return fmt.Sprintf("Invalid passivation of service %s : %s", e.Service, e.Reason)
+}
+
+// A scheduled change of the state of a service : it is started before a
+// window opens, and the passivation action is applied when it closes.
+type ScheduledTransition struct {
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+}
+
+type parsedSchedule struct {
+	start, stop *CronExpression
+	location    *time.Location
+	warmup      time.Duration
+}
+
+func (s *PassivationSchedule) parse() (*parsedSchedule, error) {
+	start, err := ParseCron(s.Start)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := ParseCron(s.Stop)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	if s.WarmupInMinutes < 0 {
+		return nil, errors.New("The warmup can't be negative")
+	}
+	return &parsedSchedule{start, stop, location, time.Duration(s.WarmupInMinutes) * time.Minute}, nil
+}
+
+// Tells if the window is open at t : its last opening is more recent than
+// its last closing.
+func (s *parsedSchedule) isOpen(t time.Time) bool {
+	t = t.In(s.location)
+	lastStart := s.start.Prev(t)
+	return !lastStart.IsZero() && lastStart.After(s.stop.Prev(t))
+}
+
+// Checks the schedules of the passivation.
+func (c *PassivationConfig) Validate(serviceName string) error {
+	if c == nil {
+		return nil
+	}
+	for _, schedule := range c.Schedules {
+		if _, err := schedule.parse(); err != nil {
+			return &InvalidPassivationError{serviceName, err.Error()}
+		}
+	}
+	return nil
+}
+
+// The action applied when a window closes
+func (c *PassivationConfig) closingAction() string {
+	if c.Action == "" {
+		return PASSIVATE_ACTION
+	}
+	return c.Action
+}
+
+func (c *PassivationConfig) parsedSchedules() []*parsedSchedule {
+	result := make([]*parsedSchedule, 0, len(c.Schedules))
+	for _, schedule := range c.Schedules {
+		if parsed, err := schedule.parse(); err == nil {
+			result = append(result, parsed)
+		}
+	}
+	return result
+}
+
+// Tells if a window of the schedules is open at t.
+func (c *PassivationConfig) InWindow(t time.Time) bool {
+	if c == nil {
+		return false
+	}
+	for _, schedule := range c.parsedSchedules() {
+		if schedule.isOpen(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the transitions scheduled after from and until to, by time.
+func (c *PassivationConfig) Transitions(from time.Time, to time.Time) []*ScheduledTransition {
+	result := []*ScheduledTransition{}
+	if c == nil {
+		return result
+	}
+
+	for _, schedule := range c.parsedSchedules() {
+		for at := schedule.start.Next(from.Add(schedule.warmup).In(schedule.location)); !at.IsZero() && !at.After(to.Add(schedule.warmup)); at = schedule.start.Next(at) {
+			result = append(result, &ScheduledTransition{START_ACTION, at.Add(-schedule.warmup)})
+		}
+		for at := schedule.stop.Next(from.In(schedule.location)); !at.IsZero() && !at.After(to); at = schedule.stop.Next(at) {
+			result = append(result, &ScheduledTransition{c.closingAction(), at})
+		}
+	}
+	sort.Sort(transitionsByTime(result))
+	return result
+}
+
+// Returns the next transition scheduled after t, or nil when there is none.
+func (c *PassivationConfig) NextTransition(t time.Time) *ScheduledTransition {
+	if c == nil {
+		return nil
+	}
+
+	var next *ScheduledTransition
+	for _, schedule := range c.parsedSchedules() {
+		if at := schedule.start.Next(t.Add(schedule.warmup).In(schedule.location)); !at.IsZero() {
+			if at = at.Add(-schedule.warmup); next == nil || at.Before(next.At) {
+				next = &ScheduledTransition{START_ACTION, at}
+			}
+		}
+		if at := schedule.stop.Next(t.In(schedule.location)); !at.IsZero() && (next == nil || at.Before(next.At)) {
+			next = &ScheduledTransition{c.closingAction(), at}
+		}
+	}
+	return next
+}
+
+type transitionsByTime []*ScheduledTransition
+
+func (t transitionsByTime) Len() int           { return len(t) }
+func (t transitionsByTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t transitionsByTime) Less(i, j int) bool { return t[i].At.Before(t[j].At) }
@@ -0,0 +1,126 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func Test_Cron(t *testing.T) {
+
+	Convey("Given a cron expression on week days", t, func() {
+		cron, err := ParseCron("30 8-18/2 * * 1-5")
+		So(err, ShouldBeNil)
+
+		Convey("Then its next occurrence skips the week end", func() {
+			// Friday
+			friday := time.Date(2016, 5, 6, 18, 30, 0, 0, time.UTC)
+			So(cron.Next(friday), ShouldResemble, time.Date(2016, 5, 9, 8, 30, 0, 0, time.UTC))
+		})
+
+		Convey("Then its next occurrence follows the steps", func() {
+			monday := time.Date(2016, 5, 9, 8, 30, 0, 0, time.UTC)
+			So(cron.Next(monday), ShouldResemble, time.Date(2016, 5, 9, 10, 30, 0, 0, time.UTC))
+		})
+
+		Convey("Then its previous occurrence includes the given time", func() {
+			monday := time.Date(2016, 5, 9, 10, 30, 0, 0, time.UTC)
+			So(cron.Prev(monday), ShouldResemble, monday)
+			So(cron.Prev(monday.Add(-time.Second)), ShouldResemble, time.Date(2016, 5, 9, 8, 30, 0, 0, time.UTC))
+			So(cron.Prev(time.Date(2016, 5, 9, 8, 0, 0, 0, time.UTC)), ShouldResemble, time.Date(2016, 5, 6, 18, 30, 0, 0, time.UTC))
+		})
+	})
+
+	Convey("Given a cron expression on a day of month and a day of week", t, func() {
+		cron, err := ParseCron("0 0 13 * 5")
+		So(err, ShouldBeNil)
+
+		Convey("Then it matches any of them", func() {
+			So(cron.Next(time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)), ShouldResemble, time.Date(2016, 5, 6, 0, 0, 0, 0, time.UTC))
+			So(cron.Next(time.Date(2016, 5, 12, 0, 0, 0, 0, time.UTC)), ShouldResemble, time.Date(2016, 5, 13, 0, 0, 0, 0, time.UTC))
+		})
+	})
+
+	Convey("Given invalid cron expressions", t, func() {
+		expressions := []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"}
+
+		Convey("Then they can't be parsed", func() {
+			for _, expression := range expressions {
+				_, err := ParseCron(expression)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_PassivationSchedules(t *testing.T) {
+
+	Convey("Given a passivation scheduled on office hours in Paris", t, func() {
+		paris, _ := time.LoadLocation("Europe/Paris")
+		config := DefaultPassivation()
+		config.Schedules = []*PassivationSchedule{
+			{Start: "0 8 * * 1-5", Stop: "0 19 * * 1-5", TimeZone: "Europe/Paris", WarmupInMinutes: 15},
+		}
+		So(config.Validate("testService"), ShouldBeNil)
+
+		Convey("Then the window is open during office hours", func() {
+			So(config.InWindow(time.Date(2016, 5, 9, 10, 0, 0, 0, paris)), ShouldBeTrue)
+			So(config.InWindow(time.Date(2016, 5, 9, 7, 59, 0, 0, paris)), ShouldBeFalse)
+			So(config.InWindow(time.Date(2016, 5, 9, 20, 0, 0, 0, paris)), ShouldBeFalse)
+			So(config.InWindow(time.Date(2016, 5, 7, 10, 0, 0, 0, paris)), ShouldBeFalse)
+		})
+
+		Convey("Then the service is started before the window opens", func() {
+			next := config.NextTransition(time.Date(2016, 5, 9, 5, 0, 0, 0, time.UTC))
+			So(next.Action, ShouldEqual, START_ACTION)
+			So(next.At.Equal(time.Date(2016, 5, 9, 7, 45, 0, 0, paris)), ShouldBeTrue)
+		})
+
+		Convey("Then the service is passivated when the window closes", func() {
+			next := config.NextTransition(time.Date(2016, 5, 9, 10, 0, 0, 0, paris))
+			So(next.Action, ShouldEqual, PASSIVATE_ACTION)
+			So(next.At.Equal(time.Date(2016, 5, 9, 19, 0, 0, 0, paris)), ShouldBeTrue)
+		})
+
+		Convey("Then the transitions of a period are listed by time", func() {
+			transitions := config.Transitions(time.Date(2016, 5, 6, 12, 0, 0, 0, paris), time.Date(2016, 5, 9, 12, 0, 0, 0, paris))
+			So(len(transitions), ShouldEqual, 2)
+			So(transitions[0].Action, ShouldEqual, PASSIVATE_ACTION)
+			So(transitions[0].At.Equal(time.Date(2016, 5, 6, 19, 0, 0, 0, paris)), ShouldBeTrue)
+			So(transitions[1].Action, ShouldEqual, START_ACTION)
+			So(transitions[1].At.Equal(time.Date(2016, 5, 9, 7, 45, 0, 0, paris)), ShouldBeTrue)
+		})
+
+		Convey("Then the schedules are copied with the service", func() {
+			service := &Service{Name: "testService"}
+			service.Init()
+			service.Config.Passivation = config
+			copied := service.Copy()
+			copied.Config.Passivation.Schedules[0].Start = "0 9 * * 1-5"
+			So(config.Schedules[0].Start, ShouldEqual, "0 8 * * 1-5")
+		})
+	})
+
+	Convey("Given invalid schedules", t, func() {
+		config := DefaultPassivation()
+		config.Schedules = []*PassivationSchedule{{Start: "0 8 * * 1-5", Stop: "0 19 * * 1-5", TimeZone: "Nowhere/City"}}
+
+		Convey("Then the passivation is not valid", func() {
+			err := config.Validate("testService")
+			So(err, ShouldHaveSameTypeAs, &InvalidPassivationError{})
+		})
+	})
+}
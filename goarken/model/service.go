@@ -14,6 +14,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"time"
@@ -34,6 +35,14 @@ func (s *Location) Equals(other *Location) bool {
 		s.Port == other.Port
 }
 
+func (s *Location) Copy() *Location {
+	if s == nil {
+		return nil
+	}
+	location := *s
+	return &location
+}
+
 func (s *Location) IsFullyDefined() bool {
 	if s == nil {
 		return false
@@ -50,28 +59,53 @@ func (s Location) String() string {
 type ServiceConfig struct {
 	Robots      string                 `json:"robots"`
 	Environment map[string]interface{} `json:"environment,omitempty"`
-	// Rancher backed service information
-	RancherInfo *RancherInfoType `json:"rancherInfo,omitempty"`
-	// Fleet backed service information
-	FleetInfo   *FleetInfoType     `json:"fleetInfo,omitempty"`
+	// Service driver backed information
+	DriverInfo  *DriverInfo        `json:"driverInfo,omitempty"`
 	Passivation *PassivationConfig `json:"passivation,omitempty`
 }
 
-type RancherInfoType struct {
-	EnvironmentId   string    `json:"environmentId,omitempty"`
-	EnvironmentName string    `json:"environmentName,omitempty"`
-	Location        *Location `json:"location,omitempty"`
-	HealthState     string    `json:"healthState,omitempty"`
-	CurrentStatus   string    `json:"currentStatus,omitempty"`
-	TemplateId      string    `json:"templateId,omitempty"`
-}
+func (config *ServiceConfig) UnmarshalJSON(data []byte) error {
+	type serviceConfig ServiceConfig
+	if err := json.Unmarshal(data, (*serviceConfig)(config)); err != nil {
+		return err
+	}
 
-func (r RancherInfoType) String() string {
-	return fmt.Sprintf("RancherInfo for %s : envId: %s, location: %s, currentStatus: %s, rancherHealth: %s", r.EnvironmentName, r.EnvironmentId, r.Location, r.CurrentStatus, r.HealthState)
+	if config.DriverInfo == nil {
+		info, err := driverInfoFromLegacyKeys(data)
+		if err != nil {
+			return err
+		}
+		config.DriverInfo = info
+	}
+	return nil
 }
 
-type FleetInfoType struct {
-	UnitName string
+// Returns a deep copy of the configuration.
+func (config *ServiceConfig) Copy() *ServiceConfig {
+	if config == nil {
+		return nil
+	}
+
+	result := *config
+	if config.Environment != nil {
+		result.Environment = make(map[string]interface{}, len(config.Environment))
+		for k, v := range config.Environment {
+			result.Environment[k] = v
+		}
+	}
+	result.DriverInfo = config.DriverInfo.Copy()
+	if config.Passivation != nil {
+		passivation := *config.Passivation
+		if config.Passivation.Schedules != nil {
+			passivation.Schedules = make([]*PassivationSchedule, len(config.Passivation.Schedules))
+			for i, schedule := range config.Passivation.Schedules {
+				copied := *schedule
+				passivation.Schedules[i] = &copied
+			}
+		}
+		result.Passivation = &passivation
+	}
+	return &result
 }
 
 func (config *ServiceConfig) Equals(other *ServiceConfig) bool {
@@ -85,7 +119,11 @@ func (config *ServiceConfig) Equals(other *ServiceConfig) bool {
 
 // Holds information about a given service
 type Service struct {
-	NodeKey    string         `json:"-"`
+	NodeKey string `json:"-"`
+	// Revision of the service in the persistence driver, 0 when unknown. A
+	// service persisted with a revision is only written if it is still the
+	// stored one.
+	Revision   int64          `json:"revision,omitempty"`
 	Location   *Location      `json:"location"`
 	Domain     string         `json:"domain"`
 	Name       string         `json:"name"`
@@ -93,6 +131,12 @@ type Service struct {
 	Actions    interface{}  `json:"actions"` 
 	LastAccess *time.Time     `json:"lastAccess"`
 	Config     *ServiceConfig `json:"config"`
+	// Why the service has been passivated, empty once it is started again
+	PassivationReason string `json:"passivationReason,omitempty"`
+	// The service is not passivated until then
+	SnoozedUntil *time.Time `json:"snoozedUntil,omitempty"`
+	// Next scheduled transition of the service, computed by the API
+	NextTransition *ScheduledTransition `json:"nextTransition,omitempty"`
 	log        *logrus.Logger
 }
 
@@ -111,6 +155,40 @@ func (s *Service) Init() *Service {
 
 }
 
+// Returns a deep copy of the service. The model only hands out copies of
+// its services so that they can be modified without any locking.
+func (s *Service) Copy() *Service {
+	if s == nil {
+		return nil
+	}
+
+	result := *s
+	result.Location = s.Location.Copy()
+	result.Config = s.Config.Copy()
+
+	if s.Status != nil {
+		status := *s.Status
+		status.Service = &result
+		result.Status = &status
+	}
+
+	if actions, ok := s.Actions.([]string); ok {
+		result.Actions = append(make([]string, 0, len(actions)), actions...)
+	}
+
+	if s.LastAccess != nil {
+		lastAccess := *s.LastAccess
+		result.LastAccess = &lastAccess
+	}
+
+	if s.SnoozedUntil != nil {
+		snoozedUntil := *s.SnoozedUntil
+		result.SnoozedUntil = &snoozedUntil
+	}
+
+	return &result
+}
+
 func (service *Service) Equals(other *Service) bool {
 	if service == nil && other == nil {
 		return true
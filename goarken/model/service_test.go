@@ -0,0 +1,85 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// Stores the services without comparing their revisions, the other methods
+// are not implemented
+type unversionedDriver struct {
+	PersistenceDriver
+	persisted int
+}
+
+func (d *unversionedDriver) PersistService(service *Service) (*Service, error) {
+	d.persisted++
+	return service, nil
+}
+
+func Test_UpdateServiceRevision(t *testing.T) {
+
+	Convey("Given a model with a service at revision 5", t, func() {
+		driver := &unversionedDriver{}
+		m := &Model{store: newStateStore(), persistenceDriver: driver}
+		service := &Service{Name: "app1", Revision: 5}
+		service.Init()
+		m.store.putService(service)
+
+		update := func(revision int64) error {
+			_, err := m.UpdateService(&Service{Name: "app1", Revision: revision, Config: &ServiceConfig{Environment: map[string]interface{}{"KEY": "value"}}})
+			return err
+		}
+
+		Convey("When it is updated from its revision", func() {
+			err := update(5)
+
+			Convey("Then the service is updated", func() {
+				So(err, ShouldBeNil)
+				So(driver.persisted, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When it is updated without revision", func() {
+			err := update(0)
+
+			Convey("Then the service is updated", func() {
+				So(err, ShouldBeNil)
+				So(driver.persisted, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When it is updated from an older revision", func() {
+			err := update(4)
+
+			Convey("Then a conflict is returned", func() {
+				So(err, ShouldEqual, ErrConflict)
+				So(driver.persisted, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When it is updated from a newer revision", func() {
+			err := update(6)
+
+			Convey("Then a conflict is returned", func() {
+				So(err, ShouldEqual, ErrConflict)
+				So(driver.persisted, ShouldEqual, 0)
+				stored, _ := m.GetService("app1")
+				So(stored.Config.Environment, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"sync"
+)
+
+// Concurrency safe store of the Services and Domains of the model.
+//
+// Values are copied when they enter and when they leave the store, so
+// a pointer held by a caller is never shared with the store. The values
+// returned by the put methods are the stored instances : they are meant
+// to be published in ModelEvents and MUST NOT be modified.
+type stateStore struct {
+	mutex    sync.RWMutex
+	services map[string]*Service
+	domains  map[string]*Domain
+}
+
+func newStateStore() *stateStore {
+	return &stateStore{
+		services: make(map[string]*Service),
+		domains:  make(map[string]*Domain),
+	}
+}
+
+// Returns a copy of the service with the given name.
+func (st *stateStore) getService(name string) (*Service, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	service, ok := st.services[name]
+	if !ok {
+		return nil, false
+	}
+	return service.Copy(), true
+}
+
+// Stores a copy of the given service and returns the stored instance. A
+// service with an older revision than the stored one, as in a late event,
+// is ignored.
+func (st *stateStore) putService(service *Service) *Service {
+	stored := service.Copy()
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if current, ok := st.services[stored.Name]; ok && isOlderRevision(stored.Revision, current.Revision) {
+		return current
+	}
+	st.services[stored.Name] = stored
+	return stored
+}
+
+func (st *stateStore) deleteService(name string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	delete(st.services, name)
+}
+
+// Replaces all the services of the store.
+func (st *stateStore) resetServices(services map[string]*Service) {
+	copies := make(map[string]*Service, len(services))
+	for name, service := range services {
+		copies[name] = service.Copy()
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.services = copies
+}
+
+// Returns a snapshot of all the services, indexed by name.
+func (st *stateStore) allServices() map[string]*Service {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	result := make(map[string]*Service, len(st.services))
+	for name, service := range st.services {
+		result[name] = service.Copy()
+	}
+	return result
+}
+
+// Returns a copy of the domain with the given name.
+func (st *stateStore) getDomain(name string) (*Domain, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	domain, ok := st.domains[name]
+	if !ok {
+		return nil, false
+	}
+	return domain.Copy(), true
+}
+
+// Stores a copy of the given domain and returns the stored instance. As for
+// services, a domain with an older revision is ignored.
+func (st *stateStore) putDomain(domain *Domain) *Domain {
+	stored := domain.Copy()
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if current, ok := st.domains[stored.Name]; ok && isOlderRevision(stored.Revision, current.Revision) {
+		return current
+	}
+	st.domains[stored.Name] = stored
+	return stored
+}
+
+func (st *stateStore) deleteDomain(name string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	delete(st.domains, name)
+}
+
+// Replaces all the domains of the store.
+func (st *stateStore) resetDomains(domains map[string]*Domain) {
+	copies := make(map[string]*Domain, len(domains))
+	for name, domain := range domains {
+		copies[name] = domain.Copy()
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.domains = copies
+}
+
+// Returns a snapshot of all the domains, indexed by name.
+func (st *stateStore) allDomains() map[string]*Domain {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	result := make(map[string]*Domain, len(st.domains))
+	for name, domain := range st.domains {
+		result[name] = domain.Copy()
+	}
+	return result
+}
+
+// Unknown revisions (0) are never older.
+func isOlderRevision(revision int64, than int64) bool {
+	return revision != 0 && revision < than
+}
@@ -0,0 +1,120 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+func Test_stateStore(t *testing.T) {
+
+	Convey("Given a store with one service", t, func() {
+		store := newStateStore()
+
+		service := &Service{Name: "testService"}
+		service.Init()
+		service.Location = &Location{Host: "127.0.0.1", Port: 8080}
+		service.Config.Environment = map[string]interface{}{"key": "value"}
+		store.putService(service)
+
+		Convey("When the service is modified after being put", func() {
+			service.Status.Current = STARTED_STATUS
+			service.Location.Port = 9090
+
+			Convey("Then the stored service is not modified", func() {
+				stored, ok := store.getService("testService")
+				So(ok, ShouldBeTrue)
+				So(stored.Status.Current, ShouldEqual, STOPPED_STATUS)
+				So(stored.Location.Port, ShouldEqual, 8080)
+			})
+		})
+
+		Convey("When a returned service is modified", func() {
+			stored, _ := store.getService("testService")
+			stored.Status.Expected = STARTED_STATUS
+			stored.Config.Environment["key"] = "otherValue"
+			stored.Config.Passivation.Enabled = false
+			AddAction(stored, STOP_ACTION)
+
+			Convey("Then the stored service is not modified", func() {
+				again, _ := store.getService("testService")
+				So(again.Status.Expected, ShouldEqual, STOPPED_STATUS)
+				So(again.Config.Environment["key"], ShouldEqual, "value")
+				So(again.Config.Passivation.Enabled, ShouldBeTrue)
+				So(again.Actions, ShouldResemble, []string{START_ACTION, DELETE_ACTION, UPDATE_ACTION})
+			})
+
+			Convey("Then the status of the copy points to the copy", func() {
+				So(stored.Status.Service, ShouldEqual, stored)
+			})
+		})
+
+		Convey("When an older revision of the service is put", func() {
+			service.Revision = 5
+			store.putService(service)
+
+			older := service.Copy()
+			older.Revision = 4
+			older.Status.Current = STARTED_STATUS
+			stored := store.putService(older)
+
+			Convey("Then it is ignored", func() {
+				So(stored.Revision, ShouldEqual, 5)
+				again, _ := store.getService("testService")
+				So(again.Status.Current, ShouldEqual, STOPPED_STATUS)
+			})
+		})
+
+		Convey("When the service is deleted", func() {
+			store.deleteService("testService")
+
+			Convey("Then it is not found anymore", func() {
+				_, ok := store.getService("testService")
+				So(ok, ShouldBeFalse)
+				So(len(store.allServices()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When services are read and written concurrently", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					s := &Service{Name: fmt.Sprintf("service%d", i)}
+					s.Init()
+					store.putService(s)
+					store.putDomain(&Domain{Name: fmt.Sprintf("domain%d", i), Typ: "service", Value: s.Name})
+				}(i)
+				go func() {
+					defer wg.Done()
+					for _, s := range store.allServices() {
+						s.Status.Compute()
+					}
+					store.allDomains()
+				}()
+			}
+			wg.Wait()
+
+			Convey("Then all services and domains are stored", func() {
+				So(len(store.allServices()), ShouldEqual, 21)
+				So(len(store.allDomains()), ShouldEqual, 20)
+			})
+		})
+	})
+
+}
@@ -0,0 +1,55 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidSnooze = errors.New("The passivation can only be snoozed for a positive duration")
+
+// A PassivationWarning announces that a service is about to be passivated,
+// stopped or destroyed. It is published as a "warning" event of the model.
+type PassivationWarning struct {
+	ServiceName string    `json:"serviceName"`
+	Action      string    `json:"action"`
+	At          time.Time `json:"at"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Publishes a warning to the listeners of the model.
+func (m *Model) WarnPassivation(warning *PassivationWarning) {
+	m.eventBuffer.events <- NewModelEvent("warning", warning)
+}
+
+// Delays the passivation of a service for the given duration, whatever its
+// activity and its scheduled windows.
+func (m *Model) SnoozePassivation(service *Service, duration time.Duration) (*Service, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	if duration <= 0 {
+		return nil, ErrInvalidSnooze
+	}
+
+	until := time.Now().Add(duration)
+	service.SnoozedUntil = &until
+	service, err := m.saveService(service)
+	if err != nil {
+		return nil, err
+	}
+	m.eventBuffer.events <- NewModelEvent("update", service.Copy())
+	return service, nil
+}
@@ -0,0 +1,160 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrWebhooksNotSupported = errors.New("The persistence driver can't store webhooks")
+
+// A WebhookSubscription delivers the events of the model that match its
+// filters to its URL. Empty filters match any event.
+type WebhookSubscription struct {
+	Id  string `json:"id"`
+	URL string `json:"url"`
+	// Types of the events
+	EventTypes []string `json:"eventTypes,omitempty"`
+	// Types of the objects of the events
+	ModelTypes []string `json:"modelTypes,omitempty"`
+	// Patterns of the names of the services, as in path.Match
+	Services []string `json:"services,omitempty"`
+	// Signs the deliveries with HMAC-SHA256 when set, never returned by the API
+	Secret string `json:"secret,omitempty"`
+}
+
+// A WebhookDelivery tracks the posting of an event to a subscription.
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	EventType      string    `json:"eventType"`
+	ModelType      string    `json:"modelType"`
+	State          string    `json:"state"`
+	Attempts       int       `json:"attempts"`
+	StatusCode     int       `json:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	// True while the delivery is in the dead letters of its subscription
+	DeadLetter bool `json:"deadLetter,omitempty"`
+	// The body posted, kept to deliver it again
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Implemented by the persistence drivers that can store the webhook
+// subscriptions and their deliveries.
+type WebhookPersistenceDriver interface {
+	PersistenceDriver
+	LoadAllWebhookSubscriptions() (map[string]*WebhookSubscription, error)
+	PersistWebhookSubscription(*WebhookSubscription) (*WebhookSubscription, error)
+	// Destroys the deliveries of the subscription along with it
+	DestroyWebhookSubscription(*WebhookSubscription) error
+	// Returns the deliveries of all the subscriptions, in any order
+	LoadAllWebhookDeliveries() ([]*WebhookDelivery, error)
+	PersistWebhookDelivery(*WebhookDelivery) (*WebhookDelivery, error)
+	DestroyWebhookDelivery(*WebhookDelivery) error
+}
+
+func (m *Model) webhookDriver() (WebhookPersistenceDriver, error) {
+	driver, ok := m.persistenceDriver.(WebhookPersistenceDriver)
+	if !ok {
+		return nil, ErrWebhooksNotSupported
+	}
+	return driver, nil
+}
+
+// Returns the stored webhook subscriptions, by id.
+func (m *Model) WebhookSubscriptions() (map[string]*WebhookSubscription, error) {
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return nil, err
+	}
+	return driver.LoadAllWebhookSubscriptions()
+}
+
+// Stores a webhook subscription, created or updated.
+func (m *Model) SaveWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return nil, err
+	}
+	return driver.PersistWebhookSubscription(subscription)
+}
+
+// Removes a webhook subscription and its deliveries from the store.
+func (m *Model) DestroyWebhookSubscription(subscription *WebhookSubscription) error {
+	if !m.IsLeader() {
+		return ErrNotLeader
+	}
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return err
+	}
+	return driver.DestroyWebhookSubscription(subscription)
+}
+
+// Returns the stored webhook deliveries, the oldest first.
+func (m *Model) WebhookDeliveries() ([]*WebhookDelivery, error) {
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := driver.LoadAllWebhookDeliveries()
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(webhookDeliveriesByCreation(deliveries))
+	return deliveries, nil
+}
+
+// Stores a webhook delivery, created or updated.
+func (m *Model) SaveWebhookDelivery(delivery *WebhookDelivery) (*WebhookDelivery, error) {
+	if !m.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return nil, err
+	}
+	return driver.PersistWebhookDelivery(delivery)
+}
+
+// Removes a webhook delivery from the store.
+func (m *Model) DestroyWebhookDelivery(delivery *WebhookDelivery) error {
+	if !m.IsLeader() {
+		return ErrNotLeader
+	}
+	driver, err := m.webhookDriver()
+	if err != nil {
+		return err
+	}
+	return driver.DestroyWebhookDelivery(delivery)
+}
+
+type webhookDeliveriesByCreation []*WebhookDelivery
+
+func (d webhookDeliveriesByCreation) Len() int      { return len(d) }
+func (d webhookDeliveriesByCreation) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d webhookDeliveriesByCreation) Less(i, j int) bool {
+	if d[i].CreatedAt.Equal(d[j].CreatedAt) {
+		return d[i].Id < d[j].Id
+	}
+	return d[i].CreatedAt.Before(d[j].CreatedAt)
+}
@@ -0,0 +1,647 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/arkenio/arken/goarken/model"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+var (
+	boltServicesBucket = []byte("services")
+	boltDomainsBucket  = []byte("domains")
+	boltAPIKeysBucket  = []byte("apikeys")
+	boltAuditBucket    = []byte("audit")
+	boltHistoryBucket  = []byte("history")
+	boltWebhooksBucket = []byte("webhooks")
+	boltDeliveryBucket = []byte("webhookDeliveries")
+)
+
+// BoltDriver implements the PersistenceDriver interface of the Arken
+// Model in a local bbolt file, for single node installs that don't run
+// etcd. Services, domains, API keys, the audit log and the webhook
+// subscriptions are stored as JSON, one bucket for each. The history of each
+// service has its own bucket in the history one, as the deliveries of each
+// subscription in the webhook deliveries one.
+// Since nobody else writes the file, the events on the Listen channel
+// are the ones of the local writes.
+type BoltDriver struct {
+	db          *bolt.DB
+	broadcaster *Broadcaster
+
+	// Events waiting to be broadcasted. They are written by a single
+	// goroutine, so that writes neither block nor get reordered.
+	mutex   sync.Mutex
+	pending []*ModelEvent
+	notify  chan bool
+}
+
+func NewBoltDriver(path string) (*BoltDriver, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltServicesBucket, boltDomainsBucket, boltAPIKeysBucket, boltAuditBucket, boltHistoryBucket, boltWebhooksBucket, boltDeliveryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b := &BoltDriver{
+		db:          db,
+		broadcaster: NewBroadcaster(),
+		notify:      make(chan bool, 1),
+	}
+	go b.broadcast()
+	return b, nil
+}
+
+// Closes the underlying file.
+func (b *BoltDriver) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltDriver) publish(event *ModelEvent) {
+	b.mutex.Lock()
+	b.pending = append(b.pending, event)
+	b.mutex.Unlock()
+
+	select {
+	case b.notify <- true:
+	default:
+	}
+}
+
+func (b *BoltDriver) broadcast() {
+	for range b.notify {
+		b.mutex.Lock()
+		events := b.pending
+		b.pending = nil
+		b.mutex.Unlock()
+
+		for _, event := range events {
+			b.broadcaster.Write(event)
+		}
+	}
+}
+
+func (b *BoltDriver) Listen() chan *ModelEvent {
+	return FromInterfaceChannel(b.broadcaster.Listen())
+}
+
+func (b *BoltDriver) LoadAllServices() (map[string]*Service, error) {
+	result := make(map[string]*Service)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltServicesBucket).ForEach(func(k, v []byte) error {
+			service, err := decodeBoltService(v)
+			if err != nil {
+				return err
+			}
+			result[service.Name] = service
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) LoadService(serviceName string) (*Service, error) {
+	var service *Service
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltServicesBucket).Get([]byte(serviceName))
+		if data == nil {
+			return errors.New("No service " + serviceName + " found")
+		}
+		var err error
+		service, err = decodeBoltService(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// Persists the service. As with the etcd drivers, a new service (without
+// NodeKey) must not exist yet, an existing one must be at its revision if
+// it has one, and actions are not persisted while empty. Revisions are
+// taken from the sequence of the bucket.
+func (b *BoltDriver) PersistService(s *Service) (*Service, error) {
+	var persisted []byte
+	var revision uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltServicesBucket)
+		old := bucket.Get([]byte(s.Name))
+
+		record := *s
+		record.NodeKey = boltNodeKey(boltServicesBucket, s.Name)
+		if s.NodeKey == "" && old != nil {
+			return errors.New("Service " + s.Name + " already exists")
+		} else if s.NodeKey != "" && old == nil {
+			return errors.New("No service with key " + s.NodeKey + " found")
+		} else if old != nil {
+			oldService, err := decodeBoltService(old)
+			if err != nil {
+				return err
+			}
+			if s.Revision != 0 && s.Revision != oldService.Revision {
+				return ErrConflict
+			}
+			if actions, _ := s.Actions.([]string); len(actions) == 0 {
+				record.Actions = oldService.Actions
+			}
+		}
+
+		var err error
+		if revision, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		record.Revision = int64(revision)
+
+		data, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+		persisted = data
+		return bucket.Put([]byte(s.Name), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.NodeKey = boltNodeKey(boltServicesBucket, s.Name)
+	s.Revision = int64(revision)
+	if service, err := decodeBoltService(persisted); err == nil {
+		b.publish(NewModelEvent("update", service))
+	}
+	return s, nil
+}
+
+func (b *BoltDriver) DestroyService(s *Service) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltServicesBucket).Delete([]byte(s.Name))
+	})
+	if err == nil {
+		b.publish(NewModelEvent("delete", &Service{Name: s.Name}))
+	}
+	return err
+}
+
+func (b *BoltDriver) LoadAllDomains() (map[string]*Domain, error) {
+	result := make(map[string]*Domain)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDomainsBucket).ForEach(func(k, v []byte) error {
+			domain, err := decodeBoltDomain(v)
+			if err != nil {
+				return err
+			}
+			result[domain.Name] = domain
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) LoadDomain(domainName string) (*Domain, error) {
+	var domain *Domain
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltDomainsBucket).Get([]byte(domainName))
+		if data == nil {
+			return errors.New("No domain " + domainName + " found")
+		}
+		var err error
+		domain, err = decodeBoltDomain(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return domain, nil
+}
+
+func (b *BoltDriver) PersistDomain(d *Domain) (*Domain, error) {
+	var revision uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDomainsBucket)
+		old := bucket.Get([]byte(d.Name))
+		if d.NodeKey == "" && old != nil {
+			return errors.New("Domain " + d.Name + " already exists")
+		} else if d.NodeKey != "" && old == nil {
+			return errors.New("No domain with key " + d.NodeKey + " found")
+		} else if old != nil && d.Revision != 0 {
+			oldDomain, err := decodeBoltDomain(old)
+			if err != nil {
+				return err
+			}
+			if d.Revision != oldDomain.Revision {
+				return ErrConflict
+			}
+		}
+
+		var err error
+		if revision, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		record := *d
+		record.Revision = int64(revision)
+
+		data, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(d.Name), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.NodeKey = boltNodeKey(boltDomainsBucket, d.Name)
+	d.Revision = int64(revision)
+	domain := *d
+	b.publish(NewModelEvent("update", &domain))
+	return d, nil
+}
+
+func (b *BoltDriver) DestroyDomain(d *Domain) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltDomainsBucket).Delete([]byte(d.Name))
+	})
+	if err == nil {
+		b.publish(NewModelEvent("delete", &Domain{Name: d.Name}))
+	}
+	return err
+}
+
+func (b *BoltDriver) LoadAllAPIKeys() (map[string]*APIKey, error) {
+	result := make(map[string]*APIKey)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAPIKeysBucket).ForEach(func(k, v []byte) error {
+			key := &APIKey{}
+			if err := json.Unmarshal(v, key); err != nil {
+				return err
+			}
+			result[key.Id] = key
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) LoadAPIKey(id string) (*APIKey, error) {
+	key := &APIKey{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltAPIKeysBucket).Get([]byte(id))
+		if data == nil {
+			return ErrAPIKeyNotFound
+		}
+		return json.Unmarshal(data, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Persists the API key. API keys are not part of the model, no event is
+// published.
+func (b *BoltDriver) PersistAPIKey(key *APIKey) (*APIKey, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAPIKeysBucket).Put([]byte(key.Id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (b *BoltDriver) DestroyAPIKey(key *APIKey) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAPIKeysBucket).Delete([]byte(key.Id))
+	})
+}
+
+// Records the last use on the stored key, read in the same transaction : the
+// changes made to the key since it was loaded are kept.
+func (b *BoltDriver) TouchAPIKey(key *APIKey, at time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAPIKeysBucket)
+		data := bucket.Get([]byte(key.Id))
+		if data == nil {
+			return ErrConflict
+		}
+		stored := &APIKey{}
+		if err := json.Unmarshal(data, stored); err != nil {
+			return err
+		}
+		stored.LastUsedAt = &at
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key.Id), data)
+	})
+}
+
+func (b *BoltDriver) AppendAuditEntry(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltAuditBucket).Put([]byte(entry.Id), data)
+	})
+}
+
+// Loads the entries of the audit log in a range of their ids, which start
+// with the time they were recorded.
+func (b *BoltDriver) LoadAuditEntries(since time.Time, until time.Time) ([]*AuditEntry, error) {
+	min := []byte(TimeIdPrefix(since))
+	max := []byte{0xff}
+	if !until.IsZero() {
+		max = []byte(TimeIdPrefix(until))
+	}
+
+	result := []*AuditEntry{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltAuditBucket).Cursor()
+		for k, v := cursor.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, v = cursor.Next() {
+			entry := &AuditEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			result = append(result, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) PruneAuditEntries(before time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return boltPrune(tx.Bucket(boltAuditBucket), []byte(TimeIdPrefix(before)))
+	})
+}
+
+func (b *BoltDriver) AppendHistoryEvent(serviceName string, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltHistoryBucket).CreateBucketIfNotExists([]byte(serviceName))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(event.Id), data)
+	})
+}
+
+func (b *BoltDriver) LoadServiceHistory(serviceName string, since time.Time, until time.Time) ([]*HistoryEvent, error) {
+	min := []byte(TimeIdPrefix(since))
+	max := []byte{0xff}
+	if !until.IsZero() {
+		max = []byte(TimeIdPrefix(until))
+	}
+
+	result := []*HistoryEvent{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHistoryBucket).Bucket([]byte(serviceName))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, v = cursor.Next() {
+			event := &HistoryEvent{}
+			if err := json.Unmarshal(v, event); err != nil {
+				return err
+			}
+			result = append(result, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Prunes the bucket of each service, and deletes the ones left empty, as the
+// ones of the destroyed services. The buckets are collected first, they
+// can't be modified while iterating over the history bucket.
+func (b *BoltDriver) PruneServiceHistories(before time.Time) error {
+	max := []byte(TimeIdPrefix(before))
+	return b.db.Update(func(tx *bolt.Tx) error {
+		histories := tx.Bucket(boltHistoryBucket)
+		names := [][]byte{}
+		err := histories.ForEach(func(name []byte, _ []byte) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			bucket := histories.Bucket(name)
+			if err := boltPrune(bucket, max); err != nil {
+				return err
+			}
+			if k, _ := bucket.Cursor().First(); k == nil {
+				if err := histories.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltDriver) LoadAllWebhookSubscriptions() (map[string]*WebhookSubscription, error) {
+	result := make(map[string]*WebhookSubscription)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWebhooksBucket).ForEach(func(k, v []byte) error {
+			subscription := &WebhookSubscription{}
+			if err := json.Unmarshal(v, subscription); err != nil {
+				return err
+			}
+			result[subscription.Id] = subscription
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) PersistWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltWebhooksBucket).Put([]byte(subscription.Id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (b *BoltDriver) DestroyWebhookSubscription(subscription *WebhookSubscription) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(boltDeliveryBucket)
+		if deliveries.Bucket([]byte(subscription.Id)) != nil {
+			if err := deliveries.DeleteBucket([]byte(subscription.Id)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltWebhooksBucket).Delete([]byte(subscription.Id))
+	})
+}
+
+func (b *BoltDriver) LoadAllWebhookDeliveries() ([]*WebhookDelivery, error) {
+	result := []*WebhookDelivery{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		deliveries := tx.Bucket(boltDeliveryBucket)
+		return deliveries.ForEach(func(id []byte, _ []byte) error {
+			return deliveries.Bucket(id).ForEach(func(k, v []byte) error {
+				delivery := &WebhookDelivery{}
+				if err := json.Unmarshal(v, delivery); err != nil {
+					return err
+				}
+				result = append(result, delivery)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *BoltDriver) PersistWebhookDelivery(delivery *WebhookDelivery) (*WebhookDelivery, error) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return nil, err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltDeliveryBucket).CreateBucketIfNotExists([]byte(delivery.SubscriptionId))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(delivery.Id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (b *BoltDriver) DestroyWebhookDelivery(delivery *WebhookDelivery) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltDeliveryBucket).Bucket([]byte(delivery.SubscriptionId))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(delivery.Id))
+	})
+}
+
+// Deletes the keys of the bucket lower than max. They are collected first,
+// deleting through a cursor moves it.
+func boltPrune(bucket *bolt.Bucket, max []byte) error {
+	expired := [][]byte{}
+	cursor := bucket.Cursor()
+	for k, _ := cursor.First(); k != nil && bytes.Compare(k, max) < 0; k, _ = cursor.Next() {
+		expired = append(expired, append([]byte{}, k...))
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boltNodeKey(bucket []byte, name string) string {
+	return "/" + string(bucket) + "/" + name
+}
+
+func decodeBoltService(data []byte) (*Service, error) {
+	// Actions are decoded apart : the model expects a []string
+	record := struct {
+		*Service
+		Actions []string `json:"actions"`
+	}{Service: &Service{}}
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	service := record.Service
+	service.NodeKey = boltNodeKey(boltServicesBucket, service.Name)
+	service.Actions = record.Actions
+	if service.Actions == nil {
+		service.Actions = []string{}
+	}
+	if service.Status != nil {
+		service.Status.Service = service
+	}
+	if service.Config == nil {
+		service.Config = &ServiceConfig{Robots: ""}
+	}
+	if service.Location == nil {
+		service.Location = &Location{}
+	}
+	return service, nil
+}
+
+func decodeBoltDomain(data []byte) (*Domain, error) {
+	domain := &Domain{}
+	if err := json.Unmarshal(data, domain); err != nil {
+		return nil, err
+	}
+	domain.NodeKey = boltNodeKey(boltDomainsBucket, domain.Name)
+	return domain, nil
+}
@@ -0,0 +1,372 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"fmt"
+	. "github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_BoltDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arken-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "arken.db")
+
+	Convey("Given a bolt driver", t, func() {
+		b, err := NewBoltDriver(path)
+		So(err, ShouldBeNil)
+		events := b.Listen()
+
+		Convey("When a service is persisted", func() {
+			service := &Service{Name: "testService"}
+			service.Init()
+			service.Domain = "test.domain.com"
+			service.Config.DriverInfo = &DriverInfo{Driver: "rancher", Id: "bla"}
+			_, err := b.PersistService(service)
+			So(err, ShouldBeNil)
+
+			Convey("Then an update is notified", func() {
+				event := waitForEvent(events, "update", "testService")
+				So(event, ShouldNotBeNil)
+				So(event.Model.(*Service), ShouldNotEqual, service)
+			})
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := b.LoadService("testService")
+				So(err, ShouldBeNil)
+				So(loaded.NodeKey, ShouldEqual, "/services/testService")
+				So(loaded.Domain, ShouldEqual, "test.domain.com")
+				So(loaded.Status.Compute(), ShouldEqual, STOPPED_STATUS)
+				So(loaded.Status.Service, ShouldEqual, loaded)
+				So(loaded.Actions, ShouldResemble, service.Actions)
+				So(loaded.Config.DriverInfo.Id, ShouldEqual, "bla")
+
+				services, _ := b.LoadAllServices()
+				So(len(services), ShouldEqual, 1)
+			})
+
+			Convey("Then it can not be created twice", func() {
+				other := &Service{Name: "testService"}
+				other.Init()
+				_, err := b.PersistService(other)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("When it is modified from an outdated revision", func() {
+				outdated := service.Copy()
+				service.Status.Expected = STARTED_STATUS
+				_, err := b.PersistService(service)
+				So(err, ShouldBeNil)
+				outdated.Domain = "other.domain.com"
+				_, err = b.PersistService(outdated)
+
+				Convey("Then a conflict is returned", func() {
+					So(err, ShouldEqual, ErrConflict)
+					loaded, _ := b.LoadService("testService")
+					So(loaded.Revision, ShouldEqual, service.Revision)
+					So(loaded.Domain, ShouldEqual, "test.domain.com")
+				})
+			})
+
+			Convey("When its last access and passivation reason are modified", func() {
+				lastAccess := time.Date(2016, 5, 4, 10, 20, 30, 0, time.UTC)
+				service.LastAccess = &lastAccess
+				service.PassivationReason = "idle"
+				snoozedUntil := lastAccess.Add(time.Hour)
+				service.SnoozedUntil = &snoozedUntil
+				_, err := b.PersistService(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then they are stored", func() {
+					loaded, _ := b.LoadService("testService")
+					So(loaded.LastAccess.Equal(lastAccess), ShouldBeTrue)
+					So(loaded.PassivationReason, ShouldEqual, "idle")
+					So(loaded.SnoozedUntil.Equal(snoozedUntil), ShouldBeTrue)
+				})
+			})
+
+			Convey("When it is modified without actions", func() {
+				service.Status.Expected = STARTED_STATUS
+				service.Actions = []string{}
+				_, err := b.PersistService(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then the modification is stored and the actions are kept", func() {
+					loaded, _ := b.LoadService("testService")
+					So(loaded.Status.Expected, ShouldEqual, STARTED_STATUS)
+					So(len(loaded.Actions.([]string)), ShouldBeGreaterThan, 0)
+				})
+			})
+
+			Convey("When the file is reopened", func() {
+				b.Close()
+				b, err = NewBoltDriver(path)
+				So(err, ShouldBeNil)
+
+				Convey("Then the service is still there", func() {
+					loaded, err := b.LoadService("testService")
+					So(err, ShouldBeNil)
+					So(loaded.Domain, ShouldEqual, "test.domain.com")
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				err := b.DestroyService(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then it can not be loaded anymore", func() {
+					loaded, err := b.LoadService("testService")
+					So(loaded, ShouldBeNil)
+					So(err, ShouldNotBeNil)
+				})
+
+				Convey("Then a deletion is notified", func() {
+					So(waitForEvent(events, "delete", "testService"), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When a domain is persisted", func() {
+			domain := &Domain{Name: "test.domain.com", Typ: "service", Value: "testService"}
+			_, err := b.PersistDomain(domain)
+			So(err, ShouldBeNil)
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := b.LoadDomain("test.domain.com")
+				So(err, ShouldBeNil)
+				So(loaded.NodeKey, ShouldEqual, "/domains/test.domain.com")
+				So(loaded.Value, ShouldEqual, "testService")
+
+				domains, _ := b.LoadAllDomains()
+				So(len(domains), ShouldEqual, 1)
+			})
+
+			Convey("When it is modified from an outdated revision", func() {
+				outdated := *domain
+				domain.Value = "testService2"
+				b.PersistDomain(domain)
+				_, err := b.PersistDomain(&outdated)
+
+				Convey("Then a conflict is returned", func() {
+					So(err, ShouldEqual, ErrConflict)
+				})
+			})
+
+			Convey("When it is modified", func() {
+				waitForEvent(events, "update", "test.domain.com")
+				domain.Value = "testService2"
+				b.PersistDomain(domain)
+
+				Convey("Then the modification is notified", func() {
+					event := waitForEvent(events, "update", "test.domain.com")
+					So(event, ShouldNotBeNil)
+					So(event.Model.(*Domain).Value, ShouldEqual, "testService2")
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				b.DestroyDomain(domain)
+
+				Convey("Then a deletion is notified", func() {
+					So(waitForEvent(events, "delete", "test.domain.com"), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When an API key is persisted", func() {
+			expiresAt := time.Now().Add(time.Hour).Round(time.Second)
+			key := &APIKey{Id: "k1", Description: "CI", SecretHash: "abc", Role: "operator", Services: []string{"app-*"}, ExpiresAt: &expiresAt}
+			_, err := b.PersistAPIKey(key)
+			So(err, ShouldBeNil)
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := b.LoadAPIKey("k1")
+				So(err, ShouldBeNil)
+				So(loaded.SecretHash, ShouldEqual, "abc")
+				So(loaded.Services, ShouldResemble, []string{"app-*"})
+				So(loaded.ExpiresAt.Equal(expiresAt), ShouldBeTrue)
+
+				keys, _ := b.LoadAllAPIKeys()
+				So(len(keys), ShouldEqual, 1)
+			})
+
+			Convey("When its use is recorded", func() {
+				loaded, _ := b.LoadAPIKey("k1")
+				usedAt := time.Now().Round(time.Second)
+				So(b.TouchAPIKey(loaded, usedAt), ShouldBeNil)
+
+				Convey("Then the last use is stored", func() {
+					touched, _ := b.LoadAPIKey("k1")
+					So(touched.LastUsedAt.Equal(usedAt), ShouldBeTrue)
+					So(touched.Role, ShouldEqual, "operator")
+				})
+			})
+
+			Convey("When its use is recorded after it is destroyed", func() {
+				loaded, _ := b.LoadAPIKey("k1")
+				So(b.DestroyAPIKey(key), ShouldBeNil)
+				err := b.TouchAPIKey(loaded, time.Now())
+
+				Convey("Then it is not restored", func() {
+					So(err, ShouldEqual, ErrConflict)
+					_, err := b.LoadAPIKey("k1")
+					So(err, ShouldEqual, ErrAPIKeyNotFound)
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				So(b.DestroyAPIKey(key), ShouldBeNil)
+
+				Convey("Then it is not found anymore", func() {
+					_, err := b.LoadAPIKey("k1")
+					So(err, ShouldEqual, ErrAPIKeyNotFound)
+					keys, _ := b.LoadAllAPIKeys()
+					So(len(keys), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When a webhook and its deliveries are persisted", func() {
+			subscription := &WebhookSubscription{Id: "w1", URL: "http://example.com/hook", Services: []string{"app-*"}, Secret: "s3cr3t"}
+			_, err := b.PersistWebhookSubscription(subscription)
+			So(err, ShouldBeNil)
+			for _, id := range []string{"d1", "d2"} {
+				delivery := &WebhookDelivery{Id: id, SubscriptionId: "w1", State: "pending", Body: []byte(`{"id":"` + id + `"}`)}
+				_, err := b.PersistWebhookDelivery(delivery)
+				So(err, ShouldBeNil)
+			}
+
+			Convey("Then they can be loaded", func() {
+				subscriptions, err := b.LoadAllWebhookSubscriptions()
+				So(err, ShouldBeNil)
+				So(len(subscriptions), ShouldEqual, 1)
+				So(subscriptions["w1"].Secret, ShouldEqual, "s3cr3t")
+				So(subscriptions["w1"].Services, ShouldResemble, []string{"app-*"})
+
+				deliveries, err := b.LoadAllWebhookDeliveries()
+				So(err, ShouldBeNil)
+				So(len(deliveries), ShouldEqual, 2)
+				So(string(deliveries[0].Body), ShouldStartWith, `{"id":"d`)
+			})
+
+			Convey("When a delivery is destroyed", func() {
+				So(b.DestroyWebhookDelivery(&WebhookDelivery{Id: "d1", SubscriptionId: "w1"}), ShouldBeNil)
+
+				Convey("Then the other one is left", func() {
+					deliveries, _ := b.LoadAllWebhookDeliveries()
+					So(len(deliveries), ShouldEqual, 1)
+					So(deliveries[0].Id, ShouldEqual, "d2")
+				})
+			})
+
+			Convey("When the webhook is destroyed", func() {
+				So(b.DestroyWebhookSubscription(subscription), ShouldBeNil)
+
+				Convey("Then its deliveries are destroyed with it", func() {
+					subscriptions, _ := b.LoadAllWebhookSubscriptions()
+					So(len(subscriptions), ShouldEqual, 0)
+					deliveries, _ := b.LoadAllWebhookDeliveries()
+					So(len(deliveries), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When audit entries are appended", func() {
+			start := time.Now()
+			for i, name := range []string{"app1", "app2", "app3", "app4", "app5"} {
+				entry := &AuditEntry{Id: fmt.Sprintf("%s-%d", TimeIdPrefix(start.Add(time.Duration(i)*time.Minute)), i), Action: "StopService", ServiceName: name}
+				So(b.AppendAuditEntry(entry), ShouldBeNil)
+			}
+
+			Convey("Then they are loaded in order within a time range", func() {
+				entries, err := b.LoadAuditEntries(start, time.Time{})
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 5)
+				So(entries[0].ServiceName, ShouldEqual, "app1")
+
+				entries, _ = b.LoadAuditEntries(start.Add(30*time.Second), start.Add(90*time.Second))
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].ServiceName, ShouldEqual, "app2")
+			})
+
+			Convey("When the old ones are pruned", func() {
+				So(b.PruneAuditEntries(start.Add(210*time.Second)), ShouldBeNil)
+
+				Convey("Then only the recent ones are left", func() {
+					entries, _ := b.LoadAuditEntries(time.Time{}, time.Time{})
+					So(len(entries), ShouldEqual, 1)
+					So(entries[0].ServiceName, ShouldEqual, "app5")
+				})
+			})
+		})
+
+		Convey("When events are appended to the history of services", func() {
+			start := time.Now()
+			for i, typ := range []string{STATUS_HISTORY_EVENT, LOCATION_HISTORY_EVENT, STATUS_HISTORY_EVENT, LOCATION_HISTORY_EVENT, ACTION_HISTORY_EVENT} {
+				event := &HistoryEvent{Id: fmt.Sprintf("%s-%d", TimeIdPrefix(start.Add(time.Duration(i)*time.Minute)), i), Type: typ}
+				So(b.AppendHistoryEvent("app1", event), ShouldBeNil)
+			}
+			So(b.AppendHistoryEvent("app2", &HistoryEvent{Id: TimeIdPrefix(start) + "-0", Type: ACTION_HISTORY_EVENT}), ShouldBeNil)
+
+			Convey("Then the events of a service are loaded in order within a time range", func() {
+				events, err := b.LoadServiceHistory("app1", time.Time{}, time.Time{})
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 5)
+				So(events[0].Type, ShouldEqual, STATUS_HISTORY_EVENT)
+
+				events, _ = b.LoadServiceHistory("app1", start.Add(30*time.Second), start.Add(90*time.Second))
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, LOCATION_HISTORY_EVENT)
+
+				events, _ = b.LoadServiceHistory("unknown", time.Time{}, time.Time{})
+				So(len(events), ShouldEqual, 0)
+			})
+
+			Convey("When the old ones are pruned", func() {
+				So(b.PruneServiceHistories(start.Add(210*time.Second)), ShouldBeNil)
+
+				Convey("Then only the recent ones are left", func() {
+					events, _ := b.LoadServiceHistory("app1", time.Time{}, time.Time{})
+					So(len(events), ShouldEqual, 1)
+					So(events[0].Type, ShouldEqual, ACTION_HISTORY_EVENT)
+					events, _ = b.LoadServiceHistory("app2", time.Time{}, time.Time{})
+					So(len(events), ShouldEqual, 0)
+				})
+
+				Convey("Then the emptied history is deleted", func() {
+					b.db.View(func(tx *bolt.Tx) error {
+						So(tx.Bucket(boltHistoryBucket).Bucket([]byte("app2")), ShouldBeNil)
+						So(tx.Bucket(boltHistoryBucket).Bucket([]byte("app1")), ShouldNotBeNil)
+						return nil
+					})
+				})
+			})
+		})
+
+		Reset(func() {
+			b.Close()
+			os.Remove(path)
+		})
+	})
+}
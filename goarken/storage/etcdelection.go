@@ -0,0 +1,134 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	. "github.com/arkenio/arken/goarken/model"
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// EtcdElector elects a leader on the etcd v2 API : the leader holds a key
+// with a ttl, that it renews three times per ttl. The others try to create
+// the key at the same pace, which succeeds once it has expired.
+type EtcdElector struct {
+	kapi etcd.KeysAPI
+	key  string
+	id   string
+	ttl  time.Duration
+
+	stop chan bool
+	wg   sync.WaitGroup
+}
+
+func NewEtcdElector(kapi etcd.KeysAPI, key string, id string, ttl time.Duration) *EtcdElector {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return &EtcdElector{
+		kapi: kapi,
+		key:  key,
+		id:   id,
+		ttl:  ttl,
+		stop: make(chan bool),
+	}
+}
+
+// Creates an elector that holds its election on the given key.
+func (w *Watcher) NewElector(key string, id string, ttl time.Duration) (Elector, error) {
+	return NewEtcdElector(w.kapi, key, id, ttl), nil
+}
+
+func (e *EtcdElector) Id() string {
+	return e.id
+}
+
+func (e *EtcdElector) Campaign() chan bool {
+	leadership := make(chan bool)
+	e.wg.Add(1)
+	go e.campaign(leadership)
+	return leadership
+}
+
+func (e *EtcdElector) campaign(leadership chan bool) {
+	defer e.wg.Done()
+	defer close(leadership)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	leading := false
+	for {
+		if acquired := e.acquire(); acquired != leading {
+			leading = acquired
+			select {
+			case leadership <- leading:
+			case <-e.stop:
+			}
+		}
+
+		select {
+		case <-e.stop:
+			if leading {
+				ctx, cancel := context.WithTimeout(context.Background(), e.ttl)
+				_, err := e.kapi.Delete(ctx, e.key, &etcd.DeleteOptions{PrevValue: e.id})
+				cancel()
+				if err != nil {
+					log.Errorf("Unable to resign from the leadership : %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Renews the leader key if it holds the id of the elector, or creates it if
+// it does not exist. Returns true when the elector holds the key.
+func (e *EtcdElector) acquire() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+
+	_, err := e.kapi.Set(ctx, e.key, e.id, &etcd.SetOptions{PrevExist: etcd.PrevExist, PrevValue: e.id, TTL: e.ttl})
+	if err == nil {
+		return true
+	}
+	_, err = e.kapi.Set(ctx, e.key, e.id, &etcd.SetOptions{PrevExist: etcd.PrevNoExist, TTL: e.ttl})
+	return err == nil
+}
+
+func (e *EtcdElector) Leader() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl)
+	defer cancel()
+
+	resp, err := e.kapi.Get(ctx, e.key, nil)
+	if etcd.IsKeyNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
+
+func (e *EtcdElector) Resign() error {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	e.wg.Wait()
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"github.com/coreos/etcd/client"
+	"github.com/coreos/etcd/integration"
+	"github.com/coreos/etcd/pkg/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func Test_EtcdElector(t *testing.T) {
+	//Wait for potential other etcd cluster to stop
+	time.Sleep(3 * time.Second)
+
+	defer testutil.AfterTest(t)
+	cl := integration.NewCluster(t, 1)
+	cl.Launch(t)
+	defer cl.Terminate(t)
+
+	cfg := client.Config{
+		Endpoints:               []string{cl.URL(0)},
+		Transport:               client.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second,
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	kapi := client.NewKeysAPI(c)
+
+	Convey("Given two electors on the same key", t, func() {
+		first := NewEtcdElector(kapi, "/arken/leader", "first", time.Second)
+		second := NewEtcdElector(kapi, "/arken/leader", "second", time.Second)
+
+		Convey("When the first one campaigns", func() {
+			firstLeadership := first.Campaign()
+
+			Convey("Then it is elected", func() {
+				So(waitForLeadership(firstLeadership, true), ShouldBeTrue)
+				leader, err := second.Leader()
+				So(err, ShouldBeNil)
+				So(leader, ShouldEqual, "first")
+			})
+
+			Convey("When the second one campaigns and the first one resigns", func() {
+				So(waitForLeadership(firstLeadership, true), ShouldBeTrue)
+				secondLeadership := second.Campaign()
+				go first.Resign()
+
+				Convey("Then the second one is elected", func() {
+					So(waitForLeadership(firstLeadership, false), ShouldBeTrue)
+					So(waitForLeadership(secondLeadership, true), ShouldBeTrue)
+					leader, _ := first.Leader()
+					So(leader, ShouldEqual, "second")
+				})
+			})
+		})
+
+		Reset(func() {
+			first.Resign()
+			second.Resign()
+		})
+	})
+}
@@ -0,0 +1,723 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/arkenio/arken/goarken/metrics"
+	. "github.com/arkenio/arken/goarken/model"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEtcdV3NotFound = errors.New("Not found in etcd")
+)
+
+// EtcdV3Driver implements the PersistenceDriver interface of the Arken
+// Model on top of the etcd v3 API. It keeps the key layout of the etcd v2
+// Watcher :
+//
+//	<servicePrefix>/<name>/status/expected|current|alive
+//	<servicePrefix>/<name>/location|config|domain|lastAccess|passivationReason|snoozedUntil|actions
+//	<domainPrefix>/<name>/type|value
+//	<APIKeyDir>/<id>
+//	<AuditDir>/<id>
+//	<HistoryDir>/<service>/<id>
+//	<WebhookDir>/subscriptions/<id>
+//	<WebhookDir>/deliveries/<subscription>/<id>
+type EtcdV3Driver struct {
+	client        *clientv3.Client
+	broadcaster   *Broadcaster
+	servicePrefix string
+	domainPrefix  string
+	ctx           context.Context
+	cancel        context.CancelFunc
+	// Where the API keys, the audit log, the histories of the services and
+	// the webhooks are stored, as JSON
+	APIKeyDir  string
+	AuditDir   string
+	HistoryDir string
+	WebhookDir string
+}
+
+func NewEtcdV3Driver(client *clientv3.Client, servicePrefix string, domainPrefix string) (*EtcdV3Driver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &EtcdV3Driver{
+		client:        client,
+		broadcaster:   NewBroadcaster(),
+		servicePrefix: etcdV3Key(servicePrefix),
+		domainPrefix:  etcdV3Key(domainPrefix),
+		ctx:           ctx,
+		cancel:        cancel,
+		APIKeyDir:     DEFAULT_API_KEY_DIR,
+		AuditDir:      DEFAULT_AUDIT_DIR,
+		HistoryDir:    DEFAULT_HISTORY_DIR,
+		WebhookDir:    DEFAULT_WEBHOOK_DIR,
+	}
+
+	// Watches start right after the current revision, so that no change
+	// made after the model loaded its state is missed.
+	resp, err := client.Get(ctx, d.servicePrefix, clientv3.WithCountOnly())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go d.doWatch(d.domainPrefix, resp.Header.Revision, d.registerDomain)
+	go d.doWatch(d.servicePrefix, resp.Header.Revision, d.registerService)
+
+	return d, nil
+}
+
+// Stops watching etcd. The client is left open.
+func (d *EtcdV3Driver) Stop() {
+	d.cancel()
+}
+
+func (d *EtcdV3Driver) Listen() chan *ModelEvent {
+	return FromInterfaceChannel(d.broadcaster.Listen())
+}
+
+// Watches every key under prefix from the revision following rev. The
+// register function is called once per object modified in a watch response,
+// with the name of that object, and tells if the object still exists. When
+// etcd compacted the revisions the watch needed, all the objects under
+// prefix are registered again.
+func (d *EtcdV3Driver) doWatch(prefix string, rev int64, registerFunc func(string) bool) {
+	known := make(map[string]bool)
+
+	for d.ctx.Err() == nil {
+		watchChan := d.client.Watch(d.ctx, prefix+"/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+
+		for resp := range watchChan {
+			if resp.CompactRevision != 0 {
+				log.Warnf("Watch on %s is compacted at revision %d, reloading it", prefix, resp.CompactRevision)
+				rev, known = d.resync(prefix, known, registerFunc)
+				metrics.WatchRestarts.WithLabelValues(prefix).Inc()
+				break
+			}
+			if err := resp.Err(); err != nil {
+				log.Warnf("Watch on %s failed, resuming it at revision %d : %v", prefix, rev+1, err)
+				metrics.WatchRestarts.WithLabelValues(prefix).Inc()
+				break
+			}
+
+			names := make([]string, 0)
+			seen := make(map[string]bool)
+			for _, event := range resp.Events {
+				name := etcdV3Name(prefix, string(event.Kv.Key))
+				if name != "" && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+			for _, name := range names {
+				if registerFunc(name) {
+					known[name] = true
+				} else {
+					delete(known, name)
+				}
+			}
+			rev = resp.Header.Revision
+		}
+	}
+}
+
+// Registers again every object under prefix, and the deletion of the known
+// objects that disappeared. Returns the revision the objects were read at,
+// and the objects known from then on.
+func (d *EtcdV3Driver) resync(prefix string, known map[string]bool, registerFunc func(string) bool) (int64, map[string]bool) {
+	for d.ctx.Err() == nil {
+		resp, err := d.client.Get(d.ctx, prefix+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			log.Errorf("Unable to reload %s from etcd : %v", prefix, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		current := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			if name := etcdV3Name(prefix, string(kv.Key)); name != "" {
+				current[name] = true
+			}
+		}
+		for name := range known {
+			if !current[name] {
+				registerFunc(name)
+			}
+		}
+		for name := range current {
+			registerFunc(name)
+		}
+		return resp.Header.Revision, current
+	}
+	return 0, known
+}
+
+// Publishes the service as it is in etcd. Returns false when it has been
+// deleted.
+func (d *EtcdV3Driver) registerService(serviceName string) bool {
+	service, err := d.LoadService(serviceName)
+	if err == nil {
+		d.broadcaster.Write(NewModelEvent("update", service))
+	} else if err == ErrEtcdV3NotFound {
+		d.broadcaster.Write(NewModelEvent("delete", &Service{Name: serviceName}))
+		return false
+	} else {
+		log.Errorf("Unable to get information for service %s from etcd (%v)", serviceName, err)
+	}
+	return true
+}
+
+// Publishes the domain as it is in etcd. Returns false when it has been
+// deleted.
+func (d *EtcdV3Driver) registerDomain(domainName string) bool {
+	domain, err := d.LoadDomain(domainName)
+	if err == nil {
+		if domain.Typ != "" && domain.Value != "" {
+			d.broadcaster.Write(NewModelEvent("update", domain))
+		}
+	} else if err == ErrEtcdV3NotFound {
+		d.broadcaster.Write(NewModelEvent("delete", &Domain{Name: domainName}))
+		return false
+	} else {
+		log.Errorf("Unable to get information for domain %s from etcd (%v)", domainName, err)
+	}
+	return true
+}
+
+func (d *EtcdV3Driver) LoadAllServices() (map[string]*Service, error) {
+	resp, err := d.client.Get(d.ctx, d.servicePrefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Service)
+	for name, kvs := range groupByName(d.servicePrefix, resp.Kvs) {
+		result[name] = newServiceFromKvs(name, etcdV3Key(d.servicePrefix, name), kvs)
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) LoadService(serviceName string) (*Service, error) {
+	nodeKey := etcdV3Key(d.servicePrefix, serviceName)
+	resp, err := d.client.Get(d.ctx, nodeKey+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrEtcdV3NotFound
+	}
+	return newServiceFromKvs(serviceName, nodeKey, resp.Kvs), nil
+}
+
+func newServiceFromKvs(serviceName string, nodeKey string, kvs []*mvccpb.KeyValue) *Service {
+	service := &Service{}
+	service.Location = &Location{}
+	service.Config = &ServiceConfig{Robots: ""}
+	service.Name = serviceName
+	service.NodeKey = nodeKey
+
+	for _, kv := range kvs {
+		value := string(kv.Value)
+		switch strings.TrimPrefix(string(kv.Key), nodeKey+"/") {
+		case "location":
+			location := &Location{}
+			err := json.Unmarshal(kv.Value, location)
+			if err == nil {
+				service.Location.Host = location.Host
+				service.Location.Port = location.Port
+			}
+		case "config":
+			serviceConfig := &ServiceConfig{}
+			err := json.Unmarshal(kv.Value, serviceConfig)
+			if err == nil {
+				service.Config = serviceConfig
+			}
+		case "domain":
+			service.Domain = value
+		case "lastAccess":
+			lastAccessTime, err := time.Parse(TIME_FORMAT, value)
+			if err != nil {
+				log.Errorf("Error parsing last access date with service %s: %s", service.Name, err)
+				break
+			}
+			service.LastAccess = &lastAccessTime
+		case "passivationReason":
+			service.PassivationReason = value
+		case "snoozedUntil":
+			snoozedUntil, err := time.Parse(TIME_FORMAT, value)
+			if err != nil {
+				log.Errorf("Error parsing snooze date with service %s: %s", service.Name, err)
+				break
+			}
+			service.SnoozedUntil = &snoozedUntil
+		case "actions":
+			var actions []string
+			err := json.Unmarshal(kv.Value, &actions)
+			if err != nil {
+				log.Errorf("Error parsing actions on the service %s: %v", service.Name, err)
+				break
+			}
+			service.Actions = actions
+		case "status/alive":
+			newStatusIfNil(service).Alive = value
+		case "status/current":
+			newStatusIfNil(service).Current = value
+		case "status/expected":
+			// Written on each PersistService, its revision is the one of the service
+			newStatusIfNil(service).Expected = value
+			service.Revision = kv.ModRevision
+		}
+	}
+	return service
+}
+
+func newStatusIfNil(service *Service) *Status {
+	if service.Status == nil {
+		service.Status = &Status{Service: service}
+	}
+	return service.Status
+}
+
+// Persists the service in a single transaction. A new service (without
+// NodeKey) must not exist yet, an existing one must still exist and be at
+// its revision if it has one.
+func (d *EtcdV3Driver) PersistService(s *Service) (*Service, error) {
+	if s.Status == nil {
+		return nil, errors.New("Can't persist service " + s.Name + " without status")
+	}
+
+	nodeKey := s.NodeKey
+	isNew := nodeKey == ""
+	if isNew {
+		nodeKey = etcdV3Key(d.servicePrefix, s.Name)
+	}
+
+	config, err := json.Marshal(s.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []clientv3.Op{
+		clientv3.OpPut(nodeKey+"/status/expected", s.Status.Expected),
+		clientv3.OpPut(nodeKey+"/status/current", s.Status.Current),
+		clientv3.OpPut(nodeKey+"/config", string(config)),
+		clientv3.OpPut(nodeKey+"/domain", s.Domain),
+		clientv3.OpPut(nodeKey+"/passivationReason", s.PassivationReason),
+	}
+
+	if s.LastAccess != nil {
+		ops = append(ops, clientv3.OpPut(nodeKey+"/lastAccess", s.LastAccess.UTC().Format(TIME_FORMAT)))
+	}
+
+	if s.SnoozedUntil != nil {
+		ops = append(ops, clientv3.OpPut(nodeKey+"/snoozedUntil", s.SnoozedUntil.UTC().Format(TIME_FORMAT)))
+	}
+
+	actions, _ := s.Actions.([]string)
+	if isNew || len(actions) > 0 { //don't perists actions on intermediate states
+		bytes, err := json.Marshal(actions)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, clientv3.OpPut(nodeKey+"/actions", string(bytes)))
+	}
+
+	if !isNew {
+		location, err := json.Marshal(s.Location)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops,
+			clientv3.OpPut(nodeKey+"/status/alive", s.Status.Alive),
+			clientv3.OpPut(nodeKey+"/location", string(location)))
+	}
+
+	log.Debugf("Persisting key %s ", nodeKey)
+	revision, err := d.commit(nodeKey, "/status/expected", isNew, s.Revision, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	s.NodeKey = nodeKey
+	s.Revision = revision
+	return s, nil
+}
+
+func (d *EtcdV3Driver) DestroyService(s *Service) error {
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.servicePrefix, s.Name)+"/", clientv3.WithPrefix())
+	return err
+}
+
+func (d *EtcdV3Driver) LoadAllDomains() (map[string]*Domain, error) {
+	resp, err := d.client.Get(d.ctx, d.domainPrefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Domain)
+	for name, kvs := range groupByName(d.domainPrefix, resp.Kvs) {
+		result[name] = newDomainFromKvs(name, etcdV3Key(d.domainPrefix, name), kvs)
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) LoadDomain(domainName string) (*Domain, error) {
+	nodeKey := etcdV3Key(d.domainPrefix, domainName)
+	resp, err := d.client.Get(d.ctx, nodeKey+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrEtcdV3NotFound
+	}
+	return newDomainFromKvs(domainName, nodeKey, resp.Kvs), nil
+}
+
+func newDomainFromKvs(domainName string, nodeKey string, kvs []*mvccpb.KeyValue) *Domain {
+	domain := &Domain{Name: domainName, NodeKey: nodeKey}
+	for _, kv := range kvs {
+		switch string(kv.Key) {
+		case nodeKey + "/type":
+			domain.Typ = string(kv.Value)
+			domain.Revision = kv.ModRevision
+		case nodeKey + "/value":
+			domain.Value = string(kv.Value)
+		}
+	}
+	return domain
+}
+
+// Persists the domain in a single transaction, with the same existence and
+// revision rules as PersistService.
+func (d *EtcdV3Driver) PersistDomain(domain *Domain) (*Domain, error) {
+	nodeKey := domain.NodeKey
+	isNew := nodeKey == ""
+	if isNew {
+		nodeKey = etcdV3Key(d.domainPrefix, domain.Name)
+	}
+
+	revision, err := d.commit(nodeKey, "/type", isNew, domain.Revision, []clientv3.Op{
+		clientv3.OpPut(nodeKey+"/type", domain.Typ),
+		clientv3.OpPut(nodeKey+"/value", domain.Value),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	domain.NodeKey = nodeKey
+	domain.Revision = revision
+	return domain, nil
+}
+
+// Commits the operations on the object at nodeKey if its marker key, written
+// by each operation, is as expected : absent for a new object, present
+// otherwise and at the given revision if not 0. Returns the revision of the
+// commit.
+func (d *EtcdV3Driver) commit(nodeKey string, marker string, isNew bool, revision int64, ops []clientv3.Op) (int64, error) {
+	markerKey := nodeKey + marker
+
+	var cmps []clientv3.Cmp
+	if isNew {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(markerKey), "=", 0))
+	} else {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(markerKey), ">", 0))
+		if revision != 0 {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(markerKey), "=", revision))
+		}
+	}
+
+	resp, err := d.client.Txn(d.ctx).If(cmps...).Then(ops...).Else(clientv3.OpGet(markerKey, clientv3.WithCountOnly())).Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		exists := resp.Responses[0].GetResponseRange().Count > 0
+		if isNew {
+			return 0, errors.New(nodeKey + " already exists in etcd")
+		} else if !exists {
+			return 0, errors.New("No object with key " + nodeKey + " found in etcd")
+		}
+		return 0, ErrConflict
+	}
+	return resp.Header.Revision, nil
+}
+
+func (d *EtcdV3Driver) DestroyDomain(domain *Domain) error {
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.domainPrefix, domain.Name)+"/", clientv3.WithPrefix())
+	return err
+}
+
+func (d *EtcdV3Driver) LoadAllAPIKeys() (map[string]*APIKey, error) {
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.APIKeyDir)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*APIKey)
+	for _, kv := range resp.Kvs {
+		key := &APIKey{}
+		if err := json.Unmarshal(kv.Value, key); err != nil {
+			return nil, err
+		}
+		key.Revision = kv.ModRevision
+		result[key.Id] = key
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) LoadAPIKey(id string) (*APIKey, error) {
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.APIKeyDir, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	key := &APIKey{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, key); err != nil {
+		return nil, err
+	}
+	key.Revision = resp.Kvs[0].ModRevision
+	return key, nil
+}
+
+func (d *EtcdV3Driver) PersistAPIKey(key *APIKey) (*APIKey, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Put(d.ctx, etcdV3Key(d.APIKeyDir, key.Id), string(data))
+	if err != nil {
+		return nil, err
+	}
+	key.Revision = resp.Header.Revision
+	return key, nil
+}
+
+func (d *EtcdV3Driver) DestroyAPIKey(key *APIKey) error {
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.APIKeyDir, key.Id))
+	return err
+}
+
+// Records the last use if the key is still at the revision it was loaded at.
+func (d *EtcdV3Driver) TouchAPIKey(key *APIKey, at time.Time) error {
+	touched := key.Copy()
+	touched.LastUsedAt = &at
+	data, err := json.Marshal(touched)
+	if err != nil {
+		return err
+	}
+
+	nodeKey := etcdV3Key(d.APIKeyDir, key.Id)
+	resp, err := d.client.Txn(d.ctx).If(clientv3.Compare(clientv3.ModRevision(nodeKey), "=", key.Revision)).Then(clientv3.OpPut(nodeKey, string(data))).Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (d *EtcdV3Driver) AppendAuditEntry(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = d.client.Put(d.ctx, etcdV3Key(d.AuditDir, entry.Id), string(data))
+	return err
+}
+
+// Loads the entries of the audit log in a range of their keys, which start
+// with the time they were recorded.
+func (d *EtcdV3Driver) LoadAuditEntries(since time.Time, until time.Time) ([]*AuditEntry, error) {
+	end := clientv3.GetPrefixRangeEnd(etcdV3Key(d.AuditDir) + "/")
+	if !until.IsZero() {
+		end = etcdV3Key(d.AuditDir, TimeIdPrefix(until))
+	}
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.AuditDir, TimeIdPrefix(since)), clientv3.WithRange(end), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*AuditEntry, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		entry := &AuditEntry{}
+		if err := json.Unmarshal(kv.Value, entry); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) PruneAuditEntries(before time.Time) error {
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.AuditDir)+"/", clientv3.WithRange(etcdV3Key(d.AuditDir, TimeIdPrefix(before))))
+	return err
+}
+
+func (d *EtcdV3Driver) AppendHistoryEvent(serviceName string, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = d.client.Put(d.ctx, etcdV3Key(d.HistoryDir, serviceName, event.Id), string(data))
+	return err
+}
+
+func (d *EtcdV3Driver) LoadServiceHistory(serviceName string, since time.Time, until time.Time) ([]*HistoryEvent, error) {
+	end := clientv3.GetPrefixRangeEnd(etcdV3Key(d.HistoryDir, serviceName) + "/")
+	if !until.IsZero() {
+		end = etcdV3Key(d.HistoryDir, serviceName, TimeIdPrefix(until))
+	}
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.HistoryDir, serviceName, TimeIdPrefix(since)), clientv3.WithRange(end), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*HistoryEvent, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		event := &HistoryEvent{}
+		if err := json.Unmarshal(kv.Value, event); err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// Deletes the old events of each service that has a history, in a range of
+// its keys.
+func (d *EtcdV3Driver) PruneServiceHistories(before time.Time) error {
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.HistoryDir)+"/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+
+	pruned := make(map[string]bool)
+	for _, kv := range resp.Kvs {
+		name := etcdV3Name(etcdV3Key(d.HistoryDir), string(kv.Key))
+		if name == "" || pruned[name] {
+			continue
+		}
+		pruned[name] = true
+		if _, err := d.client.Delete(d.ctx, etcdV3Key(d.HistoryDir, name)+"/", clientv3.WithRange(etcdV3Key(d.HistoryDir, name, TimeIdPrefix(before)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *EtcdV3Driver) LoadAllWebhookSubscriptions() (map[string]*WebhookSubscription, error) {
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.WebhookDir, "subscriptions")+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*WebhookSubscription)
+	for _, kv := range resp.Kvs {
+		subscription := &WebhookSubscription{}
+		if err := json.Unmarshal(kv.Value, subscription); err != nil {
+			return nil, err
+		}
+		result[subscription.Id] = subscription
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) PersistWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.client.Put(d.ctx, etcdV3Key(d.WebhookDir, "subscriptions", subscription.Id), string(data)); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (d *EtcdV3Driver) DestroyWebhookSubscription(subscription *WebhookSubscription) error {
+	if _, err := d.client.Delete(d.ctx, etcdV3Key(d.WebhookDir, "deliveries", subscription.Id)+"/", clientv3.WithPrefix()); err != nil {
+		return err
+	}
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.WebhookDir, "subscriptions", subscription.Id))
+	return err
+}
+
+func (d *EtcdV3Driver) LoadAllWebhookDeliveries() ([]*WebhookDelivery, error) {
+	resp, err := d.client.Get(d.ctx, etcdV3Key(d.WebhookDir, "deliveries")+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*WebhookDelivery, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		delivery := &WebhookDelivery{}
+		if err := json.Unmarshal(kv.Value, delivery); err != nil {
+			return nil, err
+		}
+		result = append(result, delivery)
+	}
+	return result, nil
+}
+
+func (d *EtcdV3Driver) PersistWebhookDelivery(delivery *WebhookDelivery) (*WebhookDelivery, error) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.client.Put(d.ctx, etcdV3Key(d.WebhookDir, "deliveries", delivery.SubscriptionId, delivery.Id), string(data)); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (d *EtcdV3Driver) DestroyWebhookDelivery(delivery *WebhookDelivery) error {
+	_, err := d.client.Delete(d.ctx, etcdV3Key(d.WebhookDir, "deliveries", delivery.SubscriptionId, delivery.Id))
+	return err
+}
+
+// Builds a clean absolute key from its parts : etcd v3 keys are plain
+// strings, "/services" and "//services/" are different keys.
+func etcdV3Key(parts ...string) string {
+	return path.Join(append([]string{"/"}, parts...)...)
+}
+
+// Returns the name of the object a key under prefix belongs to, or an
+// empty string if the key is not under prefix.
+func etcdV3Name(prefix string, key string) string {
+	if !strings.HasPrefix(key, prefix+"/") {
+		return ""
+	}
+	return strings.SplitN(strings.TrimPrefix(key, prefix+"/"), "/", 2)[0]
+}
+
+func groupByName(prefix string, kvs []*mvccpb.KeyValue) map[string][]*mvccpb.KeyValue {
+	result := make(map[string][]*mvccpb.KeyValue)
+	for _, kv := range kvs {
+		if name := etcdV3Name(prefix, string(kv.Key)); name != "" {
+			result[name] = append(result[name], kv)
+		}
+	}
+	return result
+}
@@ -0,0 +1,470 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"context"
+	"fmt"
+	. "github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func startEmbeddedEtcd(t *testing.T) (*embed.Etcd, *clientv3.Client) {
+	dir, err := ioutil.TempDir("", "arken-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientURL, _ := url.Parse("http://127.0.0.1:23790")
+	peerURL, _ := url.Parse("http://127.0.0.1:23800")
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("Embedded etcd took too long to start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{clientURL.String()}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return e, client
+}
+
+func waitForEvent(events chan *ModelEvent, eventType string, name string) *ModelEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.EventType != eventType {
+				continue
+			}
+			if s, ok := event.Model.(*Service); ok && s.Name == name {
+				return event
+			}
+			if d, ok := event.Model.(*Domain); ok && d.Name == name {
+				return event
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func Test_EtcdV3Driver(t *testing.T) {
+	e, client := startEmbeddedEtcd(t)
+	defer os.RemoveAll(e.Config().Dir)
+	defer e.Close()
+	defer client.Close()
+
+	Convey("Given an etcd v3 driver", t, func() {
+		client.Delete(context.Background(), "/", clientv3.WithPrefix())
+
+		d, err := NewEtcdV3Driver(client, "/services", "/domains")
+		So(err, ShouldBeNil)
+		events := d.Listen()
+
+		Convey("When a service is persisted", func() {
+			service := &Service{Name: "testService"}
+			service.Init()
+			service.Domain = "test.domain.com"
+			service, err := d.PersistService(service)
+			So(err, ShouldBeNil)
+
+			Convey("Then it is stored with the etcd v2 key layout", func() {
+				So(service.NodeKey, ShouldEqual, "/services/testService")
+				resp, _ := client.Get(context.Background(), "/services/testService/status/expected")
+				So(len(resp.Kvs), ShouldEqual, 1)
+				So(string(resp.Kvs[0].Value), ShouldEqual, STOPPED_STATUS)
+				resp, _ = client.Get(context.Background(), "/services/testService/domain")
+				So(string(resp.Kvs[0].Value), ShouldEqual, "test.domain.com")
+			})
+
+			Convey("Then all its keys are written in a single revision", func() {
+				resp, _ := client.Get(context.Background(), "/services/testService/", clientv3.WithPrefix())
+				So(len(resp.Kvs), ShouldBeGreaterThan, 1)
+				for _, kv := range resp.Kvs {
+					So(kv.ModRevision, ShouldEqual, resp.Kvs[0].ModRevision)
+				}
+			})
+
+			Convey("Then an update is notified", func() {
+				So(waitForEvent(events, "update", "testService"), ShouldNotBeNil)
+			})
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := d.LoadService("testService")
+				So(err, ShouldBeNil)
+				So(loaded.Status.Expected, ShouldEqual, STOPPED_STATUS)
+				So(loaded.Domain, ShouldEqual, "test.domain.com")
+
+				services, _ := d.LoadAllServices()
+				So(len(services), ShouldEqual, 1)
+			})
+
+			Convey("Then it can not be created twice", func() {
+				other := &Service{Name: "testService"}
+				other.Init()
+				_, err := d.PersistService(other)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("When it is modified", func() {
+				revision := service.Revision
+				service.Status.Expected = STARTED_STATUS
+				service.Config.DriverInfo = &DriverInfo{Driver: "rancher", Id: "bla"}
+				lastAccess := time.Date(2016, 5, 4, 10, 20, 30, 0, time.UTC)
+				service.LastAccess = &lastAccess
+				service.PassivationReason = "idle"
+				snoozedUntil := lastAccess.Add(time.Hour)
+				service.SnoozedUntil = &snoozedUntil
+				_, err := d.PersistService(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then the modification is stored", func() {
+					loaded, _ := d.LoadService("testService")
+					So(loaded.Status.Expected, ShouldEqual, STARTED_STATUS)
+					So(loaded.Config.DriverInfo.Id, ShouldEqual, "bla")
+					So(loaded.LastAccess.Equal(lastAccess), ShouldBeTrue)
+					So(loaded.PassivationReason, ShouldEqual, "idle")
+					So(loaded.SnoozedUntil.Equal(snoozedUntil), ShouldBeTrue)
+				})
+
+				Convey("Then its revision changes", func() {
+					So(service.Revision, ShouldBeGreaterThan, revision)
+					loaded, _ := d.LoadService("testService")
+					So(loaded.Revision, ShouldEqual, service.Revision)
+				})
+
+				Convey("Then it can not be modified from its previous revision", func() {
+					outdated := service.Copy()
+					outdated.Revision = revision
+					_, err := d.PersistService(outdated)
+					So(err, ShouldEqual, ErrConflict)
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				waitForEvent(events, "update", "testService")
+				err := d.DestroyService(service)
+				So(err, ShouldBeNil)
+
+				Convey("Then it can not be loaded anymore", func() {
+					loaded, err := d.LoadService("testService")
+					So(loaded, ShouldBeNil)
+					So(err, ShouldEqual, ErrEtcdV3NotFound)
+				})
+
+				Convey("Then a deletion is notified", func() {
+					So(waitForEvent(events, "delete", "testService"), ShouldNotBeNil)
+				})
+
+				Convey("Then it can not be modified anymore", func() {
+					_, err := d.PersistService(service)
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When a domain is persisted", func() {
+			domain := &Domain{Name: "test.domain.com", Typ: "service", Value: "testService"}
+			_, err := d.PersistDomain(domain)
+			So(err, ShouldBeNil)
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := d.LoadDomain("test.domain.com")
+				So(err, ShouldBeNil)
+				So(loaded.Typ, ShouldEqual, "service")
+				So(loaded.Value, ShouldEqual, "testService")
+
+				domains, _ := d.LoadAllDomains()
+				So(len(domains), ShouldEqual, 1)
+			})
+
+			Convey("When it is modified from an outdated revision", func() {
+				outdated := *domain
+				domain.Value = "testService2"
+				d.PersistDomain(domain)
+				outdated.Value = "testService3"
+				_, err := d.PersistDomain(&outdated)
+
+				Convey("Then a conflict is returned", func() {
+					So(err, ShouldEqual, ErrConflict)
+					loaded, _ := d.LoadDomain("test.domain.com")
+					So(loaded.Value, ShouldEqual, "testService2")
+				})
+			})
+
+			Convey("When it is modified", func() {
+				waitForEvent(events, "update", "test.domain.com")
+				domain.Value = "testService2"
+				d.PersistDomain(domain)
+
+				Convey("Then the modification is notified", func() {
+					event := waitForEvent(events, "update", "test.domain.com")
+					So(event, ShouldNotBeNil)
+					So(event.Model.(*Domain).Value, ShouldEqual, "testService2")
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				d.DestroyDomain(domain)
+
+				Convey("Then a deletion is notified", func() {
+					So(waitForEvent(events, "delete", "test.domain.com"), ShouldNotBeNil)
+				})
+			})
+		})
+
+		Convey("When an API key is persisted", func() {
+			expiresAt := time.Now().Add(time.Hour).Round(time.Second)
+			key := &APIKey{Id: "k1", Description: "CI", SecretHash: "abc", Role: "operator", Services: []string{"app-*"}, ExpiresAt: &expiresAt}
+			_, err := d.PersistAPIKey(key)
+			So(err, ShouldBeNil)
+
+			Convey("Then it can be loaded", func() {
+				loaded, err := d.LoadAPIKey("k1")
+				So(err, ShouldBeNil)
+				So(loaded.SecretHash, ShouldEqual, "abc")
+				So(loaded.Services, ShouldResemble, []string{"app-*"})
+				So(loaded.ExpiresAt.Equal(expiresAt), ShouldBeTrue)
+
+				keys, _ := d.LoadAllAPIKeys()
+				So(len(keys), ShouldEqual, 1)
+			})
+
+			Convey("When its use is recorded", func() {
+				loaded, _ := d.LoadAPIKey("k1")
+				usedAt := time.Now().Round(time.Second)
+				So(d.TouchAPIKey(loaded, usedAt), ShouldBeNil)
+
+				Convey("Then the last use is stored", func() {
+					touched, _ := d.LoadAPIKey("k1")
+					So(touched.LastUsedAt.Equal(usedAt), ShouldBeTrue)
+					So(touched.Role, ShouldEqual, "operator")
+				})
+			})
+
+			Convey("When its use is recorded after it is destroyed", func() {
+				loaded, _ := d.LoadAPIKey("k1")
+				So(d.DestroyAPIKey(key), ShouldBeNil)
+				err := d.TouchAPIKey(loaded, time.Now())
+
+				Convey("Then it is not restored", func() {
+					So(err, ShouldEqual, ErrConflict)
+					_, err := d.LoadAPIKey("k1")
+					So(err, ShouldEqual, ErrAPIKeyNotFound)
+				})
+			})
+
+			Convey("When it is destroyed", func() {
+				So(d.DestroyAPIKey(key), ShouldBeNil)
+
+				Convey("Then it is not found anymore", func() {
+					_, err := d.LoadAPIKey("k1")
+					So(err, ShouldEqual, ErrAPIKeyNotFound)
+					keys, _ := d.LoadAllAPIKeys()
+					So(len(keys), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When a webhook and its deliveries are persisted", func() {
+			subscription := &WebhookSubscription{Id: "w1", URL: "http://example.com/hook", Services: []string{"app-*"}, Secret: "s3cr3t"}
+			_, err := d.PersistWebhookSubscription(subscription)
+			So(err, ShouldBeNil)
+			for _, id := range []string{"d1", "d2"} {
+				delivery := &WebhookDelivery{Id: id, SubscriptionId: "w1", State: "pending", Body: []byte(`{"id":"` + id + `"}`)}
+				_, err := d.PersistWebhookDelivery(delivery)
+				So(err, ShouldBeNil)
+			}
+
+			Convey("Then they can be loaded", func() {
+				subscriptions, err := d.LoadAllWebhookSubscriptions()
+				So(err, ShouldBeNil)
+				So(len(subscriptions), ShouldEqual, 1)
+				So(subscriptions["w1"].Secret, ShouldEqual, "s3cr3t")
+				So(subscriptions["w1"].Services, ShouldResemble, []string{"app-*"})
+
+				deliveries, err := d.LoadAllWebhookDeliveries()
+				So(err, ShouldBeNil)
+				So(len(deliveries), ShouldEqual, 2)
+				So(string(deliveries[0].Body), ShouldStartWith, `{"id":"d`)
+			})
+
+			Convey("When a delivery is destroyed", func() {
+				So(d.DestroyWebhookDelivery(&WebhookDelivery{Id: "d1", SubscriptionId: "w1"}), ShouldBeNil)
+
+				Convey("Then the other one is left", func() {
+					deliveries, _ := d.LoadAllWebhookDeliveries()
+					So(len(deliveries), ShouldEqual, 1)
+					So(deliveries[0].Id, ShouldEqual, "d2")
+				})
+			})
+
+			Convey("When the webhook is destroyed", func() {
+				So(d.DestroyWebhookSubscription(subscription), ShouldBeNil)
+
+				Convey("Then its deliveries are destroyed with it", func() {
+					subscriptions, _ := d.LoadAllWebhookSubscriptions()
+					So(len(subscriptions), ShouldEqual, 0)
+					deliveries, _ := d.LoadAllWebhookDeliveries()
+					So(len(deliveries), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When audit entries are appended", func() {
+			start := time.Now()
+			for i, name := range []string{"app1", "app2", "app3", "app4", "app5"} {
+				entry := &AuditEntry{Id: fmt.Sprintf("%s-%d", TimeIdPrefix(start.Add(time.Duration(i)*time.Minute)), i), Action: "StopService", ServiceName: name}
+				So(d.AppendAuditEntry(entry), ShouldBeNil)
+			}
+
+			Convey("Then they are loaded in order within a time range", func() {
+				entries, err := d.LoadAuditEntries(start, time.Time{})
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 5)
+				So(entries[0].ServiceName, ShouldEqual, "app1")
+
+				entries, _ = d.LoadAuditEntries(start.Add(30*time.Second), start.Add(90*time.Second))
+				So(len(entries), ShouldEqual, 1)
+				So(entries[0].ServiceName, ShouldEqual, "app2")
+			})
+
+			Convey("When the old ones are pruned", func() {
+				So(d.PruneAuditEntries(start.Add(210*time.Second)), ShouldBeNil)
+
+				Convey("Then only the recent ones are left", func() {
+					entries, _ := d.LoadAuditEntries(time.Time{}, time.Time{})
+					So(len(entries), ShouldEqual, 1)
+					So(entries[0].ServiceName, ShouldEqual, "app5")
+				})
+			})
+		})
+
+		Convey("When events are appended to the history of services", func() {
+			start := time.Now()
+			for i, typ := range []string{STATUS_HISTORY_EVENT, LOCATION_HISTORY_EVENT, STATUS_HISTORY_EVENT, LOCATION_HISTORY_EVENT, ACTION_HISTORY_EVENT} {
+				event := &HistoryEvent{Id: fmt.Sprintf("%s-%d", TimeIdPrefix(start.Add(time.Duration(i)*time.Minute)), i), Type: typ}
+				So(d.AppendHistoryEvent("app1", event), ShouldBeNil)
+			}
+			So(d.AppendHistoryEvent("app2", &HistoryEvent{Id: TimeIdPrefix(start) + "-0", Type: ACTION_HISTORY_EVENT}), ShouldBeNil)
+
+			Convey("Then the events of a service are loaded in order within a time range", func() {
+				events, err := d.LoadServiceHistory("app1", time.Time{}, time.Time{})
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 5)
+				So(events[0].Type, ShouldEqual, STATUS_HISTORY_EVENT)
+
+				events, _ = d.LoadServiceHistory("app1", start.Add(30*time.Second), start.Add(90*time.Second))
+				So(len(events), ShouldEqual, 1)
+				So(events[0].Type, ShouldEqual, LOCATION_HISTORY_EVENT)
+
+				events, _ = d.LoadServiceHistory("unknown", time.Time{}, time.Time{})
+				So(len(events), ShouldEqual, 0)
+			})
+
+			Convey("When the old ones are pruned", func() {
+				So(d.PruneServiceHistories(start.Add(210*time.Second)), ShouldBeNil)
+
+				Convey("Then only the recent ones are left", func() {
+					events, _ := d.LoadServiceHistory("app1", time.Time{}, time.Time{})
+					So(len(events), ShouldEqual, 1)
+					So(events[0].Type, ShouldEqual, ACTION_HISTORY_EVENT)
+					events, _ = d.LoadServiceHistory("app2", time.Time{}, time.Time{})
+					So(len(events), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When objects are registered again after a compaction", func() {
+			service := &Service{Name: "resyncedService"}
+			service.Init()
+			d.PersistService(service)
+
+			registered := []string{}
+			register := func(name string) bool {
+				registered = append(registered, name)
+				_, err := d.LoadService(name)
+				return err == nil
+			}
+			_, known := d.resync(d.servicePrefix, map[string]bool{"deletedService": true}, register)
+
+			Convey("Then the known objects are the ones in etcd, and the deleted ones are registered once", func() {
+				So(known["resyncedService"], ShouldBeTrue)
+				So(known["deletedService"], ShouldBeFalse)
+				So(registered, ShouldContain, "deletedService")
+
+				registered = []string{}
+				d.resync(d.servicePrefix, known, register)
+				So(registered, ShouldContain, "resyncedService")
+				So(registered, ShouldNotContain, "deletedService")
+			})
+		})
+
+		Convey("When the driver is stopped while changes are made", func() {
+			rev, _ := client.Get(context.Background(), "/", clientv3.WithCountOnly())
+			d.Stop()
+
+			service := &Service{Name: "offlineService"}
+			service.Init()
+			d.PersistService(service)
+
+			Convey("Then a watch resumed from the last revision sees them", func() {
+				resumed := &EtcdV3Driver{
+					client:        client,
+					broadcaster:   NewBroadcaster(),
+					servicePrefix: "/services",
+					domainPrefix:  "/domains",
+				}
+				resumed.ctx, resumed.cancel = context.WithCancel(context.Background())
+				defer resumed.Stop()
+				resumedEvents := resumed.Listen()
+				go resumed.doWatch(resumed.servicePrefix, rev.Header.Revision, resumed.registerService)
+
+				So(waitForEvent(resumedEvents, "update", "offlineService"), ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			d.Stop()
+		})
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"context"
+	. "github.com/arkenio/arken/goarken/model"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"sync"
+	"time"
+)
+
+// EtcdV3Elector elects a leader with the election recipe of etcd : each
+// daemon puts its id under the election prefix, attached to the lease of
+// its session, and the oldest one is the leader. A leader that stops to
+// keep its lease alive loses the leadership when the lease expires.
+type EtcdV3Elector struct {
+	client *clientv3.Client
+	prefix string
+	id     string
+	ttl    time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewEtcdV3Elector(client *clientv3.Client, prefix string, id string, ttl time.Duration) *EtcdV3Elector {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	e := &EtcdV3Elector{
+		client: client,
+		prefix: prefix,
+		id:     id,
+		ttl:    ttl,
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
+}
+
+// Creates an elector that holds its election under the given prefix.
+func (d *EtcdV3Driver) NewElector(prefix string, id string, ttl time.Duration) (Elector, error) {
+	return NewEtcdV3Elector(d.client, prefix, id, ttl), nil
+}
+
+func (e *EtcdV3Elector) Id() string {
+	return e.id
+}
+
+func (e *EtcdV3Elector) Campaign() chan bool {
+	leadership := make(chan bool)
+	e.wg.Add(1)
+	go e.campaign(leadership)
+	return leadership
+}
+
+func (e *EtcdV3Elector) campaign(leadership chan bool) {
+	defer e.wg.Done()
+	defer close(leadership)
+
+	for e.ctx.Err() == nil {
+		session, err := concurrency.NewSession(e.client, concurrency.WithTTL(int(e.ttl.Seconds())))
+		if err != nil {
+			log.Errorf("Unable to open an etcd session for the election : %v", err)
+			e.pause()
+			continue
+		}
+
+		election := concurrency.NewElection(session, e.prefix)
+		if err := election.Campaign(e.ctx, e.id); err != nil {
+			session.Close()
+			if e.ctx.Err() == nil {
+				log.Errorf("Unable to campaign for the leadership : %v", err)
+				e.pause()
+			}
+			continue
+		}
+
+		e.publish(leadership, true)
+		select {
+		case <-session.Done():
+			log.Warnf("The etcd session of %s has expired", e.id)
+			e.publish(leadership, false)
+		case <-e.ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), e.ttl)
+			if err := election.Resign(ctx); err != nil {
+				log.Errorf("Unable to resign from the leadership : %v", err)
+			}
+			cancel()
+		}
+		session.Close()
+	}
+}
+
+// Publishes a leadership change, unless the elector is stopped.
+func (e *EtcdV3Elector) publish(leadership chan bool, leading bool) {
+	select {
+	case leadership <- leading:
+	case <-e.ctx.Done():
+	}
+}
+
+// Waits before the next attempt to campaign, unless the elector is stopped.
+func (e *EtcdV3Elector) pause() {
+	select {
+	case <-time.After(e.ttl / 3):
+	case <-e.ctx.Done():
+	}
+}
+
+func (e *EtcdV3Elector) Leader() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl)
+	defer cancel()
+
+	// The leader is the oldest candidate
+	resp, err := e.client.Get(ctx, e.prefix+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+func (e *EtcdV3Elector) Resign() error {
+	e.cancel()
+	e.wg.Wait()
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"time"
+)
+
+func waitForLeadership(leadership chan bool, leading bool) bool {
+	select {
+	case l := <-leadership:
+		return l == leading
+	case <-time.After(10 * time.Second):
+		return false
+	}
+}
+
+func Test_EtcdV3Elector(t *testing.T) {
+	e, client := startEmbeddedEtcd(t)
+	defer os.RemoveAll(e.Config().Dir)
+	defer e.Close()
+	defer client.Close()
+
+	Convey("Given two electors on the same prefix", t, func() {
+		first := NewEtcdV3Elector(client, "/arken/leader", "first", time.Second)
+		second := NewEtcdV3Elector(client, "/arken/leader", "second", time.Second)
+
+		Convey("When the first one campaigns", func() {
+			firstLeadership := first.Campaign()
+
+			Convey("Then it is elected", func() {
+				So(waitForLeadership(firstLeadership, true), ShouldBeTrue)
+				leader, err := second.Leader()
+				So(err, ShouldBeNil)
+				So(leader, ShouldEqual, "first")
+			})
+
+			Convey("When the second one campaigns and the first one resigns", func() {
+				So(waitForLeadership(firstLeadership, true), ShouldBeTrue)
+				secondLeadership := second.Campaign()
+				go first.Resign()
+
+				Convey("Then the second one is elected", func() {
+					So(waitForLeadership(firstLeadership, false), ShouldBeTrue)
+					So(waitForLeadership(secondLeadership, true), ShouldBeTrue)
+					leader, _ := first.Leader()
+					So(leader, ShouldEqual, "second")
+				})
+			})
+		})
+
+		Reset(func() {
+			first.Resign()
+			second.Resign()
+		})
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/arkenio/arken/goarken/metrics"
 	. "github.com/arkenio/arken/goarken/model"
 	etcd "github.com/coreos/etcd/client"
 	"regexp"
@@ -29,6 +30,12 @@ import (
 
 const (
 	TIME_FORMAT = "2006-01-02 15:04:05"
+	// Where the etcd drivers store the API keys, the audit log, the
+	// histories of the services and the webhooks by default
+	DEFAULT_API_KEY_DIR = "/apikeys"
+	DEFAULT_AUDIT_DIR   = "/audit"
+	DEFAULT_HISTORY_DIR = "/history"
+	DEFAULT_WEBHOOK_DIR = "/webhooks"
 )
 
 var (
@@ -45,6 +52,12 @@ type Watcher struct {
 	servicePrefix string
 	domainPrefix  string
 	stop          *Broadcaster
+	// Where the API keys, the audit log, the histories of the services and
+	// the webhooks are stored, one JSON node each
+	APIKeyDir  string
+	AuditDir   string
+	HistoryDir string
+	WebhookDir string
 }
 
 func NewWatcher(client etcd.KeysAPI, servicePrefix string, domainPrefix string) *Watcher {
@@ -55,6 +68,10 @@ func NewWatcher(client etcd.KeysAPI, servicePrefix string, domainPrefix string)
 		servicePrefix: servicePrefix,
 		domainPrefix:  domainPrefix,
 		stop:          NewBroadcaster(),
+		APIKeyDir:     DEFAULT_API_KEY_DIR,
+		AuditDir:      DEFAULT_AUDIT_DIR,
+		HistoryDir:    DEFAULT_HISTORY_DIR,
+		WebhookDir:    DEFAULT_WEBHOOK_DIR,
 	}
 
 	watcher.Init()
@@ -112,6 +129,7 @@ func (w *Watcher) doWatch(etcdDir string, registerFunc func(*etcd.Node, string))
 		}
 
 		log.Warningf("Waiting 1 second and relaunch watch")
+		metrics.WatchRestarts.WithLabelValues(etcdDir).Inc()
 		time.Sleep(time.Second)
 
 	}
@@ -201,6 +219,7 @@ func NewDomain(domainNode *etcd.Node) (*Domain, error) {
 		switch node.Key {
 		case domainNode.Key + "/type":
 			domain.Typ = node.Value
+			domain.Revision = int64(node.ModifiedIndex)
 		case domainNode.Key + "/value":
 			domain.Value = node.Value
 		}
@@ -273,6 +292,17 @@ func newService(serviceNode *etcd.Node) (*Service, error) {
 			}
 			service.LastAccess = &lastAccessTime
 
+		case service.NodeKey + "/passivationReason":
+			service.PassivationReason = node.Value
+
+		case service.NodeKey + "/snoozedUntil":
+			snoozedUntil, err := time.Parse(TIME_FORMAT, node.Value)
+			if err != nil {
+				log.Errorf("Error parsing snooze date with service %s: %s", service.Name, err)
+				break
+			}
+			service.SnoozedUntil = &snoozedUntil
+
 		case service.NodeKey + "/status":
 			service.Status = NewStatus(service, node)
 		case service.NodeKey + "/actions":
@@ -288,6 +318,11 @@ func newService(serviceNode *etcd.Node) (*Service, error) {
 	return service, nil
 }
 
+// Persists a service. The expected status is written first, compared and
+// swapped on the revision of the service, so that only one of the writers of
+// a revision succeeds. etcd v2 has no transaction over several keys : the
+// other keys are written one by one after it, and a failure leaves the
+// service partially written until its next write.
 func (w *Watcher) PersistService(s *Service) (*Service, error) {
 	if s.NodeKey != "" {
 		log.Debugf("Persisting key %s ", s.NodeKey)
@@ -300,9 +335,11 @@ func (w *Watcher) PersistService(s *Service) (*Service, error) {
 		if err != nil {
 			return nil, err
 		} else {
-			if oldService.Status.Expected != s.Status.Expected {
-				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/status/expected", s.NodeKey), s.Status.Expected, nil)
+			resp, err := w.kapi.Set(context.Background(), fmt.Sprintf("%s/status/expected", s.NodeKey), s.Status.Expected, revisionSetOptions(s.Revision))
+			if err != nil {
+				return nil, conflictOrError(err)
 			}
+			s.Revision = int64(resp.Node.ModifiedIndex)
 
 			if err == nil && oldService.Status.Current != s.Status.Current {
 				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/status/current", s.NodeKey), s.Status.Current, nil)
@@ -339,6 +376,18 @@ func (w *Watcher) PersistService(s *Service) (*Service, error) {
 				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/domain", s.NodeKey), s.Domain, nil)
 			}
 
+			if err == nil && s.LastAccess != nil && (oldService.LastAccess == nil || !oldService.LastAccess.Equal(s.LastAccess.UTC().Truncate(time.Second))) {
+				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/lastAccess", s.NodeKey), s.LastAccess.UTC().Format(TIME_FORMAT), nil)
+			}
+
+			if err == nil && oldService.PassivationReason != s.PassivationReason {
+				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/passivationReason", s.NodeKey), s.PassivationReason, nil)
+			}
+
+			if err == nil && s.SnoozedUntil != nil && (oldService.SnoozedUntil == nil || !oldService.SnoozedUntil.Equal(s.SnoozedUntil.UTC().Truncate(time.Second))) {
+				_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/snoozedUntil", s.NodeKey), s.SnoozedUntil.UTC().Format(TIME_FORMAT), nil)
+			}
+
 			if err != nil {
 				return nil, err
 			}
@@ -348,8 +397,9 @@ func (w *Watcher) PersistService(s *Service) (*Service, error) {
 	} else {
 		s.NodeKey = computeNodeKey(s, w.servicePrefix)
 
-		_, err := w.kapi.Create(context.Background(), fmt.Sprintf("%s/status/expected", s.NodeKey), s.Status.Expected)
+		resp, err := w.kapi.Create(context.Background(), fmt.Sprintf("%s/status/expected", s.NodeKey), s.Status.Expected)
 		if err == nil {
+			s.Revision = int64(resp.Node.ModifiedIndex)
 			_, err = w.kapi.Create(context.Background(), fmt.Sprintf("%s/status/current", s.NodeKey), s.Status.Current)
 		}
 		if err == nil {
@@ -432,6 +482,8 @@ func NewStatus(service *Service, node *etcd.Node) *Status {
 			status.Current = subNode.Value
 		case statusKey + "/expected":
 			status.Expected = subNode.Value
+			// Written on each PersistService, its index is the revision of the service
+			service.Revision = int64(subNode.ModifiedIndex)
 		}
 	}
 	return status
@@ -509,9 +561,13 @@ func (w *Watcher) PersistDomain(d *Domain) (*Domain, error) {
 			return nil, err
 		} else {
 			oldDomain, _ := NewDomain(resp.Node)
-			if oldDomain.Typ != d.Typ {
-				w.kapi.Set(context.Background(), fmt.Sprintf("%s/type", d.NodeKey), d.Typ, &etcd.SetOptions{PrevExist: etcd.PrevExist})
+			// The type is always set first, compared and swapped on the
+			// revision of the domain
+			resp, err := w.kapi.Set(context.Background(), fmt.Sprintf("%s/type", d.NodeKey), d.Typ, revisionSetOptions(d.Revision))
+			if err != nil {
+				return nil, conflictOrError(err)
 			}
+			d.Revision = int64(resp.Node.ModifiedIndex)
 
 			if oldDomain.Value != d.Value {
 				w.kapi.Set(context.Background(), fmt.Sprintf("%s/value", d.NodeKey), d.Value, &etcd.SetOptions{PrevExist: etcd.PrevExist})
@@ -520,8 +576,9 @@ func (w *Watcher) PersistDomain(d *Domain) (*Domain, error) {
 
 	} else {
 		d.NodeKey = computeDomainNodeKey(d.Name, w.domainPrefix)
-		_, err := w.kapi.Create(context.Background(), fmt.Sprintf("%s/type", d.NodeKey), d.Typ)
+		resp, err := w.kapi.Create(context.Background(), fmt.Sprintf("%s/type", d.NodeKey), d.Typ)
 		if err == nil {
+			d.Revision = int64(resp.Node.ModifiedIndex)
 			_, err = w.kapi.Create(context.Background(), fmt.Sprintf("%s/value", d.NodeKey), d.Value)
 		}
 
@@ -541,3 +598,293 @@ func (w *Watcher) DestroyDomain(d *Domain) error {
 	return err
 
 }
+
+func revisionSetOptions(revision int64) *etcd.SetOptions {
+	options := &etcd.SetOptions{PrevExist: etcd.PrevExist}
+	if revision != 0 {
+		options.PrevIndex = uint64(revision)
+	}
+	return options
+}
+
+// Translates a failed compare and swap into ErrConflict.
+func conflictOrError(err error) error {
+	if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeTestFailed {
+		return ErrConflict
+	}
+	return err
+}
+
+func (w *Watcher) LoadAllAPIKeys() (map[string]*APIKey, error) {
+	result := make(map[string]*APIKey)
+
+	response, err := w.kapi.Get(context.Background(), w.APIKeyDir, &etcd.GetOptions{Recursive: true})
+	if etcd.IsKeyNotFound(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, node := range response.Node.Nodes {
+		key := &APIKey{}
+		if err := json.Unmarshal([]byte(node.Value), key); err != nil {
+			return nil, err
+		}
+		key.Revision = int64(node.ModifiedIndex)
+		result[key.Id] = key
+	}
+	return result, nil
+}
+
+func (w *Watcher) LoadAPIKey(id string) (*APIKey, error) {
+	response, err := w.kapi.Get(context.Background(), fmt.Sprintf("%s/%s", w.APIKeyDir, id), nil)
+	if etcd.IsKeyNotFound(err) {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{}
+	if err := json.Unmarshal([]byte(response.Node.Value), key); err != nil {
+		return nil, err
+	}
+	key.Revision = int64(response.Node.ModifiedIndex)
+	return key, nil
+}
+
+func (w *Watcher) PersistAPIKey(key *APIKey) (*APIKey, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.kapi.Set(context.Background(), fmt.Sprintf("%s/%s", w.APIKeyDir, key.Id), string(data), nil)
+	if err != nil {
+		return nil, err
+	}
+	key.Revision = int64(resp.Node.ModifiedIndex)
+	return key, nil
+}
+
+func (w *Watcher) DestroyAPIKey(key *APIKey) error {
+	_, err := w.kapi.Delete(context.Background(), fmt.Sprintf("%s/%s", w.APIKeyDir, key.Id), nil)
+	return err
+}
+
+// Records the last use if the key is still at the index it was loaded at.
+func (w *Watcher) TouchAPIKey(key *APIKey, at time.Time) error {
+	touched := key.Copy()
+	touched.LastUsedAt = &at
+	data, err := json.Marshal(touched)
+	if err != nil {
+		return err
+	}
+
+	options := &etcd.SetOptions{PrevExist: etcd.PrevExist, PrevIndex: uint64(key.Revision)}
+	_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/%s", w.APIKeyDir, key.Id), string(data), options)
+	if etcd.IsKeyNotFound(err) {
+		return ErrConflict
+	}
+	return conflictOrError(err)
+}
+
+func (w *Watcher) AppendAuditEntry(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/%s", w.AuditDir, entry.Id), string(data), nil)
+	return err
+}
+
+// Loads the entries of the audit log whose keys, which start with the time
+// they were recorded, are in the range.
+func (w *Watcher) LoadAuditEntries(since time.Time, until time.Time) ([]*AuditEntry, error) {
+	result := []*AuditEntry{}
+	nodes, err := w.auditNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	min := TimeIdPrefix(since)
+	for _, node := range nodes {
+		id := node.Key[strings.LastIndex(node.Key, "/")+1:]
+		if id < min || !until.IsZero() && id >= TimeIdPrefix(until) {
+			continue
+		}
+		entry := &AuditEntry{}
+		if err := json.Unmarshal([]byte(node.Value), entry); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func (w *Watcher) PruneAuditEntries(before time.Time) error {
+	nodes, err := w.auditNodes()
+	if err != nil {
+		return err
+	}
+
+	max := TimeIdPrefix(before)
+	for _, node := range nodes {
+		if node.Key[strings.LastIndex(node.Key, "/")+1:] >= max {
+			break
+		}
+		if _, err := w.kapi.Delete(context.Background(), node.Key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the nodes of the audit log, sorted by key.
+func (w *Watcher) auditNodes() (etcd.Nodes, error) {
+	response, err := w.kapi.Get(context.Background(), w.AuditDir, &etcd.GetOptions{Recursive: true, Sort: true})
+	if etcd.IsKeyNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return response.Node.Nodes, nil
+}
+
+func (w *Watcher) AppendHistoryEvent(serviceName string, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = w.kapi.Set(context.Background(), fmt.Sprintf("%s/%s/%s", w.HistoryDir, serviceName, event.Id), string(data), nil)
+	return err
+}
+
+func (w *Watcher) LoadServiceHistory(serviceName string, since time.Time, until time.Time) ([]*HistoryEvent, error) {
+	result := []*HistoryEvent{}
+	response, err := w.kapi.Get(context.Background(), fmt.Sprintf("%s/%s", w.HistoryDir, serviceName), &etcd.GetOptions{Sort: true})
+	if etcd.IsKeyNotFound(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	min := TimeIdPrefix(since)
+	for _, node := range response.Node.Nodes {
+		id := node.Key[strings.LastIndex(node.Key, "/")+1:]
+		if id < min || !until.IsZero() && id >= TimeIdPrefix(until) {
+			continue
+		}
+		event := &HistoryEvent{}
+		if err := json.Unmarshal([]byte(node.Value), event); err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func (w *Watcher) PruneServiceHistories(before time.Time) error {
+	response, err := w.kapi.Get(context.Background(), w.HistoryDir, &etcd.GetOptions{Recursive: true, Sort: true})
+	if etcd.IsKeyNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	max := TimeIdPrefix(before)
+	for _, history := range response.Node.Nodes {
+		pruned := 0
+		for _, node := range history.Nodes {
+			if node.Key[strings.LastIndex(node.Key, "/")+1:] >= max {
+				break
+			}
+			if _, err := w.kapi.Delete(context.Background(), node.Key, nil); err != nil {
+				return err
+			}
+			pruned++
+		}
+		// The histories of the destroyed services end up empty
+		if pruned == len(history.Nodes) {
+			if _, err := w.kapi.Delete(context.Background(), history.Key, &etcd.DeleteOptions{Dir: true}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) LoadAllWebhookSubscriptions() (map[string]*WebhookSubscription, error) {
+	result := make(map[string]*WebhookSubscription)
+
+	response, err := w.kapi.Get(context.Background(), fmt.Sprintf("%s/subscriptions", w.WebhookDir), nil)
+	if etcd.IsKeyNotFound(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, node := range response.Node.Nodes {
+		subscription := &WebhookSubscription{}
+		if err := json.Unmarshal([]byte(node.Value), subscription); err != nil {
+			return nil, err
+		}
+		result[subscription.Id] = subscription
+	}
+	return result, nil
+}
+
+func (w *Watcher) PersistWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.kapi.Set(context.Background(), fmt.Sprintf("%s/subscriptions/%s", w.WebhookDir, subscription.Id), string(data), nil); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (w *Watcher) DestroyWebhookSubscription(subscription *WebhookSubscription) error {
+	_, err := w.kapi.Delete(context.Background(), fmt.Sprintf("%s/deliveries/%s", w.WebhookDir, subscription.Id), &etcd.DeleteOptions{Recursive: true})
+	if err != nil && !etcd.IsKeyNotFound(err) {
+		return err
+	}
+	_, err = w.kapi.Delete(context.Background(), fmt.Sprintf("%s/subscriptions/%s", w.WebhookDir, subscription.Id), nil)
+	return err
+}
+
+func (w *Watcher) LoadAllWebhookDeliveries() ([]*WebhookDelivery, error) {
+	result := []*WebhookDelivery{}
+	response, err := w.kapi.Get(context.Background(), fmt.Sprintf("%s/deliveries", w.WebhookDir), &etcd.GetOptions{Recursive: true})
+	if etcd.IsKeyNotFound(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, deliveries := range response.Node.Nodes {
+		for _, node := range deliveries.Nodes {
+			delivery := &WebhookDelivery{}
+			if err := json.Unmarshal([]byte(node.Value), delivery); err != nil {
+				return nil, err
+			}
+			result = append(result, delivery)
+		}
+	}
+	return result, nil
+}
+
+func (w *Watcher) PersistWebhookDelivery(delivery *WebhookDelivery) (*WebhookDelivery, error) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.kapi.Set(context.Background(), fmt.Sprintf("%s/deliveries/%s/%s", w.WebhookDir, delivery.SubscriptionId, delivery.Id), string(data), nil); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (w *Watcher) DestroyWebhookDelivery(delivery *WebhookDelivery) error {
+	_, err := w.kapi.Delete(context.Background(), fmt.Sprintf("%s/deliveries/%s/%s", w.WebhookDir, delivery.SubscriptionId, delivery.Id), nil)
+	return err
+}
@@ -133,14 +133,22 @@ func Test_EtcdWatcher(t *testing.T) {
 			service, _ := w.LoadService(testServiceName)
 
 			service.Status.Expected = STARTED_STATUS
-			service.Config.RancherInfo = &RancherInfoType{EnvironmentId: "bla"}
+			service.Config.DriverInfo = &DriverInfo{Driver: "rancher", Id: "bla"}
+			lastAccess := time.Date(2016, 5, 4, 10, 20, 30, 0, time.UTC)
+			service.LastAccess = &lastAccess
+			service.PassivationReason = "idle"
+			snoozedUntil := lastAccess.Add(time.Hour)
+			service.SnoozedUntil = &snoozedUntil
 
 			w.PersistService(service)
 
 			Convey("Then the service should be modified", func() {
 				service, _ := w.LoadService(testServiceName)
 				So(service.Status.Expected, ShouldEqual, STARTED_STATUS)
-				So(service.Config.RancherInfo.EnvironmentId, ShouldEqual, "bla")
+				So(service.Config.DriverInfo.Id, ShouldEqual, "bla")
+				So(service.LastAccess.Equal(lastAccess), ShouldBeTrue)
+				So(service.PassivationReason, ShouldEqual, "idle")
+				So(service.SnoozedUntil.Equal(snoozedUntil), ShouldBeTrue)
 			})
 
 			Convey("Then notification should have been sent", func() {
@@ -148,6 +156,21 @@ func Test_EtcdWatcher(t *testing.T) {
 			})
 
 		})
+
+		Convey("When i modify a service from an outdated revision", func() {
+			outdated, _ := w.LoadService(testServiceName)
+			service, _ := w.LoadService(testServiceName)
+			service.Status.Expected = STARTED_STATUS
+			w.PersistService(service)
+
+			outdated.Domain = "other.domain.com"
+			_, err := w.PersistService(outdated)
+
+			Convey("Then a conflict should be returned", func() {
+				So(err, ShouldEqual, ErrConflict)
+			})
+
+		})
 	})
 
 	Convey("Given a Model with one domain", t, func() {
@@ -37,39 +37,39 @@ func NewMockServiceDriver() *MockServiceDriver {
 	}
 }
 
-func (sd *MockServiceDriver) Create(s *Service, startOnCreate bool) (interface{}, error) {
+func (sd *MockServiceDriver) Create(s *Service, startOnCreate bool) (*DriverInfo, error) {
 	sd.calls["create"] = sd.calls["create"] + 1
 	sd.events.Write(NewModelEvent("update", s))
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
-func (sd *MockServiceDriver) Start(s *Service) (interface{}, error) {
+func (sd *MockServiceDriver) Start(s *Service) (*DriverInfo, error) {
 	sd.calls["start"] = sd.calls["start"] + 1
 	sd.events.Write(NewModelEvent("update", s))
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
-func (sd *MockServiceDriver) Upgrade(s *Service) (interface{}, error) {
+func (sd *MockServiceDriver) Upgrade(s *Service) (*DriverInfo, error) {
 	sd.calls["upgrade"] = sd.calls["upgrade"] + 1
 	sd.events.Write(NewModelEvent("update", s))
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
-func (sd *MockServiceDriver) FinishUpgrade(s *Service) (interface{}, error) {
+func (sd *MockServiceDriver) FinishUpgrade(s *Service) (*DriverInfo, error) {
 	sd.calls["finishupgrade"] = sd.calls["finishupgrade"] + 1
 	sd.events.Write(NewModelEvent("update", s))
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
-func (sd *MockServiceDriver) Rollback(s *Service) (interface{}, error) {
+func (sd *MockServiceDriver) Rollback(s *Service) (*DriverInfo, error) {
 	sd.calls["rollback"] = sd.calls["rollback"] + 1
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
-func (sd *MockServiceDriver) Stop(s *Service) (interface{}, error) {
+func (sd *MockServiceDriver) Stop(s *Service) (*DriverInfo, error) {
 	sd.calls["stop"] = sd.calls["stop"] + 1
 	sd.events.Write(NewModelEvent("update", s))
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
 func (sd *MockServiceDriver) Destroy(s *Service) error {
@@ -82,8 +82,8 @@ func (sd *MockServiceDriver) Listen() chan *ModelEvent {
 	return FromInterfaceChannel(sd.events.Listen())
 }
 
-func (sd *MockServiceDriver) GetInfo(s *Service) (interface{}, error) {
-	return &RancherInfoType{EnvironmentId: "rancherId"}, nil
+func (sd *MockServiceDriver) GetInfo(s *Service) (*DriverInfo, error) {
+	return &DriverInfo{Driver: "mock", Id: "rancherId"}, nil
 }
 
 func (w *MockServiceDriver) StopDriver() {
@@ -124,11 +124,11 @@ func Test_EtcdWatcher(t *testing.T) {
 		pd := storage.NewWatcher(kapi, "/services", "/domains")
 		model, _ = NewArkenModel(sd, pd)
 
-		for _, s := range model.Services {
+		for _, s := range model.Services() {
 			model.DestroyService(s)
 		}
 
-		for _, d := range model.Domains {
+		for _, d := range model.Domains() {
 			model.DestroyDomain(d)
 		}
 
@@ -143,14 +143,15 @@ func Test_EtcdWatcher(t *testing.T) {
 
 			Convey("Then the service should be available in all services", func() {
 				So(err, ShouldBeNil)
-				So(len(model.Services), ShouldEqual, 1)
-				sc := model.Services["testService"]
+				So(len(model.Services()), ShouldEqual, 1)
+				sc, ok := model.GetService("testService")
+				So(ok, ShouldBeTrue)
 				So(sc, ShouldNotBeNil)
 			})
 
 			Convey("Then its status should be stopped", func() {
 
-				service := model.Services["testService"]
+				service, _ := model.GetService("testService")
 				st := StatusError{service.Status.Compute(), service.Status}
 				So(st.ComputedStatus, ShouldEqual, STOPPED_STATUS)
 			})
@@ -158,11 +159,11 @@ func Test_EtcdWatcher(t *testing.T) {
 			Convey("Then the service should be created in the backend", func() {
 				time.Sleep(time.Second)
 				So(sd.calls["create"], ShouldEqual, initialCreateCount+1)
-				instance := model.Services["testService"]
+				instance, _ := model.GetService("testService")
 
 				So(instance.Config, ShouldNotBeNil)
-				So(instance.Config.RancherInfo, ShouldNotBeNil)
-				So(instance.Config.RancherInfo.EnvironmentId, ShouldEqual, "rancherId")
+				So(instance.Config.DriverInfo, ShouldNotBeNil)
+				So(instance.Config.DriverInfo.Id, ShouldEqual, "rancherId")
 			})
 
 			Convey("When I start the service", func() {
@@ -182,15 +183,52 @@ func Test_EtcdWatcher(t *testing.T) {
 			Convey("When I start the service and the service is started", func() {
 				model.StartService(service)
 
-				service := model.Services[service.Name]
+				service, _ := model.GetService(service.Name)
 				service.Status.Current = STARTED_STATUS
 				service.Status.Alive = "1"
 
-				Convey("Then its status should be started", func() {
+				Convey("Then the model is not modified by changes on the returned copy", func() {
+					So(getServiceStatus(model, "testService"), ShouldEqual, STARTING_STATUS)
+				})
+
+				Convey("Then its status should be started once the driver reports it", func() {
+					sd.events.Write(NewModelEvent("update", &DriverInfo{
+						Driver:        "mock",
+						Id:            "rancherId",
+						ServiceName:   "testService",
+						CurrentStatus: STARTED_STATUS,
+					}))
+					time.Sleep(time.Second)
 					So(getServiceStatus(model, "testService"), ShouldEqual, STARTED_STATUS)
+				})
+
+			})
 
+			Convey("When I update the service from an outdated revision", func() {
+				outdated, _ := model.GetService("testService")
+				model.StartService(service)
+
+				outdated.Domain = "other.domain.com"
+				_, err := model.UpdateService(outdated)
+
+				Convey("Then a conflict is returned", func() {
+					So(err, ShouldEqual, ErrConflict)
+					service, _ := model.GetService("testService")
+					So(service.Domain, ShouldEqual, "")
 				})
+			})
+
+			Convey("When I update the service from a revision that is not stored yet", func() {
+				newer, _ := model.GetService("testService")
+				newer.Revision++
+				newer.Domain = "other.domain.com"
+				_, err := model.UpdateService(newer)
 
+				Convey("Then a conflict is returned", func() {
+					So(err, ShouldEqual, ErrConflict)
+					service, _ := model.GetService("testService")
+					So(service.Domain, ShouldEqual, "")
+				})
 			})
 
 		})
@@ -205,8 +243,9 @@ func Test_EtcdWatcher(t *testing.T) {
 			model.CreateService(service, false)
 			Convey("Then a domain should be created", func() {
 				time.Sleep(2 * time.Second)
-				So(len(model.Domains), ShouldEqual, 1)
-				So(model.Domains["test.domain.com"], ShouldNotBeNil)
+				So(len(model.Domains()), ShouldEqual, 1)
+				_, ok := model.GetDomain("test.domain.com")
+				So(ok, ShouldBeTrue)
 			})
 		})
 
@@ -230,6 +269,36 @@ func Test_EtcdWatcher(t *testing.T) {
 					So(service.Status.Compute(), ShouldEqual, PASSIVATED_STATUS)
 					So(sd.calls["stop"], ShouldEqual, initial+1)
 				})
+
+				Convey("When the service is accessed", func() {
+					initialStart := sd.calls["start"]
+					accessed, err := model.RecordAccess(service.Name)
+
+					Convey("Then the service is started again", func() {
+						So(err, ShouldBeNil)
+						So(accessed.LastAccess, ShouldNotBeNil)
+						So(accessed.Status.Compute(), ShouldEqual, STARTING_STATUS)
+						So(sd.calls["start"], ShouldEqual, initialStart+1)
+					})
+				})
+			})
+
+			Convey("When I snooze its passivation", func() {
+				snoozed, err := model.SnoozePassivation(service, time.Hour)
+
+				Convey("Then the service is snoozed for that duration", func() {
+					So(err, ShouldBeNil)
+					So(snoozed.SnoozedUntil, ShouldNotBeNil)
+					So(snoozed.SnoozedUntil.After(time.Now().Add(59*time.Minute)), ShouldBeTrue)
+				})
+			})
+
+			Convey("When I snooze its passivation for a negative duration", func() {
+				_, err := model.SnoozePassivation(service, -time.Hour)
+
+				Convey("Then the snooze is rejected", func() {
+					So(err, ShouldEqual, ErrInvalidSnooze)
+				})
 			})
 
 		})
@@ -238,7 +307,7 @@ func Test_EtcdWatcher(t *testing.T) {
 }
 
 func getServiceStatus(model *Model, serviceName string) string {
-	service := model.Services[serviceName]
+	service, _ := model.GetService(serviceName)
 	st := StatusError{service.Status.Compute(), service.Status}
 	return st.ComputedStatus
 }
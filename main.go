@@ -51,6 +51,7 @@ func handleSignals() {
 			case syscall.SIGTERM, syscall.SIGINT:
 				//Exit gracefully
 				log.Infof("Shutting down...")
+				cli.Shutdown()
 				os.Exit(0)
 			case syscall.SIGUSR1:
 				pprof.Lookup("goroutine").WriteTo(os.Stdout, 2)
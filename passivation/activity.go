@@ -0,0 +1,374 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passivation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ACCESS_SOURCE = "access"
+	HTTP_SOURCE   = "http"
+	DRIVER_SOURCE = "driver"
+)
+
+// An ActivitySource tells when a service has last been active.
+type ActivitySource interface {
+	// Name of the source, recorded in the passivation reason
+	Name() string
+	// Returns the time of the last activity of the service, or nil when the
+	// source does not know it.
+	LastActivity(service *model.Service) (*time.Time, error)
+}
+
+// Creates an ActivitySource from the configuration
+type ActivitySourceFactory func(config model.DriverConfig, arkenModel *model.Model) (ActivitySource, error)
+
+var sourceRegistry = struct {
+	sync.RWMutex
+	factories map[string]ActivitySourceFactory
+}{factories: make(map[string]ActivitySourceFactory)}
+
+// Registers an ActivitySource under the given name, registering the same
+// name twice replaces the first one.
+func RegisterActivitySource(name string, factory ActivitySourceFactory) {
+	sourceRegistry.Lock()
+	defer sourceRegistry.Unlock()
+	sourceRegistry.factories[name] = factory
+}
+
+// Returns the names of the registered sources, sorted.
+func ActivitySourceNames() []string {
+	sourceRegistry.RLock()
+	defer sourceRegistry.RUnlock()
+
+	names := make([]string, 0, len(sourceRegistry.factories))
+	for name := range sourceRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates the registered source with the given name.
+func NewActivitySource(name string, config model.DriverConfig, arkenModel *model.Model) (ActivitySource, error) {
+	sourceRegistry.RLock()
+	factory, ok := sourceRegistry.factories[name]
+	sourceRegistry.RUnlock()
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown activity source %s, available sources are %v", name, ActivitySourceNames()))
+	}
+	return factory(config, arkenModel)
+}
+
+func init() {
+	RegisterActivitySource(ACCESS_SOURCE, func(config model.DriverConfig, arkenModel *model.Model) (ActivitySource, error) {
+		return &AccessSource{}, nil
+	})
+	RegisterActivitySource(HTTP_SOURCE, func(config model.DriverConfig, arkenModel *model.Model) (ActivitySource, error) {
+		return NewHttpProbeSource(config.GetString("passivation.http.path"), config.GetString("passivation.http.metric")), nil
+	})
+	RegisterActivitySource(DRIVER_SOURCE, func(config model.DriverConfig, arkenModel *model.Model) (ActivitySource, error) {
+		source := NewDriverSource(arkenModel)
+		var err error
+		if value := config.GetString("passivation.driver.cpuThreshold"); value != "" {
+			source.CpuThreshold, err = strconv.ParseUint(value, 10, 64)
+		}
+		if value := config.GetString("passivation.driver.networkThreshold"); err == nil && value != "" {
+			source.NetworkThreshold, err = strconv.ParseUint(value, 10, 64)
+		}
+		return source, err
+	})
+}
+
+// AccessSource reads the last access of the service, as recorded by the
+// arken proxy or by any router that writes it.
+type AccessSource struct{}
+
+func (s *AccessSource) Name() string {
+	return ACCESS_SOURCE
+}
+
+func (s *AccessSource) LastActivity(service *model.Service) (*time.Time, error) {
+	return service.LastAccess, nil
+}
+
+// Tracks counters by service. A counter that grows by more than a threshold
+// between two observations, or that is reset, denotes an activity.
+type counters struct {
+	mutex  sync.Mutex
+	values map[string]uint64
+	last   map[string]time.Time
+}
+
+func newCounters() *counters {
+	return &counters{
+		values: make(map[string]uint64),
+		last:   make(map[string]time.Time),
+	}
+}
+
+// Records the value of a counter and returns the time of its last
+// significant change, or nil when none has been seen yet.
+func (c *counters) observe(key string, value uint64, threshold uint64) *time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if previous, ok := c.values[key]; ok && (value < previous || value-previous > threshold) {
+		c.last[key] = time.Now()
+	}
+	c.values[key] = value
+
+	if last, ok := c.last[key]; ok {
+		return &last
+	}
+	return nil
+}
+
+// HttpProbeSource reads a request counter exposed by the service over HTTP.
+// The probed path either returns the counter alone, or metrics in the
+// Prometheus text format, in which case the samples of the metric are
+// summed.
+type HttpProbeSource struct {
+	Path   string
+	Metric string
+
+	client   *http.Client
+	counters *counters
+}
+
+func NewHttpProbeSource(path string, metric string) *HttpProbeSource {
+	if path == "" {
+		path = "/metrics"
+	}
+	return &HttpProbeSource{
+		Path:     path,
+		Metric:   metric,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		counters: newCounters(),
+	}
+}
+
+func (s *HttpProbeSource) Name() string {
+	return HTTP_SOURCE
+}
+
+func (s *HttpProbeSource) LastActivity(service *model.Service) (*time.Time, error) {
+	if service.Location == nil || service.Location.Host == "" {
+		return nil, nil
+	}
+
+	host := service.Location.Host
+	if service.Location.Port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(service.Location.Port))
+	}
+	resp, err := s.client.Get("http://" + host + s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Unable to probe %s on service %s : %s", s.Path, service.Name, resp.Status))
+	}
+
+	value, err := s.readCounter(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return s.counters.observe(service.Name, uint64(value), 0), nil
+}
+
+func (s *HttpProbeSource) readCounter(body io.Reader) (float64, error) {
+	scanner := bufio.NewScanner(body)
+	if s.Metric == "" {
+		scanner.Scan()
+		return strconv.ParseFloat(strings.TrimSpace(scanner.Text()), 64)
+	}
+
+	found := false
+	total := 0.0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, s.Metric) || len(line) == len(s.Metric) {
+			continue
+		}
+		if next := line[len(s.Metric)]; next != ' ' && next != '{' {
+			continue
+		}
+
+		// The value follows the labels, a timestamp may follow it
+		sample := line[len(s.Metric):]
+		if i := strings.LastIndex(sample, "}"); i >= 0 {
+			sample = sample[i+1:]
+		}
+		fields := strings.Fields(sample)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, err
+		}
+		found = true
+		total += value
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.New("No sample of metric " + s.Metric)
+	}
+	return total, nil
+}
+
+// DriverSource reads the CPU and network counters of the service from the
+// service driver. The CPU time (in nanoseconds) or the network bytes have to
+// grow by more than their threshold between two passivation checks to count
+// as an activity.
+type DriverSource struct {
+	CpuThreshold     uint64
+	NetworkThreshold uint64
+
+	arkenModel *model.Model
+	counters   *counters
+}
+
+func NewDriverSource(arkenModel *model.Model) *DriverSource {
+	return &DriverSource{
+		CpuThreshold: uint64(time.Second),
+		arkenModel:   arkenModel,
+		counters:     newCounters(),
+	}
+}
+
+func (s *DriverSource) Name() string {
+	return DRIVER_SOURCE
+}
+
+func (s *DriverSource) LastActivity(service *model.Service) (*time.Time, error) {
+	stats, err := s.arkenModel.ServiceActivity(service)
+	if err != nil {
+		return nil, err
+	}
+
+	cpu := s.counters.observe(service.Name+"/cpu", stats.CpuUsage, s.CpuThreshold)
+	network := s.counters.observe(service.Name+"/network", stats.NetworkBytes, s.NetworkThreshold)
+	return latest(cpu, network), nil
+}
+
+func latest(first *time.Time, second *time.Time) *time.Time {
+	if first == nil || (second != nil && second.After(*first)) {
+		return second
+	}
+	return first
+}
+
+// IdlePolicy decides when a service is idle from the activity reported by
+// its sources. A service without any known activity is considered active
+// since the policy first saw it started.
+type IdlePolicy struct {
+	Sources []ActivitySource
+
+	mutex     sync.Mutex
+	startedAt map[string]time.Time
+	known     map[string]knownActivity
+}
+
+type knownActivity struct {
+	at     time.Time
+	source string
+}
+
+func NewIdlePolicy(sources ...ActivitySource) *IdlePolicy {
+	return &IdlePolicy{
+		Sources:   sources,
+		startedAt: make(map[string]time.Time),
+		known:     make(map[string]knownActivity),
+	}
+}
+
+// Returns the time of the last activity of a started service and the name
+// of the source that reported it.
+func (p *IdlePolicy) LastActivity(service *model.Service) (time.Time, string) {
+	p.mutex.Lock()
+	last, ok := p.startedAt[service.Name]
+	if !ok {
+		last = time.Now()
+		p.startedAt[service.Name] = last
+	}
+	p.mutex.Unlock()
+
+	source := "start"
+	for _, s := range p.Sources {
+		activity, err := s.LastActivity(service)
+		if err != nil {
+			log.Warnf("Unable to read the activity of service %s from %s : %v", service.Name, s.Name(), err)
+		} else if activity != nil && activity.After(last) {
+			last = *activity
+			source = s.Name()
+		}
+	}
+
+	p.mutex.Lock()
+	p.known[service.Name] = knownActivity{last, source}
+	p.mutex.Unlock()
+	return last, source
+}
+
+// Returns the last activity of a service as of the previous call to
+// LastActivity, without probing the sources.
+func (p *IdlePolicy) KnownActivity(service *model.Service) (time.Time, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if known, ok := p.known[service.Name]; ok {
+		return known.at, known.source
+	}
+	if started, ok := p.startedAt[service.Name]; ok {
+		return started, "start"
+	}
+	return time.Now(), "start"
+}
+
+// The time after which a service whose last activity is given becomes idle
+func idleDeadline(service *model.Service, last time.Time) time.Time {
+	return last.Add(time.Duration(service.Config.Passivation.DelayInSeconds) * time.Second)
+}
+
+func idleReason(service *model.Service, last time.Time, source string, now time.Time) string {
+	if !now.After(idleDeadline(service, last)) {
+		return ""
+	}
+	delay := time.Duration(service.Config.Passivation.DelayInSeconds) * time.Second
+	return fmt.Sprintf("idle for more than %s, last activity at %s (%s)", delay, last.UTC().Format(time.RFC3339), source)
+}
+
+// Forgets what the policy knows about a service.
+func (p *IdlePolicy) Forget(serviceName string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.startedAt, serviceName)
+	delete(p.known, serviceName)
+}
@@ -0,0 +1,236 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passivation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A source that reports a fixed activity, or an error.
+type mockSource struct {
+	name     string
+	activity *time.Time
+	err      error
+}
+
+func (s *mockSource) Name() string {
+	return s.name
+}
+
+func (s *mockSource) LastActivity(service *model.Service) (*time.Time, error) {
+	return s.activity, s.err
+}
+
+type mockConfig map[string]string
+
+func (c mockConfig) GetString(key string) string {
+	return c[key]
+}
+
+// Serves the given body on /metrics
+type mockMetrics struct {
+	mutex sync.Mutex
+	body  string
+}
+
+func (m *mockMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fmt.Fprint(w, m.body)
+}
+
+func (m *mockMetrics) setBody(body string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.body = body
+}
+
+func Test_Counters(t *testing.T) {
+
+	Convey("Given counters", t, func() {
+		c := newCounters()
+
+		Convey("Then a counter seen once has no activity", func() {
+			So(c.observe("cpu", 10, 5), ShouldBeNil)
+		})
+
+		Convey("Then a counter that grows less than its threshold has no activity", func() {
+			c.observe("cpu", 10, 5)
+			So(c.observe("cpu", 15, 5), ShouldBeNil)
+		})
+
+		Convey("Then a counter that grows more than its threshold is active", func() {
+			c.observe("cpu", 10, 5)
+			So(c.observe("cpu", 16, 5), ShouldNotBeNil)
+		})
+
+		Convey("Then a counter that is reset is active", func() {
+			c.observe("cpu", 10, 5)
+			So(c.observe("cpu", 0, 5), ShouldNotBeNil)
+		})
+
+		Convey("Then the last activity is kept while the counter is still", func() {
+			c.observe("cpu", 10, 5)
+			last := c.observe("cpu", 20, 5)
+			So(c.observe("cpu", 20, 5).Equal(*last), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_HttpProbeSource(t *testing.T) {
+
+	Convey("Given a service that exposes its metrics", t, func() {
+		metrics := &mockMetrics{}
+		server := httptest.NewServer(metrics)
+		host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+		service := newTestService(model.STARTED_STATUS, model.STARTED_STATUS)
+		service.Location = &model.Location{Host: host}
+		service.Location.Port, _ = strconv.Atoi(port)
+
+		Convey("When it exposes the counter alone", func() {
+			source := NewHttpProbeSource("", "")
+			metrics.setBody("12\n")
+			first, err := source.LastActivity(service)
+			So(err, ShouldBeNil)
+			So(first, ShouldBeNil)
+
+			Convey("Then it is active when the counter grows", func() {
+				metrics.setBody("13\n")
+				last, err := source.LastActivity(service)
+				So(err, ShouldBeNil)
+				So(last, ShouldNotBeNil)
+			})
+
+			Convey("Then it is not active while the counter is still", func() {
+				last, err := source.LastActivity(service)
+				So(err, ShouldBeNil)
+				So(last, ShouldBeNil)
+			})
+		})
+
+		Convey("When it exposes metrics in the Prometheus text format", func() {
+			source := NewHttpProbeSource("", "http_requests_total")
+			metrics.setBody(strings.Join([]string{
+				"# TYPE http_requests_total counter",
+				`http_requests_total{code="200"} 10 1462780800000`,
+				`http_requests_total{code="500"} 2`,
+				"http_requests_total_created 1462780800",
+				"other_total 7",
+			}, "\n"))
+
+			Convey("Then the samples of the metric are summed", func() {
+				value, err := source.readCounter(strings.NewReader(metrics.body))
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, 12)
+			})
+
+			Convey("Then a missing metric is an error", func() {
+				source.Metric = "missing_total"
+				_, err := source.LastActivity(service)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the probed path is missing", func() {
+			source := NewHttpProbeSource("/missing", "")
+
+			Convey("Then the probe fails", func() {
+				_, err := source.LastActivity(service)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			server.Close()
+		})
+	})
+}
+
+func Test_IdlePolicy(t *testing.T) {
+
+	Convey("Given a policy with several sources", t, func() {
+		old := time.Now().Add(-time.Hour)
+		recent := time.Now().Add(time.Hour)
+		access := &mockSource{name: ACCESS_SOURCE, activity: &old}
+		probe := &mockSource{name: HTTP_SOURCE, activity: &recent}
+		failing := &mockSource{name: DRIVER_SOURCE, err: errors.New("unavailable")}
+		policy := NewIdlePolicy(access, probe, failing)
+		service := newTestService(model.STARTED_STATUS, model.STARTED_STATUS)
+
+		Convey("Then the latest activity wins", func() {
+			last, source := policy.LastActivity(service)
+			So(last.Equal(recent), ShouldBeTrue)
+			So(source, ShouldEqual, HTTP_SOURCE)
+
+			Convey("Then it is known without probing again", func() {
+				probe.activity = nil
+				last, source := policy.KnownActivity(service)
+				So(last.Equal(recent), ShouldBeTrue)
+				So(source, ShouldEqual, HTTP_SOURCE)
+			})
+		})
+
+		Convey("Then an activity older than the start does not count", func() {
+			probe.activity = nil
+			last, source := policy.LastActivity(service)
+			So(last.After(old), ShouldBeTrue)
+			So(source, ShouldEqual, "start")
+
+			Convey("Then the start is kept until the service is forgotten", func() {
+				again, _ := policy.LastActivity(service)
+				So(again.Equal(last), ShouldBeTrue)
+
+				policy.Forget(service.Name)
+				time.Sleep(time.Millisecond)
+				again, _ = policy.LastActivity(service)
+				So(again.After(last), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given the registered activity sources", t, func() {
+
+		Convey("Then the builtin sources are listed", func() {
+			So(ActivitySourceNames(), ShouldResemble, []string{ACCESS_SOURCE, DRIVER_SOURCE, HTTP_SOURCE})
+		})
+
+		Convey("Then an unknown source can't be created", func() {
+			_, err := NewActivitySource("unknown", nil, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then the driver source reads its thresholds from the configuration", func() {
+			config := mockConfig{"passivation.driver.cpuThreshold": "100", "passivation.driver.networkThreshold": "2048"}
+			source, err := NewActivitySource(DRIVER_SOURCE, config, nil)
+			So(err, ShouldBeNil)
+			So(source.(*DriverSource).CpuThreshold, ShouldEqual, 100)
+			So(source.(*DriverSource).NetworkThreshold, ShouldEqual, 2048)
+		})
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passivation
+
+import (
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"time"
+)
+
+// The rules of the passivation configuration that decide of a service
+const (
+	RULE_DISABLED = "disabled"
+	RULE_SCHEDULE = "schedule"
+	RULE_WINDOW   = "window"
+	RULE_IDLE     = "idle"
+	RULE_SNOOZED  = "snoozed"
+)
+
+// Decision tells what the passivation handler does with a service at a given
+// time : the action applied on it, if any, and the rule of its passivation
+// configuration that decides it. Next is the next time the handler would
+// act on the service when nothing else happens.
+type Decision struct {
+	ServiceName string                     `json:"serviceName"`
+	Action      string                     `json:"action,omitempty"`
+	Rule        string                     `json:"rule"`
+	Reason      string                     `json:"reason,omitempty"`
+	Next        *model.ScheduledTransition `json:"next,omitempty"`
+}
+
+// Returns the last activity of a service and the source that reported it
+type activityFunc func(service *model.Service) (time.Time, string)
+
+// Decides what to do with the service at now, given the transitions scheduled
+// since from. Only the activity function may have side effects.
+func decide(service *model.Service, from time.Time, now time.Time, lastActivity activityFunc) *Decision {
+	decision := &Decision{ServiceName: service.Name, Rule: RULE_DISABLED}
+	if service.Config == nil || service.Config.Passivation == nil || !service.Config.Passivation.Enabled || service.Status == nil {
+		return decision
+	}
+	config := service.Config.Passivation
+	decision.Next = config.NextTransition(now)
+	inWindow := config.InWindow(now)
+	snoozed := service.SnoozedUntil != nil && service.SnoozedUntil.After(now)
+
+	// The last transition scheduled since the previous check wins
+	if transitions := config.Transitions(from, now); len(transitions) > 0 {
+		transition := transitions[len(transitions)-1]
+		if transition.Action == model.START_ACTION {
+			if service.Status.Expected != model.STARTED_STATUS {
+				decision.Rule = RULE_SCHEDULE
+				decision.Action = model.START_ACTION
+				decision.Reason = fmt.Sprintf("scheduled window opens, started at %s", transition.At.Format(time.RFC3339))
+				return decision
+			}
+		} else if service.Status.Expected == model.STARTED_STATUS && !inWindow && !snoozed {
+			decision.Rule = RULE_SCHEDULE
+			decision.Action = passivationAction(transition.Action)
+			decision.Reason = fmt.Sprintf("scheduled window closed at %s", transition.At.Format(time.RFC3339))
+			return decision
+		}
+	}
+
+	// Services are kept up during the windows of their schedules
+	if inWindow {
+		decision.Rule = RULE_WINDOW
+		return decision
+	}
+
+	decision.Rule = RULE_IDLE
+	if service.Status.Current != model.STARTED_STATUS {
+		return decision
+	}
+
+	action := passivationAction(config.Action)
+	last, source := lastActivity(service)
+	reason := idleReason(service, last, source, now)
+	deadline := idleDeadline(service, last)
+
+	// A snoozed service is not passivated before the end of its snooze
+	if snoozed {
+		decision.Rule = RULE_SNOOZED
+		decision.Reason = fmt.Sprintf("passivation snoozed until %s", service.SnoozedUntil.UTC().Format(time.RFC3339))
+		reason = ""
+		if deadline.Before(*service.SnoozedUntil) {
+			deadline = *service.SnoozedUntil
+		}
+		if decision.Next != nil && decision.Next.Action != model.START_ACTION && decision.Next.At.Before(*service.SnoozedUntil) {
+			decision.Next = nil
+		}
+	}
+
+	if reason != "" {
+		decision.Action = action
+		decision.Reason = reason
+	} else if decision.Next == nil || deadline.Before(decision.Next.At) {
+		decision.Next = &model.ScheduledTransition{Action: action, At: deadline}
+	}
+	return decision
+}
+
+// The action applied on a passivated service, passivate by default
+func passivationAction(action string) string {
+	if action == "destroy" || action == model.STOP_ACTION {
+		return action
+	}
+	return model.PASSIVATE_ACTION
+}
+
+type decisionsByService []*Decision
+
+func (d decisionsByService) Len() int           { return len(d) }
+func (d decisionsByService) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d decisionsByService) Less(i, j int) bool { return d[i].ServiceName < d[j].ServiceName }
@@ -0,0 +1,180 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passivation
+
+import (
+	"github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// Returns the time of the day on monday, 2016-05-09
+func monday(hour int, min int) time.Time {
+	return time.Date(2016, 5, 9, hour, min, 0, 0, time.UTC)
+}
+
+func timeRef(t time.Time) *time.Time {
+	return &t
+}
+
+func newTestService(expected string, current string) *model.Service {
+	service := &model.Service{Name: "testService"}
+	service.Init()
+	service.Status.Expected = expected
+	service.Status.Current = current
+	service.Config.Passivation.DelayInSeconds = 3600
+	return service
+}
+
+type decisionCase struct {
+	name string
+	// Opens the window of the service from 8:00 to 19:00 on week days
+	scheduled    bool
+	disabled     bool
+	action       string
+	expected     string
+	current      string
+	snoozedUntil *time.Time
+	from, now    time.Time
+	lastActivity time.Time
+
+	rule     string
+	decided  string
+	reason   string
+	next     *model.ScheduledTransition
+	nextNone bool
+}
+
+func Test_Decide(t *testing.T) {
+	started := model.STARTED_STATUS
+	passivated := model.PASSIVATED_STATUS
+
+	cases := []decisionCase{
+		{
+			name: "a service whose passivation is disabled", disabled: true,
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(0, 0),
+			rule: RULE_DISABLED, nextNone: true,
+		},
+		{
+			name:     "an idle service",
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(1, 0),
+			rule: RULE_IDLE, decided: model.PASSIVATE_ACTION, reason: "idle for more than 1h0m0s, last activity at 2016-05-09T01:00:00Z (access)",
+		},
+		{
+			name: "an idle service stopped on passivation", action: model.STOP_ACTION,
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(1, 0),
+			rule: RULE_IDLE, decided: model.STOP_ACTION,
+		},
+		{
+			name: "an idle service destroyed on passivation", action: "destroy",
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(1, 0),
+			rule: RULE_IDLE, decided: "destroy",
+		},
+		{
+			name:     "an active service",
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(2, 50),
+			rule: RULE_IDLE, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(3, 50)},
+		},
+		{
+			name:     "a service that is not started",
+			expected: passivated, current: passivated, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(0, 0),
+			rule: RULE_IDLE, nextNone: true,
+		},
+		{
+			name: "an idle service snoozed", snoozedUntil: timeRef(monday(3, 30)),
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(1, 0),
+			rule: RULE_SNOOZED, reason: "passivation snoozed until 2016-05-09T03:30:00Z",
+			next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(3, 30)},
+		},
+		{
+			name: "an active service snoozed for less than its delay", snoozedUntil: timeRef(monday(3, 30)),
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(2, 50),
+			rule: RULE_SNOOZED, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(3, 50)},
+		},
+		{
+			name: "an idle service whose snooze is over", snoozedUntil: timeRef(monday(2, 30)),
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(1, 0),
+			rule: RULE_IDLE, decided: model.PASSIVATE_ACTION,
+		},
+		{
+			name: "an idle service in its window", scheduled: true,
+			expected: started, current: started, from: monday(9, 59), now: monday(10, 0), lastActivity: monday(8, 0),
+			rule: RULE_WINDOW, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(19, 0)},
+		},
+		{
+			name: "an active service out of its window", scheduled: true,
+			expected: started, current: started, from: monday(2, 59), now: monday(3, 0), lastActivity: monday(2, 50),
+			rule: RULE_IDLE, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(3, 50)},
+		},
+		{
+			name: "a service whose window has closed", scheduled: true,
+			expected: started, current: started, from: monday(18, 59), now: monday(19, 1), lastActivity: monday(18, 55),
+			rule: RULE_SCHEDULE, decided: model.PASSIVATE_ACTION, reason: "scheduled window closed at 2016-05-09T19:00:00Z",
+			next: &model.ScheduledTransition{Action: model.START_ACTION, At: monday(8, 0).AddDate(0, 0, 1)},
+		},
+		{
+			name: "a service snoozed when its window closes", scheduled: true, snoozedUntil: timeRef(monday(20, 0)),
+			expected: started, current: started, from: monday(18, 59), now: monday(19, 1), lastActivity: monday(17, 0),
+			rule: RULE_SNOOZED, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(20, 0)},
+		},
+		{
+			name: "a passivated service whose window opens", scheduled: true,
+			expected: passivated, current: passivated, from: monday(7, 59), now: monday(8, 1), lastActivity: monday(0, 0),
+			rule: RULE_SCHEDULE, decided: model.START_ACTION, reason: "scheduled window opens, started at 2016-05-09T08:00:00Z",
+		},
+		{
+			name: "a started service whose window opens", scheduled: true,
+			expected: started, current: started, from: monday(7, 59), now: monday(8, 1), lastActivity: monday(0, 0),
+			rule: RULE_WINDOW, next: &model.ScheduledTransition{Action: model.PASSIVATE_ACTION, At: monday(19, 0)},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		Convey("Given "+c.name, t, func() {
+			service := newTestService(c.expected, c.current)
+			config := service.Config.Passivation
+			config.Enabled = !c.disabled
+			if c.action != "" {
+				config.Action = c.action
+			}
+			if c.scheduled {
+				config.Schedules = []*model.PassivationSchedule{{Start: "0 8 * * 1-5", Stop: "0 19 * * 1-5"}}
+			}
+			service.SnoozedUntil = c.snoozedUntil
+
+			decision := decide(service, c.from, c.now, func(s *model.Service) (time.Time, string) {
+				return c.lastActivity, ACCESS_SOURCE
+			})
+
+			Convey("Then the decision follows its rule", func() {
+				So(decision.ServiceName, ShouldEqual, "testService")
+				So(decision.Rule, ShouldEqual, c.rule)
+				So(decision.Action, ShouldEqual, c.decided)
+				if c.reason != "" {
+					So(decision.Reason, ShouldEqual, c.reason)
+				}
+				if c.nextNone {
+					So(decision.Next, ShouldBeNil)
+				}
+				if c.next != nil {
+					So(decision.Next, ShouldNotBeNil)
+					So(decision.Next.Action, ShouldEqual, c.next.Action)
+					So(decision.Next.At.Equal(c.next.At), ShouldBeTrue)
+				}
+			})
+		})
+	}
+}
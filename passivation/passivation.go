@@ -16,7 +16,10 @@ package passivation
 
 import (
 	"github.com/Sirupsen/logrus"
+	"github.com/arkenio/arken/goarken/metrics"
 	"github.com/arkenio/arken/goarken/model"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -25,12 +28,25 @@ var log = logrus.New()
 type PassivationHandler struct {
 	arkenModel *model.Model
 	Stop       chan interface{}
+	// Decides which services are idle, from their last access by default
+	Policy *IdlePolicy
+	// Only logs the actions, without applying them
+	DryRun bool
+	// Services are warned that long before they are passivated, never when 0
+	WarningDelay time.Duration
+
+	mutex     sync.Mutex
+	lastCheck time.Time
+	warnings  map[string]*model.PassivationWarning
 }
 
-func NewHandler(model *model.Model) *PassivationHandler {
+func NewHandler(arkenModel *model.Model) *PassivationHandler {
 	return &PassivationHandler{
-		arkenModel: model,
+		arkenModel: arkenModel,
 		Stop:       make(chan interface{}),
+		Policy:     NewIdlePolicy(&AccessSource{}),
+		lastCheck:  time.Now(),
+		warnings:   make(map[string]*model.PassivationWarning),
 	}
 }
 
@@ -42,64 +58,187 @@ func (p *PassivationHandler) Start() {
 		select {
 		case <-p.Stop:
 			return
-		case <-ticker.C:
-			// Check every minute which service has to be passivated
-			for _, serviceCluster := range p.arkenModel.Services {
-				p.passivateServiceIfNeeded(serviceCluster)
+		case now := <-ticker.C:
+			p.mutex.Lock()
+			from := p.lastCheck
+			p.lastCheck = now
+			p.mutex.Unlock()
+			// Only the leader of the cluster passivates the services
+			if !p.arkenModel.IsLeader() {
+				continue
+			}
+			// Check every minute which service has a scheduled transition
+			// or has to be passivated
+			for _, service := range p.arkenModel.Services() {
+				if service.Status == nil || service.Status.Current != model.STARTED_STATUS {
+					p.Policy.Forget(service.Name)
+				}
+				decision := decide(service, from, now, p.Policy.LastActivity)
+				if p.warnIfNeeded(decision, now) {
+					p.apply(service, decision)
+				}
 			}
 		case event := <-updateChannel:
 			// When a service changes, check if it has to be started
 
-			if sc, ok := event.Model.(*model.Service); ok {
-				service := p.arkenModel.Services[sc.Name]
-				//Cluster may be nil if event was a delete
-				if service != nil {
+			if sc, ok := event.Model.(*model.Service); ok && p.arkenModel.IsLeader() {
+				service, ok := p.arkenModel.GetService(sc.Name)
+				//Service may be missing if event was a delete
+				if ok {
 					p.restartIfNeeded(service)
+				} else {
+					p.Policy.Forget(sc.Name)
+					delete(p.warnings, sc.Name)
 				}
 			}
 		}
 	}
 }
 
-func (p *PassivationHandler) passivateServiceIfNeeded(service *model.Service) {
-
-	// Checking if the service should be passivated or not
-	if p.hasToBePassivated(service) {
-		log.Infof("Service %s enters passivation", service.Name)
-		if "destroy" == service.Config.Passivation.Action {
-			p.arkenModel.DestroyService(service)
-		} else if "stop" == service.Config.Passivation.Action {
-			p.arkenModel.StopService(service)
-		} else {
-			// By default passivate
-			p.arkenModel.PassivateService(service)
+// Returns what the handler would do with every service now, by service name,
+// from the activity known at the previous check. Nothing is applied.
+func (p *PassivationHandler) Preview(now time.Time) []*Decision {
+	p.mutex.Lock()
+	from := p.lastCheck
+	p.mutex.Unlock()
+
+	decisions := []*Decision{}
+	for _, service := range p.arkenModel.Services() {
+		decisions = append(decisions, decide(service, from, now, p.Policy.KnownActivity))
+	}
+	sort.Sort(decisionsByService(decisions))
+	return decisions
+}
+
+// Warns when a service is about to be passivated, stopped or destroyed.
+// Returns false while the action of the decision has to wait for the end of
+// the warning it has been given.
+func (p *PassivationHandler) warnIfNeeded(decision *Decision, now time.Time) bool {
+	if p.WarningDelay <= 0 {
+		return true
+	}
+
+	upcoming := decision.Next
+	if decision.Action != "" {
+		upcoming = &model.ScheduledTransition{Action: decision.Action, At: now}
+	}
+	if upcoming == nil || upcoming.Action == model.START_ACTION || upcoming.At.Sub(now) > p.WarningDelay {
+		// Nothing is coming, a previous warning is obsolete
+		delete(p.warnings, decision.ServiceName)
+		return true
+	}
+
+	if warning, ok := p.warnings[decision.ServiceName]; ok {
+		if decision.Action == "" || now.Before(warning.At) {
+			return false
 		}
+		delete(p.warnings, decision.ServiceName)
+		return true
+	}
+
+	warning := &model.PassivationWarning{
+		ServiceName: decision.ServiceName,
+		Action:      upcoming.Action,
+		At:          upcoming.At,
+		Reason:      decision.Reason,
+	}
+	if decision.Action != "" {
+		// An action due without warning is delayed by the warning
+		warning.At = now.Add(p.WarningDelay)
+	}
+	p.warnings[decision.ServiceName] = warning
 
+	if p.DryRun {
+		log.Infof("Dry run, service %s would be warned of its %s at %s", warning.ServiceName, warning.Action, warning.At.Format(time.RFC3339))
+		return false
+	}
+	log.Infof("Service %s is warned of its %s at %s", warning.ServiceName, warning.Action, warning.At.Format(time.RFC3339))
+	p.arkenModel.WarnPassivation(warning)
+	return false
+}
+
+func (p *PassivationHandler) apply(service *model.Service, decision *Decision) {
+	if decision.Action == "" {
+		return
 	}
 
+	if p.DryRun {
+		log.Infof("Dry run, service %s would be %s by rule %s : %s", service.Name, decision.Action, decision.Rule, decision.Reason)
+		return
+	}
+
+	if decision.Action == model.START_ACTION {
+		log.Infof("Service %s is started before its scheduled window", service.Name)
+		before := p.arkenModel.ServiceStatus(service.Name)
+		_, err := p.arkenModel.StartService(service)
+		if err != nil {
+			log.Errorf("Scheduled start of service %s has failed : %s", service.Name, err)
+		}
+		p.audit("StartService", service.Name, before, decision.Reason, err)
+		return
+	}
+	p.passivate(service, decision.Action, decision.Reason)
 }
 
-func (p *PassivationHandler) hasToBePassivated(service *model.Service) bool {
+// Applies the passivation action on the service.
+func (p *PassivationHandler) passivate(service *model.Service, action string, reason string) {
+	log.Infof("Service %s enters passivation : %s", service.Name, reason)
+	service.PassivationReason = reason
+	before := p.arkenModel.ServiceStatus(service.Name)
+
+	var err error
+	auditAction := "PassivateService"
+	if "destroy" == action {
+		auditAction = "DestroyService"
+		err = p.arkenModel.DestroyService(service)
+	} else if "stop" == action {
+		auditAction = "StopService"
+		_, err = p.arkenModel.StopService(service)
+	} else {
+		_, err = p.arkenModel.PassivateService(service)
+	}
 
-	config := service.Config.Passivation
-	if config.Enabled {
-		passiveLimitDuration := time.Duration(config.DelayInSeconds) * time.Second
+	if err != nil {
+		log.Errorf("Passivation of service %s has failed : %s", service.Name, err)
+	} else {
+		metrics.Passivations.WithLabelValues(action).Inc()
+	}
+	p.audit(auditAction, service.Name, before, reason, err)
+}
 
-		return service.StartedSince() != nil &&
-			time.Now().After(service.StartedSince().Add(passiveLimitDuration))
+// Records an action of the passivation handler in the audit log.
+func (p *PassivationHandler) audit(action string, serviceName string, before string, reason string, err error) {
+	entry := &model.AuditEntry{
+		Actor:        model.PASSIVATION_COMPONENT,
+		ActorType:    model.COMPONENT_ACTOR,
+		Action:       action,
+		ServiceName:  serviceName,
+		StatusBefore: before,
+		StatusAfter:  p.arkenModel.ServiceStatus(serviceName),
+		Reason:       reason,
 	}
-	return false
+	if err != nil {
+		entry.Result = model.AUDIT_FAILURE
+		entry.Error = err.Error()
+	}
+	p.arkenModel.Audit(entry)
 }
 
 func (p *PassivationHandler) restartIfNeeded(service *model.Service) {
 
 	if p.hasToBeRestarted(service) {
-		service, err := p.arkenModel.StartService(service)
+		if p.DryRun {
+			log.Infof("Dry run, service %s would be restarted", service.Name)
+			return
+		}
+		before := p.arkenModel.ServiceStatus(service.Name)
+		started, err := p.arkenModel.StartService(service)
+		p.audit("StartService", service.Name, before, "The service has been accessed", err)
 		if err != nil {
 			log.Errorf("Service "+service.Name+" restart has failed: %s", err)
 			return
 		}
-		log.Infof("Service %s restarted", service.Name)
+		log.Infof("Service %s restarted", started.Name)
 	}
 }
 
@@ -0,0 +1,201 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package passivation
+
+import (
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns the next warning published by the model, or nil when none is
+// published in time.
+func nextWarning(events chan *model.ModelEvent) *model.PassivationWarning {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if warning, ok := event.Model.(*model.PassivationWarning); ok {
+				return warning
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func Test_PassivationWarnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arken-passivation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "arken.db")
+
+	Convey("Given a handler that warns 10 minutes before passivating", t, func() {
+		driver, err := storage.NewBoltDriver(path)
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+		events := arkenModel.Listen()
+
+		p := NewHandler(arkenModel)
+		p.WarningDelay = 10 * time.Minute
+		service := newTestService(model.STARTED_STATUS, model.STARTED_STATUS)
+		lastActivity := monday(2, 50)
+
+		// Checks the service at now, as the ticker of the handler does
+		check := func(now time.Time) (*Decision, bool) {
+			decision := decide(service, now.Add(-time.Minute), now, func(s *model.Service) (time.Time, string) {
+				return lastActivity, ACCESS_SOURCE
+			})
+			return decision, p.warnIfNeeded(decision, now)
+		}
+
+		Convey("When its passivation is not close", func() {
+			_, proceed := check(monday(3, 0))
+
+			Convey("Then it is not warned", func() {
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When its passivation comes within the warning delay", func() {
+			_, proceed := check(monday(3, 45))
+
+			Convey("Then it is warned of its passivation", func() {
+				So(proceed, ShouldBeFalse)
+				warning := nextWarning(events)
+				So(warning, ShouldNotBeNil)
+				So(warning.ServiceName, ShouldEqual, "testService")
+				So(warning.Action, ShouldEqual, model.PASSIVATE_ACTION)
+				So(warning.At.Equal(monday(3, 50)), ShouldBeTrue)
+			})
+
+			Convey("Then it is warned only once", func() {
+				_, proceed = check(monday(3, 48))
+				So(proceed, ShouldBeFalse)
+				So(len(p.warnings), ShouldEqual, 1)
+			})
+
+			Convey("Then it is passivated when the warning expires", func() {
+				decision, proceed := check(monday(3, 51))
+				So(decision.Action, ShouldEqual, model.PASSIVATE_ACTION)
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+			})
+
+			Convey("Then the warning is dropped when the service is active again", func() {
+				lastActivity = monday(3, 47)
+				_, proceed = check(monday(3, 48))
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+			})
+
+			Convey("Then the warning is dropped when the passivation is snoozed", func() {
+				service.SnoozedUntil = timeRef(monday(5, 0))
+				decision, proceed := check(monday(3, 48))
+				So(decision.Rule, ShouldEqual, RULE_SNOOZED)
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+
+				Convey("Then it is warned again before the end of the snooze", func() {
+					decision, proceed = check(monday(4, 55))
+					So(decision.Action, ShouldEqual, "")
+					So(proceed, ShouldBeFalse)
+					So(p.warnings["testService"].At.Equal(monday(5, 0)), ShouldBeTrue)
+
+					decision, proceed = check(monday(5, 1))
+					So(decision.Action, ShouldEqual, model.PASSIVATE_ACTION)
+					So(proceed, ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When its passivation is due without warning", func() {
+			lastActivity = monday(1, 0)
+			decision, proceed := check(monday(3, 0))
+
+			Convey("Then it is delayed by the warning", func() {
+				So(decision.Action, ShouldEqual, model.PASSIVATE_ACTION)
+				So(proceed, ShouldBeFalse)
+				warning := nextWarning(events)
+				So(warning, ShouldNotBeNil)
+				So(warning.At.Equal(monday(3, 10)), ShouldBeTrue)
+
+				_, proceed = check(monday(3, 5))
+				So(proceed, ShouldBeFalse)
+				_, proceed = check(monday(3, 10))
+				So(proceed, ShouldBeTrue)
+			})
+		})
+
+		Convey("When its scheduled window opens", func() {
+			service.Status.Expected = model.PASSIVATED_STATUS
+			service.Status.Current = model.PASSIVATED_STATUS
+			service.Config.Passivation.Schedules = []*model.PassivationSchedule{{Start: "0 8 * * 1-5", Stop: "0 19 * * 1-5"}}
+			decision, proceed := check(monday(8, 0))
+
+			Convey("Then it is started without warning", func() {
+				So(decision.Action, ShouldEqual, model.START_ACTION)
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the warning delay is not set", func() {
+			p.WarningDelay = 0
+			lastActivity = monday(1, 0)
+			_, proceed := check(monday(3, 0))
+
+			Convey("Then it is passivated without warning", func() {
+				So(proceed, ShouldBeTrue)
+				So(len(p.warnings), ShouldEqual, 0)
+			})
+		})
+
+		Reset(func() {
+			driver.Close()
+			os.Remove(path)
+		})
+	})
+
+	Convey("Given a handler in dry run", t, func() {
+		// Without model, anything applied or published would panic
+		p := NewHandler(nil)
+		p.DryRun = true
+		p.WarningDelay = 10 * time.Minute
+		service := newTestService(model.STARTED_STATUS, model.STARTED_STATUS)
+		decision := decide(service, monday(2, 59), monday(3, 0), func(s *model.Service) (time.Time, string) {
+			return monday(1, 0), ACCESS_SOURCE
+		})
+
+		Convey("Then the service is warned without publishing the warning", func() {
+			So(p.warnIfNeeded(decision, monday(3, 0)), ShouldBeFalse)
+			So(p.warnings["testService"].At.Equal(monday(3, 10)), ShouldBeTrue)
+		})
+
+		Convey("Then the service is left as is", func() {
+			p.apply(service, decision)
+			So(service.Status.Expected, ShouldEqual, model.STARTED_STATUS)
+			So(service.PassivationReason, ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package proxy
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/arkenio/arken/goarken/model"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logrus.New()
+
+// Proxy is an HTTP reverse proxy in front of the services of the model. It
+// resolves the Host header of a request through the domains of the model,
+// records the access on the service and forwards the request to the location
+// of the service. The request to a passivated service wakes it up.
+type Proxy struct {
+	arkenModel *model.Model
+
+	// How long a request waits for its service to start
+	StartTimeout time.Duration
+	// When set, it is served while the service starts instead of holding the
+	// request. It should reload itself.
+	WaitingPage []byte
+
+	mutex   sync.Mutex
+	waiters map[string]chan bool
+}
+
+func NewProxy(model *model.Model) *Proxy {
+	return &Proxy{
+		arkenModel:   model,
+		StartTimeout: 2 * time.Minute,
+		waiters:      make(map[string]chan bool),
+	}
+}
+
+// Listens to the model to release the requests waiting for a service to
+// start.
+func (p *Proxy) Start() {
+	for event := range p.arkenModel.Listen() {
+		if service, ok := event.Model.(*model.Service); ok && isReady(service) {
+			p.mutex.Lock()
+			if waiter, ok := p.waiters[service.Name]; ok {
+				close(waiter)
+				delete(p.waiters, service.Name)
+			}
+			p.mutex.Unlock()
+		}
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	domain, ok := p.resolve(r.Host)
+	if !ok {
+		http.Error(w, "Unknown domain "+r.Host, http.StatusNotFound)
+		return
+	}
+
+	switch domain.Typ {
+	case model.REDIRECT_DOMAIN:
+		http.Redirect(w, r, domain.Value, http.StatusFound)
+	case model.URI_DOMAIN:
+		target, err := url.Parse(domain.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	default:
+		p.serveService(w, r, domain.Value)
+	}
+}
+
+func (p *Proxy) serveService(w http.ResponseWriter, r *http.Request, serviceName string) {
+	if _, err := p.arkenModel.RecordAccess(serviceName); err != nil {
+		log.Errorf("Unable to record the access to service %s : %v", serviceName, err)
+	}
+
+	service, err := p.waitFor(w, r, serviceName)
+	if err != nil {
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: service.Location.Host}
+	if service.Location.Port != 0 {
+		target.Host = net.JoinHostPort(service.Location.Host, strconv.Itoa(service.Location.Port))
+	}
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+}
+
+// Returns the service once it is started. Meanwhile the waiting page is
+// served, or the request is held until the service starts. An error is
+// written to the response when the service does not start.
+func (p *Proxy) waitFor(w http.ResponseWriter, r *http.Request, serviceName string) (*model.Service, error) {
+	timeout := time.After(p.StartTimeout)
+	for {
+		p.mutex.Lock()
+		service, ok := p.arkenModel.GetService(serviceName)
+		if !ok {
+			p.mutex.Unlock()
+			http.Error(w, "Unknown service "+serviceName, http.StatusNotFound)
+			return nil, fmt.Errorf("Service %s not found", serviceName)
+		}
+		if isReady(service) {
+			p.mutex.Unlock()
+			return service, nil
+		}
+		if service.Status == nil || service.Status.Expected != model.STARTED_STATUS {
+			p.mutex.Unlock()
+			http.Error(w, "Service "+serviceName+" is "+service.Status.Compute(), http.StatusServiceUnavailable)
+			return nil, fmt.Errorf("Service %s is not expected to start", serviceName)
+		}
+		if p.WaitingPage != nil {
+			p.mutex.Unlock()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(p.WaitingPage)
+			return nil, fmt.Errorf("Service %s is starting", serviceName)
+		}
+
+		waiter, ok := p.waiters[serviceName]
+		if !ok {
+			waiter = make(chan bool)
+			p.waiters[serviceName] = waiter
+		}
+		p.mutex.Unlock()
+
+		select {
+		case <-waiter:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-timeout:
+			http.Error(w, "Service "+serviceName+" did not start in time", http.StatusGatewayTimeout)
+			return nil, fmt.Errorf("Service %s did not start in time", serviceName)
+		}
+	}
+}
+
+// Finds the domain of a host, or the wildcard domain of its parent.
+func (p *Proxy) resolve(host string) (*model.Domain, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if domain, ok := p.arkenModel.GetDomain(host); ok {
+		return domain, true
+	}
+	if i := strings.Index(host, "."); i >= 0 {
+		return p.arkenModel.GetDomain("*" + host[i:])
+	}
+	return nil, false
+}
+
+func isReady(service *model.Service) bool {
+	return service.Status != nil && service.Status.Current == model.STARTED_STATUS && service.Location != nil
+}
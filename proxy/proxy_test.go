@@ -0,0 +1,278 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package proxy
+
+import (
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An in memory persistence driver. The updates of the leader are simulated
+// by sending them on its events.
+type mockPersistence struct {
+	mutex    sync.Mutex
+	services map[string]*model.Service
+	domains  map[string]*model.Domain
+	events   chan *model.ModelEvent
+}
+
+func newMockPersistence() *mockPersistence {
+	return &mockPersistence{
+		services: make(map[string]*model.Service),
+		domains:  make(map[string]*model.Domain),
+		events:   make(chan *model.ModelEvent),
+	}
+}
+
+func (d *mockPersistence) LoadAllServices() (map[string]*model.Service, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	result := make(map[string]*model.Service)
+	for name, service := range d.services {
+		result[name] = service.Copy()
+	}
+	return result, nil
+}
+
+func (d *mockPersistence) LoadService(serviceName string) (*model.Service, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if service, ok := d.services[serviceName]; ok {
+		return service.Copy(), nil
+	}
+	return nil, fmt.Errorf("Service %s not found", serviceName)
+}
+
+func (d *mockPersistence) PersistService(service *model.Service) (*model.Service, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.services[service.Name] = service.Copy()
+	return service, nil
+}
+
+func (d *mockPersistence) DestroyService(service *model.Service) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.services, service.Name)
+	return nil
+}
+
+func (d *mockPersistence) LoadAllDomains() (map[string]*model.Domain, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	result := make(map[string]*model.Domain)
+	for name, domain := range d.domains {
+		result[name] = domain.Copy()
+	}
+	return result, nil
+}
+
+func (d *mockPersistence) LoadDomain(domainName string) (*model.Domain, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if domain, ok := d.domains[domainName]; ok {
+		return domain.Copy(), nil
+	}
+	return nil, fmt.Errorf("Domain %s not found", domainName)
+}
+
+func (d *mockPersistence) PersistDomain(domain *model.Domain) (*model.Domain, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.domains[domain.Name] = domain.Copy()
+	return domain, nil
+}
+
+func (d *mockPersistence) DestroyDomain(domain *model.Domain) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.domains, domain.Name)
+	return nil
+}
+
+func (d *mockPersistence) Listen() chan *model.ModelEvent {
+	return d.events
+}
+
+// Returns the expected status of the service as persisted
+func (d *mockPersistence) expected(serviceName string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if service, ok := d.services[serviceName]; ok {
+		return service.Status.Expected
+	}
+	return ""
+}
+
+func waitFor(condition func() bool) bool {
+	timeout := time.After(2 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func newTestService(name string, expected string, current string) *model.Service {
+	service := &model.Service{Name: name}
+	service.Init()
+	service.Status.Expected = expected
+	service.Status.Current = current
+	return service
+}
+
+// Returns the location of the server
+func locationOf(server *httptest.Server) *model.Location {
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	location := &model.Location{Host: host}
+	location.Port, _ = strconv.Atoi(port)
+	return location
+}
+
+func get(handler http.Handler, host string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", "http://"+host+"/index.html", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func Test_Proxy(t *testing.T) {
+
+	Convey("Given a proxy in front of the services of a follower model", t, func() {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "Hello from "+r.URL.Path)
+		}))
+
+		driver := newMockPersistence()
+		started := newTestService("started", model.STARTED_STATUS, model.STARTED_STATUS)
+		started.Location = locationOf(backend)
+		driver.services["started"] = started
+		driver.services["passivated"] = newTestService("passivated", model.PASSIVATED_STATUS, model.PASSIVATED_STATUS)
+		driver.services["stopped"] = newTestService("stopped", model.STOPPED_STATUS, model.STOPPED_STATUS)
+		driver.domains["started.example.com"] = &model.Domain{Name: "started.example.com", Typ: model.SERVICE_DOMAIN, Value: "started"}
+		driver.domains["passivated.example.com"] = &model.Domain{Name: "passivated.example.com", Typ: model.SERVICE_DOMAIN, Value: "passivated"}
+		driver.domains["stopped.example.com"] = &model.Domain{Name: "stopped.example.com", Typ: model.SERVICE_DOMAIN, Value: "stopped"}
+		driver.domains["*.apps.example.com"] = &model.Domain{Name: "*.apps.example.com", Typ: model.SERVICE_DOMAIN, Value: "started"}
+		driver.domains["old.example.com"] = &model.Domain{Name: "old.example.com", Typ: model.REDIRECT_DOMAIN, Value: "http://new.example.com/"}
+
+		arkenModel, err := model.NewFollowerArkenModel(nil, driver, "proxy")
+		So(err, ShouldBeNil)
+		p := NewProxy(arkenModel)
+		p.StartTimeout = 2 * time.Second
+		go p.Start()
+
+		Convey("Then a started service is served", func() {
+			w := get(p, "started.example.com")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "Hello from /index.html")
+			So(arkenModel.Services()["started"].LastAccess, ShouldNotBeNil)
+		})
+
+		Convey("Then a host is resolved by its wildcard domain", func() {
+			w := get(p, "www.apps.example.com:8080")
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Then a redirect domain is redirected", func() {
+			w := get(p, "old.example.com")
+			So(w.Code, ShouldEqual, http.StatusFound)
+			So(w.Header().Get("Location"), ShouldEqual, "http://new.example.com/")
+		})
+
+		Convey("Then an unknown domain is not found", func() {
+			w := get(p, "unknown.example.org")
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Then a service that is not expected to start is unavailable", func() {
+			w := get(p, "stopped.example.com")
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(driver.expected("stopped"), ShouldEqual, model.STOPPED_STATUS)
+		})
+
+		Convey("When a passivated service is accessed", func() {
+			responses := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				responses <- get(p, "passivated.example.com")
+			}()
+
+			Convey("Then the follower records that it is expected to start", func() {
+				So(waitFor(func() bool { return driver.expected("passivated") == model.STARTED_STATUS }), ShouldBeTrue)
+
+				Convey("Then the request is held until the leader starts it", func() {
+					held := false
+					select {
+					case <-responses:
+					case <-time.After(100 * time.Millisecond):
+						held = true
+					}
+					So(held, ShouldBeTrue)
+
+					service := newTestService("passivated", model.STARTED_STATUS, model.STARTED_STATUS)
+					service.Location = locationOf(backend)
+					driver.events <- model.NewModelEvent("update", service)
+
+					var w *httptest.ResponseRecorder
+					select {
+					case w = <-responses:
+					case <-time.After(3 * time.Second):
+					}
+					So(w, ShouldNotBeNil)
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Body.String(), ShouldEqual, "Hello from /index.html")
+				})
+			})
+		})
+
+		Convey("When a passivated service does not start in time", func() {
+			p.StartTimeout = 50 * time.Millisecond
+			w := get(p, "passivated.example.com")
+
+			Convey("Then the request times out", func() {
+				So(w.Code, ShouldEqual, http.StatusGatewayTimeout)
+				So(driver.expected("passivated"), ShouldEqual, model.STARTED_STATUS)
+			})
+		})
+
+		Convey("When the proxy has a waiting page", func() {
+			p.WaitingPage = []byte("<html>Starting</html>")
+			w := get(p, "passivated.example.com")
+
+			Convey("Then it is served while the service starts", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+				body, _ := ioutil.ReadAll(w.Body)
+				So(string(body), ShouldEqual, "<html>Starting</html>")
+				So(driver.expected("passivated"), ShouldEqual, model.STARTED_STATUS)
+			})
+		})
+
+		Reset(func() {
+			backend.Close()
+		})
+	})
+}
@@ -60,6 +60,10 @@ paths:
       responses:
         200:
           description: The service
+          headers:
+            ETag:
+              type: string
+              description: The revision of the service
           schema:
             $ref: '#/definitions/ServiceCluster'
         404:
@@ -77,12 +81,38 @@ paths:
           description: Id of the service
           required: true
           type: string
+        - name: If-Match
+          in: header
+          description: ETag of the service revision the update is based on. The revision of the body is used when missing.
+          required: false
+          type: string
         - in: "body"
           name: "body"
           description: "The update Service definition. Only Domain, Config.Environment and Config.Passivation have effects"
           required: true
           schema:
             $ref: "#/definitions/ServiceForCreation"
+      responses:
+        200:
+          description: The updated service
+          headers:
+            ETag:
+              type: string
+              description: The new revision of the service
+          schema:
+            $ref: '#/definitions/Service'
+        400:
+          description: The body names another service than the one of the URL
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The service does not exist
+          schema:
+            $ref: '#/definitions/Error'
+        409:
+          description: The service has been modified since the given revision
+          schema:
+            $ref: '#/definitions/Error'
     post:
       summary: Start/Stop/Upgrade/FinishUpgrade/Rollback/Passivate the service
       description: |
@@ -101,14 +131,26 @@ paths:
           enum: ['start','stop','upgrade','finishupgrade','rollback','passivate']
 
       responses:
-        200:
-          description: The service
+        202:
+          description: The action runs in the background, the operation tracks it
+          headers:
+            Location:
+              type: string
+              description: The URL of the operation
           schema:
-            $ref: '#/definitions/ServiceCluster'
+            $ref: '#/definitions/Operation'
+        400:
+          description: The action does not exist
+          schema:
+            $ref: '#/definitions/Error'
         404:
           description: The service does not exist
           schema:
             $ref: '#/definitions/Error'
+        409:
+          description: An operation is already in progress on the service
+          schema:
+            $ref: '#/definitions/Error'
     delete:
       summary: destroys a service
       parameters:
@@ -126,6 +168,619 @@ paths:
           description: The service does not exist
           schema:
             $ref: '#/definitions/Error'
+  /services/{serviceId}/snooze:
+    post:
+      summary: Snoozes the passivation of a service
+      description: |
+        The service is not passivated, stopped or destroyed by its passivation for the given
+        duration, whatever its activity and its scheduled windows.
+      parameters:
+        - name: serviceId
+          in: path
+          description: Id of the service
+          required: true
+          type: string
+        - name: snooze
+          in: body
+          required: true
+          schema:
+            $ref: '#/definitions/Snooze'
+      responses:
+        200:
+          description: The snoozed service
+          schema:
+            $ref: '#/definitions/Service'
+        400:
+          description: The duration is not positive
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The service does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /services/{serviceId}/history:
+    get:
+      summary: Gets the history of a service
+      description: |
+        The changes of status and of location of the service, and the actions run on it. The
+        history is kept after the service is destroyed, for history.retentionInDays.
+      parameters:
+        - name: serviceId
+          in: path
+          description: Id of the service
+          required: true
+          type: string
+        - name: since
+          in: query
+          description: Only the events from this time, included
+          required: false
+          type: string
+          format: date-time
+        - name: until
+          in: query
+          description: Only the events before this time
+          required: false
+          type: string
+          format: date-time
+        - name: limit
+          in: query
+          description: Only the latest events, 1000 by default
+          required: false
+          type: integer
+      responses:
+        200:
+          description: The events, from the oldest to the newest
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/HistoryEvent'
+        400:
+          description: The times or the limit are not valid
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The service does not exist and has no history
+          schema:
+            $ref: '#/definitions/Error'
+        501:
+          description: The storage can't store the histories
+          schema:
+            $ref: '#/definitions/Error'
+  /domains:
+    get:
+      summary: Gets the list of domains
+      description: |
+        Gets the domains, indexed by name. Service domains are given with their service,
+        the other ones as domains.
+      parameters:
+        - name: type
+          in: query
+          description: Only returns the domains of that type
+          type: string
+          enum: ['service','uri','redirect']
+        - name: status
+          in: query
+          description: Only returns the service domains whose service has that status
+          type: string
+      responses:
+        200:
+          description: The domains
+          schema:
+            type: object
+  /domains/{domain}:
+    get:
+      summary: Shows a domain
+      description: Shows the service of a service domain, the domain otherwise.
+      parameters:
+        - name: domain
+          in: path
+          description: Name of the domain
+          required: true
+          type: string
+      responses:
+        200:
+          description: The domain, or its service
+          headers:
+            ETag:
+              description: The revision of the domain
+              type: string
+        404:
+          description: The domain does not exist
+          schema:
+            $ref: '#/definitions/Error'
+    post:
+      summary: Creates a domain
+      parameters:
+        - name: domain
+          in: path
+          description: Name of the domain
+          required: true
+          type: string
+        - name: body
+          in: body
+          description: The type and the value of the domain
+          required: true
+          schema:
+            $ref: '#/definitions/Domain'
+      responses:
+        201:
+          description: The domain
+          schema:
+            $ref: '#/definitions/Domain'
+        400:
+          description: The domain is not valid
+          schema:
+            $ref: '#/definitions/Error'
+        409:
+          description: The domain exists or is claimed by another service
+          schema:
+            $ref: '#/definitions/Error'
+    put:
+      summary: Updates a domain
+      parameters:
+        - name: domain
+          in: path
+          description: Name of the domain
+          required: true
+          type: string
+        - name: If-Match
+          in: header
+          description: The revision of the domain to update
+          type: string
+        - name: body
+          in: body
+          description: The type and the value of the domain
+          required: true
+          schema:
+            $ref: '#/definitions/Domain'
+      responses:
+        200:
+          description: The domain
+          schema:
+            $ref: '#/definitions/Domain'
+        400:
+          description: The domain is not valid
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The domain does not exist
+          schema:
+            $ref: '#/definitions/Error'
+        409:
+          description: The domain has been modified or is claimed by another service
+          schema:
+            $ref: '#/definitions/Error'
+    delete:
+      summary: Destroys a domain
+      parameters:
+        - name: domain
+          in: path
+          description: Name of the domain
+          required: true
+          type: string
+      responses:
+        204:
+          description: The domain has been destroyed
+        404:
+          description: The domain does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /operations:
+    get:
+      summary: Gets the list of operations
+      description: |
+        Gets the operations run by this daemon, by creation time. Finished operations
+        are kept for an hour.
+      parameters:
+        - name: service
+          in: query
+          description: Only returns the operations of that service
+          type: string
+      responses:
+        200:
+          description: The operations
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Operation'
+  /operations/{operationId}:
+    get:
+      summary: Shows an operation
+      parameters:
+        - name: operationId
+          in: path
+          description: Id of the operation
+          required: true
+          type: string
+      responses:
+        200:
+          description: The operation
+          schema:
+            $ref: '#/definitions/Operation'
+        404:
+          description: The operation does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /cluster:
+    get:
+      summary: Shows the daemon in the cluster
+      description: |
+        Shows which daemon leads the cluster. Only the leader accepts the requests that
+        modify the model, the others answer them with a 503 and the id of the leader in
+        the X-Arken-Leader header.
+      responses:
+        200:
+          description: The cluster status
+          schema:
+            $ref: '#/definitions/ClusterStatus'
+  /events:
+    get:
+      summary: Streams the events of the model
+      description: |
+        Server-Sent Events stream of the ModelEvents pushed on the websocket. The id of each event is
+        its sequence : a client that reconnects with a Last-Event-ID header gets the events it missed
+        first, or a resync event when they are no longer available. Each parameter may be repeated or
+        hold comma separated values, an event has to match all of them.
+      produces:
+        - text/event-stream
+      parameters:
+        - name: Last-Event-ID
+          in: header
+          description: Sequence of the last event received
+          type: string
+        - name: since
+          in: query
+          description: Sequence of the last event received, when the header can not be set
+          type: string
+        - name: modelType
+          in: query
+          type: string
+        - name: eventType
+          in: query
+          type: string
+        - name: service
+          in: query
+          description: Patterns of the names of the services, like app-*
+          type: string
+        - name: domain
+          in: query
+          description: Patterns of the domain names
+          type: string
+        - name: status
+          in: query
+          description: Computed statuses of the services
+          type: string
+      responses:
+        200:
+          description: The stream of events
+        400:
+          description: The sequence or a pattern is not valid
+          schema:
+            $ref: '#/definitions/Error'
+  /passivation/preview:
+    get:
+      summary: Previews the passivation
+      description: |
+        Lists, for every service, what the passivation would do with it now, when it would act
+        on it next and which rule of its passivation configuration decides it. Nothing is applied.
+      responses:
+        200:
+          description: The decisions, by service name
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/PassivationDecision'
+        503:
+          description: The passivation does not run on this daemon
+          schema:
+            $ref: '#/definitions/Error'
+  /webhooks:
+    get:
+      summary: Lists the webhooks
+      description: Secrets are never returned.
+      responses:
+        200:
+          description: The webhooks, by id
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Webhook'
+    post:
+      summary: Creates a webhook
+      description: |
+        The events of the model that match the filters of the webhook are posted to its URL, signed
+        with its secret in the X-Arken-Signature header. The id is generated when not given.
+      parameters:
+        - name: webhook
+          in: body
+          required: true
+          schema:
+            $ref: '#/definitions/Webhook'
+      responses:
+        201:
+          description: The created webhook
+          schema:
+            $ref: '#/definitions/Webhook'
+        400:
+          description: The webhook is not valid
+          schema:
+            $ref: '#/definitions/Error'
+        409:
+          description: A webhook with that id already exists
+          schema:
+            $ref: '#/definitions/Error'
+  /webhooks/{webhookId}:
+    get:
+      summary: Shows a webhook
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+      responses:
+        200:
+          description: The webhook
+          schema:
+            $ref: '#/definitions/Webhook'
+        404:
+          description: The webhook does not exist
+          schema:
+            $ref: '#/definitions/Error'
+    put:
+      summary: Replaces a webhook
+      description: The secret is kept when none is given.
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+        - name: webhook
+          in: body
+          required: true
+          schema:
+            $ref: '#/definitions/Webhook'
+      responses:
+        200:
+          description: The updated webhook
+          schema:
+            $ref: '#/definitions/Webhook'
+        400:
+          description: The webhook is not valid
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The webhook does not exist
+          schema:
+            $ref: '#/definitions/Error'
+    delete:
+      summary: Deletes a webhook, along with its deliveries
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+      responses:
+        204:
+          description: The webhook has been deleted
+        404:
+          description: The webhook does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /webhooks/{webhookId}/deliveries:
+    get:
+      summary: Lists the last deliveries of a webhook, the latest first
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+      responses:
+        200:
+          description: The deliveries
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Delivery'
+        404:
+          description: The webhook does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /webhooks/{webhookId}/deadletters:
+    get:
+      summary: Lists the deliveries of a webhook that have failed after all their attempts
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+      responses:
+        200:
+          description: The failed deliveries
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/Delivery'
+        404:
+          description: The webhook does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /webhooks/{webhookId}/deadletters/{deliveryId}:
+    post:
+      summary: Delivers a failed delivery again
+      parameters:
+        - name: webhookId
+          in: path
+          description: Id of the webhook
+          required: true
+          type: string
+        - name: deliveryId
+          in: path
+          description: Id of the delivery
+          required: true
+          type: string
+      responses:
+        202:
+          description: The delivery, pending again
+          schema:
+            $ref: '#/definitions/Delivery'
+        404:
+          description: The webhook or the delivery does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /apikeys:
+    get:
+      summary: Lists the API keys stored by arken
+      description: The hashes of their secrets are never returned.
+      responses:
+        200:
+          description: The API keys, from the oldest to the newest
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/APIKey'
+        501:
+          description: The storage can't store API keys
+          schema:
+            $ref: '#/definitions/Error'
+    post:
+      summary: Creates an API key
+      description: |
+        The id and the secret are generated, the secret is only returned in this response. A key
+        may not be given more access than the one of its creator.
+      parameters:
+        - name: apikey
+          in: body
+          required: true
+          schema:
+            $ref: '#/definitions/APIKey'
+      responses:
+        201:
+          description: The created API key, with its secret
+          schema:
+            $ref: '#/definitions/CreatedAPIKey'
+        400:
+          description: The role, the permissions or the services are not valid
+          schema:
+            $ref: '#/definitions/Error'
+        403:
+          description: The key would have more access than its creator
+          schema:
+            $ref: '#/definitions/Error'
+  /apikeys/{keyId}:
+    get:
+      summary: Shows an API key
+      parameters:
+        - name: keyId
+          in: path
+          description: Id of the API key, its access key
+          required: true
+          type: string
+      responses:
+        200:
+          description: The API key
+          schema:
+            $ref: '#/definitions/APIKey'
+        404:
+          description: The API key does not exist
+          schema:
+            $ref: '#/definitions/Error'
+    put:
+      summary: Replaces the description, access and expiry of an API key
+      description: Its secret is kept.
+      parameters:
+        - name: keyId
+          in: path
+          description: Id of the API key, its access key
+          required: true
+          type: string
+        - name: apikey
+          in: body
+          required: true
+          schema:
+            $ref: '#/definitions/APIKey'
+      responses:
+        200:
+          description: The updated API key
+          schema:
+            $ref: '#/definitions/APIKey'
+        400:
+          description: The role, the permissions or the services are not valid
+          schema:
+            $ref: '#/definitions/Error'
+        404:
+          description: The API key does not exist
+          schema:
+            $ref: '#/definitions/Error'
+    delete:
+      summary: Revokes an API key, on every arken daemon at once
+      parameters:
+        - name: keyId
+          in: path
+          description: Id of the API key, its access key
+          required: true
+          type: string
+      responses:
+        204:
+          description: The API key has been revoked
+        404:
+          description: The API key does not exist
+          schema:
+            $ref: '#/definitions/Error'
+  /audit:
+    get:
+      summary: Lists the changes made to arken
+      description: |
+        Every change made through the API, by the passivation or by the sync with the service
+        driver is recorded, with who made it and its result. The entries are kept for
+        audit.retentionInDays.
+      parameters:
+        - name: service
+          in: query
+          description: Only the changes of this service
+          required: false
+          type: string
+        - name: actor
+          in: query
+          description: Only the changes made by this API key, user or component
+          required: false
+          type: string
+        - name: since
+          in: query
+          description: Only the changes made from this time, included
+          required: false
+          type: string
+          format: date-time
+        - name: until
+          in: query
+          description: Only the changes made before this time
+          required: false
+          type: string
+          format: date-time
+        - name: limit
+          in: query
+          description: Only the latest changes, 1000 by default
+          required: false
+          type: integer
+      responses:
+        200:
+          description: The changes, from the oldest to the newest
+          schema:
+            type: array
+            items:
+              $ref: '#/definitions/AuditEntry'
+        400:
+          description: The times or the limit are not valid
+          schema:
+            $ref: '#/definitions/Error'
+        501:
+          description: The storage can't store the audit log
+          schema:
+            $ref: '#/definitions/Error'
 definitions:
   ServiceCluster:
     type: object
@@ -141,6 +796,9 @@ definitions:
   Service:
     type: object
     properties:
+      revision:
+        type: integer
+        description: Revision of the service, changed on each update.
       location:
         $ref: '#/definitions/Location'
       domain:
@@ -156,10 +814,26 @@ definitions:
           $ref: '#/definitions/Action'
       config:
         $ref: '#/definitions/ServiceConfig'
+      lastAccess:
+        type: string
+        format: date-time
+        description: Last access to the service, recorded by the proxy.
+      passivationReason:
+        type: string
+        description: Why the service has been passivated, until it is started again.
+      snoozedUntil:
+        type: string
+        format: date-time
+        description: The service is not passivated until then.
+      nextTransition:
+        $ref: '#/definitions/ScheduledTransition'
 
   ServiceForCreation:
     type: object
     properties:
+      revision:
+        type: integer
+        description: On update, the revision the update is based on.
       name:
         type: string
       domain:
@@ -170,8 +844,10 @@ definitions:
       name: nxio-000001
       domain: test.devio
       config:
-        rancherInfo:
-          templateId: community:nuxeo:0
+        driverInfo:
+          driver: rancher
+          payload:
+            templateId: community:nuxeo:0
         passivation:
           delayInSeconds: 43200
 
@@ -213,9 +889,84 @@ definitions:
           The content of the robots.txt that gogeta has to serve
       Environment:
         type: object
-      RancherInfo:
-        $ref: '#/definitions/RancherInfo'
+      driverInfo:
+        $ref: '#/definitions/DriverInfo'
+      passivation:
+        $ref: '#/definitions/PassivationConfig'
 
+  PassivationConfig:
+    type: object
+    properties:
+      enabled:
+        type: boolean
+      delayInSeconds:
+        type: integer
+        description: The service is passivated once idle for that long.
+      action:
+        type: string
+        enum: ['passivate','stop','destroy']
+      schedules:
+        type: array
+        description: Windows during which the service is up, whatever its activity.
+        items:
+          $ref: '#/definitions/PassivationSchedule'
+
+  PassivationSchedule:
+    type: object
+    properties:
+      start:
+        type: string
+        description: Cron expression (minute hour day-of-month month day-of-week) of the opening of the window.
+      stop:
+        type: string
+        description: Cron expression of the closing of the window.
+      timeZone:
+        type: string
+        description: Time zone of the expressions, UTC by default.
+      warmupInMinutes:
+        type: integer
+        description: The service is started that long before the window opens.
+
+  ScheduledTransition:
+    type: object
+    properties:
+      action:
+        type: string
+        enum: ['start','passivate','stop','destroy']
+      at:
+        type: string
+        format: date-time
+
+
+  DriverInfo:
+    type: object
+    description: |
+      The state of the service in the backend of the service driver. The
+      former per driver keys (rancherInfo, fleetInfo...) are still accepted.
+    properties:
+      driver:
+        type: string
+        description: The name of the service driver (rancher, fleet, docker, kubernetes)
+      id:
+        type: string
+        description: The id of the service in the driver backend
+      serviceName:
+        type: string
+      location:
+        $ref: '#/definitions/Location'
+      healthState:
+        type: string
+        description: The health of the service as given by the driver
+      currentStatus:
+        type: string
+        description: the status computed from the health
+      payload:
+        type: object
+        description: The driver specific information, e.g. a RancherInfo
+    example:
+      driver: rancher
+      payload:
+        templateId: community:nuxeo:0
 
   RancherInfo:
     type: object
@@ -231,20 +982,270 @@ definitions:
       environmentName:
         type: string
         description: The user readable Rancher environment name.
-      location:
-        $ref: '#/definitions/Location'
-      healtState:
-        type: string
-        description: The Rancher given healthState of the service
-      currentStatus:
-        type: string
-        description: the status computed from healthState
     example:
       templateId: community:nuxeo:0
 
 
 
 
+  Operation:
+    type: object
+    properties:
+      id:
+        type: string
+      serviceName:
+        type: string
+      action:
+        type: string
+        enum: ['start','stop','upgrade','finishupgrade','rollback','passivate']
+      state:
+        type: string
+        enum: ['pending','running','succeeded','failed']
+      progress:
+        type: integer
+        description: Percentage of completion of the operation.
+      error:
+        type: string
+        description: The error of a failed operation.
+      createdAt:
+        type: string
+        format: date-time
+      startedAt:
+        type: string
+        format: date-time
+      finishedAt:
+        type: string
+        format: date-time
+  PassivationDecision:
+    type: object
+    properties:
+      serviceName:
+        type: string
+      action:
+        type: string
+        enum: ['start','passivate','stop','destroy']
+        description: The action that would be applied now, none when absent.
+      rule:
+        type: string
+        enum: ['disabled','schedule','window','snoozed','idle']
+        description: The rule of the passivation configuration that decides.
+      reason:
+        type: string
+      next:
+        $ref: '#/definitions/ScheduledTransition'
+  Snooze:
+    type: object
+    properties:
+      durationInSeconds:
+        type: integer
+        format: int32
+  PassivationWarning:
+    type: object
+    description: Pushed on the websocket as a warning event, and posted to the webhooks.
+    properties:
+      serviceName:
+        type: string
+      action:
+        type: string
+        enum: ['passivate','stop','destroy']
+      at:
+        type: string
+        format: date-time
+        description: When the action is applied.
+      reason:
+        type: string
+  APIKey:
+    type: object
+    properties:
+      id:
+        type: string
+        description: The access key, sent in the AuthKey header.
+      description:
+        type: string
+      role:
+        type: string
+        enum: ['viewer','operator','admin']
+      permissions:
+        type: array
+        description: Permissions added to the ones of the role.
+        items:
+          type: string
+          enum: ['read','create','update','start','stop','passivate','upgrade','delete','domains','webhooks','apikeys','audit']
+      services:
+        type: array
+        description: Patterns of the names of the services the key accesses, all when empty.
+        items:
+          type: string
+      createdAt:
+        type: string
+        format: date-time
+      lastUsedAt:
+        type: string
+        format: date-time
+        description: Recorded at most once a minute.
+      expiresAt:
+        type: string
+        format: date-time
+        description: The key is rejected from then on, it never expires when not set.
+  CreatedAPIKey:
+    allOf:
+      - $ref: '#/definitions/APIKey'
+      - type: object
+        properties:
+          secret:
+            type: string
+            description: Sent in the AuthSecret header, only returned when the key is created.
+  AuditEntry:
+    type: object
+    properties:
+      id:
+        type: string
+      time:
+        type: string
+        format: date-time
+      actor:
+        type: string
+        description: Name of the API key, of the user or of the component that made the change.
+      actorType:
+        type: string
+        enum: ['apikey','user','anonymous','component']
+      action:
+        type: string
+        description: The change, as CreateService, StopService or DestroyDomain.
+      serviceName:
+        type: string
+      domainName:
+        type: string
+      object:
+        type: string
+        description: Id of the changed webhook or API key.
+      statusBefore:
+        type: string
+      statusAfter:
+        type: string
+      result:
+        type: string
+        enum: ['success','failure']
+      error:
+        type: string
+      reason:
+        type: string
+        description: Why a component made the change.
+  HistoryEvent:
+    type: object
+    properties:
+      id:
+        type: string
+      time:
+        type: string
+        format: date-time
+      type:
+        type: string
+        enum: ['status','location','action']
+      previousStatus:
+        type: string
+      status:
+        type: string
+        description: The computed status of the service after a status event.
+      previousLocation:
+        $ref: '#/definitions/Location'
+      location:
+        $ref: '#/definitions/Location'
+      action:
+        type: string
+        description: The action run on the service, as start, stop or create.
+      error:
+        type: string
+        description: Why the action failed.
+  Webhook:
+    type: object
+    properties:
+      id:
+        type: string
+      url:
+        type: string
+      eventTypes:
+        type: array
+        description: The types of the events, all when empty.
+        items:
+          type: string
+          enum: ['create','update','delete','warning']
+      modelTypes:
+        type: array
+        description: The types of the objects of the events, all when empty.
+        items:
+          type: string
+          enum: ['Service','Domain','Operation','PassivationWarning']
+      services:
+        type: array
+        description: Patterns of the names of the services, like s* or ?pp, all when empty.
+        items:
+          type: string
+      secret:
+        type: string
+        description: Signs the deliveries with HMAC-SHA256 when set, never returned.
+  Delivery:
+    type: object
+    properties:
+      id:
+        type: string
+        description: Also sent in the X-Arken-Delivery header and in the body.
+      subscriptionId:
+        type: string
+      eventType:
+        type: string
+      modelType:
+        type: string
+      state:
+        type: string
+        enum: ['pending','delivered','failed']
+      attempts:
+        type: integer
+        format: int32
+      statusCode:
+        type: integer
+        format: int32
+      error:
+        type: string
+      createdAt:
+        type: string
+        format: date-time
+      updatedAt:
+        type: string
+        format: date-time
+      deadLetter:
+        type: boolean
+        description: True while the delivery is in the dead letters of its webhook.
+  ClusterStatus:
+    type: object
+    properties:
+      enabled:
+        type: boolean
+        description: False when the daemon runs alone, without leader election.
+      id:
+        type: string
+        description: The id of the daemon.
+      leader:
+        type: string
+        description: The id of the leader of the cluster.
+      isLeader:
+        type: boolean
+        description: True when the daemon is the leader.
+  Domain:
+    type: object
+    properties:
+      name:
+        type: string
+        description: The lower case host name, whose first label may be a wildcard.
+      type:
+        type: string
+        enum: ['service','uri','redirect']
+      value:
+        type: string
+        description: The name of the service of a service domain, an http(s) URI otherwise.
+      revision:
+        type: integer
+        format: int64
   Error:
     type: object
     properties:
@@ -0,0 +1,716 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/arkenio/arken/goarken/metrics"
+	"github.com/arkenio/arken/goarken/model"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var log = logrus.New()
+
+const (
+	// Attempts of a delivery before it is dead lettered
+	MAX_ATTEMPTS = 5
+	// Delay before the first retry of a delivery, doubled at each retry
+	RETRY_DELAY = 2 * time.Second
+	// The time given to a webhook to answer
+	DELIVERY_TIMEOUT = 10 * time.Second
+	// Deliveries kept in the log and in the dead letters of a subscription
+	DELIVERY_LOG_SIZE = 100
+	// How often the dispatcher checks if the daemon has become the leader
+	LEADERSHIP_CHECK_INTERVAL = 5 * time.Second
+
+	SIGNATURE_HEADER = "X-Arken-Signature"
+	EVENT_HEADER     = "X-Arken-Event"
+	DELIVERY_HEADER  = "X-Arken-Delivery"
+)
+
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+var ErrSubscriptionNotFound = errors.New("Webhook not found")
+var ErrSubscriptionExists = errors.New("Webhook already exists")
+var ErrDeliveryNotFound = errors.New("Delivery not found")
+
+// The body posted to the webhooks
+type Payload struct {
+	Id        string      `json:"id"`
+	EventType string      `json:"eventType"`
+	ModelType string      `json:"modelType"`
+	Time      time.Time   `json:"time"`
+	Model     interface{} `json:"model"`
+}
+
+// A Delivery tracks the posting of an event to a subscription. The
+// deliveries are stored until they leave both the log and the dead letters
+// of their subscription.
+type Delivery model.WebhookDelivery
+
+// Returns a copy of the delivery, without the body posted.
+func (delivery *Delivery) withoutBody() *Delivery {
+	result := *delivery
+	result.Body = nil
+	return &result
+}
+
+// Dispatcher delivers the events of the model to the subscriptions they
+// match. Only the leader of the cluster delivers the events. The
+// subscriptions and their deliveries are kept in the store when the
+// persistence driver supports it, and loaded again when the daemon becomes
+// the leader, so that it delivers the pending ones of the previous leader.
+// The followers load them again regularly, so that they list them as the
+// leader does.
+type Dispatcher struct {
+	arkenModel  *model.Model
+	Stop        chan interface{}
+	MaxAttempts int
+	RetryDelay  time.Duration
+
+	client        *http.Client
+	leading       bool
+	mutex         sync.Mutex
+	configured    map[string]*Subscription
+	subscriptions map[string]*Subscription
+	deliveries    map[string][]*Delivery
+	deadLetters   map[string][]*Delivery
+	// The deliveries being posted, by id, each one by a single goroutine
+	inFlight map[string]*Delivery
+	// Serializes the loads with the end of the deliveries
+	loading sync.Mutex
+	writes  []*write
+	writing bool
+}
+
+// A write to the store, of a delivery or of a subscription. The writes are
+// applied in the order they are queued, without the mutex.
+type write struct {
+	delivery     *model.WebhookDelivery
+	subscription *model.WebhookSubscription
+	destroy      bool
+	// Receives the result of the write when set
+	done chan error
+}
+
+func NewDispatcher(arkenModel *model.Model) *Dispatcher {
+	return &Dispatcher{
+		arkenModel:    arkenModel,
+		Stop:          make(chan interface{}),
+		MaxAttempts:   MAX_ATTEMPTS,
+		RetryDelay:    RETRY_DELAY,
+		client:        &http.Client{Timeout: DELIVERY_TIMEOUT},
+		configured:    make(map[string]*Subscription),
+		subscriptions: make(map[string]*Subscription),
+		deliveries:    make(map[string][]*Delivery),
+		deadLetters:   make(map[string][]*Delivery),
+		inFlight:      make(map[string]*Delivery),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	d.leading = d.arkenModel.IsLeader()
+	if err := d.load(d.leading); err != nil {
+		log.Errorf("Unable to load the webhooks : %v", err)
+	}
+
+	ticker := time.NewTicker(LEADERSHIP_CHECK_INTERVAL)
+	defer ticker.Stop()
+	events := d.arkenModel.Listen()
+	for {
+		select {
+		case <-d.Stop:
+			return
+		case <-ticker.C:
+			d.refresh()
+		case event := <-events:
+			if d.followLeadership() {
+				d.dispatch(event)
+			}
+		}
+	}
+}
+
+// Loads the webhooks again when the daemon becomes the leader, and tells if
+// it leads.
+func (d *Dispatcher) followLeadership() bool {
+	leading := d.arkenModel.IsLeader()
+	if leading && !d.leading {
+		if err := d.load(true); err != nil {
+			log.Errorf("Unable to load the webhooks : %v", err)
+		}
+	}
+	d.leading = leading
+	return leading
+}
+
+// Follows the leadership. The followers load the webhooks again, as the
+// leader changes them.
+func (d *Dispatcher) refresh() {
+	if d.followLeadership() {
+		return
+	}
+	if err := d.load(false); err != nil {
+		log.Errorf("Unable to load the webhooks : %v", err)
+	}
+}
+
+// Replaces the subscriptions and their deliveries by the stored ones. The
+// configured subscriptions replace the stored ones with the same id. The
+// leader resumes the pending deliveries that are not being posted.
+func (d *Dispatcher) load(leading bool) error {
+	d.loading.Lock()
+	defer d.loading.Unlock()
+
+	// Reads the store once the queued writes are applied
+	<-d.flush()
+	stored, err := d.arkenModel.WebhookSubscriptions()
+	if err == model.ErrWebhooksNotSupported {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	deliveries, err := d.arkenModel.WebhookDeliveries()
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.subscriptions = make(map[string]*Subscription)
+	for id, subscription := range stored {
+		d.subscriptions[id] = (*Subscription)(subscription)
+	}
+	for id, subscription := range d.configured {
+		d.subscriptions[id] = subscription.Copy()
+		if leading {
+			d.queue(&write{subscription: (*model.WebhookSubscription)(subscription.Copy())})
+		}
+	}
+
+	d.deliveries = make(map[string][]*Delivery)
+	d.deadLetters = make(map[string][]*Delivery)
+	loaded := make([]*Delivery, 0, len(deliveries))
+	for _, stored := range deliveries {
+		// The deliveries being posted are more recent than the stored ones
+		delivery, ok := d.inFlight[stored.Id]
+		if !ok {
+			delivery = (*Delivery)(stored)
+		}
+		if _, ok := d.subscriptions[delivery.SubscriptionId]; !ok {
+			continue
+		}
+		d.appendToLog(delivery)
+		if delivery.DeadLetter {
+			d.appendToDeadLetters(delivery)
+		}
+		loaded = append(loaded, delivery)
+	}
+
+	if leading {
+		for _, delivery := range loaded {
+			if delivery.State == DELIVERY_PENDING {
+				d.startDelivery(d.subscriptions[delivery.SubscriptionId], delivery)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the subscriptions, by id.
+func (d *Dispatcher) Subscriptions() []*Subscription {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	result := make([]*Subscription, 0, len(d.subscriptions))
+	for _, subscription := range d.subscriptions {
+		result = append(result, subscription.Copy())
+	}
+	sort.Sort(subscriptionsById(result))
+	return result
+}
+
+func (d *Dispatcher) Subscription(id string) (*Subscription, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if subscription, ok := d.subscriptions[id]; ok {
+		return subscription.Copy(), true
+	}
+	return nil, false
+}
+
+// Adds a subscription of the configuration. It replaces the stored one with
+// the same id whenever the subscriptions are loaded.
+func (d *Dispatcher) ConfigureSubscription(subscription *Subscription) error {
+	if err := subscription.Validate(); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.configured[subscription.Id]; ok {
+		return ErrSubscriptionExists
+	}
+	d.configured[subscription.Id] = subscription.Copy()
+	d.subscriptions[subscription.Id] = subscription.Copy()
+	return nil
+}
+
+// Adds a subscription, whose id is generated when empty.
+func (d *Dispatcher) CreateSubscription(subscription *Subscription) (*Subscription, error) {
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	subscription = subscription.Copy()
+	if subscription.Id == "" {
+		subscription.Id = newId()
+	} else if _, ok := d.subscriptions[subscription.Id]; ok {
+		d.mutex.Unlock()
+		return nil, ErrSubscriptionExists
+	}
+	d.subscriptions[subscription.Id] = subscription
+	done := d.saveSubscription(subscription)
+	d.mutex.Unlock()
+
+	if err := <-done; err != nil {
+		d.mutex.Lock()
+		if d.subscriptions[subscription.Id] == subscription {
+			delete(d.subscriptions, subscription.Id)
+		}
+		d.mutex.Unlock()
+		return nil, err
+	}
+	return subscription.Copy(), nil
+}
+
+// Replaces a subscription. Its secret is kept when the new one is empty.
+func (d *Dispatcher) UpdateSubscription(subscription *Subscription) (*Subscription, error) {
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	existing, ok := d.subscriptions[subscription.Id]
+	if !ok {
+		d.mutex.Unlock()
+		return nil, ErrSubscriptionNotFound
+	}
+	subscription = subscription.Copy()
+	if subscription.Secret == "" {
+		subscription.Secret = existing.Secret
+	}
+	d.subscriptions[subscription.Id] = subscription
+	done := d.saveSubscription(subscription)
+	d.mutex.Unlock()
+
+	if err := <-done; err != nil {
+		d.mutex.Lock()
+		if d.subscriptions[subscription.Id] == subscription {
+			d.subscriptions[subscription.Id] = existing
+		}
+		d.mutex.Unlock()
+		return nil, err
+	}
+	return subscription.Copy(), nil
+}
+
+// Removes a subscription along with its deliveries.
+func (d *Dispatcher) DestroySubscription(id string) error {
+	d.mutex.Lock()
+	subscription, ok := d.subscriptions[id]
+	if !ok {
+		d.mutex.Unlock()
+		return ErrSubscriptionNotFound
+	}
+	// The deliveries of a subscription that is not known anymore are not
+	// stored again
+	deliveries, deadLetters := d.deliveries[id], d.deadLetters[id]
+	delete(d.subscriptions, id)
+	delete(d.deliveries, id)
+	delete(d.deadLetters, id)
+	done := make(chan error, 1)
+	d.queue(&write{subscription: (*model.WebhookSubscription)(subscription.Copy()), destroy: true, done: done})
+	d.mutex.Unlock()
+
+	if err := <-done; err != nil {
+		d.mutex.Lock()
+		if _, ok := d.subscriptions[id]; !ok {
+			d.subscriptions[id] = subscription
+			d.deliveries[id] = deliveries
+			d.deadLetters[id] = deadLetters
+		}
+		d.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Returns the last deliveries of a subscription, the latest first.
+func (d *Dispatcher) Deliveries(id string) ([]*Delivery, error) {
+	return d.list(id, d.deliveries)
+}
+
+// Returns the deliveries of a subscription that have failed after all their
+// attempts, the latest first.
+func (d *Dispatcher) DeadLetters(id string) ([]*Delivery, error) {
+	return d.list(id, d.deadLetters)
+}
+
+func (d *Dispatcher) list(id string, deliveries map[string][]*Delivery) ([]*Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.subscriptions[id]; !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	result := make([]*Delivery, 0, len(deliveries[id]))
+	for i := len(deliveries[id]) - 1; i >= 0; i-- {
+		result = append(result, deliveries[id][i].withoutBody())
+	}
+	return result, nil
+}
+
+// Delivers a dead letter again, with all its attempts. It leaves the dead
+// letters.
+func (d *Dispatcher) Redeliver(id string, deliveryId string) (*Delivery, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	subscription, ok := d.subscriptions[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	for i, delivery := range d.deadLetters[id] {
+		if delivery.Id == deliveryId {
+			d.deadLetters[id] = append(d.deadLetters[id][:i], d.deadLetters[id][i+1:]...)
+			delivery.DeadLetter = false
+			delivery.State = DELIVERY_PENDING
+			delivery.Attempts = 0
+			delivery.UpdatedAt = time.Now()
+			d.store(delivery)
+			d.startDelivery(subscription, delivery)
+			return delivery.withoutBody(), nil
+		}
+	}
+	return nil, ErrDeliveryNotFound
+}
+
+// Creates a delivery of the event for each subscription it matches.
+func (d *Dispatcher) dispatch(event *model.ModelEvent) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, subscription := range d.subscriptions {
+		if !subscription.Matches(event) {
+			continue
+		}
+
+		id := newId()
+		body, err := json.Marshal(&Payload{id, event.EventType, event.ModelType, event.Time, event.Model})
+		if err != nil {
+			log.Errorf("Unable to serialize the %s event of %s : %v", event.EventType, event.ModelType, err)
+			return
+		}
+
+		now := time.Now()
+		delivery := &Delivery{
+			Id:             id,
+			SubscriptionId: subscription.Id,
+			EventType:      event.EventType,
+			ModelType:      event.ModelType,
+			State:          DELIVERY_PENDING,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+			Body:           body,
+		}
+		d.appendToLog(delivery)
+		d.store(delivery)
+		d.startDelivery(subscription, delivery)
+	}
+}
+
+// Starts to post a delivery, unless it is being posted already. Must be
+// called with the mutex.
+func (d *Dispatcher) startDelivery(subscription *Subscription, delivery *Delivery) {
+	if _, ok := d.inFlight[delivery.Id]; ok {
+		return
+	}
+	d.inFlight[delivery.Id] = delivery
+	go d.deliver(subscription.Copy(), delivery)
+}
+
+// Posts the delivery until it succeeds, fails for good or runs out of
+// attempts, waiting longer between each attempt. A daemon that is not the
+// leader anymore leaves the pending delivery to the next one.
+func (d *Dispatcher) deliver(subscription *Subscription, delivery *Delivery) {
+	delay := d.RetryDelay
+	for {
+		statusCode, err := d.post(subscription, delivery)
+
+		// A load reads the delivery being posted, or its last stored state
+		d.loading.Lock()
+		d.mutex.Lock()
+		delivery.Attempts++
+		delivery.StatusCode = statusCode
+		delivery.UpdatedAt = time.Now()
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		if err == nil {
+			delivery.State = DELIVERY_DELIVERED
+		} else if delivery.Attempts >= d.MaxAttempts || !retryable(statusCode) {
+			delivery.State = DELIVERY_FAILED
+			if _, ok := d.subscriptions[subscription.Id]; ok {
+				d.appendToDeadLetters(delivery)
+			}
+			log.Warnf("Delivery %s to webhook %s has failed after %d attempts : %v", delivery.Id, subscription.Id, delivery.Attempts, err)
+		}
+		stored := d.store(delivery)
+		state := delivery.State
+		if state != DELIVERY_PENDING {
+			delete(d.inFlight, delivery.Id)
+		}
+		d.mutex.Unlock()
+		if state != DELIVERY_PENDING && stored != nil {
+			<-stored
+		}
+		d.loading.Unlock()
+
+		if state != DELIVERY_PENDING {
+			metrics.WebhookDeliveries.WithLabelValues(state).Inc()
+			return
+		}
+
+		select {
+		case <-d.Stop:
+			d.leave(delivery)
+			return
+		case <-time.After(delay):
+			delay *= 2
+		}
+		if !d.arkenModel.IsLeader() {
+			d.leave(delivery)
+			return
+		}
+	}
+}
+
+// Stops posting a pending delivery, the leader posts it again once it loads
+// it.
+func (d *Dispatcher) leave(delivery *Delivery) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.inFlight, delivery.Id)
+}
+
+func (d *Dispatcher) post(subscription *Subscription, delivery *Delivery) (int, error) {
+	request, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EVENT_HEADER, delivery.EventType)
+	request.Header.Set(DELIVERY_HEADER, delivery.Id)
+	if subscription.Secret != "" {
+		request.Header.Set(SIGNATURE_HEADER, Sign(subscription.Secret, delivery.Body))
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, errors.New(fmt.Sprintf("Webhook answered %s", response.Status))
+	}
+	return response.StatusCode, nil
+}
+
+// Returns the signature of a body, as sent in the X-Arken-Signature header :
+// sha256= followed by the hex encoded HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Client errors are not retried, but timeouts and rate limits
+func retryable(statusCode int) bool {
+	return statusCode < 400 || statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+// Appends a delivery to the log of its subscription. The oldest one leaves
+// the log when it is full. Must be called with the mutex.
+func (d *Dispatcher) appendToLog(delivery *Delivery) {
+	id := delivery.SubscriptionId
+	d.deliveries[id] = append(d.deliveries[id], delivery)
+	if len(d.deliveries[id]) > DELIVERY_LOG_SIZE {
+		oldest := d.deliveries[id][0]
+		d.deliveries[id] = d.deliveries[id][1:]
+		d.store(oldest)
+	}
+}
+
+// Appends a delivery to the dead letters of its subscription. The oldest
+// one leaves the dead letters when they are full. Must be called with the
+// mutex.
+func (d *Dispatcher) appendToDeadLetters(delivery *Delivery) {
+	id := delivery.SubscriptionId
+	delivery.DeadLetter = true
+	d.deadLetters[id] = append(d.deadLetters[id], delivery)
+	if len(d.deadLetters[id]) > DELIVERY_LOG_SIZE {
+		oldest := d.deadLetters[id][0]
+		d.deadLetters[id] = d.deadLetters[id][1:]
+		oldest.DeadLetter = false
+		d.store(oldest)
+	}
+}
+
+// Saves a delivery while it is pending, in the log or in the dead letters of
+// its subscription, and destroys it otherwise. Only the leader writes to the
+// store. Returns the channel of the result of the write, nil when the
+// subscription is not known anymore. Must be called with the mutex.
+func (d *Dispatcher) store(delivery *Delivery) chan error {
+	if _, ok := d.subscriptions[delivery.SubscriptionId]; !ok {
+		return nil
+	}
+
+	saved := model.WebhookDelivery(*delivery)
+	keep := delivery.State == DELIVERY_PENDING || delivery.DeadLetter || containsDelivery(d.deliveries[delivery.SubscriptionId], delivery)
+	done := make(chan error, 1)
+	d.queue(&write{delivery: &saved, destroy: !keep, done: done})
+	return done
+}
+
+// Saves a copy of a subscription and returns the channel of the result. Must
+// be called with the mutex.
+func (d *Dispatcher) saveSubscription(subscription *Subscription) chan error {
+	done := make(chan error, 1)
+	d.queue(&write{subscription: (*model.WebhookSubscription)(subscription.Copy()), done: done})
+	return done
+}
+
+// Returns a channel that receives once the writes queued before are
+// applied.
+func (d *Dispatcher) flush() chan error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	done := make(chan error, 1)
+	d.queue(&write{done: done})
+	return done
+}
+
+// Queues a write to the store, applied by a single goroutine. Must be called
+// with the mutex.
+func (d *Dispatcher) queue(w *write) {
+	d.writes = append(d.writes, w)
+	if !d.writing {
+		d.writing = true
+		go d.write()
+	}
+}
+
+// Applies the queued writes in order, until there is none left.
+func (d *Dispatcher) write() {
+	for {
+		d.mutex.Lock()
+		if len(d.writes) == 0 {
+			d.writing = false
+			d.mutex.Unlock()
+			return
+		}
+		w := d.writes[0]
+		d.writes = d.writes[1:]
+		d.mutex.Unlock()
+
+		err := d.apply(w)
+		if w.done != nil {
+			w.done <- err
+		}
+	}
+}
+
+// Applies a write to the store. The webhooks only live in memory when the
+// persistence driver can't store them.
+func (d *Dispatcher) apply(w *write) error {
+	var err error
+	if w.delivery != nil {
+		if w.destroy {
+			err = d.arkenModel.DestroyWebhookDelivery(w.delivery)
+		} else {
+			_, err = d.arkenModel.SaveWebhookDelivery(w.delivery)
+		}
+		if err != nil && err != model.ErrNotLeader && err != model.ErrWebhooksNotSupported {
+			log.Errorf("Unable to store delivery %s to webhook %s : %v", w.delivery.Id, w.delivery.SubscriptionId, err)
+		}
+	} else if w.subscription != nil {
+		if w.destroy {
+			err = d.arkenModel.DestroyWebhookSubscription(w.subscription)
+		} else {
+			_, err = d.arkenModel.SaveWebhookSubscription(w.subscription)
+		}
+		if err != nil && w.done == nil && err != model.ErrNotLeader && err != model.ErrWebhooksNotSupported {
+			log.Errorf("Unable to store webhook %s : %v", w.subscription.Id, err)
+		}
+	}
+	if err == model.ErrWebhooksNotSupported {
+		return nil
+	}
+	return err
+}
+
+func containsDelivery(deliveries []*Delivery, delivery *Delivery) bool {
+	for _, d := range deliveries {
+		if d == delivery {
+			return true
+		}
+	}
+	return false
+}
+
+func newId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+type subscriptionsById []*Subscription
+
+func (s subscriptionsById) Len() int           { return len(s) }
+func (s subscriptionsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s subscriptionsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
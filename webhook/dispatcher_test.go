@@ -0,0 +1,268 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"github.com/arkenio/arken/goarken/model"
+	"github.com/arkenio/arken/goarken/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockElector struct {
+	leadership chan bool
+}
+
+func (e *mockElector) Id() string {
+	return "self"
+}
+
+func (e *mockElector) Campaign() chan bool {
+	return e.leadership
+}
+
+func (e *mockElector) Leader() (string, error) {
+	return "", nil
+}
+
+func (e *mockElector) Resign() error {
+	close(e.leadership)
+	return nil
+}
+
+// A webhook that records the bodies posted to it and answers with status.
+type mockWebhook struct {
+	mutex  sync.Mutex
+	status int
+	bodies []string
+}
+
+func (h *mockWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.bodies = append(h.bodies, string(body))
+	w.WriteHeader(h.status)
+}
+
+func (h *mockWebhook) setStatus(status int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status = status
+}
+
+func (h *mockWebhook) received() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string(nil), h.bodies...)
+}
+
+func waitFor(condition func() bool) bool {
+	timeout := time.After(2 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func Test_Dispatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arken-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "arken.db")
+
+	Convey("Given a dispatcher whose webhook answers errors", t, func() {
+		hook := &mockWebhook{status: http.StatusInternalServerError}
+		server := httptest.NewServer(hook)
+		driver, err := storage.NewBoltDriver(path)
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+
+		d := NewDispatcher(arkenModel)
+		d.MaxAttempts = 1
+		subscription, err := d.CreateSubscription(&Subscription{URL: server.URL})
+		So(err, ShouldBeNil)
+
+		Convey("When an event is dead lettered", func() {
+			d.dispatch(model.NewModelEvent("create", &model.Domain{Name: "example.com"}))
+			So(waitFor(func() bool {
+				deadLetters, _ := d.DeadLetters(subscription.Id)
+				return len(deadLetters) == 1
+			}), ShouldBeTrue)
+			So(waitFor(func() bool {
+				stored, _ := arkenModel.WebhookDeliveries()
+				return len(stored) == 1 && stored[0].DeadLetter
+			}), ShouldBeTrue)
+
+			Convey("Then another dispatcher loads the webhook and its deliveries", func() {
+				other := NewDispatcher(arkenModel)
+				So(other.load(false), ShouldBeNil)
+
+				_, ok := other.Subscription(subscription.Id)
+				So(ok, ShouldBeTrue)
+				deliveries, _ := other.Deliveries(subscription.Id)
+				So(len(deliveries), ShouldEqual, 1)
+				So(deliveries[0].State, ShouldEqual, DELIVERY_FAILED)
+				So(deliveries[0].Body, ShouldBeNil)
+				deadLetters, _ := other.DeadLetters(subscription.Id)
+				So(len(deadLetters), ShouldEqual, 1)
+
+				Convey("Then it delivers the dead letter again with its body", func() {
+					hook.setStatus(http.StatusOK)
+					_, err := other.Redeliver(subscription.Id, deadLetters[0].Id)
+					So(err, ShouldBeNil)
+					So(waitFor(func() bool { return len(hook.received()) == 2 }), ShouldBeTrue)
+					So(hook.received()[1], ShouldEqual, hook.received()[0])
+
+					So(waitFor(func() bool {
+						stored, _ := arkenModel.WebhookDeliveries()
+						return len(stored) == 1 && stored[0].State == DELIVERY_DELIVERED && !stored[0].DeadLetter
+					}), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When the webhook is destroyed", func() {
+			d.dispatch(model.NewModelEvent("create", &model.Domain{Name: "example.com"}))
+			So(d.DestroySubscription(subscription.Id), ShouldBeNil)
+
+			Convey("Then it is not stored anymore, nor its deliveries", func() {
+				subscriptions, _ := arkenModel.WebhookSubscriptions()
+				So(len(subscriptions), ShouldEqual, 0)
+				So(waitFor(func() bool { return len(hook.received()) == 1 }), ShouldBeTrue)
+				deliveries, _ := arkenModel.WebhookDeliveries()
+				So(len(deliveries), ShouldEqual, 0)
+			})
+		})
+
+		Reset(func() {
+			server.Close()
+			driver.Close()
+			os.Remove(path)
+		})
+	})
+
+	Convey("Given a dispatcher of a daemon that does not lead", t, func() {
+		hook := &mockWebhook{status: http.StatusOK}
+		server := httptest.NewServer(hook)
+		driver, err := storage.NewBoltDriver(path)
+		So(err, ShouldBeNil)
+		driver.PersistWebhookSubscription(&model.WebhookSubscription{Id: "w1", URL: server.URL})
+		driver.PersistWebhookDelivery(&model.WebhookDelivery{Id: "d1", SubscriptionId: "w1", State: DELIVERY_PENDING, Body: []byte(`{"id":"d1"}`), CreatedAt: time.Now()})
+
+		elector := &mockElector{leadership: make(chan bool)}
+		arkenModel, err := model.NewClusteredArkenModel(nil, driver, elector)
+		So(err, ShouldBeNil)
+		d := NewDispatcher(arkenModel)
+		So(d.load(false), ShouldBeNil)
+
+		Convey("Then it does not deliver the pending deliveries", func() {
+			_, ok := d.Subscription("w1")
+			So(ok, ShouldBeTrue)
+			time.Sleep(100 * time.Millisecond)
+			So(len(hook.received()), ShouldEqual, 0)
+		})
+
+		Convey("When the leader adds a webhook", func() {
+			driver.PersistWebhookSubscription(&model.WebhookSubscription{Id: "w2", URL: server.URL})
+			d.refresh()
+
+			Convey("Then the follower lists it", func() {
+				_, ok := d.Subscription("w2")
+				So(ok, ShouldBeTrue)
+				So(len(hook.received()), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When it becomes the leader", func() {
+			elector.leadership <- true
+			So(waitFor(arkenModel.IsLeader), ShouldBeTrue)
+			So(d.followLeadership(), ShouldBeTrue)
+
+			Convey("Then it delivers the pending deliveries of the previous leader", func() {
+				So(waitFor(func() bool { return len(hook.received()) == 1 }), ShouldBeTrue)
+				So(hook.received()[0], ShouldEqual, `{"id":"d1"}`)
+				So(waitFor(func() bool {
+					deliveries, _ := d.Deliveries("w1")
+					return len(deliveries) == 1 && deliveries[0].State == DELIVERY_DELIVERED
+				}), ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			elector.Resign()
+			server.Close()
+			driver.Close()
+			os.Remove(path)
+		})
+	})
+
+	Convey("Given a dispatcher that retries a delivery", t, func() {
+		hook := &mockWebhook{status: http.StatusInternalServerError}
+		server := httptest.NewServer(hook)
+		driver, err := storage.NewBoltDriver(path)
+		So(err, ShouldBeNil)
+		arkenModel, err := model.NewArkenModel(nil, driver)
+		So(err, ShouldBeNil)
+
+		d := NewDispatcher(arkenModel)
+		d.RetryDelay = time.Hour
+		subscription, err := d.CreateSubscription(&Subscription{URL: server.URL})
+		So(err, ShouldBeNil)
+		d.dispatch(model.NewModelEvent("create", &model.Domain{Name: "example.com"}))
+		So(waitFor(func() bool { return len(hook.received()) == 1 }), ShouldBeTrue)
+
+		Convey("When it loads the webhooks again", func() {
+			So(d.load(true), ShouldBeNil)
+			So(d.load(true), ShouldBeNil)
+
+			Convey("Then the delivery is still posted by a single goroutine", func() {
+				time.Sleep(100 * time.Millisecond)
+				So(len(hook.received()), ShouldEqual, 1)
+				deliveries, _ := d.Deliveries(subscription.Id)
+				So(len(deliveries), ShouldEqual, 1)
+				So(deliveries[0].State, ShouldEqual, DELIVERY_PENDING)
+				So(deliveries[0].Attempts, ShouldEqual, 1)
+
+				d.mutex.Lock()
+				inFlight := d.inFlight[deliveries[0].Id]
+				logged := d.deliveries[subscription.Id][0]
+				d.mutex.Unlock()
+				So(inFlight, ShouldNotBeNil)
+				So(inFlight, ShouldEqual, logged)
+			})
+		})
+
+		Reset(func() {
+			close(d.Stop)
+			server.Close()
+			driver.Close()
+			os.Remove(path)
+		})
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright © 2016 Nuxeo SA (http://nuxeo.com/) and others.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"fmt"
+	"github.com/arkenio/arken/goarken/model"
+	"net/url"
+	"path"
+)
+
+// The types of the events of the model
+var EventTypes = []string{"create", "update", "delete", "warning"}
+
+// The types of the objects of the events of the model
+var ModelTypes = []string{"Service", "Domain", "Operation", "PassivationWarning"}
+
+// A Subscription delivers the events of the model that match its filters to
+// its URL. Its event types are among EventTypes and its model types among
+// ModelTypes.
+type Subscription model.WebhookSubscription
+
+type InvalidSubscriptionError struct {
+	Reason string
+}
+
+func (e *InvalidSubscriptionError) Error() string {
+	return fmt.Sprintf("Invalid webhook : %s", e.Reason)
+}
+
+// Checks the URL and the filters of the subscription.
+func (s *Subscription) Validate() error {
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &InvalidSubscriptionError{fmt.Sprintf("the URL %q is not an http(s) URL", s.URL)}
+	}
+	for _, eventType := range s.EventTypes {
+		if !contains(EventTypes, eventType) {
+			return &InvalidSubscriptionError{fmt.Sprintf("unknown event type %s, available types are %v", eventType, EventTypes)}
+		}
+	}
+	for _, modelType := range s.ModelTypes {
+		if !contains(ModelTypes, modelType) {
+			return &InvalidSubscriptionError{fmt.Sprintf("unknown model type %s, available types are %v", modelType, ModelTypes)}
+		}
+	}
+	for _, pattern := range s.Services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &InvalidSubscriptionError{fmt.Sprintf("invalid service pattern %q", pattern)}
+		}
+	}
+	return nil
+}
+
+// Tells if the event passes the filters of the subscription. Events that do
+// not relate to a service never match service patterns.
+func (s *Subscription) Matches(event *model.ModelEvent) bool {
+	if len(s.EventTypes) > 0 && !contains(s.EventTypes, event.EventType) {
+		return false
+	}
+	if len(s.ModelTypes) > 0 && !contains(s.ModelTypes, event.ModelType) {
+		return false
+	}
+	if len(s.Services) == 0 {
+		return true
+	}
+
+	name, ok := event.ServiceName()
+	if !ok {
+		return false
+	}
+	for _, pattern := range s.Services {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) Copy() *Subscription {
+	result := *s
+	result.EventTypes = append([]string(nil), s.EventTypes...)
+	result.ModelTypes = append([]string(nil), s.ModelTypes...)
+	result.Services = append([]string(nil), s.Services...)
+	return &result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}